@@ -3,16 +3,25 @@ package radix
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"crypto/sha1"
+	"encoding"
 	"encoding/hex"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
 
 	"golang.org/x/xerrors"
 
+	"github.com/mediocregopher/radix/v3/internal/bytesutil"
 	"github.com/mediocregopher/radix/v3/resp"
 	"github.com/mediocregopher/radix/v3/resp/resp2"
 )
@@ -44,6 +53,26 @@ type CmdAction interface {
 	resp.Unmarshaler
 }
 
+// CmdInfo is implemented by the CmdActions returned from Cmd, FlatCmd, and
+// EvalScript's methods, and allows for inspecting the command which will be
+// sent to redis, e.g. by a Conn wrapper doing logging:
+//
+//	if ci, ok := a.(radix.CmdInfo); ok {
+//		log.Printf("performing %s %v", ci.Cmd(), ci.Args())
+//	}
+//
+// The slice returned from Args must not be modified.
+type CmdInfo interface {
+	// Cmd returns the name of the command, e.g. "GET" or "EVALSHA".
+	Cmd() string
+
+	// Args returns the arguments of the command, not including its name, as
+	// they will be sent to redis. If they can't be determined, e.g. because
+	// the arguments of a FlatCmd can't be flattened, then nil is returned, and
+	// performing the command will return the error.
+	Args() []string
+}
+
 var noKeyCmds = map[string]bool{
 	"SENTINEL": true,
 
@@ -54,6 +83,7 @@ var noKeyCmds = map[string]bool{
 
 	"AUTH":   true,
 	"ECHO":   true,
+	"HELLO":  true,
 	"PING":   true,
 	"QUIT":   true,
 	"SELECT": true,
@@ -61,14 +91,14 @@ var noKeyCmds = map[string]bool{
 
 	"KEYS":      true,
 	"MIGRATE":   true,
-	"OBJECT":    true,
 	"RANDOMKEY": true,
 	"WAIT":      true,
 	"SCAN":      true,
 
-	"EVAL":    true,
-	"EVALSHA": true,
-	"SCRIPT":  true,
+	"EVAL":     true,
+	"EVALSHA":  true,
+	"SCRIPT":   true,
+	"FUNCTION": true,
 
 	"BGREWRITEAOF": true,
 	"BGSAVE":       true,
@@ -81,6 +111,7 @@ var noKeyCmds = map[string]bool{
 	"FLUSHDB":      true,
 	"INFO":         true,
 	"LASTSAVE":     true,
+	"LATENCY":      true,
 	"MONITOR":      true,
 	"ROLE":         true,
 	"SAVE":         true,
@@ -97,22 +128,83 @@ var noKeyCmds = map[string]bool{
 	"WATCH":   true,
 }
 
+// cmdStringScratch holds the buffers used by cmdString, so that they can be
+// pooled and reused across calls.
+type cmdStringScratch struct {
+	buf bytes.Buffer
+	ss  []string
+	out []byte
+}
+
+// cmdStringScratchMaxSize is the largest buffer size which cmdString will
+// return to cmdStringPool, so that a single very large command doesn't pin a
+// large buffer in memory indefinitely.
+const cmdStringScratchMaxSize = 64 * 1024
+
+var cmdStringPool sync.Pool
+
 func cmdString(m resp.Marshaler) string {
+	sc, _ := cmdStringPool.Get().(*cmdStringScratch)
+	if sc == nil {
+		sc = new(cmdStringScratch)
+	}
+	defer func() {
+		for i := range sc.ss {
+			sc.ss[i] = ""
+		}
+		sc.buf.Reset()
+		if sc.buf.Cap() <= cmdStringScratchMaxSize && cap(sc.out) <= cmdStringScratchMaxSize {
+			cmdStringPool.Put(sc)
+		}
+	}()
+
 	// we go way out of the way here to display the command as it would be sent
 	// to redis. This is pretty similar logic to what the stub does as well
-	buf := new(bytes.Buffer)
-	if err := m.MarshalRESP(buf); err != nil {
+	if err := m.MarshalRESP(&sc.buf); err != nil {
 		return fmt.Sprintf("error creating string: %q", err.Error())
 	}
-	var ss []string
-	err := resp2.RawMessage(buf.Bytes()).UnmarshalInto(resp2.Any{I: &ss})
+	err := resp2.RawMessage(sc.buf.Bytes()).UnmarshalInto(resp2.Any{I: &sc.ss})
 	if err != nil {
 		return fmt.Sprintf("error creating string: %q", err.Error())
 	}
-	for i := range ss {
-		ss[i] = strconv.QuoteToASCII(ss[i])
+
+	sc.out = append(sc.out[:0], '[')
+	for i := range sc.ss {
+		if i > 0 {
+			sc.out = append(sc.out, ' ')
+		}
+		sc.out = strconv.AppendQuoteToASCII(sc.out, sc.ss[i])
+	}
+	sc.out = append(sc.out, ']')
+	return string(sc.out)
+}
+
+// Marshal returns the RESP bytes which would be written to a Conn when the
+// given Action is performed, without needing a Conn. This works for any
+// CmdAction (e.g. those returned from Cmd, FlatCmd, and EvalScript's methods)
+// and for the Actions returned from Pipeline, PipelineCollect and PipelineAll,
+// for which the bytes of each CmdAction are concatenated in order. It's useful
+// for debugging, and for testing redis compatible servers.
+//
+// Actions whose commands aren't known until they're performed, such as those
+// returned from WithConn or Transaction, can't be marshaled and an error is
+// returned for them.
+func Marshal(a Action) ([]byte, error) {
+	var m resp.Marshaler
+	switch at := a.(type) {
+	case *PipelineBuilder:
+		m = at.cmds
+	case resp.Marshaler:
+		m = at
+	default:
+		return nil, xerrors.Errorf("cannot marshal Action of type %T, only CmdActions and pipelines can be marshaled", a)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := m.MarshalRESP(buf); err != nil {
+		return nil, err
 	}
-	return "[" + strings.Join(ss, " ") + "]"
+	return buf.Bytes(), nil
 }
 
 func marshalBulkString(prevErr error, w io.Writer, str string) error {
@@ -139,6 +231,13 @@ type cmdAction struct {
 	flat     bool
 	flatKey  [1]string // use array to avoid allocation in Keys
 	flatArgs []interface{}
+
+	// the result of Args for a FlatCmd, which is only filled on demand
+	flatArgsStr []string
+
+	// err is returned by MarshalRESP, if set, for commands whose arguments
+	// couldn't be built when the CmdAction was created
+	err error
 }
 
 // BREAM: Benchmarks Rule Everything Around Me
@@ -158,6 +257,27 @@ func getCmdAction() *cmdAction {
 // pointer must be passed in. It may also be an io.Writer, an
 // encoding.Text/BinaryUnmarshaler, or a resp.Unmarshaler. See the package docs
 // for more on how results are unmarshaled into the receiver.
+//
+// The receiver may also be a channel which can be sent to, e.g. a chan<- string.
+// In that case the reply must be an array, each of whose elements is
+// unmarshaled into the channel's element type and sent to the channel as soon
+// as it's read, so that the whole reply is never held in memory. The channel is
+// closed once the reply has been read, or if an error occurs, and so a nil
+// array just results in the channel being closed. Sends block until the
+// channel is received from, unless the Cmd was created using CmdCtx and its
+// Context is done first.
+//
+// If the receiver is a *resp2.RawMessage then the complete reply is copied into
+// it byte-for-byte, including all elements of nested arrays and maps, so that
+// it can be forwarded verbatim. This includes error replies, which are captured
+// rather than returned as errors. RESP3 attributes preceding the reply, or any
+// of its elements, are not included.
+//
+// If the receiver is a *sync.Map then the reply, e.g. to HGETALL, must be an
+// array of alternating keys and values, each pair being stored into the
+// sync.Map as strings as soon as it's read, so that it can be read from
+// concurrently while being populated. A reply with an odd number of elements
+// results in an error, though pairs read before the error remain stored.
 func Cmd(rcv interface{}, cmd string, args ...string) CmdAction {
 	c := getCmdAction()
 	*c = cmdAction{
@@ -168,19 +288,105 @@ func Cmd(rcv interface{}, cmd string, args ...string) CmdAction {
 	return c
 }
 
+// cmdMinArgs are the minimum number of arguments, not including the command
+// name, which some common commands require. It's used by CmdChecked.
+var cmdMinArgs = map[string]int{
+	"APPEND":    2,
+	"DECR":      1,
+	"DECRBY":    2,
+	"DEL":       1,
+	"EXISTS":    1,
+	"EXPIRE":    2,
+	"EXPIREAT":  2,
+	"GET":       1,
+	"GETSET":    2,
+	"HDEL":      2,
+	"HEXISTS":   2,
+	"HGET":      2,
+	"HGETALL":   1,
+	"HINCRBY":   3,
+	"HMGET":     2,
+	"HSET":      3,
+	"INCR":      1,
+	"INCRBY":    2,
+	"LPOP":      1,
+	"LPUSH":     2,
+	"LRANGE":    3,
+	"MGET":      1,
+	"MSET":      2,
+	"PEXPIRE":   2,
+	"PEXPIREAT": 2,
+	"RPOP":      1,
+	"RPUSH":     2,
+	"SADD":      2,
+	"SET":       2,
+	"SETEX":     3,
+	"SISMEMBER": 2,
+	"SMEMBERS":  1,
+	"SREM":      2,
+	"TTL":       1,
+	"UNLINK":    1,
+	"ZADD":      3,
+	"ZRANGE":    3,
+	"ZREM":      2,
+	"ZSCORE":    2,
+}
+
+// CmdChecked is like Cmd, but the command is first checked for common mistakes,
+// returning an error describing the problem rather than a CmdAction if one is
+// found. This avoids a round-trip to redis for commands which would be
+// rejected anyway, and is useful when commands are built from untrusted input.
+//
+// The command is rejected if its name is invalid, if any argument contains a
+// carriage return or newline, or if it's a common command (e.g. GET, SET,
+// HSET) which is given fewer arguments than it requires. Since arguments
+// containing newlines are rejected, Cmd should be used for arguments which may
+// be arbitrary binary data.
+func CmdChecked(rcv interface{}, cmd string, args ...string) (CmdAction, error) {
+	if err := validateCmdName(cmd); err != nil {
+		return nil, err
+	}
+	for i, arg := range args {
+		if strings.ContainsAny(arg, "\r\n") {
+			return nil, xerrors.Errorf("argument %d of %s contains a carriage return or newline", i, cmd)
+		}
+	}
+	if min := cmdMinArgs[strings.ToUpper(cmd)]; len(args) < min {
+		return nil, xerrors.Errorf("%s requires at least %d arguments, got %d", cmd, min, len(args))
+	}
+	return Cmd(rcv, cmd, args...), nil
+}
+
 // FlatCmd is like Cmd, but the arguments can be of almost any type, and FlatCmd
 // will automatically flatten them into a single array of strings. Like Cmd, a
 // FlatCmd should not be passed into Do more than once.
 //
 // FlatCmd does _not_ work for commands whose first parameter isn't a key, or
-// (generally) for MSET. Use Cmd for those.
+// (generally) for MSET. Use Cmd or FlatMapCmd for those.
 //
 // FlatCmd supports using a resp.LenReader (an io.Reader with a Len() method) as
 // an argument. *bytes.Buffer is an example of a LenReader, and the resp package
 // has a NewLenReader function which can wrap an existing io.Reader.
 //
-// FlatCmd also supports encoding.Text/BinaryMarshalers. It does _not_ currently
-// support resp.Marshaler.
+// FlatCmd also supports encoding.Text/BinaryMarshalers, and resp.Marshalers.
+// A resp.Marshaler given directly as an argument (i.e. not within a slice or
+// map) is marshaled using its MarshalRESP method, and may write any number of
+// RESP messages, each of which becomes an argument of the command. Those
+// messages must be bulk strings, simple strings or integers, an error is
+// returned for any other kind of message, e.g. an array.
+//
+// A time.Duration must be wrapped using Seconds or Millis, depending on the
+// unit which the command expects, otherwise marshaling the command fails. A
+// time.Time is sent using its MarshalText method, as for any
+// encoding.TextMarshaler, unless it's wrapped using Seconds or Millis to send
+// it as a Unix timestamp.
+//
+// A nil pointer argument is skipped, and sends no arguments at all, as are map
+// entries and struct fields whose value is a nil pointer. EmptyIfNil can be
+// used to send an empty string for a nil pointer instead. A nil argument which
+// isn't a pointer, i.e. an untyped nil, is sent as an empty string.
+//
+// Flatten can be used to see what arguments will be sent, without sending them.
 //
 // The receiver to FlatCmd follows the same rules as for Cmd.
 func FlatCmd(rcv interface{}, cmd, key string, args ...interface{}) CmdAction {
@@ -195,6 +401,96 @@ func FlatCmd(rcv interface{}, cmd, key string, args ...interface{}) CmdAction {
 	return c
 }
 
+type flatMapCmdAction struct {
+	*cmdAction
+	keys []string
+}
+
+// FlatMapCmd is like FlatCmd, but is intended for commands such as MSET and
+// MSETNX whose arguments are all alternating keys and values. kv must be either
+// a map with string keys, or a slice of structs which have a string field
+// named Key and a field named Value, e.g.
+//
+//	[]struct{ Key string; Value int }
+//
+// Each value is flattened in the same way as by FlatCmd, and must flatten to
+// exactly one argument, i.e. it can't be a slice or map, nor a nil pointer,
+// since those are skipped (EmptyIfNil can be used to send them as an empty
+// string instead). If kv isn't of one of the expected types, or if any value
+// can't be flattened, the error (naming the value's key) is returned when the
+// CmdAction is marshaled, i.e. when it's run.
+// The keys of a map are sorted, so that the arguments are always given in the
+// same order.
+//
+// The returned CmdAction's Keys method returns every key, so when using
+// Cluster keys which belong to different slots will result in an error rather
+// than the command being sent to the wrong node.
+func FlatMapCmd(rcv interface{}, cmd string, kv interface{}) CmdAction {
+	keys, args, err := flatMapArgs(kv)
+	if err != nil {
+		c := getCmdAction()
+		*c = cmdAction{rcv: rcv, cmd: cmd, err: err}
+		return &flatMapCmdAction{cmdAction: c}
+	}
+	return &flatMapCmdAction{
+		cmdAction: Cmd(rcv, cmd, args...).(*cmdAction),
+		keys:      keys,
+	}
+}
+
+// flatMapArgs returns the keys in kv, and the alternating keys and flattened
+// values which FlatMapCmd will send for it.
+func flatMapArgs(kv interface{}) ([]string, []string, error) {
+	var keys []string
+	var vals []interface{}
+	v := reflect.Indirect(reflect.ValueOf(kv))
+	switch {
+	case v.Kind() == reflect.Map && v.Type().Key().Kind() == reflect.String:
+		mapKeys := v.MapKeys()
+		sort.Slice(mapKeys, func(i, j int) bool {
+			return mapKeys[i].String() < mapKeys[j].String()
+		})
+		for _, k := range mapKeys {
+			keys = append(keys, k.String())
+			vals = append(vals, v.MapIndex(k).Interface())
+		}
+
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Struct:
+		keyF, ok := v.Type().Elem().FieldByName("Key")
+		if !ok || keyF.Type.Kind() != reflect.String {
+			return nil, nil, xerrors.Errorf("FlatMapCmd kv elements must have a string Key field, got %v", v.Type().Elem())
+		}
+		valF, ok := v.Type().Elem().FieldByName("Value")
+		if !ok {
+			return nil, nil, xerrors.Errorf("FlatMapCmd kv elements must have a Value field, got %v", v.Type().Elem())
+		}
+		for i := 0; i < v.Len(); i++ {
+			keys = append(keys, v.Index(i).FieldByIndex(keyF.Index).String())
+			vals = append(vals, v.Index(i).FieldByIndex(valF.Index).Interface())
+		}
+
+	default:
+		return nil, nil, xerrors.Errorf("FlatMapCmd kv must be a map or slice of structs, got %T", kv)
+	}
+
+	args := make([]string, 0, len(keys)*2)
+	for i := range keys {
+		valArgs := vals[i : i+1]
+		valStrs, err := flattenArgs(&valArgs)
+		if err != nil {
+			return nil, nil, xerrors.Errorf("FlatMapCmd value for key %q can't be flattened: %w", keys[i], err)
+		} else if len(valStrs) != 1 {
+			return nil, nil, xerrors.Errorf("FlatMapCmd value for key %q must flatten to a single argument, got %d", keys[i], len(valStrs))
+		}
+		args = append(args, keys[i], valStrs[0])
+	}
+	return keys, args, nil
+}
+
+func (c *flatMapCmdAction) Keys() []string {
+	return c.keys
+}
+
 func findStreamsKeys(args []string) []string {
 	for i, arg := range args {
 		if strings.ToUpper(arg) != "STREAMS" {
@@ -212,211 +508,1557 @@ func findStreamsKeys(args []string) []string {
 	return nil
 }
 
-func (c *cmdAction) Keys() []string {
-	if c.flat {
-		return c.flatKey[:]
+// geoRadiusCmds maps the commands which may be given a STORE or STOREDIST
+// destination key to the index of their first optional argument.
+var geoRadiusCmds = map[string]int{
+	"GEORADIUS":         5, // key longitude latitude radius unit
+	"GEORADIUSBYMEMBER": 4, // key member radius unit
+}
+
+// findGeoRadiusKeys returns the source key of a GEORADIUS(BYMEMBER) command,
+// along with any STORE or STOREDIST destination keys. The optional arguments
+// start at optsIdx, and are only searched from there so that e.g. a member
+// named "STORE" isn't mistaken for the option.
+func findGeoRadiusKeys(args []string, optsIdx int) []string {
+	var keys []string
+	for i := optsIdx; i < len(args)-1; i++ {
+		if opt := strings.ToUpper(args[i]); opt == "STORE" || opt == "STOREDIST" {
+			if keys == nil {
+				keys = []string{args[0]}
+			}
+			i++
+			keys = append(keys, args[i])
+		}
+	}
+	if keys == nil {
+		return args[:1]
 	}
+	return keys
+}
 
-	cmd := strings.ToUpper(c.cmd)
-	if cmd == "BITOP" && len(c.args) > 1 { // antirez why you do this
-		return c.args[1:]
-	} else if cmd == "XINFO" {
-		if len(c.args) < 2 {
-			return nil
+// findSortKeys returns the key of a SORT command, along with its STORE
+// destination key, if any. The options are parsed so that a BY or GET pattern
+// of "STORE" isn't mistaken for the option. The patterns themselves aren't
+// returned, since they aren't keys.
+func findSortKeys(args []string) []string {
+	for i := 1; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "BY", "GET":
+			i++
+		case "LIMIT":
+			i += 2
+		case "STORE":
+			if i+1 < len(args) {
+				return []string{args[0], args[i+1]}
+			}
 		}
-		return c.args[1:2]
-	} else if cmd == "XGROUP" && len(c.args) > 1 {
-		return c.args[1:2]
-	} else if cmd == "XREAD" || cmd == "XREADGROUP" { // antirez why you still do this
-		return findStreamsKeys(c.args)
-	} else if noKeyCmds[cmd] || len(c.args) == 0 {
-		return nil
 	}
-	return c.args[:1]
+	return args[:1]
 }
 
-func (c *cmdAction) flatMarshalRESP(w io.Writer) error {
-	var err error
-	a := resp2.Any{
-		I:                     c.flatArgs,
-		MarshalBulkString:     true,
-		MarshalNoArrayHeaders: true,
+// numKeysCmd describes a command whose keys are given as a count followed by
+// that many keys, e.g. "LMPOP numkeys key [key ...] LEFT|RIGHT".
+type numKeysCmd struct {
+	// idx is the index of the numkeys argument within the command's
+	// arguments, which follows the timeout of blocking commands like BLMPOP.
+	// If dest is true then the argument at index 0 (e.g. the destination of
+	// ZUNIONSTORE) is a key as well.
+	idx  int
+	dest bool
+
+	// opts are the tokens which may directly follow the keys. If optsRequired
+	// is true then one of them must be present.
+	opts         []string
+	optsRequired bool
+
+	// zeroKeysOK is true for commands which accept a numkeys of 0, rather than
+	// requiring at least one key.
+	zeroKeysOK bool
+}
+
+var numKeysCmds = map[string]numKeysCmd{
+	"LMPOP":       {opts: []string{"LEFT", "RIGHT"}, optsRequired: true},
+	"ZMPOP":       {opts: []string{"MIN", "MAX"}, optsRequired: true},
+	"BLMPOP":      {idx: 1, opts: []string{"LEFT", "RIGHT"}, optsRequired: true},
+	"BZMPOP":      {idx: 1, opts: []string{"MIN", "MAX"}, optsRequired: true},
+	"SINTERCARD":  {opts: []string{"LIMIT"}},
+	"ZINTERCARD":  {opts: []string{"LIMIT"}},
+	"ZDIFF":       {opts: []string{"WITHSCORES"}},
+	"ZINTER":      {opts: []string{"WEIGHTS", "AGGREGATE", "WITHSCORES"}},
+	"ZUNION":      {opts: []string{"WEIGHTS", "AGGREGATE", "WITHSCORES"}},
+	"ZDIFFSTORE":  {idx: 1, dest: true},
+	"ZINTERSTORE": {idx: 1, dest: true, opts: []string{"WEIGHTS", "AGGREGATE"}, zeroKeysOK: true},
+	"ZUNIONSTORE": {idx: 1, dest: true, opts: []string{"WEIGHTS", "AGGREGATE"}, zeroKeysOK: true},
+}
+
+// numKeysCmdsByLen holds the names in numKeysCmds by their length, so that
+// lookupNumKeysCmd can compare against the few names which could match.
+var numKeysCmdsByLen = func() map[int][]string {
+	m := map[int][]string{}
+	for cmd := range numKeysCmds {
+		m[len(cmd)] = append(m[len(cmd)], cmd)
 	}
-	arrL := 2 + a.NumElems()
-	err = resp2.ArrayHeader{N: arrL}.MarshalRESP(w)
-	err = marshalBulkString(err, w, c.cmd)
-	err = marshalBulkString(err, w, c.flatKey[0])
-	if err != nil {
-		return err
+	return m
+}()
+
+// lookupNumKeysCmd returns the numKeysCmd for the given command name, which may
+// be in any case. Unlike upper-casing it first it never allocates, since it's
+// used by MarshalRESP for every command.
+func lookupNumKeysCmd(cmd string) (numKeysCmd, bool) {
+	if nk, ok := numKeysCmds[cmd]; ok {
+		return nk, true
+	}
+	for _, name := range numKeysCmdsByLen[len(cmd)] {
+		if strings.EqualFold(cmd, name) {
+			return numKeysCmds[name], true
+		}
 	}
-	return a.MarshalRESP(w)
+	return numKeysCmd{}, false
 }
 
-func (c *cmdAction) MarshalRESP(w io.Writer) error {
-	if c.flat {
-		return c.flatMarshalRESP(w)
+// extractNumKeys expects args to start with a numkeys argument, and returns
+// that many of the arguments following it. nil is returned if the numkeys
+// argument is invalid or there aren't enough arguments.
+func extractNumKeys(args []string) []string {
+	if len(args) == 0 {
+		return nil
 	}
-
-	err := resp2.ArrayHeader{N: len(c.args) + 1}.MarshalRESP(w)
-	err = marshalBulkString(err, w, c.cmd)
-	for i := range c.args {
-		err = marshalBulkString(err, w, c.args[i])
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n < 0 || n > len(args)-1 {
+		return nil
 	}
-	return err
+	return args[1 : 1+n]
 }
 
-func (c *cmdAction) UnmarshalRESP(br *bufio.Reader) error {
-	if err := (resp2.Any{I: c.rcv}).UnmarshalRESP(br); err != nil {
-		return err
+func (nk numKeysCmd) keys(args []string) []string {
+	var keys []string
+	if len(args) > nk.idx {
+		keys = extractNumKeys(args[nk.idx:])
 	}
-	cmdActionPool.Put(c)
-	return nil
+	if nk.dest && len(args) > 0 {
+		return append([]string{args[0]}, keys...)
+	}
+	return keys
 }
 
-func (c *cmdAction) Run(conn Conn) error {
-	if err := conn.Encode(c); err != nil {
-		return err
+// validate returns an error if the numkeys argument doesn't match up with the
+// keys which were actually given, as best as can be determined by looking at
+// the argument following the keys.
+func (nk numKeysCmd) validate(cmd string, args []string) error {
+	if len(args) <= nk.idx {
+		return xerrors.Errorf("%s is missing its numkeys argument", cmd)
 	}
-	return conn.Decode(c)
-}
+	args = args[nk.idx:]
 
-func (c *cmdAction) String() string {
-	return cmdString(c)
-}
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n < 0 || (n == 0 && !nk.zeroKeysOK) {
+		return xerrors.Errorf("%s has invalid numkeys argument %q", cmd, args[0])
+	} else if n > len(args)-1 {
+		return xerrors.Errorf("%s declares %d keys but only %d are given", cmd, n, len(args)-1)
+	}
 
-func (c *cmdAction) ClusterCanRetry() bool {
-	return true
+	rest := args[1+n:]
+	if len(rest) == 0 {
+		if nk.optsRequired {
+			return xerrors.Errorf("%s requires one of %v following its %d keys", cmd, nk.opts, n)
+		}
+		return nil
+	}
+	for _, opt := range nk.opts {
+		if strings.EqualFold(rest[0], opt) {
+			return nil
+		}
+	}
+	return xerrors.Errorf("%s declares %d keys but is followed by unexpected argument %q, numkeys may not match the number of keys given", cmd, n, rest[0])
 }
 
-////////////////////////////////////////////////////////////////////////////////
+// srcDstCmds are commands whose first two arguments are a source key and a
+// destination key, in either order. Any arguments following them, e.g. the DB
+// and REPLACE options of COPY, aren't keys.
+var srcDstCmds = map[string]bool{
+	"COPY":           true,
+	"RPOPLPUSH":      true,
+	"BRPOPLPUSH":     true,
+	"LMOVE":          true,
+	"BLMOVE":         true,
+	"SMOVE":          true,
+	"RENAME":         true,
+	"RENAMENX":       true,
+	"GEOSEARCHSTORE": true,
+	"ZRANGESTORE":    true,
+}
 
-// MaybeNil is a type which wraps a receiver. It will first detect if what's
-// being received is a nil RESP type (either bulk string or array), and if so
-// set Nil to true. If not the return value will be unmarshalled into Rcv
-// normally. If the response being received is an empty array then the EmptyArray
-// field will be set and Rcv unmarshalled into normally.
-type MaybeNil struct {
-	Nil        bool
-	EmptyArray bool
-	Rcv        interface{}
+// allKeysCmds are commands whose arguments are all keys.
+var allKeysCmds = map[string]bool{
+	"PFCOUNT": true,
+	"PFMERGE": true,
 }
 
-// UnmarshalRESP implements the method for the resp.Unmarshaler interface.
-func (mn *MaybeNil) UnmarshalRESP(br *bufio.Reader) error {
-	var rm resp2.RawMessage
-	err := rm.UnmarshalRESP(br)
-	switch {
-	case err != nil:
-		return err
-	case rm.IsNil():
-		mn.Nil = true
-		return nil
-	case rm.IsEmptyArray():
-		mn.EmptyArray = true
-		fallthrough // to not break backwards compatibility
-	default:
-		return rm.UnmarshalInto(resp2.Any{I: mn.Rcv})
-	}
+// KeySpec describes which of a command's arguments are keys, for use with
+// RegisterCommandKeys. FirstKey, LastKey and Step have the same meaning as in
+// the reply to the COMMAND command: positions count from 1, the command name
+// itself being at position 0, and a negative LastKey counts back from the last
+// argument, e.g. -1 means the last argument.
+//
+// If Func is set then it's used instead, and is given the command's arguments,
+// not including the command name. The slice it returns shouldn't be modified
+// afterwards.
+type KeySpec struct {
+	FirstKey, LastKey, Step int
+	Func                    func(args []string) []string
 }
 
-////////////////////////////////////////////////////////////////////////////////
+func (ks KeySpec) keys(args []string) []string {
+	if ks.Func != nil {
+		return ks.Func(args)
+	}
 
-// EvalScript contains the body of a script to be used with redis' EVAL
-// functionality. Call Cmd on a EvalScript to actually create an Action which
-// can be run.
-type EvalScript struct {
-	script, sum string
-	numKeys     int
-}
+	first, last := ks.FirstKey-1, ks.LastKey-1
+	if ks.LastKey < 0 {
+		last = len(args) + ks.LastKey
+	}
+	if last >= len(args) {
+		last = len(args) - 1
+	}
+	if ks.FirstKey <= 0 || first > last {
+		return nil
+	}
 
-// NewEvalScript initializes a EvalScript instance. numKeys corresponds to the
-// number of arguments which will be keys when Cmd is called
-func NewEvalScript(numKeys int, script string) EvalScript {
-	sumRaw := sha1.Sum([]byte(script))
-	sum := hex.EncodeToString(sumRaw[:])
-	return EvalScript{
-		script:  script,
-		sum:     sum,
-		numKeys: numKeys,
+	step := ks.Step
+	if step <= 1 {
+		return args[first : last+1]
+	}
+	keys := make([]string, 0, (last-first)/step+1)
+	for i := first; i <= last; i += step {
+		keys = append(keys, args[i])
 	}
+	return keys
 }
 
+// registeredKeySpecs holds a map[string]KeySpec which is replaced with an
+// updated copy, while holding registeredKeySpecsL, on every call to
+// RegisterCommandKeys. This keeps Keys, which Cluster calls for every command,
+// from needing to take a lock.
 var (
-	evalsha = []byte("EVALSHA")
-	eval    = []byte("EVAL")
+	registeredKeySpecsL sync.Mutex
+	registeredKeySpecs  atomic.Value
 )
 
-type evalAction struct {
-	EvalScript
-	args []string
-	rcv  interface{}
+// RegisterCommandKeys registers the KeySpec which the Keys method of a Cmd for
+// the given command (case-insensitive) will use to determine its keys. This
+// allows for commands which radix doesn't know about, e.g. those of modules,
+// to be routed correctly by Cluster. A registered KeySpec takes precedence
+// over radix's built-in handling of the command.
+//
+//	// MYMOD.COPY src dst [options...]
+//	radix.RegisterCommandKeys("MYMOD.COPY", radix.KeySpec{FirstKey: 1, LastKey: 2, Step: 1})
+//
+// RegisterCommandKeys is safe to call concurrently, but will generally be
+// called during initialization.
+func RegisterCommandKeys(cmd string, spec KeySpec) {
+	registeredKeySpecsL.Lock()
+	defer registeredKeySpecsL.Unlock()
+	old, _ := registeredKeySpecs.Load().(map[string]KeySpec)
+	m := make(map[string]KeySpec, len(old)+1)
+	for k, v := range old {
+		m[k] = v
+	}
+	m[strings.ToUpper(cmd)] = spec
+	registeredKeySpecs.Store(m)
+}
 
-	eval bool
+func registeredKeySpec(cmd string) (KeySpec, bool) {
+	m, _ := registeredKeySpecs.Load().(map[string]KeySpec)
+	ks, ok := m[cmd]
+	return ks, ok
 }
 
-// Cmd is like the top-level Cmd but it uses the the EvalScript to perform an
-// EVALSHA command (and will automatically fallback to EVAL as necessary). args
-// must be at least as long as the numKeys argument of NewEvalScript.
-func (es EvalScript) Cmd(rcv interface{}, args ...string) Action {
-	if len(args) < es.numKeys {
-		panic("not enough arguments passed into EvalScript.Cmd")
+// firstKey returns the first of the given arguments as the only key, or nil if
+// there are no arguments.
+func firstKey(args []string) []string {
+	if len(args) == 0 {
+		return nil
 	}
-	return &evalAction{
-		EvalScript: es,
-		args:       args,
-		rcv:        rcv,
+	return args[:1]
+}
+
+// secondKey returns the second of the given arguments as the only key, e.g. for
+// commands whose first argument is a sub-command, or nil if there isn't one.
+func secondKey(args []string) []string {
+	if len(args) < 2 {
+		return nil
 	}
+	return args[1:2]
 }
 
-func (ec *evalAction) Keys() []string {
-	return ec.args[:ec.numKeys]
+// keysFuncCmds are commands whose keys are found by a function of their
+// arguments, rather than by one of the tables of commands above.
+var keysFuncCmds = map[string]func(args []string) []string{
+	"BITOP": func(args []string) []string { // antirez why you do this
+		if len(args) > 1 {
+			return args[1:]
+		}
+		return firstKey(args)
+	},
+	"XINFO":  secondKey,
+	"OBJECT": secondKey,
+	"MEMORY": secondKey,
+	"XGROUP": func(args []string) []string {
+		if len(args) > 1 {
+			return args[1:2]
+		}
+		return firstKey(args)
+	},
+	"XREAD":      findStreamsKeys, // antirez why you still do this
+	"XREADGROUP": findStreamsKeys,
+	"SORT": func(args []string) []string {
+		if len(args) > 0 {
+			return findSortKeys(args)
+		}
+		return nil
+	},
+	"FCALL":    fcallKeys,
+	"FCALL_RO": fcallKeys,
 }
 
-func (ec *evalAction) MarshalRESP(w io.Writer) error {
-	// EVAL(SHA) script/sum numkeys args...
-	if err := (resp2.ArrayHeader{N: 3 + len(ec.args)}).MarshalRESP(w); err != nil {
-		return err
+func fcallKeys(args []string) []string {
+	if len(args) > 1 {
+		return extractNumKeys(args[1:])
 	}
+	return firstKey(args)
+}
 
-	var err error
-	if ec.eval {
-		err = marshalBulkStringBytes(err, w, eval)
-		err = marshalBulkString(err, w, ec.script)
-	} else {
-		err = marshalBulkStringBytes(err, w, evalsha)
-		err = marshalBulkString(err, w, ec.sum)
+// specialKeysCmds are all commands whose keys aren't simply their first
+// argument, so that Keys only needs a single lookup for the common case. It's
+// built from the same tables which Keys uses for those commands.
+var specialKeysCmds = func() map[string]bool {
+	m := map[string]bool{}
+	for cmd := range keysFuncCmds {
+		m[cmd] = true
+	}
+	for cmd := range numKeysCmds {
+		m[cmd] = true
+	}
+	for cmd := range srcDstCmds {
+		m[cmd] = true
+	}
+	for cmd := range allKeysCmds {
+		m[cmd] = true
+	}
+	for cmd := range geoRadiusCmds {
+		m[cmd] = true
+	}
+	for cmd := range noKeyCmds {
+		m[cmd] = true
 	}
+	return m
+}()
 
-	err = marshalBulkString(err, w, strconv.Itoa(ec.numKeys))
-	for i := range ec.args {
-		err = marshalBulkString(err, w, ec.args[i])
+func (c *cmdAction) Keys() []string {
+	if c.flat {
+		return c.flatKey[:]
 	}
-	return err
+
+	cmd := strings.ToUpper(c.cmd)
+	if ks, ok := registeredKeySpec(cmd); ok {
+		return ks.keys(c.args)
+	} else if !specialKeysCmds[cmd] {
+		return firstKey(c.args)
+	} else if fn, ok := keysFuncCmds[cmd]; ok {
+		return fn(c.args)
+	} else if nk, ok := numKeysCmds[cmd]; ok {
+		return nk.keys(c.args)
+	} else if srcDstCmds[cmd] && len(c.args) > 1 {
+		return c.args[:2]
+	} else if allKeysCmds[cmd] {
+		return c.args
+	} else if optsIdx, ok := geoRadiusCmds[cmd]; ok && len(c.args) > 0 {
+		return findGeoRadiusKeys(c.args, optsIdx)
+	} else if noKeyCmds[cmd] {
+		return nil
+	}
+	return firstKey(c.args)
 }
 
-func (ec *evalAction) Run(conn Conn) error {
-	run := func(eval bool) error {
-		ec.eval = eval
-		if err := conn.Encode(ec); err != nil {
-			return err
-		}
-		return conn.Decode(resp2.Any{I: ec.rcv})
+func (c *cmdAction) flatMarshalRESP(w io.Writer) error {
+	fa, err := newFlatArgs(c.flatArgs)
+	if err != nil {
+		return err
 	}
 
-	err := run(false)
-	if err != nil && strings.HasPrefix(err.Error(), "NOSCRIPT") {
-		err = run(true)
+	err = resp2.ArrayHeader{N: 2 + fa.n}.MarshalRESP(w)
+	err = marshalBulkString(err, w, c.cmd)
+	err = marshalBulkString(err, w, c.flatKey[0])
+	if err != nil {
+		return err
 	}
-	return err
+	return fa.MarshalRESP(w)
 }
 
-func (ec *evalAction) ClusterCanRetry() bool {
-	return true
-}
+// flatArgs marshals the arguments of a FlatCmd, without an array header.
+type flatArgs struct {
+	args []interface{}
 
-////////////////////////////////////////////////////////////////////////////////
+	// the MarshalRESP output of any args which are resp.Marshalers, by index.
+	// This is nil if there are none.
+	marshaled [][]byte
 
-type pipeline []CmdAction
+	// the number of RESP messages the args marshal as
+	n int
+}
+
+func newFlatArgs(args []interface{}) (flatArgs, error) {
+	fa := flatArgs{args: args}
+	for i, arg := range args {
+		// a nil pointer is skipped by Any, even if it's a resp.Marshaler
+		m, ok := arg.(resp.Marshaler)
+		if v := reflect.ValueOf(arg); !ok || (v.Kind() == reflect.Ptr && v.IsNil()) {
+			fa.n += (resp2.Any{I: arg, MarshalNoArrayHeaders: true}).NumElems()
+			continue
+		} else if fa.marshaled == nil {
+			fa.marshaled = make([][]byte, len(args))
+		}
+
+		// a resp.Marshaler may write any number of messages, and the only way
+		// to know how many is to marshal it.
+		buf := new(bytes.Buffer)
+		if err := m.MarshalRESP(buf); err != nil {
+			return flatArgs{}, err
+		}
+		msgs, err := splitCmds(buf.Bytes())
+		if err != nil {
+			return flatArgs{}, xerrors.Errorf("parsing output of %T's MarshalRESP: %w", arg, err)
+		}
+
+		// each message becomes an argument of the command, so they can't be
+		// arrays or other aggregate types, which would make the command
+		// malformed
+		for _, msg := range msgs {
+			switch msg[0] {
+			case resp2.BulkStringPrefix[0], resp2.SimpleStringPrefix[0], resp2.IntPrefix[0]:
+			default:
+				return flatArgs{}, xerrors.Errorf("%T's MarshalRESP wrote %q, but only bulk strings, simple strings and integers can be used as arguments", arg, msg)
+			}
+		}
+		fa.marshaled[i] = buf.Bytes()
+		fa.n += len(msgs)
+	}
+	return fa, nil
+}
+
+func (fa flatArgs) MarshalRESP(w io.Writer) error {
+	a := resp2.Any{MarshalBulkString: true, MarshalNoArrayHeaders: true}
+	if fa.marshaled == nil {
+		a.I = fa.args
+		return a.MarshalRESP(w)
+	}
+
+	for i, arg := range fa.args {
+		if b := fa.marshaled[i]; b != nil {
+			if _, err := w.Write(b); err != nil {
+				return err
+			}
+			continue
+		}
+
+		a.I = arg
+		if err := a.MarshalRESP(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateCmdName returns an error if the given command name is empty or
+// contains any whitespace or control characters, none of which are valid in the
+// name of a redis command.
+func validateCmdName(cmd string) error {
+	if cmd == "" {
+		return xerrors.New("command name is empty")
+	}
+	for _, r := range cmd {
+		if unicode.IsSpace(r) || unicode.IsControl(r) {
+			return xerrors.Errorf("command name %q contains whitespace or control characters", cmd)
+		}
+	}
+	return nil
+}
+
+func (c *cmdAction) MarshalRESP(w io.Writer) error {
+	if c.err != nil {
+		return c.err
+	} else if err := validateCmdName(c.cmd); err != nil {
+		return err
+	} else if c.flat {
+		return c.flatMarshalRESP(w)
+	} else if nk, ok := lookupNumKeysCmd(c.cmd); ok {
+		if err := nk.validate(c.cmd, c.args); err != nil {
+			return err
+		}
+	}
+
+	err := resp2.ArrayHeader{N: len(c.args) + 1}.MarshalRESP(w)
+	err = marshalBulkString(err, w, c.cmd)
+	for i := range c.args {
+		err = marshalBulkString(err, w, c.args[i])
+	}
+	return err
+}
+
+func (c *cmdAction) UnmarshalRESP(br *bufio.Reader) error {
+	return c.unmarshalRESP(context.Background(), br)
+}
+
+// unmarshalRESP unmarshals the reply into the receiver, using the given Context
+// for any blocking which the receiver may need to do.
+func (c *cmdAction) unmarshalRESP(ctx context.Context, br *bufio.Reader) error {
+	var err error
+	if wa, ok := c.rcv.(withAttributes); ok {
+		// Any would discard the attributes before wa could see them
+		err = wa.UnmarshalRESP(br)
+	} else if chV, ok := chanRcv(c.rcv); ok {
+		err = unmarshalChan(ctx, br, chV)
+	} else {
+		err = (resp2.Any{I: c.rcv}).UnmarshalRESP(br)
+	}
+	if err != nil {
+		return err
+	}
+	cmdActionPool.Put(c)
+	return nil
+}
+
+func (c *cmdAction) attributes() *map[string]interface{} {
+	if wa, ok := c.rcv.(withAttributes); ok {
+		return wa.attrs
+	}
+	return nil
+}
+
+func (c *cmdAction) Run(conn Conn) error {
+	if err := conn.Encode(c); err != nil {
+		return err
+	}
+	return conn.Decode(c)
+}
+
+func (c *cmdAction) String() string {
+	return cmdString(c)
+}
+
+// IsApplicationError returns true if the given error is, or wraps, an error
+// reply from redis, e.g. WRONGTYPE, in which case it can be retrieved using
+// errors.As with a *resp2.Error. Such errors leave the connection usable, and
+// retrying the command as-is will generally result in the same error.
+//
+// Errors which occur while writing to or reading from the connection, e.g.
+// network errors, aren't application errors, nor are errors which occur when
+// unmarshaling a reply into its receiver.
+func IsApplicationError(err error) bool {
+	return xerrors.As(err, new(resp2.Error))
+}
+
+func (c *cmdAction) Cmd() string {
+	return c.cmd
+}
+
+func (c *cmdAction) Args() []string {
+	if !c.flat {
+		return c.args
+	} else if c.flatArgsStr == nil {
+		ss, err := flattenArgs(&c.flatArgs)
+		if err != nil {
+			return nil
+		}
+		c.flatArgsStr = append([]string{c.flatKey[0]}, ss...)
+	}
+	return c.flatArgsStr
+}
+
+// flattenArgs returns the strings which the given FlatCmd arguments will be
+// marshaled as. Since a resp.LenReader can only be read once, any which are
+// given are read fully and *args is replaced with a copy in which they're
+// substituted by the bytes which were read, so that the marshaled arguments are
+// the same as they would have been.
+func flattenArgs(args *[]interface{}) ([]string, error) {
+	copied := false
+	for i, arg := range *args {
+		lr, ok := arg.(resp.LenReader)
+		if !ok {
+			continue
+		} else if !copied {
+			*args = append([]interface{}(nil), *args...)
+			copied = true
+		}
+
+		b, err := ioutil.ReadAll(lr)
+		if err != nil {
+			return nil, err
+		}
+		(*args)[i] = b
+	}
+	return Flatten(*args...)
+}
+
+// flattenString returns the string which the given argument is flattened into,
+// for the common types which are always flattened into exactly one string, in
+// the same way as resp2.Any would marshal them. false is returned for any other
+// type.
+func flattenString(arg interface{}) (string, bool) {
+	switch at := arg.(type) {
+	case string:
+		return at, true
+	case []byte:
+		return string(at), true
+	case bool:
+		if at {
+			return "1", true
+		}
+		return "0", true
+	case nil:
+		return "", true
+	case float32:
+		return strconv.FormatFloat(float64(at), 'f', -1, 32), true
+	case float64:
+		return strconv.FormatFloat(at, 'f', -1, 64), true
+	case int:
+		return strconv.FormatInt(int64(at), 10), true
+	case int8:
+		return strconv.FormatInt(int64(at), 10), true
+	case int16:
+		return strconv.FormatInt(int64(at), 10), true
+	case int32:
+		return strconv.FormatInt(int64(at), 10), true
+	case int64:
+		return strconv.FormatInt(at, 10), true
+	case uint, uint8, uint16, uint32, uint64:
+		// resp2.Any converts these to an int64 too
+		return strconv.FormatInt(bytesutil.AnyIntToInt64(at), 10), true
+	}
+	return "", false
+}
+
+// Flatten returns the strings which the given arguments would be sent as if
+// they were passed to FlatCmd, following the same flattening rules: slices and
+// maps are expanded into their elements (and keys), numbers are formatted as
+// strings, and so on. See FlatCmd for the full details.
+//
+// The result can be passed to Cmd, which allows the flattening to be done once
+// for arguments which are used in multiple commands, and allows arguments to be
+// validated before any command is sent. Any resp.LenReaders given are read
+// fully.
+func Flatten(args ...interface{}) ([]string, error) {
+	ss := make([]string, 0, len(args))
+	var buf *bytes.Buffer
+	for _, arg := range args {
+		if s, ok := flattenString(arg); ok {
+			ss = append(ss, s)
+			continue
+		} else if buf == nil {
+			buf = new(bytes.Buffer)
+		}
+
+		// anything else is marshaled as it would be sent, and then read back
+		fa, err := newFlatArgs([]interface{}{arg})
+		if err != nil {
+			return nil, err
+		}
+		buf.Reset()
+		if err := (resp2.ArrayHeader{N: fa.n}).MarshalRESP(buf); err != nil {
+			return nil, err
+		} else if err := fa.MarshalRESP(buf); err != nil {
+			return nil, err
+		}
+
+		var argSS []string
+		if err := resp2.RawMessage(buf.Bytes()).UnmarshalInto(resp2.Any{I: &argSS}); err != nil {
+			return nil, err
+		}
+		ss = append(ss, argSS...)
+	}
+	return ss, nil
+}
+
+// EmptyIfNil returns v, unless v is nil or a nil pointer, in which case it
+// returns the empty string. It can be used with FlatCmd to send an empty string
+// argument for a nil pointer, rather than skipping it.
+func EmptyIfNil(v interface{}) interface{} {
+	if rv := reflect.ValueOf(v); !rv.IsValid() || (rv.Kind() == reflect.Ptr && rv.IsNil()) {
+		return ""
+	}
+	return v
+}
+
+type timeUnit struct {
+	v    interface{}
+	unit time.Duration
+}
+
+// Seconds wraps a time.Time or time.Duration so that, when given as an argument
+// to FlatCmd, it's sent as an integer number of seconds. A time.Time is sent as
+// a Unix timestamp, as expected by EXPIREAT, and a time.Duration as a number of
+// whole seconds, as expected by EXPIRE:
+//
+//	err := client.Do(radix.FlatCmd(nil, "EXPIREAT", key, radix.Seconds(deadline)))
+//
+// A time.Duration which isn't zero but is less than a second, and so would be
+// sent as 0, results in an error when the command is marshaled, as does any
+// other type of value.
+func Seconds(v interface{}) encoding.TextMarshaler {
+	return timeUnit{v: v, unit: time.Second}
+}
+
+// Millis is like Seconds, but the value is sent as an integer number of
+// milliseconds, as expected by commands like PEXPIRE and PEXPIREAT.
+func Millis(v interface{}) encoding.TextMarshaler {
+	return timeUnit{v: v, unit: time.Millisecond}
+}
+
+func (tu timeUnit) MarshalText() ([]byte, error) {
+	switch v := tu.v.(type) {
+	case time.Time:
+		return strconv.AppendInt(nil, v.UnixNano()/int64(tu.unit), 10), nil
+	case time.Duration:
+		if v != 0 && v/tu.unit == 0 {
+			// e.g. EXPIRE 0 would delete the key rather than expire it soon
+			return nil, xerrors.Errorf("time.Duration %v is less than %v, and would be sent as 0", v, tu.unit)
+		}
+		return strconv.AppendInt(nil, int64(v/tu.unit), 10), nil
+	}
+	return nil, xerrors.Errorf("expected time.Time or time.Duration, got %T", tu.v)
+}
+
+func (c *cmdAction) ClusterCanRetry() bool {
+	return true
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+type cmdCtxAction struct {
+	*cmdAction
+	ctx context.Context
+}
+
+// CmdCtx is like Cmd, but the returned CmdAction will stop waiting for its
+// reply once the given Context is cancelled or its deadline passes. This is
+// primarily useful for blocking commands, e.g. BLPOP or XREAD with BLOCK 0,
+// which might otherwise wait indefinitely. When this happens the Context's
+// error is returned, wrapped, and can be checked for using errors.Is.
+//
+// If the Context is already done when the Action is performed then nothing is
+// sent. Otherwise, since a cancelled command's reply may still be sent by
+// redis at some later point, the connection is left in an unknown state and so
+// is closed. When performed through a Pool this causes the connection to be
+// discarded from the Pool rather than reused.
+//
+// The Context is only checked by the Action's Run method, so it has no effect
+// when the CmdAction is used within a Pipeline.
+func CmdCtx(ctx context.Context, rcv interface{}, cmd string, args ...string) CmdAction {
+	return &cmdCtxAction{
+		cmdAction: Cmd(rcv, cmd, args...).(*cmdAction),
+		ctx:       ctx,
+	}
+}
+
+func (c *cmdCtxAction) UnmarshalRESP(br *bufio.Reader) error {
+	return c.cmdAction.unmarshalRESP(c.ctx, br)
+}
+
+func (c *cmdCtxAction) Run(conn Conn) error {
+	if err := c.ctx.Err(); err != nil {
+		return xerrors.Errorf("performing %s: %w", c.cmd, err)
+	} else if c.ctx.Done() == nil {
+		// the Context can never be cancelled
+		return c.cmdAction.Run(conn)
+	}
+
+	if err := conn.Encode(c); err != nil {
+		return err
+	}
+
+	// the cmdAction may be returned to its pool by Decode, so its fields can't
+	// be used afterwards
+	cmd := c.cmd
+
+	interrupted, err := interruptible(c.ctx, conn, func() error {
+		return conn.Decode(c)
+	})
+	if interrupted && err != nil {
+		return xerrors.Errorf("performing %s: %w", cmd, c.ctx.Err())
+	}
+	return err
+}
+
+// interruptible calls fn, which reads from or writes to conn. If ctx is done
+// before fn returns then the underlying net.Conn is closed, which interrupts
+// whatever read or write is blocked on it, and true is returned.
+//
+// In that case the Conn itself is closed too, in case it wraps the net.Conn
+// and tracks whether it's still usable, as Pool's connections do. fn may still
+// have succeeded, if it completed before the net.Conn was closed.
+func interruptible(ctx context.Context, conn Conn, fn func() error) (bool, error) {
+	stopCh, interruptedCh := make(chan struct{}), make(chan bool, 1)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.NetConn().Close()
+			interruptedCh <- true
+		case <-stopCh:
+			interruptedCh <- false
+		}
+	}()
+
+	err := fn()
+	close(stopCh)
+	interrupted := <-interruptedCh
+	if interrupted {
+		conn.Close()
+	}
+	return interrupted, err
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+type replyHook struct {
+	CmdAction
+	cmd string
+	fn  func(cmd string, bytes int)
+}
+
+// WithReplyHook wraps the given CmdAction so that fn is called with the name of
+// the command, e.g. "GET", and the size in bytes of its reply each time a reply
+// is read for it, before the reply is unmarshaled into the CmdAction. This is
+// useful for collecting metrics without wrapping the Conn. The name is only
+// known for CmdActions which implement CmdInfo, otherwise it's empty.
+//
+// fn is called for error replies as well, but not if the reply couldn't be read
+// at all, e.g. due to a network error.
+//
+// In order to measure the reply it's first read into a resp2.RawMessage, and
+// then unmarshaled from there, so using WithReplyHook incurs an extra
+// allocation and copy of the reply each time the CmdAction is performed.
+//
+// The wrapped CmdAction's Run method is still used, so e.g. a CmdCtx can still
+// be cancelled, and fn is called for every reply which it reads. All other
+// methods are delegated to the wrapped CmdAction, so the result can be
+// used anywhere the CmdAction could be, including in a Pipeline.
+func WithReplyHook(cmd CmdAction, fn func(cmd string, bytes int)) CmdAction {
+	rh := replyHook{CmdAction: cmd, fn: fn}
+	if ci, ok := cmd.(CmdInfo); ok {
+		rh.cmd = ci.Cmd()
+	}
+	return rh
+}
+
+func (rh replyHook) Run(c Conn) error {
+	return rh.CmdAction.Run(&replyHookConn{Conn: c, rh: rh})
+}
+
+func (rh replyHook) UnmarshalRESP(br *bufio.Reader) error {
+	var rm resp2.RawMessage
+	if err := rm.UnmarshalRESP(br); err != nil {
+		return err
+	}
+	rh.fn(rh.cmd, len(rm))
+	return rm.UnmarshalInto(rh.CmdAction)
+}
+
+// replyHookConn is the Conn which a replyHook's CmdAction is run with, so that
+// any behavior of that CmdAction's Run method (e.g. a Context) is kept, while
+// each reply it decodes is measured first.
+type replyHookConn struct {
+	Conn
+	rh replyHook
+}
+
+func (rhc *replyHookConn) Do(a Action) error {
+	return a.Run(rhc)
+}
+
+func (rhc *replyHookConn) Decode(u resp.Unmarshaler) error {
+	var rm resp2.RawMessage
+	if err := rhc.Conn.Decode(&rm); err != nil {
+		return err
+	}
+	rhc.rh.fn(rhc.rh.cmd, len(rm))
+	return rm.UnmarshalInto(u)
+}
+
+func (rh replyHook) ClusterCanRetry() bool {
+	ccra, ok := rh.CmdAction.(ClusterCanRetryAction)
+	return ok && ccra.ClusterCanRetry()
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// MaybeNil is a type which wraps a receiver. It will first detect if what's
+// being received is a nil RESP type (either bulk string or array), and if so
+// set Nil to true. If not the return value will be unmarshalled into Rcv
+// normally. If the response being received is an empty array then the EmptyArray
+// field will be set and Rcv unmarshalled into normally.
+//
+// NilBulk or NilArray is also set, depending on which of the nil types was
+// received. The RESP3 null is treated as a nil bulk string.
+type MaybeNil struct {
+	Nil        bool
+	NilBulk    bool
+	NilArray   bool
+	EmptyArray bool
+	Rcv        interface{}
+}
+
+// UnmarshalRESP implements the method for the resp.Unmarshaler interface.
+func (mn *MaybeNil) UnmarshalRESP(br *bufio.Reader) error {
+	var rm resp2.RawMessage
+	err := rm.UnmarshalRESP(br)
+	switch {
+	case err != nil:
+		return err
+	case rm.IsNilBulkString():
+		mn.Nil, mn.NilBulk = true, true
+		return nil
+	case rm.IsNilArray():
+		mn.Nil, mn.NilArray = true, true
+		return nil
+	case rm.IsEmptyArray():
+		mn.EmptyArray = true
+		fallthrough // to not break backwards compatibility
+	default:
+		return rm.UnmarshalInto(resp2.Any{I: mn.Rcv})
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+type stream func(resp2.RawMessage) error
+
+// Stream returns a receiver which unmarshals an array reply by calling fn with
+// each of its elements, in order, as they're read off the connection. Only a
+// single element is held in memory at a time, which makes this useful for very
+// large replies, e.g. LRANGE over a long list:
+//
+//	var n int
+//	err := client.Do(radix.Cmd(radix.Stream(func(rm resp2.RawMessage) error {
+//		var s string
+//		if err := rm.UnmarshalInto(resp2.Any{I: &s}); err != nil {
+//			return err
+//		}
+//		n += len(s)
+//		return nil
+//	}), "LRANGE", key, "0", "-1"))
+//
+// The RawMessage given to fn is only valid until fn returns, it must be copied
+// if it's to be retained. A nil array reply results in fn never being called.
+//
+// If fn returns an error then it isn't called again, the remaining elements are
+// discarded, and the error is returned wrapped in a resp.ErrDiscarded.
+func Stream(fn func(resp2.RawMessage) error) resp.Unmarshaler {
+	return stream(fn)
+}
+
+func (s stream) UnmarshalRESP(br *bufio.Reader) error {
+	var ah resp2.ArrayHeader
+	if err := ah.UnmarshalRESP(br); err != nil {
+		return err
+	}
+
+	var rm resp2.RawMessage
+	var fnErr error
+	for i := 0; i < ah.N; i++ {
+		if err := rm.UnmarshalRESP(br); err != nil {
+			return err
+		} else if fnErr != nil {
+			continue
+		} else if err := s(rm); err != nil {
+			fnErr = resp.ErrDiscarded{Err: err}
+		}
+	}
+	return fnErr
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+type count struct {
+	n *int
+}
+
+// Count returns a receiver which unmarshals the number of elements in an array
+// reply into n, discarding the elements themselves without allocating them.
+// This is useful when only the size of a reply is needed, e.g. when polling
+// the size of a set using SMEMBERS. A nil array is counted as having zero
+// elements.
+//
+// If the reply isn't an array then an error is returned and n is left
+// untouched.
+func Count(n *int) resp.Unmarshaler {
+	return count{n: n}
+}
+
+func (c count) UnmarshalRESP(br *bufio.Reader) error {
+	var ah resp2.ArrayHeader
+	if err := ah.UnmarshalRESP(br); err != nil {
+		return err
+	}
+
+	for i := 0; i < ah.N; i++ {
+		if err := (resp2.Any{}).UnmarshalRESP(br); err != nil {
+			return err
+		}
+	}
+
+	if *c.n = ah.N; ah.N < 0 {
+		*c.n = 0
+	}
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+type into struct {
+	rcv       interface{}
+	setFields *[]string
+}
+
+// Into returns a receiver which unmarshals a reply into rcv normally, while
+// recording into setFields the names of the struct fields which were set from
+// the reply, when rcv is a pointer to a struct. This allows for distinguishing
+// fields which were absent from the reply from those which were present but
+// had a zero value, e.g. when merging partial updates read using HGETALL:
+//
+//	var user User
+//	var set []string
+//	err := client.Do(radix.Cmd(radix.Into(&user, &set), "HGETALL", key))
+//
+// setFields is reset each time the receiver is used. Field names are those of
+// the go struct, regardless of any redis tags, and fields which aren't present
+// in the reply are left untouched, as with any other struct receiver. A field
+// which is given more than once in the reply is listed once for each time it
+// was set. See resp2.Any's UnmarshalSetFields field for more.
+//
+// If setFields is nil then the receiver behaves exactly like rcv would on its
+// own.
+func Into(rcv interface{}, setFields *[]string) resp.Unmarshaler {
+	return into{rcv: rcv, setFields: setFields}
+}
+
+func (i into) UnmarshalRESP(br *bufio.Reader) error {
+	if i.setFields != nil {
+		*i.setFields = (*i.setFields)[:0]
+	}
+	return resp2.Any{I: i.rcv, UnmarshalSetFields: i.setFields}.UnmarshalRESP(br)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// chanRcv returns the reflect.Value of rcv if it's a channel which can be sent
+// to.
+func chanRcv(rcv interface{}) (reflect.Value, bool) {
+	if rcv == nil {
+		return reflect.Value{}, false
+	} else if t := reflect.TypeOf(rcv); t.Kind() != reflect.Chan || t.ChanDir()&reflect.SendDir == 0 {
+		return reflect.Value{}, false
+	}
+	return reflect.ValueOf(rcv), true
+}
+
+// unmarshalChan unmarshals each element of an array reply into the element type
+// of the channel chV, sending each to it in turn, and closing it once done.
+// Elements which can't be unmarshaled aren't sent, and the first of their
+// errors is returned once the rest of the reply has been read.
+func unmarshalChan(ctx context.Context, br *bufio.Reader, chV reflect.Value) error {
+	defer chV.Close()
+
+	var ah resp2.ArrayHeader
+	if err := ah.UnmarshalRESP(br); err != nil {
+		return err
+	}
+
+	cases := []reflect.SelectCase{
+		{Dir: reflect.SelectSend, Chan: chV},
+		{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())},
+	}
+
+	elemT := chV.Type().Elem()
+	var firstErr error
+	for i := 0; i < ah.N; i++ {
+		elem := reflect.New(elemT)
+		err := (resp2.Any{I: elem.Interface()}).UnmarshalRESP(br)
+		if err != nil && !xerrors.As(err, new(resp.ErrDiscarded)) {
+			return err
+		} else if err != nil {
+			if firstErr == nil {
+				firstErr = resp.ErrDiscarded{
+					Err: xerrors.Errorf("unmarshaling element %d: %w", i, err),
+				}
+			}
+			continue
+		}
+
+		if cases[0].Send = elem.Elem(); ctx.Done() == nil {
+			chV.Send(cases[0].Send)
+		} else if chosen, _, _ := reflect.Select(cases); chosen == 1 {
+			// the rest of the reply hasn't been read, so the connection is in an
+			// unknown state
+			return ctx.Err()
+		}
+	}
+	return firstErr
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// attributeReceiver is implemented by Unmarshalers which want the RESP3
+// attributes preceding a reply, which Conn implementations would otherwise
+// discard before calling UnmarshalRESP. If attributes returns non-nil then any
+// attributes are stored into it.
+type attributeReceiver interface {
+	attributes() *map[string]interface{}
+}
+
+func receiverAttributes(u resp.Unmarshaler) *map[string]interface{} {
+	if ar, ok := u.(attributeReceiver); ok {
+		return ar.attributes()
+	}
+	return nil
+}
+
+// storeAttributes stores the key/value pairs of attr into dst, creating the map
+// if it's nil.
+func storeAttributes(dst *map[string]interface{}, attr map[string]interface{}) {
+	if *dst == nil {
+		*dst = make(map[string]interface{}, len(attr))
+	}
+	for k, v := range attr {
+		(*dst)[k] = v
+	}
+}
+
+type withAttributes struct {
+	rcv   interface{}
+	attrs *map[string]interface{}
+}
+
+// WithAttributes returns a receiver which unmarshals a reply into rcv normally,
+// while storing the key/value pairs of any RESP3 attributes which precede the
+// reply into attrs. Attributes carry out-of-band metadata about a reply, and
+// are only sent to connections which have been switched to RESP3. The map is
+// created if it's nil, and is left untouched if the reply has no attributes,
+// so a fresh map should generally be given each time:
+//
+//	var val string
+//	var attrs map[string]interface{}
+//	err := client.Do(radix.Cmd(radix.WithAttributes(&val, &attrs), "GET", key))
+//
+// Each attribute is unmarshaled as a map[string]interface{} would be using
+// resp2.Any. If multiple attributes precede a reply then they're all stored
+// into attrs, with later keys overwriting earlier ones. Attributes are still
+// passed to the callback given by DialOnAttribute as well, if any.
+func WithAttributes(rcv interface{}, attrs *map[string]interface{}) resp.Unmarshaler {
+	return withAttributes{rcv: rcv, attrs: attrs}
+}
+
+func (wa withAttributes) attributes() *map[string]interface{} {
+	return wa.attrs
+}
+
+func (wa withAttributes) UnmarshalRESP(br *bufio.Reader) error {
+	for {
+		if b, err := br.Peek(1); err != nil {
+			return err
+		} else if b[0] != resp2.AttributePrefix[0] {
+			break
+		}
+
+		var attr map[string]interface{}
+		if err := (resp2.Attribute{I: &attr}).UnmarshalRESP(br); err != nil {
+			return err
+		}
+		storeAttributes(wa.attrs, attr)
+	}
+	return resp2.Any{I: wa.rcv}.UnmarshalRESP(br)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// ReplyErrors is a type which wraps a receiver. If what's being received is a
+// simple or bulk string, and that string is one of the keys of Errs, then the
+// corresponding error is returned and Rcv is left untouched. Otherwise the reply
+// is unmarshalled into Rcv normally.
+//
+// This is useful for lua scripts which return outcome codes, such as "LOCKED"
+// or "EXPIRED", which are more naturally handled as errors by the caller. The
+// returned error will be wrapped in a resp.ErrDiscarded, so errors.Is should be
+// used to check for it:
+//
+//	var ErrLocked = errors.New("resource is locked")
+//
+//	var res string
+//	rcv := radix.ReplyErrors{Rcv: &res, Errs: map[string]error{"LOCKED": ErrLocked}}
+//	err := client.Do(script.Cmd(&rcv, key))
+//	if errors.Is(err, ErrLocked) {
+//		// handle the resource being locked
+//	}
+//
+// Replies of any other type (integers, arrays, etc...) are never mapped to an
+// error, even if their string form matches one of the keys of Errs.
+type ReplyErrors struct {
+	Rcv  interface{}
+	Errs map[string]error
+}
+
+// UnmarshalRESP implements the method for the resp.Unmarshaler interface.
+func (re *ReplyErrors) UnmarshalRESP(br *bufio.Reader) error {
+	var rm resp2.RawMessage
+	if err := rm.UnmarshalRESP(br); err != nil {
+		return err
+	}
+
+	if len(rm) > 0 && !rm.IsNil() &&
+		(rm[0] == resp2.SimpleStringPrefix[0] || rm[0] == resp2.BulkStringPrefix[0]) {
+		var str string
+		if err := rm.UnmarshalInto(resp2.Any{I: &str}); err != nil {
+			return err
+		} else if err, ok := re.Errs[str]; ok {
+			return resp.ErrDiscarded{Err: err}
+		}
+	}
+	return rm.UnmarshalInto(resp2.Any{I: re.Rcv})
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+type tuple []interface{}
+
+// Tuple returns a receiver which unmarshals an array reply element by element,
+// the first element into the first of the given receivers, the second into the
+// second, and so on. This is useful for replies whose elements have fixed
+// positions but differing types, e.g. the summary form of XPENDING:
+//
+//	var count int64
+//	var lowest, highest string
+//	var consumers [][]string
+//	err := client.Do(radix.Cmd(
+//		radix.Tuple(&count, &lowest, &highest, &consumers),
+//		"XPENDING", "mystream", "mygroup",
+//	))
+//
+// Each element follows the same rules as for the receiver of Cmd, and a nil
+// receiver causes its element to be discarded. If the array doesn't have
+// exactly as many elements as there are receivers then an error is returned.
+func Tuple(rcvs ...interface{}) resp.Unmarshaler {
+	return tuple(rcvs)
+}
+
+func (t tuple) UnmarshalRESP(br *bufio.Reader) error {
+	var ah resp2.ArrayHeader
+	if err := ah.UnmarshalRESP(br); err != nil {
+		return err
+	} else if ah.N != len(t) {
+		for i := 0; i < ah.N; i++ {
+			if err := (resp2.Any{}).UnmarshalRESP(br); err != nil {
+				return err
+			}
+		}
+		return resp.ErrDiscarded{
+			Err: xerrors.Errorf("expected array of %d elements, got %d", len(t), ah.N),
+		}
+	}
+
+	var firstErr error
+	for i, rcv := range t {
+		err := (resp2.Any{I: rcv}).UnmarshalRESP(br)
+		if err == nil {
+			continue
+		} else if !xerrors.As(err, new(resp.ErrDiscarded)) {
+			return err
+		} else if firstErr == nil {
+			firstErr = resp.ErrDiscarded{
+				Err: xerrors.Errorf("unmarshaling element %d: %w", i, err),
+			}
+		}
+	}
+	return firstErr
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// DecodeFunc is a receiver which hands the raw reader off to the wrapped
+// function, which is then entirely responsible for unmarshaling the reply. It
+// is an escape hatch for replies which the other receivers don't handle:
+//
+//	rcv := radix.DecodeFunc(func(br *bufio.Reader) error {
+//		h, err := resp2.PeekHeader(br)
+//		...
+//	})
+//	err := client.Do(radix.Cmd(rcv, "SOME", "COMMAND"))
+//
+// The function MUST consume exactly one complete reply from the reader, no more
+// and no less, even if it returns an error. If it doesn't then the connection
+// will be left in an unknown state and every following reply read off of it
+// will be wrong.
+//
+// If the function returns an error, but has consumed the reply, then the error
+// should be wrapped in a resp.ErrDiscarded. Any other error is treated as the
+// connection having been broken, and the connection will be closed.
+type DecodeFunc func(br *bufio.Reader) error
+
+// UnmarshalRESP implements the method for the resp.Unmarshaler interface.
+func (df DecodeFunc) UnmarshalRESP(br *bufio.Reader) error {
+	return df(br)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// EvalScript contains the body of a script to be used with redis' EVAL
+// functionality. Call Cmd on a EvalScript to actually create an Action which
+// can be run.
+type EvalScript struct {
+	script, sum string
+	numKeys     int
+	trackLoaded bool
+	forceEval   bool
+	onNoScript  func(sum string)
+}
+
+// NewEvalScript initializes a EvalScript instance. numKeys corresponds to the
+// number of arguments which will be keys when Cmd is called
+func NewEvalScript(numKeys int, script string) EvalScript {
+	sumRaw := sha1.Sum([]byte(script))
+	sum := hex.EncodeToString(sumRaw[:])
+	return EvalScript{
+		script:  script,
+		sum:     sum,
+		numKeys: numKeys,
+	}
+}
+
+// TrackLoaded returns a copy of the EvalScript which keeps track of which
+// connections the script is known to have been loaded on. Normally EVALSHA is
+// always tried first, falling back to EVAL if redis replies with NOSCRIPT, which
+// costs an extra round-trip. With tracking enabled EVAL is used immediately on
+// any connection the script hasn't yet been performed on, and EVALSHA is only
+// used afterwards.
+//
+// This is useful when there are many connections, e.g. in a large Pool, which
+// are likely to be talking to instances which haven't seen the script. Tracking
+// is only possible for Conns created by this package, e.g. by Dial or by a Pool,
+// on other Conns the EvalScript behaves as normal. The tracked state belongs to
+// the connection itself, and so is discarded along with it.
+func (es EvalScript) TrackLoaded() EvalScript {
+	es.trackLoaded = true
+	return es
+}
+
+// ForceEval returns a copy of the EvalScript which always performs EVAL,
+// sending the whole script, rather than first trying EVALSHA. This is useful
+// for debugging, or when the server doesn't cache scripts, e.g. because SCRIPT
+// FLUSH is being performed frequently.
+func (es EvalScript) ForceEval() EvalScript {
+	es.forceEval = true
+	return es
+}
+
+// OnNoScriptFallback returns a copy of the EvalScript which calls fn, with the
+// script's SHA1 sum, whenever redis replies to EVALSHA with NOSCRIPT, just
+// before the script is retried using EVAL. This can be used to keep track of
+// how often that happens, which may indicate that scripts are being flushed or
+// that instances are failing over.
+//
+// fn may be called concurrently, and should return quickly.
+func (es EvalScript) OnNoScriptFallback(fn func(sum string)) EvalScript {
+	es.onNoScript = fn
+	return es
+}
+
+var (
+	evalsha = []byte("EVALSHA")
+	eval    = []byte("EVAL")
+)
+
+type evalAction struct {
+	EvalScript
+	args     []string
+	flatArgv []interface{}
+	rcv      interface{}
+
+	eval bool
+
+	// the result of Args, which is only filled on demand
+	argsStr []string
+}
+
+// Cmd is like the top-level Cmd but it uses the the EvalScript to perform an
+// EVALSHA command (and will automatically fallback to EVAL as necessary). args
+// must be at least as long as the numKeys argument of NewEvalScript.
+func (es EvalScript) Cmd(rcv interface{}, args ...string) Action {
+	if len(args) < es.numKeys {
+		panic("not enough arguments passed into EvalScript.Cmd")
+	}
+	return &evalAction{
+		EvalScript: es,
+		args:       args,
+		rcv:        rcv,
+		eval:       es.forceEval,
+	}
+}
+
+// CmdKV is like Cmd, but the keys and the arguments passed to the script are
+// given separately, and the arguments can be of almost any type, being
+// flattened in the same way as by FlatCmd. The number of keys given must match
+// the numKeys argument of NewEvalScript exactly, otherwise CmdKV panics. This
+// avoids mistakes where an argument ends up being treated as a key, or vice
+// versa.
+//
+//	script := radix.NewEvalScript(1, `return redis.call("SET", KEYS[1], ARGV[1], "EX", ARGV[2])`)
+//	err := client.Do(script.CmdKV(nil, []string{"foo"}, "bar", 10))
+func (es EvalScript) CmdKV(rcv interface{}, keys []string, argv ...interface{}) Action {
+	if len(keys) != es.numKeys {
+		panic(fmt.Sprintf("EvalScript.CmdKV expected %d keys, got %d", es.numKeys, len(keys)))
+	}
+	return &evalAction{
+		EvalScript: es,
+		args:       keys,
+		flatArgv:   argv,
+		rcv:        rcv,
+		eval:       es.forceEval,
+	}
+}
+
+// FlatCmd is like CmdKV, except that the number of keys given isn't checked
+// against the numKeys argument of NewEvalScript. Instead the number of keys
+// sent to redis is always len(keys), which makes it suitable for scripts which
+// take a variable number of keys. The returned Action's Keys method returns
+// exactly the given keys.
+//
+//	script := radix.NewEvalScript(0, `return redis.call("DEL", unpack(KEYS)) * ARGV[1]`)
+//	err := client.Do(script.FlatCmd(&n, []string{"foo", "bar"}, 2))
+func (es EvalScript) FlatCmd(rcv interface{}, keys []string, argv ...interface{}) Action {
+	es.numKeys = len(keys)
+	return &evalAction{
+		EvalScript: es,
+		args:       keys,
+		flatArgv:   argv,
+		rcv:        rcv,
+		eval:       es.forceEval,
+	}
+}
+
+func (ec *evalAction) Keys() []string {
+	return ec.args[:ec.numKeys]
+}
+
+func (ec *evalAction) MarshalRESP(w io.Writer) error {
+	// EVAL(SHA) script/sum numkeys args... flatArgv...
+	fa, err := newFlatArgs(ec.flatArgv)
+	if err != nil {
+		return err
+	} else if err := (resp2.ArrayHeader{N: 3 + len(ec.args) + fa.n}).MarshalRESP(w); err != nil {
+		return err
+	}
+
+	if ec.eval {
+		err = marshalBulkStringBytes(err, w, eval)
+		err = marshalBulkString(err, w, ec.script)
+	} else {
+		err = marshalBulkStringBytes(err, w, evalsha)
+		err = marshalBulkString(err, w, ec.sum)
+	}
+
+	err = marshalBulkString(err, w, strconv.Itoa(ec.numKeys))
+	for i := range ec.args {
+		err = marshalBulkString(err, w, ec.args[i])
+	}
+	if err != nil || len(ec.flatArgv) == 0 {
+		return err
+	}
+	return fa.MarshalRESP(w)
+}
+
+func (ec *evalAction) Cmd() string {
+	if ec.eval {
+		return "EVAL"
+	}
+	return "EVALSHA"
+}
+
+func (ec *evalAction) Args() []string {
+	if ec.argsStr == nil {
+		ec.argsStr = make([]string, 0, 2+len(ec.args))
+		ec.argsStr = append(ec.argsStr, "", strconv.Itoa(ec.numKeys))
+		ec.argsStr = append(ec.argsStr, ec.args...)
+		if len(ec.flatArgv) > 0 {
+			ss, err := flattenArgs(&ec.flatArgv)
+			if err != nil {
+				ec.argsStr = nil
+				return nil
+			}
+			ec.argsStr = append(ec.argsStr, ss...)
+		}
+	}
+
+	// the first argument depends on whether EVAL or EVALSHA is being used
+	ec.argsStr[0] = ec.sum
+	if ec.eval {
+		ec.argsStr[0] = ec.script
+	}
+	return ec.argsStr
+}
+
+// isNoScriptErr returns true if the given error is, or wraps, a NOSCRIPT error
+// reply from redis, indicating that EVALSHA was given the sum of a script which
+// isn't loaded.
+func isNoScriptErr(err error) bool {
+	var rerr resp2.Error
+	if !xerrors.As(err, &rerr) || rerr.E == nil {
+		return false
+	}
+	msg := rerr.E.Error()
+	return len(msg) >= len("NOSCRIPT") && strings.EqualFold(msg[:len("NOSCRIPT")], "NOSCRIPT")
+}
+
+func (ec *evalAction) Run(conn Conn) error {
+	run := func(eval bool) error {
+		ec.eval = eval
+		if err := conn.Encode(ec); err != nil {
+			return err
+		}
+		return conn.Decode(resp2.Any{I: ec.rcv})
+	}
+
+	var loaded *sync.Map
+	if ec.trackLoaded {
+		loaded = loadedScripts(conn)
+	}
+
+	useEval := ec.forceEval
+	if loaded != nil && !useEval {
+		_, ok := loaded.Load(ec.sum)
+		useEval = !ok
+	}
+
+	err := run(useEval)
+	if !useEval && isNoScriptErr(err) {
+		if ec.onNoScript != nil {
+			ec.onNoScript(ec.sum)
+		}
+		err = run(true)
+	}
+
+	// an application error, e.g. from redis.error_reply, still means the
+	// script was loaded
+	if loaded != nil && (err == nil || xerrors.As(err, new(resp.ErrDiscarded))) {
+		loaded.Store(ec.sum, struct{}{})
+	}
+	return err
+}
+
+func (ec *evalAction) ClusterCanRetry() bool {
+	return true
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+type pipeline []CmdAction
 
 // Pipeline returns an Action which first writes multiple commands to a Conn in
 // a single write, then reads their responses in a single read. This reduces
@@ -424,12 +2066,91 @@ type pipeline []CmdAction
 //
 // Run will not be called on any of the passed in CmdActions.
 //
+// If one of the CmdActions fails then the returned error will be a
+// PipelineError describing which one. The remaining replies are read and
+// discarded.
+//
 // NOTE that, while a Pipeline performs all commands on a single Conn, it
 // shouldn't be used by itself for MULTI/EXEC transactions, because if there's
 // an error it won't discard the incomplete transaction. Use WithConn or
 // EvalScript for transactional functionality instead.
+//
+// The returned Action should not be performed more than once. Once it has been
+// performed successfully it's returned to an internal pool to be reused by a
+// later call to Pipeline, and so must not be used further at all.
+//
+// To build up a Pipeline one command at a time use NewPipeline instead.
 func Pipeline(cmds ...CmdAction) Action {
-	return pipeline(cmds)
+	pb, _ := pipelinePool.Get().(*PipelineBuilder)
+	if pb == nil {
+		pb = &PipelineBuilder{cmds: make(pipeline, 0, len(cmds))}
+	}
+	pb.pooled = true
+	for _, cmd := range cmds {
+		pb.Append(cmd)
+	}
+	return pb
+}
+
+// pipelinePoolMaxCap is the largest number of CmdActions which a
+// PipelineBuilder returned to pipelinePool may have capacity for, so that a
+// single very large Pipeline doesn't pin a large slice in memory indefinitely.
+const pipelinePoolMaxCap = 1024
+
+var pipelinePool sync.Pool
+
+// PipelineBuilder is an Action which behaves like one returned from Pipeline,
+// but whose CmdActions are appended to it one at a time. This is useful when a
+// batch of commands is built up in a loop, or across multiple functions.
+//
+// A PipelineBuilder may be reused, e.g. via a sync.Pool, by calling Reset once
+// it has been performed. It isn't thread-safe.
+type PipelineBuilder struct {
+	cmds pipeline
+
+	// pooled is true if the PipelineBuilder was created by Pipeline, and so
+	// should be returned to pipelinePool once it's been performed.
+	pooled bool
+}
+
+// NewPipeline returns an empty PipelineBuilder.
+func NewPipeline() *PipelineBuilder {
+	return new(PipelineBuilder)
+}
+
+// Append adds the given CmdAction to the end of the Pipeline.
+func (pb *PipelineBuilder) Append(cmd CmdAction) {
+	pb.cmds = append(pb.cmds, cmd)
+}
+
+// Len returns the number of CmdActions which have been appended.
+func (pb *PipelineBuilder) Len() int {
+	return len(pb.cmds)
+}
+
+// Reset removes all CmdActions from the PipelineBuilder, so that it can be
+// reused. The memory used to hold them is retained, so subsequent batches of a
+// similar size won't need to allocate.
+func (pb *PipelineBuilder) Reset() {
+	for i := range pb.cmds {
+		pb.cmds[i] = nil
+	}
+	pb.cmds = pb.cmds[:0]
+}
+
+// Keys implements the method for the Action interface.
+func (pb *PipelineBuilder) Keys() []string {
+	return pb.cmds.Keys()
+}
+
+// Run implements the method for the Action interface.
+func (pb *PipelineBuilder) Run(c Conn) error {
+	err := pb.cmds.Run(c)
+	if err == nil && pb.pooled && cap(pb.cmds) <= pipelinePoolMaxCap {
+		pb.Reset()
+		pipelinePool.Put(pb)
+	}
+	return err
 }
 
 func (p pipeline) Keys() []string {
@@ -454,7 +2175,7 @@ func (p pipeline) Run(c Conn) error {
 	for i, cmd := range p {
 		if err := c.Decode(cmd); err != nil {
 			p.drain(c, len(p)-i-1)
-			return decodeErr(cmd, err)
+			return decodeErr(i, cmd, err)
 		}
 	}
 	return nil
@@ -467,19 +2188,38 @@ func (p pipeline) drain(c Conn, n int) {
 	}
 }
 
-func decodeErr(cmd CmdAction, err error) error {
-	c, ok := cmd.(*cmdAction)
-	if ok {
-		return xerrors.Errorf(
-			"failed to decode pipeline CmdAction '%v' with keys %v: %w",
-			c.cmd,
-			c.Keys(),
-			err)
+// PipelineError is returned from a Pipeline (and given by PipelineCollect)
+// when one of its CmdActions fails. It can be checked for using errors.As, and
+// wraps the CmdAction's original error, e.g. a resp2.Error returned by redis,
+// so that it can also be checked for using errors.As or errors.Is.
+type PipelineError struct {
+	// Index is the zero-based index of the failed CmdAction within the
+	// Pipeline.
+	Index int
+
+	// Cmd is the CmdAction which failed.
+	Cmd Action
+
+	// Err is the error the CmdAction failed with.
+	Err error
+}
+
+func (pe PipelineError) Error() string {
+	if c, ok := pe.Cmd.(*cmdAction); ok {
+		return fmt.Sprintf(
+			"failed to decode pipeline CmdAction %d '%v' with keys %v: %v",
+			pe.Index, c.cmd, c.Keys(), pe.Err)
 	}
-	return xerrors.Errorf(
-		"failed to decode pipeline CmdAction '%v': %w",
-		cmd,
-		err)
+	return fmt.Sprintf("failed to decode pipeline CmdAction %d '%v': %v", pe.Index, pe.Cmd, pe.Err)
+}
+
+// Unwrap implements the errors.Wrapper interface.
+func (pe PipelineError) Unwrap() error {
+	return pe.Err
+}
+
+func decodeErr(i int, cmd CmdAction, err error) error {
+	return PipelineError{Index: i, Cmd: cmd, Err: err}
 }
 
 // MarshalRESP implements the resp.Marshaler interface, so that the pipeline can
@@ -506,9 +2246,251 @@ func (p pipeline) MarshalRESP(w io.Writer) error {
 
 ////////////////////////////////////////////////////////////////////////////////
 
+type batchedPipeline struct {
+	pipeline
+	maxPerFlush int
+}
+
+// BatchedPipeline is like Pipeline, except that the commands are written in
+// batches of at most maxPerFlush commands, with the replies of each batch being
+// read before the next is written. All batches are performed on the same Conn,
+// in order. This bounds the memory used for buffering very large pipelines,
+// at the cost of one round-trip per batch.
+//
+// If one of the CmdActions fails then the returned error will be a
+// PipelineError describing which one, with its Index relative to all of the
+// given CmdActions. The remaining replies in its batch are read and discarded,
+// and the remaining batches aren't performed.
+//
+// BatchedPipeline panics if maxPerFlush is less than 1. Like Pipeline,
+// BatchedPipeline shouldn't be used for MULTI/EXEC transactions.
+func BatchedPipeline(maxPerFlush int, cmds ...CmdAction) Action {
+	if maxPerFlush < 1 {
+		panic(fmt.Sprintf("BatchedPipeline maxPerFlush must be at least 1, got %d", maxPerFlush))
+	}
+	return batchedPipeline{pipeline: pipeline(cmds), maxPerFlush: maxPerFlush}
+}
+
+func (p batchedPipeline) Run(c Conn) error {
+	for i := 0; i < len(p.pipeline); i += p.maxPerFlush {
+		end := i + p.maxPerFlush
+		if end > len(p.pipeline) {
+			end = len(p.pipeline)
+		}
+
+		err := p.pipeline[i:end].Run(c)
+		if pe := (PipelineError{}); xerrors.As(err, &pe) {
+			pe.Index += i
+			return pe
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+type pipelineCollect struct {
+	pipeline
+	errs *[]error
+}
+
+// PipelineCollect is like Pipeline, except that an error decoding the reply of
+// one of the commands (e.g. a WRONGTYPE error from redis, or a reply which
+// can't be unmarshaled into its receiver) won't cause the whole Action to fail.
+// Instead the rest of the replies are decoded as normal, and errs is set to a
+// slice containing the corresponding error, or nil, for each of the given
+// CmdActions.
+//
+// If an error occurs which prevents the replies from being read at all (e.g. a
+// network error) then that error is returned. In that case the error will be
+// set in errs for the CmdAction whose reply was being read, and for all of
+// those following it.
+//
+// Like Pipeline, PipelineCollect shouldn't be used for MULTI/EXEC
+// transactions.
+func PipelineCollect(errs *[]error, cmds ...CmdAction) Action {
+	return pipelineCollect{pipeline: pipeline(cmds), errs: errs}
+}
+
+func (p pipelineCollect) Run(c Conn) error {
+	errs := make([]error, len(p.pipeline))
+	*p.errs = errs
+
+	if err := c.Encode(p.pipeline); err != nil {
+		for i := range errs {
+			errs[i] = err
+		}
+		return err
+	}
+
+	for i, cmd := range p.pipeline {
+		err := c.Decode(cmd)
+		if err == nil {
+			continue
+		}
+
+		errs[i] = decodeErr(i, cmd, err)
+		if !xerrors.As(err, new(resp.ErrDiscarded)) {
+			// the reply wasn't fully read, so the connection is in an unknown
+			// state and none of the following replies can be read either.
+			for j := i + 1; j < len(errs); j++ {
+				errs[j] = err
+			}
+			return errs[i]
+		}
+	}
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// PipelineErrors is returned from a PipelineAll when one or more of its
+// CmdActions fail. It contains the error of each failed CmdAction, in the
+// order they were given, each of which is a PipelineError.
+type PipelineErrors []error
+
+func (pe PipelineErrors) Error() string {
+	msgs := make([]string, len(pe))
+	for i, err := range pe {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("%d pipeline CmdActions failed: %s", len(pe), strings.Join(msgs, "; "))
+}
+
+// Unwrap returns the errors of the failed CmdActions. This allows errors.Is
+// and errors.As to inspect each of them, as of go 1.20.
+func (pe PipelineErrors) Unwrap() []error {
+	return pe
+}
+
+type pipelineAll struct {
+	pipeline
+}
+
+// PipelineAll is like Pipeline, except that if multiple CmdActions fail then
+// all of their errors are returned, rather than only the first. This is useful
+// for batches of independent writes, where each failure needs to be reported.
+//
+// If any CmdActions fail then the returned error is a PipelineErrors,
+// containing a PipelineError for each one in order. As with PipelineCollect,
+// if an error occurs which prevents the remaining replies from being read at
+// all (e.g. a network error) then the PipelineErrors ends with that error.
+//
+// Like Pipeline, PipelineAll shouldn't be used for MULTI/EXEC transactions.
+func PipelineAll(cmds ...CmdAction) Action {
+	return pipelineAll{pipeline: pipeline(cmds)}
+}
+
+func (p pipelineAll) Run(c Conn) error {
+	var errs []error
+	err := pipelineCollect{pipeline: p.pipeline, errs: &errs}.Run(c)
+
+	var last int
+	var fatalErr PipelineError
+	if err == nil {
+		last = len(errs) - 1
+	} else if xerrors.As(err, &fatalErr) {
+		last = fatalErr.Index
+	} else {
+		return err // the pipeline couldn't be written
+	}
+
+	var pe PipelineErrors
+	for _, err := range errs[:last+1] {
+		if err != nil {
+			pe = append(pe, err)
+		}
+	}
+	if len(pe) == 0 {
+		return nil
+	}
+	return pe
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+type pipelineInto struct {
+	pipeline
+	dst interface{}
+}
+
+// PipelineInto is like Pipeline, except that the reply of each CmdAction is
+// unmarshaled into the corresponding exported field of the struct pointed to by
+// dst, in the order the fields are declared, rather than into the CmdAction's
+// own receiver, which is ignored. Each field is unmarshaled into following the
+// same rules as for any receiver.
+//
+//	var res struct {
+//		Name   string
+//		Visits int
+//		Tags   []string
+//	}
+//	err := client.Do(radix.PipelineInto(&res,
+//		radix.Cmd(nil, "GET", "user:1:name"),
+//		radix.Cmd(nil, "INCR", "user:1:visits"),
+//		radix.Cmd(nil, "SMEMBERS", "user:1:tags"),
+//	))
+//
+// An error is returned, without any commands being written, if dst isn't a
+// pointer to a struct or if its number of exported fields doesn't match the
+// number of CmdActions. Otherwise errors are returned as for Pipeline.
+func PipelineInto(dst interface{}, cmds ...CmdAction) Action {
+	return pipelineInto{pipeline: pipeline(cmds), dst: dst}
+}
+
+// pipelineIntoFields returns pointers to the exported fields of the struct
+// pointed to by dst.
+func pipelineIntoFields(dst interface{}) ([]interface{}, error) {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return nil, xerrors.Errorf("PipelineInto dst must be a non-nil pointer to a struct, got %T", dst)
+	}
+
+	v = v.Elem()
+	var fields []interface{}
+	for i := 0; i < v.NumField(); i++ {
+		if v.Type().Field(i).PkgPath != "" {
+			continue // unexported
+		}
+		fields = append(fields, v.Field(i).Addr().Interface())
+	}
+	return fields, nil
+}
+
+func (p pipelineInto) Run(c Conn) error {
+	fields, err := pipelineIntoFields(p.dst)
+	if err != nil {
+		return err
+	} else if len(fields) != len(p.pipeline) {
+		return xerrors.Errorf("PipelineInto dst %T has %d exported fields, but %d CmdActions were given", p.dst, len(fields), len(p.pipeline))
+	}
+
+	if err := c.Encode(p.pipeline); err != nil {
+		return err
+	}
+
+	for i, cmd := range p.pipeline {
+		if err := c.Decode(resp2.Any{I: fields[i]}); err != nil {
+			p.drain(c, len(p.pipeline)-i-1)
+			return decodeErr(i, cmd, err)
+		}
+	}
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
 type withConn struct {
-	key [1]string // use array to avoid allocation in Keys
-	fn  func(Conn) error
+	key     [1]string // use array to avoid allocation in Keys
+	keys    []string
+	useKeys bool // set by WithConnKeys, keys is used even if it's empty
+	fn      func(Conn) error
+}
+
+func newWithConn(key string, fn func(Conn) error) withConn {
+	return withConn{key: [1]string{key}, fn: fn}
 }
 
 // WithConn is used to perform a set of independent Actions on the same Conn.
@@ -524,13 +2506,161 @@ type withConn struct {
 // Conn, it doesn't make them transactional. Use MULTI/WATCH/EXEC within a
 // WithConn for transactions, or use EvalScript
 func WithConn(key string, fn func(Conn) error) Action {
-	return &withConn{[1]string{key}, fn}
+	wc := newWithConn(key, fn)
+	return &wc
+}
+
+// WithConnKeys is like WithConn, but takes all of the keys which the inner
+// Actions are going to act on, rather than just one. The returned Action's
+// Keys method returns all of them, and so Cluster will return an error, rather
+// than performing the Action on the wrong node, if they don't all belong to
+// the same slot. Hash tags can be used to ensure that they do, e.g.
+// "{user1}.name" and "{user1}.email". If no keys are given then the Action
+// has no keys, and so Cluster performs it on a random node.
+func WithConnKeys(keys []string, fn func(Conn) error) Action {
+	return &withConn{keys: keys, useKeys: true, fn: fn}
 }
 
 func (wc *withConn) Keys() []string {
+	if wc.useKeys {
+		return wc.keys
+	}
 	return wc.key[:]
 }
 
 func (wc *withConn) Run(c Conn) error {
 	return wc.fn(c)
 }
+
+type withConnTimeout struct {
+	withConn
+	timeout time.Duration
+}
+
+// WithConnTimeout is like WithConn, except that each Encode and Decode call
+// made on the Conn given to the callback, including those made by Actions
+// performed with it, must complete within the given timeout. This is useful
+// for bounding callbacks which might otherwise hang, e.g. a MULTI/EXEC
+// transaction which performs blocking commands.
+//
+// If a call doesn't complete in time then it's interrupted, and it and all
+// subsequent calls return an error wrapping context.DeadlineExceeded, which can
+// be checked for using errors.Is. Since the Conn is left in an unknown state it
+// is closed, and so when performed through a Pool it's discarded rather than
+// reused.
+//
+// A timeout of zero or less means there's no timeout, in which case
+// WithConnTimeout behaves exactly like WithConn.
+func WithConnTimeout(key string, timeout time.Duration, fn func(Conn) error) Action {
+	return &withConnTimeout{
+		withConn: newWithConn(key, fn),
+		timeout:  timeout,
+	}
+}
+
+func (wc *withConnTimeout) Run(c Conn) error {
+	if wc.timeout <= 0 {
+		return wc.fn(c)
+	}
+	return wc.fn(&deadlineConn{Conn: c, timeout: wc.timeout})
+}
+
+// deadlineConn is a Conn which closes the Conn it wraps if an Encode or Decode
+// call takes longer than timeout.
+type deadlineConn struct {
+	Conn
+	timeout time.Duration
+	err     error
+}
+
+func (dc *deadlineConn) Do(a Action) error {
+	return a.Run(dc)
+}
+
+func (dc *deadlineConn) Encode(m resp.Marshaler) error {
+	return dc.withDeadline(func() error { return dc.Conn.Encode(m) })
+}
+
+func (dc *deadlineConn) Decode(u resp.Unmarshaler) error {
+	return dc.withDeadline(func() error { return dc.Conn.Decode(u) })
+}
+
+func (dc *deadlineConn) withDeadline(fn func() error) error {
+	if dc.err != nil {
+		return dc.err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dc.timeout)
+	defer cancel()
+	interrupted, err := interruptible(ctx, dc.Conn, fn)
+	if !interrupted {
+		return err
+	}
+
+	// the Conn has been closed, so all subsequent calls fail, but this one
+	// may have completed just in time
+	dc.err = xerrors.Errorf("WithConnTimeout call took longer than %v: %w", dc.timeout, context.DeadlineExceeded)
+	if err == nil {
+		return nil
+	}
+	return dc.err
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+type conditional struct {
+	first  CmdAction
+	decide func(interface{}) (Action, error)
+}
+
+// Conditional returns an Action which performs first, passes its result to
+// decide, and then performs the Action returned by decide, all on the same
+// Conn. This allows for simple flows where a command depends on the result of
+// a previous one, e.g. "GET the key only if it EXISTS", without using WithConn
+// or an EvalScript.
+//
+// first's receiver is filled as normal, and decide is additionally given the
+// result decoded into an interface{}, as described by resp2.Any (e.g. int64
+// for an integer reply, []byte for a bulk string, nil for a nil reply). If
+// first returns an error, including an error reply from redis, then decide is
+// not called and the error is returned. If decide returns an error, or returns
+// a nil Action, then nothing further is done and its error (which may be nil)
+// is returned. Otherwise the error from the returned Action is returned.
+//
+// The Action's Keys method returns the keys of first. When using Cluster the
+// Action returned by decide must act on keys in the same slot.
+//
+// NOTE that, like WithConn, Conditional doesn't make the Actions transactional.
+// Another client may modify the keys in between first and the returned Action
+// being performed.
+func Conditional(first CmdAction, decide func(firstResult interface{}) (Action, error)) Action {
+	return &conditional{first: first, decide: decide}
+}
+
+func (cd *conditional) Keys() []string {
+	return cd.first.Keys()
+}
+
+func (cd *conditional) Run(c Conn) error {
+	if err := c.Encode(cd.first); err != nil {
+		return err
+	}
+
+	var rm resp2.RawMessage
+	if err := c.Decode(&rm); err != nil {
+		return err
+	} else if err := rm.UnmarshalInto(cd.first); err != nil {
+		return err
+	}
+
+	var res interface{}
+	if err := rm.UnmarshalInto(resp2.Any{I: &res}); err != nil {
+		return err
+	}
+
+	next, err := cd.decide(res)
+	if err != nil || next == nil {
+		return err
+	}
+	return c.Do(next)
+}
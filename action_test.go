@@ -3,13 +3,23 @@ package radix
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
 	. "testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	errors "golang.org/x/xerrors"
 
+	"github.com/mediocregopher/radix/v3/resp"
 	"github.com/mediocregopher/radix/v3/resp/resp2"
+	"github.com/mediocregopher/radix/v3/trace"
 )
 
 func TestCmdAction(t *T) {
@@ -83,6 +93,564 @@ func TestCmdActionStreams(t *T) {
 	require.NoError(t, c.Do(xCmd))
 }
 
+func TestCmdActionObject(t *T) {
+	key := randStr()
+	for _, subCmd := range []string{"ENCODING", "FREQ", "IDLETIME", "REFCOUNT"} {
+		assert.Equal(t, []string{key}, Cmd(nil, "OBJECT", subCmd, key).Keys())
+	}
+	assert.Equal(t, []string(nil), Cmd(nil, "OBJECT", "HELP").Keys())
+	assert.Equal(t, []string(nil), Cmd(nil, "OBJECT").Keys())
+}
+
+func TestCmdActionMemory(t *T) {
+	key := randStr()
+	assert.Equal(t, []string{key}, Cmd(nil, "MEMORY", "USAGE", key).Keys())
+	assert.Equal(t, []string{key}, Cmd(nil, "MEMORY", "USAGE", key, "SAMPLES", "5").Keys())
+	assert.Equal(t, []string(nil), Cmd(nil, "MEMORY", "STATS").Keys())
+}
+
+func TestCmdActionGeoRadius(t *T) {
+	key, dst, dst2 := randStr(), randStr(), randStr()
+	tests := []struct {
+		args []string
+		exp  []string
+	}{
+		{[]string{"GEORADIUS", key, "15", "37", "200", "km"}, []string{key}},
+		{[]string{"GEORADIUS", key, "15", "37", "200", "km", "WITHDIST", "ASC"}, []string{key}},
+		{[]string{"GEORADIUS", key, "15", "37", "200", "km", "STORE", dst}, []string{key, dst}},
+		{[]string{"georadius", key, "15", "37", "200", "km", "COUNT", "1", "storedist", dst}, []string{key, dst}},
+		{[]string{"GEORADIUS", key, "15", "37", "200", "km", "STORE", dst, "STOREDIST", dst2}, []string{key, dst, dst2}},
+		{[]string{"GEORADIUS", key, "15", "37", "200", "km", "STORE"}, []string{key}},
+		{[]string{"GEORADIUSBYMEMBER", key, "Palermo", "200", "km", "STORE", dst}, []string{key, dst}},
+		// a member named STORE is not the option
+		{[]string{"GEORADIUSBYMEMBER", key, "STORE", "200", "km"}, []string{key}},
+		{[]string{"GEORADIUS_RO", key, "15", "37", "200", "km"}, []string{key}},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.exp, Cmd(nil, test.args[0], test.args[1:]...).Keys(), "%q", test.args)
+	}
+	assert.Empty(t, Cmd(nil, "GEORADIUS").Keys())
+
+	// the returned keys don't share the args' memory
+	args := []string{key, "15", "37", "200", "km", "STORE", dst}
+	keys := Cmd(nil, "GEORADIUS", args...).Keys()
+	keys[1] = "foo"
+	assert.Equal(t, dst, args[6])
+}
+
+func TestCmdActionStoreKeys(t *T) {
+	key, dst := randStr(), randStr()
+	tests := []struct {
+		args []string
+		exp  []string
+	}{
+		{[]string{"SORT", key}, []string{key}},
+		{[]string{"SORT", key, "ALPHA", "DESC"}, []string{key}},
+		{[]string{"SORT", key, "STORE", dst}, []string{key, dst}},
+		{[]string{"sort", key, "BY", "w_*", "LIMIT", "0", "10", "GET", "#", "store", dst}, []string{key, dst}},
+		// patterns named STORE are not the option
+		{[]string{"SORT", key, "BY", "STORE", "GET", "STORE"}, []string{key}},
+		{[]string{"SORT", key, "LIMIT", "STORE", "STORE"}, []string{key}},
+		{[]string{"SORT", key, "STORE"}, []string{key}},
+		{[]string{"SORT_RO", key, "BY", "w_*"}, []string{key}},
+		{[]string{"MEMORY", "USAGE", key}, []string{key}},
+		{[]string{"GEOSEARCHSTORE", dst, key, "FROMMEMBER", "m", "BYRADIUS", "10", "km"}, []string{dst, key}},
+		{[]string{"ZRANGESTORE", dst, key, "0", "-1"}, []string{dst, key}},
+		{[]string{"ZADD", key, "GT", "CH", "1", "a"}, []string{key}},
+		{[]string{"ZADD", key, "LT", "1", "a"}, []string{key}},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.exp, Cmd(nil, test.args[0], test.args[1:]...).Keys(), "%q", test.args)
+	}
+}
+
+func TestRegisterCommandKeys(t *T) {
+	defer func() {
+		registeredKeySpecsL.Lock()
+		defer registeredKeySpecsL.Unlock()
+		m := map[string]KeySpec{}
+		for k, v := range registeredKeySpecs.Load().(map[string]KeySpec) {
+			m[k] = v
+		}
+		for _, cmd := range []string{"TEST.ONE", "TEST.RANGE", "TEST.STEP", "TEST.NONE", "TEST.FUNC", "OBJECT"} {
+			delete(m, cmd)
+		}
+		registeredKeySpecs.Store(m)
+	}()
+
+	RegisterCommandKeys("test.one", KeySpec{FirstKey: 1, LastKey: 1, Step: 1})
+	RegisterCommandKeys("TEST.RANGE", KeySpec{FirstKey: 2, LastKey: -2, Step: 1})
+	RegisterCommandKeys("TEST.STEP", KeySpec{FirstKey: 1, LastKey: -1, Step: 2})
+	RegisterCommandKeys("TEST.NONE", KeySpec{})
+	RegisterCommandKeys("TEST.FUNC", KeySpec{Func: func(args []string) []string {
+		return args[len(args)-1:]
+	}})
+	// built-in handling can be overridden
+	RegisterCommandKeys("OBJECT", KeySpec{FirstKey: 1, LastKey: 1})
+
+	tests := []struct {
+		args []string
+		exp  []string
+	}{
+		{[]string{"TEST.ONE", "a", "b"}, []string{"a"}},
+		{[]string{"Test.One"}, nil},
+		{[]string{"TEST.RANGE", "opt", "a", "b", "c", "opt"}, []string{"a", "b", "c"}},
+		{[]string{"TEST.RANGE", "opt", "opt"}, nil},
+		{[]string{"TEST.STEP", "k1", "v1", "k2", "v2", "k3", "v3"}, []string{"k1", "k2", "k3"}},
+		{[]string{"TEST.STEP", "k1"}, []string{"k1"}},
+		{[]string{"TEST.NONE", "a", "b"}, nil},
+		{[]string{"TEST.FUNC", "a", "b"}, []string{"b"}},
+		{[]string{"OBJECT", "ENCODING", "a"}, []string{"ENCODING"}},
+		// unregistered commands are unaffected
+		{[]string{"TEST.OTHER", "a", "b"}, []string{"a"}},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.exp, Cmd(nil, test.args[0], test.args[1:]...).Keys(), "%q", test.args)
+	}
+}
+
+func TestCmdActionSrcDst(t *T) {
+	src, dst := randStr(), randStr()
+	for _, args := range [][]string{
+		{"RPOPLPUSH", src, dst},
+		{"BRPOPLPUSH", src, dst, "0"},
+		{"LMOVE", src, dst, "LEFT", "RIGHT"},
+		{"BLMOVE", src, dst, "LEFT", "RIGHT", "0"},
+		{"SMOVE", src, dst, "member"},
+		{"RENAME", src, dst},
+		{"RENAMENX", src, dst},
+		{"COPY", src, dst},
+		{"COPY", src, dst, "DB", "1", "REPLACE"},
+		{"copy", src, dst, "REPLACE"},
+	} {
+		assert.Equal(t, []string{src, dst}, Cmd(nil, args[0], args[1:]...).Keys())
+	}
+	assert.Equal(t, []string{src}, Cmd(nil, "RENAME", src).Keys())
+}
+
+func TestCmdActionAllKeys(t *T) {
+	tests := []struct {
+		args []string
+		keys []string
+	}{
+		{args: []string{"PFADD", "a", "x", "y"}, keys: []string{"a"}},
+		{args: []string{"PFCOUNT", "a"}, keys: []string{"a"}},
+		{args: []string{"PFCOUNT", "a", "b", "c"}, keys: []string{"a", "b", "c"}},
+		{args: []string{"pfcount", "a", "b"}, keys: []string{"a", "b"}},
+		{args: []string{"PFMERGE", "dst", "a", "b"}, keys: []string{"dst", "a", "b"}},
+		{args: []string{"PFMERGE", "dst"}, keys: []string{"dst"}},
+		{args: []string{"PFCOUNT"}, keys: []string{}},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.keys, Cmd(nil, test.args[0], test.args[1:]...).Keys(), "args:%q", test.args)
+	}
+}
+
+func TestCmdActionNumKeys(t *T) {
+	tests := []struct {
+		args   []string
+		keys   []string
+		errStr string
+	}{
+		{args: []string{"LMPOP", "2", "a", "b", "LEFT"}, keys: []string{"a", "b"}},
+		{args: []string{"LMPOP", "1", "a", "right", "COUNT", "2"}, keys: []string{"a"}},
+		{args: []string{"ZMPOP", "1", "a", "MIN"}, keys: []string{"a"}},
+		{args: []string{"BLMPOP", "0.5", "2", "a", "b", "LEFT", "COUNT", "2"}, keys: []string{"a", "b"}},
+		{args: []string{"BZMPOP", "0", "1", "a", "max"}, keys: []string{"a"}},
+		{args: []string{"SINTERCARD", "2", "a", "b"}, keys: []string{"a", "b"}},
+		{args: []string{"SINTERCARD", "2", "a", "b", "LIMIT", "5"}, keys: []string{"a", "b"}},
+		{args: []string{"ZINTERCARD", "1", "a"}, keys: []string{"a"}},
+		{args: []string{"ZDIFF", "2", "a", "b", "WITHSCORES"}, keys: []string{"a", "b"}},
+		{args: []string{"ZINTER", "2", "a", "b", "WEIGHTS", "1", "2"}, keys: []string{"a", "b"}},
+		{args: []string{"ZUNION", "1", "a", "AGGREGATE", "MAX"}, keys: []string{"a"}},
+		{args: []string{"ZDIFFSTORE", "dst", "2", "a", "b"}, keys: []string{"dst", "a", "b"}},
+		{args: []string{"ZINTERSTORE", "dst", "1", "a"}, keys: []string{"dst", "a"}},
+		{args: []string{"ZUNIONSTORE", "dst", "2", "a", "b", "WEIGHTS", "1", "2"}, keys: []string{"dst", "a", "b"}},
+		{args: []string{"ZUNIONSTORE", "dst", "0"}, keys: []string{"dst"}},
+		{args: []string{"ZINTERSTORE", "dst", "0", "AGGREGATE", "MAX"}, keys: []string{"dst"}},
+
+		{args: []string{"LMPOP"}, errStr: "LMPOP is missing its numkeys argument"},
+		{args: []string{"LMPOP", "a", "LEFT"}, errStr: `LMPOP has invalid numkeys argument "a"`},
+		{args: []string{"LMPOP", "0", "LEFT"}, keys: []string{}, errStr: `LMPOP has invalid numkeys argument "0"`},
+		{args: []string{"LMPOP", "3", "a", "LEFT"}, errStr: "LMPOP declares 3 keys but only 2 are given"},
+		{args: []string{"LMPOP", "1", "a"}, keys: []string{"a"}, errStr: "LMPOP requires one of [LEFT RIGHT] following its 1 keys"},
+		{
+			args:   []string{"LMPOP", "1", "a", "b", "LEFT"},
+			keys:   []string{"a"},
+			errStr: `LMPOP declares 1 keys but is followed by unexpected argument "b", numkeys may not match the number of keys given`,
+		},
+		{
+			args:   []string{"SINTERCARD", "2", "a", "b", "c"},
+			keys:   []string{"a", "b"},
+			errStr: `SINTERCARD declares 2 keys but is followed by unexpected argument "c", numkeys may not match the number of keys given`,
+		},
+		{args: []string{"ZDIFFSTORE", "dst"}, keys: []string{"dst"}, errStr: "ZDIFFSTORE is missing its numkeys argument"},
+		{args: []string{"ZUNIONSTORE", "dst", "-1"}, keys: []string{"dst"}, errStr: `ZUNIONSTORE has invalid numkeys argument "-1"`},
+		{args: []string{"BLMPOP", "0"}, errStr: "BLMPOP is missing its numkeys argument"},
+		{args: []string{"BZMPOP", "0", "3", "a", "MIN"}, errStr: "BZMPOP declares 3 keys but only 2 are given"},
+	}
+
+	for _, test := range tests {
+		cmd := Cmd(nil, test.args[0], test.args[1:]...)
+		assert.Equal(t, test.keys, cmd.Keys(), "%v", test.args)
+
+		err := cmd.MarshalRESP(new(bytes.Buffer))
+		if test.errStr == "" {
+			assert.NoError(t, err, "%v", test.args)
+		} else {
+			assert.EqualError(t, err, test.errStr, "%v", test.args)
+		}
+	}
+
+	// validation is case-insensitive
+	err := Cmd(nil, "lmpop", "3", "a", "LEFT").MarshalRESP(new(bytes.Buffer))
+	assert.EqualError(t, err, "lmpop declares 3 keys but only 2 are given")
+}
+
+func TestCmdActionMarshalAllocs(t *T) {
+	buf := bytes.NewBuffer(make([]byte, 0, 1024))
+	for _, args := range [][]string{
+		{"GET", "k"},
+		{"get", "k"},
+		{"zmpop", "1", "a", "MIN"},
+	} {
+		cmd := Cmd(nil, args[0], args[1:]...)
+		allocs := AllocsPerRun(100, func() {
+			buf.Reset()
+			require.NoError(t, cmd.MarshalRESP(buf))
+		})
+		assert.Zero(t, allocs, "%q", args)
+	}
+}
+
+// pipeConn returns a Conn backed by a net.Pipe, whose other end replies +OK to
+// every command except BLPOP, which never receives a reply.
+func pipeConn() Conn {
+	client, server := net.Pipe()
+	go func() {
+		defer server.Close()
+		br := bufio.NewReader(server)
+		for {
+			var args []string
+			if err := (resp2.Any{I: &args}).UnmarshalRESP(br); err != nil {
+				return
+			} else if args[0] == "BLPOP" {
+				continue
+			} else if _, err := server.Write([]byte("+OK\r\n")); err != nil {
+				return
+			}
+		}
+	}()
+	return NewConn(client)
+}
+
+func TestIsApplicationError(t *T) {
+	stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		if args[0] == "LPUSH" {
+			return resp2.Error{E: errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")}
+		}
+		return "foo"
+	})
+
+	err := stub.Do(Cmd(nil, "LPUSH", "foo", "bar"))
+	assert.True(t, IsApplicationError(err))
+	var respErr resp2.Error
+	require.True(t, errors.As(err, &respErr))
+	assert.Contains(t, respErr.E.Error(), "WRONGTYPE")
+
+	// also when wrapped by a pipeline
+	err = stub.Do(Pipeline(Cmd(nil, "GET", "foo"), Cmd(nil, "LPUSH", "foo", "bar")))
+	assert.True(t, IsApplicationError(err))
+
+	// a reply which can't be unmarshaled isn't an application error
+	var n int
+	err = stub.Do(Cmd(&n, "GET", "foo"))
+	assert.Error(t, err)
+	assert.False(t, IsApplicationError(err))
+
+	// nor is an error from the connection itself
+	client, server := net.Pipe()
+	go func() {
+		_ = (resp2.Any{}).UnmarshalRESP(bufio.NewReader(server))
+		server.Close()
+	}()
+	c := NewConn(client)
+	defer c.Close()
+	err = c.Do(Cmd(nil, "GET", "foo"))
+	assert.Error(t, err)
+	assert.False(t, IsApplicationError(err))
+	assert.False(t, errors.As(err, new(resp2.Error)))
+
+	assert.False(t, IsApplicationError(nil))
+}
+
+func TestCmdCtx(t *T) {
+	t.Run("noCancel", func(t *T) {
+		c := pipeConn()
+		defer c.Close()
+
+		var ok string
+		require.NoError(t, c.Do(CmdCtx(context.Background(), &ok, "SET", "foo", "bar")))
+		assert.Equal(t, "OK", ok)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		require.NoError(t, c.Do(CmdCtx(ctx, &ok, "SET", "foo", "bar")))
+		assert.Equal(t, []string{"foo"}, CmdCtx(ctx, nil, "SET", "foo", "bar").Keys())
+	})
+
+	t.Run("alreadyCancelled", func(t *T) {
+		c := pipeConn()
+		defer c.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		err := c.Do(CmdCtx(ctx, nil, "SET", "foo", "bar"))
+		assert.True(t, errors.Is(err, context.Canceled))
+
+		// nothing was sent, so the Conn is still usable
+		require.NoError(t, c.Do(Cmd(nil, "SET", "foo", "bar")))
+	})
+
+	t.Run("cancelled", func(t *T) {
+		c := pipeConn()
+		defer c.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			cancel()
+		}()
+		err := c.Do(CmdCtx(ctx, nil, "BLPOP", "foo", "0"))
+		assert.True(t, errors.Is(err, context.Canceled))
+
+		// the Conn has been closed
+		assert.Error(t, c.Do(Cmd(nil, "SET", "foo", "bar")))
+	})
+
+	t.Run("cancelledAfterReply", func(t *T) {
+		c := pipeConn()
+		defer c.Close()
+
+		// the Context is cancelled once the reply has been read, but before
+		// Decode returns, giving the watcher time to close the net.Conn
+		ctx, cancel := context.WithCancel(context.Background())
+		var ok string
+		rcv := DecodeFunc(func(br *bufio.Reader) error {
+			err := (resp2.Any{I: &ok}).UnmarshalRESP(br)
+			cancel()
+			time.Sleep(20 * time.Millisecond)
+			return err
+		})
+		require.NoError(t, c.Do(CmdCtx(ctx, rcv, "SET", "foo", "bar")))
+		assert.Equal(t, "OK", ok)
+
+		// the Conn has been closed regardless
+		assert.Error(t, c.Do(Cmd(nil, "SET", "foo", "bar")))
+	})
+
+	t.Run("deadline", func(t *T) {
+		c := pipeConn()
+		defer c.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		err := c.Do(CmdCtx(ctx, nil, "BLPOP", "foo", "0"))
+		assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	})
+
+	t.Run("pool", func(t *T) {
+		var created int
+		pool, err := NewPool("tcp", "127.0.0.1:6379", 1,
+			PoolConnFunc(func(string, string) (Conn, error) { return pipeConn(), nil }),
+			PoolPipelineWindow(0, 0),
+			PoolOnEmptyCreateAfter(0),
+			PoolWithTrace(trace.PoolTrace{
+				ConnCreated: func(trace.PoolConnCreated) { created++ },
+			}),
+		)
+		require.NoError(t, err)
+		defer pool.Close()
+		<-pool.initDone
+		require.Equal(t, 1, created)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		err = pool.Do(CmdCtx(ctx, nil, "BLPOP", "foo", "0"))
+		assert.True(t, errors.Is(err, context.DeadlineExceeded))
+
+		// the cancelled connection was discarded, so a new one is created
+		require.NoError(t, pool.Do(Cmd(nil, "SET", "foo", "bar")))
+		assert.Equal(t, 2, created)
+	})
+}
+
+func TestCmdChanRcv(t *T) {
+	stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		switch {
+		case args[0] == "LRANGE" && args[1] == "missing":
+			return []string(nil)
+		case args[0] == "LRANGE":
+			return []string{"1", "2", "foo", "3"}
+		}
+		return "foo"
+	})
+
+	t.Run("strings", func(t *T) {
+		ch := make(chan string, 4)
+		require.NoError(t, stub.Do(Cmd((chan<- string)(ch), "LRANGE", "l", "0", "-1")))
+		var got []string
+		for s := range ch {
+			got = append(got, s)
+		}
+		assert.Equal(t, []string{"1", "2", "foo", "3"}, got)
+	})
+
+	t.Run("elementErr", func(t *T) {
+		ch := make(chan int, 4)
+		err := stub.Do(Cmd(ch, "LRANGE", "l", "0", "-1"))
+		assert.True(t, errors.As(err, new(resp.ErrDiscarded)))
+		assert.Contains(t, err.Error(), "element 2")
+		var got []int
+		for i := range ch {
+			got = append(got, i)
+		}
+		assert.Equal(t, []int{1, 2, 3}, got)
+
+		// the connection is still usable
+		var str string
+		require.NoError(t, stub.Do(Cmd(&str, "GET", "foo")))
+		assert.Equal(t, "foo", str)
+	})
+
+	t.Run("nilArray", func(t *T) {
+		ch := make(chan string)
+		require.NoError(t, stub.Do(Cmd(ch, "LRANGE", "missing", "0", "-1")))
+		_, ok := <-ch
+		assert.False(t, ok)
+	})
+
+	t.Run("notArray", func(t *T) {
+		ch := make(chan string, 1)
+		assert.Error(t, stub.Do(Cmd(ch, "GET", "foo")))
+		_, ok := <-ch
+		assert.False(t, ok)
+	})
+
+	t.Run("ctx", func(t *T) {
+		client, server := net.Pipe()
+		go func() {
+			defer server.Close()
+			_ = (resp2.Any{}).UnmarshalRESP(bufio.NewReader(server))
+			server.Write([]byte("*2\r\n$1\r\na\r\n$1\r\nb\r\n"))
+		}()
+		c := NewConn(client)
+		defer c.Close()
+
+		// nothing ever receives from the channel
+		ch := make(chan string)
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		err := c.Do(CmdCtx(ctx, ch, "LRANGE", "l", "0", "-1"))
+		assert.True(t, errors.Is(err, context.DeadlineExceeded))
+		_, ok := <-ch
+		assert.False(t, ok)
+	})
+}
+
+func TestWithConnTimeout(t *T) {
+	t.Run("noTimeout", func(t *T) {
+		c := pipeConn()
+		defer c.Close()
+
+		var ok string
+		a := WithConnTimeout("foo", time.Second, func(c Conn) error {
+			return c.Do(Cmd(&ok, "SET", "foo", "bar"))
+		})
+		assert.Equal(t, []string{"foo"}, a.Keys())
+		require.NoError(t, c.Do(a))
+		assert.Equal(t, "OK", ok)
+	})
+
+	t.Run("zeroTimeout", func(t *T) {
+		c := pipeConn()
+		defer c.Close()
+
+		// a timeout of zero or less is no timeout at all, rather than one which
+		// has already passed
+		for _, timeout := range []time.Duration{0, -time.Second} {
+			var ok string
+			require.NoError(t, c.Do(WithConnTimeout("foo", timeout, func(c Conn) error {
+				return c.Do(Cmd(&ok, "SET", "foo", "bar"))
+			})))
+			assert.Equal(t, "OK", ok)
+		}
+		require.NoError(t, c.Do(Cmd(nil, "SET", "foo", "bar")))
+	})
+
+	t.Run("unwrap", func(t *T) {
+		c := pipeConn()
+		defer c.Close()
+
+		// the Conn given to the callback can be unwrapped to find the state
+		// which is kept on the Conn it wraps, e.g. for EvalScript.TrackLoaded
+		require.NoError(t, c.Do(WithConnTimeout("", time.Second, func(dc Conn) error {
+			assert.True(t, loadedScripts(c) == loadedScripts(dc))
+			assert.NotNil(t, loadedScripts(dc))
+			return nil
+		})))
+	})
+
+	t.Run("timeout", func(t *T) {
+		c := pipeConn()
+		defer c.Close()
+
+		var ok string
+		var innerErr error
+		err := c.Do(WithConnTimeout("foo", 20*time.Millisecond, func(c Conn) error {
+			if err := c.Do(Cmd(&ok, "SET", "foo", "bar")); err != nil {
+				return err
+			}
+			err := c.Do(Cmd(nil, "BLPOP", "foo", "0"))
+			// subsequent calls fail immediately
+			innerErr = c.Do(Cmd(nil, "SET", "foo", "bar"))
+			return err
+		}))
+		assert.True(t, errors.Is(err, context.DeadlineExceeded))
+		assert.True(t, errors.Is(innerErr, context.DeadlineExceeded))
+		assert.Equal(t, "OK", ok)
+
+		// the Conn has been closed
+		assert.Error(t, c.Do(Cmd(nil, "SET", "foo", "bar")))
+	})
+
+	t.Run("pool", func(t *T) {
+		var created int
+		pool, err := NewPool("tcp", "127.0.0.1:6379", 1,
+			PoolConnFunc(func(string, string) (Conn, error) { return pipeConn(), nil }),
+			PoolPipelineWindow(0, 0),
+			PoolOnEmptyCreateAfter(0),
+			PoolWithTrace(trace.PoolTrace{
+				ConnCreated: func(trace.PoolConnCreated) { created++ },
+			}),
+		)
+		require.NoError(t, err)
+		defer pool.Close()
+		<-pool.initDone
+		require.Equal(t, 1, created)
+
+		err = pool.Do(WithConnTimeout("foo", 20*time.Millisecond, func(c Conn) error {
+			return c.Do(Cmd(nil, "BLPOP", "foo", "0"))
+		}))
+		assert.True(t, errors.Is(err, context.DeadlineExceeded))
+
+		// the timed out connection was discarded, so a new one is created
+		require.NoError(t, pool.Do(Cmd(nil, "SET", "foo", "bar")))
+		assert.Equal(t, 2, created)
+	})
+}
+
 func ExampleCmd() {
 	client, err := NewPool("tcp", "127.0.0.1:6379", 10) // or any other client
 	if err != nil {
@@ -116,6 +684,124 @@ func TestFlatCmdAction(t *T) {
 	assert.Equal(t, m, got)
 }
 
+// testPairMarshaler is a resp.Marshaler which marshals as two bulk strings.
+type testPairMarshaler struct {
+	a, b string
+}
+
+func (pm testPairMarshaler) MarshalRESP(w io.Writer) error {
+	if err := (resp2.BulkString{S: pm.a}).MarshalRESP(w); err != nil {
+		return err
+	}
+	return resp2.BulkString{S: pm.b}.MarshalRESP(w)
+}
+
+func TestFlatCmdActionMarshaler(t *T) {
+	pm := testPairMarshaler{a: "field", b: "value"}
+	a := FlatCmd(nil, "HSET", "key", pm, []string{"f", "v"}, testPairMarshaler{a: "x", b: "y"})
+	assert.Equal(t, `["HSET" "key" "field" "value" "f" "v" "x" "y"]`, cmdString(a))
+	assert.Equal(t, []string{"key", "field", "value", "f", "v", "x", "y"}, a.(CmdInfo).Args())
+
+	// resp.Marshalers which write simple types are passed through as-is
+	a = FlatCmd(nil, "ECHO", "key", resp2.Int{I: 5})
+	buf := new(bytes.Buffer)
+	require.NoError(t, a.MarshalRESP(buf))
+	assert.Equal(t, "*3\r\n$4\r\nECHO\r\n$3\r\nkey\r\n:5\r\n", buf.String())
+
+	// but those which write arrays are rejected, as they'd make the command
+	// malformed
+	arr := resp2.Any{I: []string{"a", "b"}}
+	err := FlatCmd(nil, "ECHO", "key", arr).MarshalRESP(new(bytes.Buffer))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "only bulk strings, simple strings and integers")
+	_, err = Flatten("a", arr)
+	assert.Error(t, err)
+	assert.Nil(t, FlatCmd(nil, "ECHO", "key", arr).(CmdInfo).Args())
+
+	script := NewEvalScript(1, "return 1")
+	ec := script.CmdKV(nil, []string{"key"}, pm, 1)
+	assert.Equal(t,
+		`["EVALSHA" "`+script.sum+`" "1" "key" "field" "value" "1"]`,
+		cmdString(ec.(resp.Marshaler)))
+}
+
+func TestFlatCmdActionMarshalerLive(t *T) {
+	c := dial()
+	defer c.Close()
+	key := randStr()
+
+	require.NoError(t, c.Do(FlatCmd(nil, "HSET", key, testPairMarshaler{a: "field", b: "value"})))
+	var got map[string]string
+	require.NoError(t, c.Do(Cmd(&got, "HGETALL", key)))
+	assert.Equal(t, map[string]string{"field": "value"}, got)
+}
+
+func TestFlatMapCmd(t *T) {
+	a := FlatMapCmd(nil, "MSET", map[string]interface{}{"b": 2, "a": "1", "c": nil})
+	assert.Equal(t, `["MSET" "a" "1" "b" "2" "c" ""]`, cmdString(a))
+	assert.Equal(t, []string{"a", "b", "c"}, a.Keys())
+
+	a = FlatMapCmd(nil, "MSETNX", []struct {
+		Key   string
+		Value float64
+	}{{"z", 1.5}, {"y", 2}})
+	assert.Equal(t, `["MSETNX" "z" "1.5" "y" "2"]`, cmdString(a))
+	assert.Equal(t, []string{"z", "y"}, a.Keys())
+
+	// invalid kvs result in an error when the command is marshaled
+	marshalErr := func(kv interface{}) string {
+		err := FlatMapCmd(nil, "MSET", kv).MarshalRESP(new(bytes.Buffer))
+		require.Error(t, err)
+		return err.Error()
+	}
+	assert.Equal(t, "FlatMapCmd kv must be a map or slice of structs, got []string", marshalErr([]string{"a", "b"}))
+	assert.Equal(t, "FlatMapCmd kv must be a map or slice of structs, got map[int]string", marshalErr(map[int]string{1: "a"}))
+	assert.Equal(t, "FlatMapCmd kv elements must have a string Key field, got struct { Key int }", marshalErr([]struct{ Key int }{{1}}))
+	assert.Equal(t, `FlatMapCmd value for key "a" must flatten to a single argument, got 2`, marshalErr(map[string][]string{"a": {"b", "c"}}))
+
+	// the key whose value can't be flattened is named
+	var nilStr *string
+	assert.Equal(t,
+		`FlatMapCmd value for key "b" must flatten to a single argument, got 0`,
+		marshalErr(map[string]*string{"a": new(string), "b": nilStr}))
+	assert.Equal(t,
+		`FlatMapCmd value for key "a" can't be flattened: cannot marshal time.Duration 1s without a unit, use radix.Seconds or radix.Millis`,
+		marshalErr(map[string]interface{}{"a": time.Second}))
+
+	// the error is returned from Do, rather than anything being sent
+	var sent bool
+	stub := Stub("tcp", "127.0.0.1:6379", func([]string) interface{} {
+		sent = true
+		return nil
+	})
+	assert.Error(t, stub.Do(FlatMapCmd(nil, "MSET", map[string]interface{}{"a": time.Second})))
+	assert.False(t, sent)
+
+	a = FlatMapCmd(nil, "MSET", map[string]interface{}{"a": EmptyIfNil(nilStr)})
+	assert.Equal(t, `["MSET" "a" ""]`, cmdString(a))
+
+	// keys in different slots result in an error from Cluster
+	c, _ := newTestCluster()
+	defer c.Close()
+	err := c.Do(FlatMapCmd(nil, "MSET", map[string]string{
+		clusterSlotKeys[0]: "a",
+		clusterSlotKeys[1]: "b",
+	}))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "do not belong to the same slot")
+}
+
+func TestFlatMapCmdLive(t *T) {
+	c := dial()
+	defer c.Close()
+	k1, k2 := randStr(), randStr()
+
+	require.NoError(t, c.Do(FlatMapCmd(nil, "MSET", map[string]int{k1: 1, k2: 2})))
+	var got []string
+	require.NoError(t, c.Do(Cmd(&got, "MGET", k1, k2)))
+	assert.Equal(t, []string{"1", "2"}, got)
+}
+
 func TestFlatCmdActionNil(t *T) {
 	c := dial()
 	defer c.Close()
@@ -143,6 +829,372 @@ func TestFlatCmdActionEmpty(t *T) {
 	require.True(t, nilVal.EmptyArray)
 }
 
+type testErrMarshaler struct{}
+
+func (testErrMarshaler) MarshalRESP(io.Writer) error {
+	return errors.New("can't marshal")
+}
+
+func TestCmdChecked(t *T) {
+	a, err := CmdChecked(nil, "SET", "foo", "bar")
+	require.NoError(t, err)
+	assert.Equal(t, `["SET" "foo" "bar"]`, cmdString(a))
+
+	// unknown commands aren't checked for their number of arguments
+	_, err = CmdChecked(nil, "SOMECMD")
+	require.NoError(t, err)
+
+	_, err = CmdChecked(nil, "")
+	assert.Error(t, err)
+
+	_, err = CmdChecked(nil, "SET", "foo", "bar\r\n*1\r\n$4\r\nQUIT\r\n")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "argument 1")
+
+	_, err = CmdChecked(nil, "set", "foo")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "at least 2 arguments, got 1")
+}
+
+func TestCmdActionMarshalInvalidName(t *T) {
+	for _, cmd := range []string{"", "GET\r\n*1\r\n$4\r\nQUIT", "CLIENT LIST", "GET\x00"} {
+		buf := new(bytes.Buffer)
+		assert.Error(t, Cmd(nil, cmd).MarshalRESP(buf), "cmd:%q", cmd)
+		assert.Error(t, FlatCmd(nil, cmd, "foo").MarshalRESP(buf), "cmd:%q", cmd)
+		assert.Zero(t, buf.Len(), "cmd:%q", cmd)
+	}
+
+	// the connection is still usable after an invalid command name is rejected
+	c := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		return args[0]
+	})
+	assert.Error(t, c.Do(Cmd(nil, "GET\r\nQUIT")))
+	var s string
+	require.NoError(t, c.Do(Cmd(&s, "ECHO")))
+	assert.Equal(t, "ECHO", s)
+}
+
+func TestWithReplyHook(t *T) {
+	stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		switch args[0] {
+		case "GET":
+			return "bar"
+		case "LRANGE":
+			return []string{"a", "b"}
+		}
+		return resp2.Error{E: errors.New("ERR unknown command")}
+	})
+
+	type reply struct {
+		cmd   string
+		bytes int
+	}
+	var replies []reply
+	hook := func(cmd string, bytes int) {
+		replies = append(replies, reply{cmd, bytes})
+	}
+
+	var s string
+	require.NoError(t, stub.Do(WithReplyHook(Cmd(&s, "GET", "foo"), hook)))
+	assert.Equal(t, "bar", s)
+	assert.Equal(t, []reply{{"GET", len("$3\r\nbar\r\n")}}, replies)
+
+	replies = nil
+	var ss []string
+	err := stub.Do(Pipeline(
+		WithReplyHook(Cmd(&ss, "LRANGE", "foo", "0", "-1"), hook),
+		WithReplyHook(Cmd(nil, "BADCMD"), hook),
+	))
+	assert.True(t, errors.As(err, new(resp2.Error)))
+	assert.Equal(t, []string{"a", "b"}, ss)
+	assert.Equal(t, []reply{
+		{"LRANGE", len("*2\r\n$1\r\na\r\n$1\r\nb\r\n")},
+		{"BADCMD", len("-ERR unknown command\r\n")},
+	}, replies)
+
+	// the wrapped CmdAction's Run is used, so a CmdCtx can still be cancelled
+	c := pipeConn()
+	defer c.Close()
+	replies = nil
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+	err = c.Do(WithReplyHook(CmdCtx(ctx, nil, "BLPOP", "foo", "0"), hook))
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.Empty(t, replies)
+}
+
+func TestCmdRawMessageRcv(t *T) {
+	replies := []string{
+		"$3\r\nfoo\r\n",
+		"$-1\r\n",
+		"*3\r\n:1\r\n*2\r\n$1\r\na\r\n*-1\r\n+OK\r\n",
+		"%1\r\n$3\r\nfoo\r\n~2\r\n$1\r\na\r\n_\r\n",
+		"-ERR bad\r\n",
+	}
+	br := bufio.NewReader(bytes.NewBufferString(
+		strings.Join(replies, "") + "|1\r\n+key\r\n+val\r\n:2\r\n",
+	))
+
+	for _, exp := range replies {
+		// the RawMessage is reset before each reply
+		raw := resp2.RawMessage("garbage")
+		require.NoError(t, Cmd(&raw, "GET", "foo").UnmarshalRESP(br))
+		assert.Equal(t, exp, string(raw))
+	}
+
+	var raw resp2.RawMessage
+	require.NoError(t, Cmd(&raw, "GET", "foo").UnmarshalRESP(br))
+	assert.Equal(t, ":2\r\n", string(raw))
+	assert.Zero(t, br.Buffered())
+}
+
+func TestCmdSyncMapRcv(t *T) {
+	var odd bool
+	stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		if odd {
+			return []string{"a", "1", "b"}
+		}
+		return []string{"a", "1", "b", "2"}
+	})
+
+	var sm sync.Map
+	sm.Store("c", "3")
+	require.NoError(t, stub.Do(Cmd(&sm, "HGETALL", "foo")))
+	m := map[interface{}]interface{}{}
+	sm.Range(func(k, v interface{}) bool {
+		m[k] = v
+		return true
+	})
+	assert.Equal(t, map[interface{}]interface{}{"a": "1", "b": "2", "c": "3"}, m)
+
+	// an odd number of elements is an error, but the Conn remains usable
+	odd = true
+	assert.Error(t, stub.Do(Cmd(&sm, "HGETALL", "foo")))
+	odd = false
+	require.NoError(t, stub.Do(Cmd(&sm, "HGETALL", "foo")))
+}
+
+func TestFlatten(t *T) {
+	type testStruct struct {
+		A string
+		B int `redis:"b"`
+	}
+
+	ss, err := Flatten(
+		"a", 1, 2.5, []byte("b"), []interface{}{"c", []int{3, 4}},
+		map[string]int{"d": 5}, testStruct{A: "e", B: 6}, nil,
+		resp.NewLenReader(bytes.NewBufferString("buf"), 3),
+		testPairMarshaler{a: "f", b: "g"},
+	)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"a", "1", "2.5", "b", "c", "3", "4", "d", "5", "A", "e", "b", "6", "",
+		"buf", "f", "g",
+	}, ss)
+
+	// the result matches what FlatCmd sends
+	args := []interface{}{"key", map[string]string{"f": "v"}, []int{1, 2}}
+	ss, err = Flatten(args...)
+	require.NoError(t, err)
+	assert.Equal(t, cmdString(FlatCmd(nil, "HSET", args[0].(string), args[1:]...)), cmdString(Cmd(nil, "HSET", ss...)))
+
+	ss, err = Flatten()
+	require.NoError(t, err)
+	assert.Empty(t, ss)
+
+	_, err = Flatten("a", testErrMarshaler{})
+	assert.Error(t, err)
+}
+
+func TestFlattenString(t *T) {
+	// the strings which common types are flattened into directly must match
+	// what's actually sent
+	for _, arg := range []interface{}{
+		"", "foo", []byte("bar"), []byte(nil), true, false, nil,
+		float32(1.5), 1.25, 1e21, -0.1, 1, int8(-8), int16(16), int32(-32),
+		int64(64), uint(1), uint8(8), uint16(16), uint32(32), uint64(64),
+	} {
+		s, ok := flattenString(arg)
+		require.True(t, ok, "%#v", arg)
+
+		buf := new(bytes.Buffer)
+		require.NoError(t, FlatCmd(nil, "SET", "k", arg).MarshalRESP(buf))
+		var sent []string
+		require.NoError(t, resp2.RawMessage(buf.Bytes()).UnmarshalInto(resp2.Any{I: &sent}))
+		assert.Equal(t, []string{"SET", "k", s}, sent, "%#v", arg)
+	}
+
+	_, ok := flattenString(struct{}{})
+	assert.False(t, ok)
+}
+
+func TestFlattenNil(t *T) {
+	type testStruct struct {
+		A *int
+		B *string
+	}
+	var (
+		nilInt   *int
+		nilSlice []string
+		nilMap   map[string]string
+		nilIface interface{} = nilInt
+		one                  = 1
+	)
+
+	ss, err := Flatten(
+		"a", nilInt, nilSlice, nilMap, nilIface, (*testPairMarshaler)(nil),
+		[]interface{}{nilInt, "b"}, map[string]*int{"c": nil},
+		testStruct{A: &one}, "d",
+	)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "A", "1", "d"}, ss)
+
+	// the number of arguments FlatCmd sends agrees with what it writes
+	cmd := FlatCmd(nil, "CMD", "key", nilInt, nilIface, &one, testStruct{})
+	assert.Equal(t, `["CMD" "key" "1"]`, cmdString(cmd))
+
+	// an untyped nil is sent as an empty string, and EmptyIfNil can be used to
+	// do the same for nil pointers
+	ss, err = Flatten(nil, EmptyIfNil(nilInt), EmptyIfNil(nilIface), EmptyIfNil(&one), EmptyIfNil(nil))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"", "", "", "1", ""}, ss)
+}
+
+func TestFlattenTime(t *T) {
+	ts := time.Unix(1600000000, 123456789)
+	ss, err := Flatten(
+		Seconds(ts), Millis(ts),
+		Seconds(90*time.Second), Millis(1500*time.Millisecond),
+		[]interface{}{Millis(ts)},
+	)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"1600000000", "1600000000123", "90", "1500", "1600000000123",
+	}, ss)
+
+	_, err = Flatten(Seconds("foo"))
+	assert.Error(t, err)
+	_, err = Flatten(Seconds(500 * time.Millisecond))
+	assert.Error(t, err)
+	_, err = Flatten(Millis(-time.Microsecond))
+	assert.Error(t, err)
+	ss, err = Flatten(Seconds(time.Duration(0)), Millis(-time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"0", "-1000"}, ss)
+
+	// a bare time.Duration must be given a unit, rather than being truncated,
+	// e.g. to an EXPIRE of 0
+	_, err = Flatten(500 * time.Millisecond)
+	assert.Error(t, err)
+	err = FlatCmd(nil, "EXPIRE", "foo", 500*time.Millisecond).MarshalRESP(new(bytes.Buffer))
+	assert.Error(t, err)
+
+	// a time.Time is sent as text unless a unit is given
+	ss, err = Flatten(ts.UTC())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"2020-09-13T12:26:40.123456789Z"}, ss)
+}
+
+func TestMarshal(t *T) {
+	b, err := Marshal(Cmd(nil, "GET", "foo"))
+	require.NoError(t, err)
+	assert.Equal(t, "*2\r\n$3\r\nGET\r\n$3\r\nfoo\r\n", string(b))
+
+	b, err = Marshal(FlatCmd(nil, "INCRBY", "foo", 5))
+	require.NoError(t, err)
+	assert.Equal(t, "*3\r\n$6\r\nINCRBY\r\n$3\r\nfoo\r\n$1\r\n5\r\n", string(b))
+
+	cmds := []CmdAction{Cmd(nil, "PING"), Cmd(nil, "GET", "foo")}
+	exp := "*1\r\n$4\r\nPING\r\n*2\r\n$3\r\nGET\r\n$3\r\nfoo\r\n"
+	for _, a := range []Action{
+		Pipeline(cmds...),
+		PipelineCollect(new([]error), cmds...),
+		PipelineAll(cmds...),
+	} {
+		b, err = Marshal(a)
+		require.NoError(t, err)
+		assert.Equal(t, exp, string(b))
+	}
+
+	_, err = Marshal(WithConn("foo", func(Conn) error { return nil }))
+	assert.Error(t, err)
+	_, err = Marshal(FlatCmd(nil, "SET", "foo", testErrMarshaler{}))
+	assert.Error(t, err)
+}
+
+func TestCmdInfo(t *T) {
+	info := func(a Action) (string, []string) {
+		ci, ok := a.(CmdInfo)
+		require.True(t, ok, "%T doesn't implement CmdInfo", a)
+		return ci.Cmd(), ci.Args()
+	}
+
+	cmd, args := info(Cmd(nil, "SET", "foo", "bar"))
+	assert.Equal(t, "SET", cmd)
+	assert.Equal(t, []string{"foo", "bar"}, args)
+
+	cmd, args = info(CmdCtx(context.Background(), nil, "GET", "foo"))
+	assert.Equal(t, "GET", cmd)
+	assert.Equal(t, []string{"foo"}, args)
+
+	// reading the args of a FlatCmd doesn't consume its LenReaders
+	flatArgs := []interface{}{1, []string{"a", "b"}, resp.NewLenReader(bytes.NewBufferString("buf"), 3), nil}
+	flat := FlatCmd(nil, "RPUSH", "foo", flatArgs...)
+	cmd, args = info(flat)
+	assert.Equal(t, "RPUSH", cmd)
+	exp := []string{"foo", "1", "a", "b", "buf", ""}
+	assert.Equal(t, exp, args)
+	_, args = info(flat)
+	assert.Equal(t, exp, args)
+	assert.Equal(t, `["RPUSH" "foo" "1" "a" "b" "buf" ""]`, cmdString(flat))
+	assert.Implements(t, new(resp.LenReader), flatArgs[2], "given args were modified")
+
+	// arguments which can't be flattened don't result in truncated args
+	_, args = info(FlatCmd(nil, "EXPIRE", "foo", time.Second))
+	assert.Nil(t, args)
+	_, args = info(NewEvalScript(1, "return 1").CmdKV(nil, []string{"foo"}, time.Second))
+	assert.Nil(t, args)
+
+	script := NewEvalScript(1, "return 1")
+	cmd, args = info(script.Cmd(nil, "foo", "bar"))
+	assert.Equal(t, "EVALSHA", cmd)
+	assert.Equal(t, []string{script.sum, "1", "foo", "bar"}, args)
+
+	cmd, args = info(script.CmdKV(nil, []string{"foo"}, "bar", 2))
+	assert.Equal(t, "EVALSHA", cmd)
+	assert.Equal(t, []string{script.sum, "1", "foo", "bar", "2"}, args)
+
+	ec := script.Cmd(nil, "foo").(*evalAction)
+	ec.eval = true
+	cmd, args = info(ec)
+	assert.Equal(t, "EVAL", cmd)
+	assert.Equal(t, []string{"return 1", "1", "foo"}, args)
+}
+
+func BenchmarkCmdInfo(b *B) {
+	b.Run("Cmd", func(b *B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_ = Cmd(nil, "SET", "foo", "bar").(CmdInfo).Args()
+		}
+	})
+	b.Run("FlatCmd", func(b *B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_ = FlatCmd(nil, "SET", "foo", 1).(CmdInfo).Args()
+		}
+	})
+	b.Run("cmdString", func(b *B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_ = cmdString(FlatCmd(nil, "SET", "foo", 1))
+		}
+	})
+}
+
 func ExampleFlatCmd() {
 	client, err := NewPool("tcp", "127.0.0.1:6379", 10) // or any other client
 	if err != nil {
@@ -196,6 +1248,219 @@ func TestEvalAction(t *T) {
 	}
 }
 
+func TestEvalActionCmdKV(t *T) {
+	script := NewEvalScript(2, `return 1`)
+
+	a := script.CmdKV(nil, []string{"a", "b"}, "c", 1, []int{2, 3}, map[string]int{"d": 4})
+	assert.Equal(t, []string{"a", "b"}, a.Keys())
+
+	buf := new(bytes.Buffer)
+	require.NoError(t, a.(resp.Marshaler).MarshalRESP(buf))
+	var got []string
+	require.NoError(t, resp2.RawMessage(buf.Bytes()).UnmarshalInto(resp2.Any{I: &got}))
+	assert.Equal(t, []string{
+		"EVALSHA", script.sum, "2", "a", "b", "c", "1", "2", "3", "d", "4",
+	}, got)
+
+	a = script.CmdKV(nil, []string{"a", "b"})
+	buf.Reset()
+	require.NoError(t, a.(resp.Marshaler).MarshalRESP(buf))
+	require.NoError(t, resp2.RawMessage(buf.Bytes()).UnmarshalInto(resp2.Any{I: &got}))
+	assert.Equal(t, []string{"EVALSHA", script.sum, "2", "a", "b"}, got)
+
+	assert.Panics(t, func() { script.CmdKV(nil, []string{"a"}, "b") })
+	assert.Panics(t, func() { script.CmdKV(nil, []string{"a", "b", "c"}) })
+}
+
+func TestEvalActionFlatCmd(t *T) {
+	script := NewEvalScript(1, `return 1`)
+
+	keys := []string{"a", "b"}
+	a := script.FlatCmd(nil, keys, 1.5, []int{2, 3})
+	assert.Equal(t, keys, a.Keys())
+	assert.Equal(t, `["EVALSHA" "`+script.sum+`" "2" "a" "b" "1.5" "2" "3"]`, cmdString(a.(resp.Marshaler)))
+
+	a = script.FlatCmd(nil, nil, "c")
+	assert.Empty(t, a.Keys())
+	assert.Equal(t, `["EVALSHA" "`+script.sum+`" "0" "c"]`, cmdString(a.(resp.Marshaler)))
+
+	// the EvalScript itself is unaffected
+	assert.Equal(t, []string{"d"}, script.Cmd(nil, "d", "e").Keys())
+}
+
+func TestEvalActionFlatCmdLive(t *T) {
+	c := dial()
+	defer c.Close()
+	k1, k2 := randStr(), randStr()
+	require.NoError(t, c.Do(Cmd(nil, "MSET", k1, "1", k2, "2")))
+
+	script := NewEvalScript(0, `
+		return redis.call("DEL", unpack(KEYS)) * ARGV[1]
+		-- `+randStr()+`
+	`)
+
+	var n int
+	require.NoError(t, c.Do(script.FlatCmd(&n, []string{k1, k2}, 3)))
+	assert.Equal(t, 6, n)
+}
+
+func TestEvalScriptTrackLoaded(t *T) {
+	// scriptConn returns a Conn to a fake redis instance, which shares whether
+	// the script is loaded with every other scriptConn, and which records the
+	// name of each command it receives.
+	var cmds []string
+	var loaded bool
+	scriptConn := func() Conn {
+		client, server := net.Pipe()
+		go func() {
+			defer server.Close()
+			br := bufio.NewReader(server)
+			for {
+				var args []string
+				if err := (resp2.Any{I: &args}).UnmarshalRESP(br); err != nil {
+					return
+				}
+				cmds = append(cmds, args[0])
+
+				reply := ":1\r\n"
+				switch {
+				case args[0] == "EVAL":
+					loaded = true
+				case args[0] == "EVALSHA" && !loaded:
+					reply = "-NOSCRIPT No matching script\r\n"
+				}
+				if _, err := server.Write([]byte(reply)); err != nil {
+					return
+				}
+			}
+		}()
+		return NewConn(client)
+	}
+
+	script := NewEvalScript(0, "return 1")
+	c1, c2 := scriptConn(), scriptConn()
+	defer c1.Close()
+	defer c2.Close()
+
+	var i int
+	require.NoError(t, c1.Do(script.Cmd(&i)))
+	assert.Equal(t, []string{"EVALSHA", "EVAL"}, cmds)
+
+	// without tracking EVALSHA is always tried first
+	cmds = nil
+	require.NoError(t, c2.Do(script.Cmd(&i)))
+	assert.Equal(t, []string{"EVALSHA"}, cmds)
+
+	tracked := script.TrackLoaded()
+	loaded = false
+	cmds = nil
+	require.NoError(t, c1.Do(tracked.Cmd(&i)))
+	require.NoError(t, c1.Do(tracked.Cmd(&i)))
+	require.NoError(t, c2.Do(tracked.Cmd(&i)))
+	require.NoError(t, c2.Do(tracked.Cmd(&i)))
+	assert.Equal(t, []string{"EVAL", "EVALSHA", "EVAL", "EVALSHA"}, cmds)
+	assert.Equal(t, 1, i)
+
+	// the script being flushed from the instance is still handled
+	loaded = false
+	cmds = nil
+	require.NoError(t, c1.Do(tracked.Cmd(&i)))
+	assert.Equal(t, []string{"EVALSHA", "EVAL"}, cmds)
+
+	// connections from a Pool are tracked too
+	pool := testPool(1, PoolPingInterval(0), PoolConnFunc(func(string, string) (Conn, error) {
+		return scriptConn(), nil
+	}))
+	defer pool.Close()
+	cmds = nil
+	require.NoError(t, pool.Do(tracked.Cmd(&i)))
+	require.NoError(t, pool.Do(tracked.Cmd(&i)))
+	assert.Equal(t, []string{"EVAL", "EVALSHA"}, cmds)
+
+	// other Conns aren't, and behave as normal
+	stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		cmds = append(cmds, args[0])
+		return 1
+	})
+	cmds = nil
+	require.NoError(t, stub.Do(tracked.Cmd(&i)))
+	assert.Equal(t, []string{"EVALSHA"}, cmds)
+}
+
+func TestEvalScriptNoScript(t *T) {
+	var cmds []string
+	var loaded bool
+	noScriptErr := "NOSCRIPT No matching script"
+	stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		cmds = append(cmds, args[0])
+		switch {
+		case args[0] == "EVAL":
+			loaded = true
+		case !loaded:
+			return resp2.Error{E: errors.New(noScriptErr)}
+		}
+		return 1
+	})
+
+	script := NewEvalScript(0, "return 1")
+	var i int
+	for _, noScriptErr = range []string{"NOSCRIPT No matching script", "noscript no matching script"} {
+		loaded, cmds = false, nil
+		require.NoError(t, stub.Do(script.Cmd(&i)))
+		assert.Equal(t, []string{"EVALSHA", "EVAL"}, cmds)
+		assert.Equal(t, 1, i)
+	}
+
+	// other errors don't cause a fallback
+	loaded, cmds, noScriptErr = false, nil, "ERR NOSCRIPT"
+	assert.Error(t, stub.Do(script.Cmd(&i)))
+	assert.Equal(t, []string{"EVALSHA"}, cmds)
+
+	// OnNoScriptFallback is called before each fallback to EVAL
+	var sums []string
+	tracked := script.OnNoScriptFallback(func(sum string) {
+		sums = append(sums, sum)
+		assert.Equal(t, []string{"EVALSHA"}, cmds)
+	})
+	loaded, cmds, noScriptErr = false, nil, "NOSCRIPT No matching script"
+	require.NoError(t, stub.Do(tracked.Cmd(&i)))
+	require.NoError(t, stub.Do(tracked.Cmd(&i)))
+	assert.Equal(t, []string{script.sum}, sums)
+
+	// ForceEval skips EVALSHA entirely
+	for _, a := range []Action{
+		script.ForceEval().Cmd(&i),
+		script.ForceEval().CmdKV(&i, nil),
+		script.ForceEval().FlatCmd(&i, nil),
+		script.TrackLoaded().ForceEval().Cmd(&i),
+	} {
+		loaded, cmds = true, nil
+		require.NoError(t, stub.Do(a))
+		assert.Equal(t, []string{"EVAL"}, cmds)
+		assert.Equal(t, "EVAL", a.(CmdInfo).Cmd())
+	}
+}
+
+func TestEvalActionCmdKVLive(t *T) {
+	c := dial()
+	defer c.Close()
+	key := randStr()
+
+	script := NewEvalScript(1, `
+		redis.call("SET", KEYS[1], ARGV[1], "EX", ARGV[2])
+		return redis.call("TTL", KEYS[1])
+		-- `+randStr()+`
+	`)
+
+	var ttl int
+	require.NoError(t, c.Do(script.CmdKV(&ttl, []string{key}, "foo", 100)))
+	assert.Equal(t, 100, ttl)
+
+	var got string
+	require.NoError(t, c.Do(Cmd(&got, "GET", key)))
+	assert.Equal(t, "foo", got)
+}
+
 func ExampleEvalScript() {
 	// set as a global variable, this script is equivalent to the builtin GETSET
 	// redis command
@@ -271,6 +1536,305 @@ func TestPipelineAction(t *T) {
 	})
 }
 
+func TestPipelinePooled(t *T) {
+	stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		return args[1]
+	})
+
+	var a, b string
+	p := Pipeline(Cmd(&a, "ECHO", "foo"), Cmd(&b, "ECHO", "bar"))
+	require.NoError(t, stub.Do(p))
+	assert.Equal(t, "foo", a)
+	assert.Equal(t, "bar", b)
+
+	// once performed successfully the Pipeline is reset, so that it can be
+	// reused by a later call to Pipeline
+	assert.Zero(t, p.(*PipelineBuilder).Len())
+	require.NoError(t, stub.Do(Pipeline(Cmd(&a, "ECHO", "baz"))))
+	assert.Equal(t, "baz", a)
+	assert.Equal(t, "bar", b)
+
+	// a failed Pipeline isn't reset
+	p = Pipeline(Cmd(nil, "ECHO", "foo"), Cmd(new(int), "ECHO", "bar"))
+	assert.Error(t, stub.Do(p))
+	assert.Equal(t, 2, p.(*PipelineBuilder).Len())
+
+	// nor is one created using NewPipeline
+	pb := NewPipeline()
+	pb.Append(Cmd(&a, "ECHO", "foo"))
+	require.NoError(t, stub.Do(pb))
+	assert.Equal(t, 1, pb.Len())
+}
+
+func TestPipelineBuilder(t *T) {
+	stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		return args[1]
+	})
+
+	pb := NewPipeline()
+	require.NoError(t, stub.Do(pb))
+	assert.Empty(t, pb.Keys())
+
+	out := make([]string, 3)
+	for i := range out {
+		pb.Append(Cmd(&out[i], "ECHO", strconv.Itoa(i)))
+	}
+	assert.Equal(t, 3, pb.Len())
+	require.NoError(t, stub.Do(pb))
+	assert.Equal(t, []string{"0", "1", "2"}, out)
+
+	capBefore := cap(pb.cmds)
+	pb.Reset()
+	assert.Equal(t, 0, pb.Len())
+	assert.Equal(t, capBefore, cap(pb.cmds))
+	assert.Nil(t, pb.cmds[:capBefore][0])
+
+	var a string
+	pb.Append(Cmd(&a, "ECHO", "foo"))
+	require.NoError(t, stub.Do(pb))
+	assert.Equal(t, "foo", a)
+	assert.Equal(t, []string{"0", "1", "2"}, out)
+}
+
+func TestPipelineError(t *T) {
+	stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		switch args[0] {
+		case "ECHO":
+			return args[1]
+		case "LPUSH":
+			return resp2.Error{E: errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")}
+		}
+		return resp2.Error{E: errors.Errorf("unknown command %q", args[0])}
+	})
+
+	var a, b string
+	var n int
+	failed := Cmd(nil, "LPUSH", "foo", "bar")
+	err := stub.Do(Pipeline(
+		Cmd(&a, "ECHO", "foo"),
+		failed,
+		Cmd(&n, "ECHO", "notint"),
+		Cmd(&b, "ECHO", "bar"),
+	))
+
+	var pe PipelineError
+	require.True(t, errors.As(err, &pe))
+	assert.Equal(t, 1, pe.Index)
+	assert.Equal(t, failed, pe.Cmd)
+	assert.Contains(t, err.Error(), "CmdAction 1 'LPUSH'")
+
+	var respErr resp2.Error
+	assert.True(t, errors.As(err, &respErr))
+	assert.True(t, errors.As(err, new(resp.ErrDiscarded)))
+	assert.True(t, errors.Is(err, pe.Err))
+	assert.Equal(t, "foo", a)
+
+	// the rest of the replies were discarded, so the Conn is still usable
+	require.NoError(t, stub.Do(Cmd(&b, "ECHO", "baz")))
+	assert.Equal(t, "baz", b)
+
+	// decode errors of the receiver are reported the same way
+	err = stub.Do(Pipeline(Cmd(&a, "ECHO", "foo"), Cmd(&n, "ECHO", "notint")))
+	require.True(t, errors.As(err, &pe))
+	assert.Equal(t, 1, pe.Index)
+	assert.True(t, errors.As(err, new(resp.ErrDiscarded)))
+}
+
+func TestPipelineAll(t *T) {
+	stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		switch args[0] {
+		case "ECHO":
+			return args[1]
+		case "LPUSH":
+			return resp2.Error{E: errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")}
+		}
+		return resp2.Error{E: errors.Errorf("unknown command %q", args[0])}
+	})
+
+	var a, b string
+	var n int
+	err := stub.Do(PipelineAll(
+		Cmd(&a, "ECHO", "foo"),
+		Cmd(nil, "LPUSH", "foo", "bar"),
+		Cmd(&n, "ECHO", "notint"),
+		Cmd(&b, "ECHO", "bar"),
+		Cmd(nil, "LPUSH", "baz", "bar"),
+	))
+
+	var pes PipelineErrors
+	require.True(t, errors.As(err, &pes))
+	require.Len(t, pes, 3)
+	for i, expIndex := range []int{1, 2, 4} {
+		var pe PipelineError
+		require.True(t, errors.As(pes[i], &pe))
+		assert.Equal(t, expIndex, pe.Index)
+	}
+	assert.True(t, errors.As(pes[0], new(resp2.Error)))
+	assert.False(t, errors.As(pes[1], new(resp2.Error)))
+	assert.Contains(t, err.Error(), "3 pipeline CmdActions failed")
+	assert.Equal(t, "foo", a)
+	assert.Equal(t, "bar", b)
+
+	require.NoError(t, stub.Do(PipelineAll(Cmd(&a, "ECHO", "baz"))))
+	assert.Equal(t, "baz", a)
+	require.NoError(t, stub.Do(PipelineAll()))
+}
+
+func TestPipelineInto(t *T) {
+	stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		switch args[0] {
+		case "ECHO":
+			return args[1]
+		case "SMEMBERS":
+			return []string{"a", "b"}
+		}
+		return resp2.Error{E: errors.Errorf("unknown command %q", args[0])}
+	})
+
+	var res struct {
+		Name    string
+		skipped string
+		Visits  int
+		Tags    []string
+	}
+	var ignored string
+	require.NoError(t, stub.Do(PipelineInto(&res,
+		Cmd(&ignored, "ECHO", "foo"),
+		Cmd(nil, "ECHO", "3"),
+		Cmd(nil, "SMEMBERS", "tags"),
+	)))
+	assert.Equal(t, "foo", res.Name)
+	assert.Equal(t, 3, res.Visits)
+	assert.Equal(t, []string{"a", "b"}, res.Tags)
+	assert.Empty(t, res.skipped)
+	assert.Empty(t, ignored)
+
+	// a failed CmdAction results in a PipelineError, and the remaining replies
+	// are discarded
+	err := stub.Do(PipelineInto(&res,
+		Cmd(nil, "ECHO", "bar"),
+		Cmd(nil, "BADCMD"),
+		Cmd(nil, "ECHO", "c"),
+	))
+	var pe PipelineError
+	require.True(t, errors.As(err, &pe))
+	assert.Equal(t, 1, pe.Index)
+	assert.Equal(t, "bar", res.Name)
+	require.NoError(t, stub.Do(Cmd(&ignored, "ECHO", "baz")))
+	assert.Equal(t, "baz", ignored)
+
+	// invalid destinations are rejected before anything is written
+	for _, dst := range []interface{}{nil, res, &ignored, &res} {
+		assert.Error(t, stub.Do(PipelineInto(dst, Cmd(nil, "ECHO", "foo"))))
+	}
+	require.NoError(t, stub.Do(Cmd(&ignored, "ECHO", "qux")))
+	assert.Equal(t, "qux", ignored)
+}
+
+type encodeCountingConn struct {
+	Conn
+	encodes int
+}
+
+func (ec *encodeCountingConn) Encode(m resp.Marshaler) error {
+	ec.encodes++
+	return ec.Conn.Encode(m)
+}
+
+func (ec *encodeCountingConn) Do(a Action) error {
+	return a.Run(ec)
+}
+
+func TestBatchedPipeline(t *T) {
+	stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		switch args[0] {
+		case "ECHO":
+			return args[1]
+		case "LPUSH":
+			return resp2.Error{E: errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")}
+		}
+		return resp2.Error{E: errors.Errorf("unknown command %q", args[0])}
+	})
+
+	strs := make([]string, 7)
+	cmds := make([]CmdAction, len(strs))
+	for i := range cmds {
+		cmds[i] = Cmd(&strs[i], "ECHO", strconv.Itoa(i))
+	}
+
+	c := &encodeCountingConn{Conn: stub}
+	require.NoError(t, c.Do(BatchedPipeline(3, cmds...)))
+	assert.Equal(t, []string{"0", "1", "2", "3", "4", "5", "6"}, strs)
+	assert.Equal(t, 3, c.encodes)
+
+	// an error aborts the remaining batches, and has an overall index
+	var a, b string
+	c = &encodeCountingConn{Conn: stub}
+	err := c.Do(BatchedPipeline(2,
+		Cmd(nil, "ECHO", "foo"),
+		Cmd(nil, "ECHO", "bar"),
+		Cmd(nil, "LPUSH", "foo", "bar"),
+		Cmd(&a, "ECHO", "baz"),
+		Cmd(&b, "ECHO", "biz"),
+	))
+	var pe PipelineError
+	require.True(t, errors.As(err, &pe))
+	assert.Equal(t, 2, pe.Index)
+	assert.True(t, errors.As(err, new(resp2.Error)))
+	assert.Equal(t, 2, c.encodes)
+	assert.Empty(t, a)
+	assert.Empty(t, b)
+
+	// the Conn is still usable afterwards
+	require.NoError(t, c.Do(BatchedPipeline(2, Cmd(&a, "ECHO", "buz"))))
+	assert.Equal(t, "buz", a)
+	require.NoError(t, c.Do(BatchedPipeline(2)))
+
+	assert.Panics(t, func() { BatchedPipeline(0) })
+}
+
+func TestPipelineCollect(t *T) {
+	stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		switch args[0] {
+		case "ECHO":
+			return args[1]
+		case "LPUSH":
+			return resp2.Error{E: errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")}
+		}
+		return resp2.Error{E: errors.Errorf("unknown command %q", args[0])}
+	})
+
+	var a, c string
+	var b, d int
+	var errs []error
+	require.NoError(t, stub.Do(PipelineCollect(&errs,
+		Cmd(&a, "ECHO", "foo"),
+		Cmd(nil, "LPUSH", "foo", "bar"),
+		Cmd(&b, "ECHO", "notint"),
+		Cmd(&c, "ECHO", "baz"),
+		Cmd(&d, "ECHO", "5"),
+	)))
+
+	require.Len(t, errs, 5)
+	assert.NoError(t, errs[0])
+	assert.True(t, errors.As(errs[1], new(resp2.Error)))
+	assert.Error(t, errs[2])
+	assert.NoError(t, errs[3])
+	assert.NoError(t, errs[4])
+	assert.Equal(t, "foo", a)
+	assert.Equal(t, "baz", c)
+	assert.Equal(t, 5, d)
+
+	// an error which prevents reading replies fails the rest of the pipeline
+	stub.Close()
+	var got string
+	err := stub.Do(PipelineCollect(&errs, Cmd(&got, "ECHO", "foo"), Cmd(&got, "ECHO", "bar")))
+	assert.Error(t, err)
+	require.Len(t, errs, 2)
+	assert.Error(t, errs[0])
+	assert.Error(t, errs[1])
+}
+
 func ExamplePipeline() {
 	client, err := NewPool("tcp", "127.0.0.1:6379", 10) // or any other client
 	if err != nil {
@@ -302,6 +1866,94 @@ func TestWithConnAction(t *T) {
 	require.Nil(t, err)
 }
 
+func TestWithConnKeys(t *T) {
+	noop := func(Conn) error { return nil }
+	assert.Equal(t, []string{"foo"}, WithConn("foo", noop).Keys())
+	assert.Equal(t, []string{"foo"}, WithConnTimeout("foo", time.Second, noop).Keys())
+	assert.Equal(t, []string{"foo", "bar"}, WithConnKeys([]string{"foo", "bar"}, noop).Keys())
+	assert.Empty(t, WithConnKeys([]string{}, noop).Keys())
+	assert.Empty(t, WithConnKeys(nil, noop).Keys())
+
+	stub := testStub()
+	var foo string
+	require.NoError(t, stub.Do(WithConnKeys([]string{"foo", "bar"}, func(conn Conn) error {
+		if err := conn.Do(Cmd(nil, "SET", "foo", "1")); err != nil {
+			return err
+		}
+		return conn.Do(Cmd(&foo, "GET", "foo"))
+	})))
+	assert.Equal(t, "1", foo)
+}
+
+func TestConditional(t *T) {
+	kv := map[string]string{"foo": "bar"}
+	stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		switch args[0] {
+		case "EXISTS":
+			if _, ok := kv[args[1]]; ok {
+				return 1
+			}
+			return 0
+		case "GET":
+			if v, ok := kv[args[1]]; ok {
+				return v
+			}
+			return nil
+		}
+		return resp2.Error{E: errors.Errorf("unknown command %q", args[0])}
+	})
+
+	getIfExists := func(rcv *string, key string) Action {
+		return Conditional(Cmd(nil, "EXISTS", key), func(res interface{}) (Action, error) {
+			if res.(int64) == 0 {
+				return nil, nil
+			}
+			return Cmd(rcv, "GET", key), nil
+		})
+	}
+
+	var got string
+	require.NoError(t, stub.Do(getIfExists(&got, "foo")))
+	assert.Equal(t, "bar", got)
+
+	got = ""
+	require.NoError(t, stub.Do(getIfExists(&got, "baz")))
+	assert.Empty(t, got)
+	assert.Equal(t, []string{"baz"}, getIfExists(nil, "baz").Keys())
+
+	t.Run("firstRcv", func(t *T) {
+		var n int
+		var res interface{}
+		require.NoError(t, stub.Do(Conditional(Cmd(&n, "EXISTS", "foo"), func(r interface{}) (Action, error) {
+			res = r
+			return nil, nil
+		})))
+		assert.Equal(t, 1, n)
+		assert.Equal(t, int64(1), res)
+	})
+
+	t.Run("firstErr", func(t *T) {
+		err := stub.Do(Conditional(Cmd(nil, "BOOM"), func(interface{}) (Action, error) {
+			t.Fatal("decide shouldn't be called")
+			return nil, nil
+		}))
+		assert.True(t, errors.As(err, new(resp2.Error)))
+
+		// the connection is still usable
+		require.NoError(t, stub.Do(Cmd(&got, "GET", "foo")))
+		assert.Equal(t, "bar", got)
+	})
+
+	t.Run("decideErr", func(t *T) {
+		decideErr := errors.New("decide failed")
+		err := stub.Do(Conditional(Cmd(nil, "GET", "foo"), func(res interface{}) (Action, error) {
+			assert.Equal(t, []byte("bar"), res)
+			return nil, decideErr
+		}))
+		assert.Equal(t, decideErr, err)
+	})
+}
+
 func ExampleWithConn() {
 	client, err := NewPool("tcp", "127.0.0.1:6379", 10) // or any other client
 	if err != nil {
@@ -386,12 +2038,14 @@ func ExampleWithConn_transaction() {
 
 func TestMaybeNil(t *T) {
 	mntests := []struct {
-		b       string
-		isNil   bool
-		isEmpty bool
+		b          string
+		isNil      bool
+		isNilArray bool
+		isEmpty    bool
 	}{
 		{b: "$-1\r\n", isNil: true},
-		{b: "*-1\r\n", isNil: true},
+		{b: "*-1\r\n", isNil: true, isNilArray: true},
+		{b: "_\r\n", isNil: true},
 		{b: "+foo\r\n"},
 		{b: "-\r\n"},
 		{b: "-foo\r\n"},
@@ -414,6 +2068,8 @@ func TestMaybeNil(t *T) {
 			switch {
 			case mnt.isNil:
 				assert.True(t, mn.Nil)
+				assert.Equal(t, !mnt.isNilArray, mn.NilBulk)
+				assert.Equal(t, mnt.isNilArray, mn.NilArray)
 			case mnt.isEmpty:
 				assert.True(t, mn.EmptyArray)
 				assert.Equal(t, mnt.b, string(rm))
@@ -424,6 +2080,251 @@ func TestMaybeNil(t *T) {
 	}
 }
 
+func TestStream(t *T) {
+	br := bufio.NewReader(bytes.NewBufferString(
+		"*3\r\n$3\r\nfoo\r\n*2\r\n:1\r\n:2\r\n$3\r\nbar\r\n" +
+			"*3\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nc\r\n" +
+			"*-1\r\n" +
+			"+OK\r\n",
+	))
+
+	var got []string
+	require.NoError(t, Stream(func(rm resp2.RawMessage) error {
+		got = append(got, string(rm))
+		return nil
+	}).UnmarshalRESP(br))
+	assert.Equal(t, []string{"$3\r\nfoo\r\n", "*2\r\n:1\r\n:2\r\n", "$3\r\nbar\r\n"}, got)
+
+	// an error from fn stops it being called, but the rest is still read
+	errFoo := errors.New("foo")
+	var calls int
+	err := Stream(func(resp2.RawMessage) error {
+		calls++
+		return errFoo
+	}).UnmarshalRESP(br)
+	assert.True(t, errors.As(err, new(resp.ErrDiscarded)))
+	assert.True(t, errors.Is(err, errFoo))
+	assert.Equal(t, 1, calls)
+
+	// nil arrays don't call fn at all
+	require.NoError(t, Stream(func(resp2.RawMessage) error {
+		t.Fatal("fn called for nil array")
+		return nil
+	}).UnmarshalRESP(br))
+
+	// the reply was fully consumed each time
+	var ok string
+	require.NoError(t, (resp2.Any{I: &ok}).UnmarshalRESP(br))
+	assert.Equal(t, "OK", ok)
+}
+
+func TestCount(t *T) {
+	br := bufio.NewReader(bytes.NewBufferString(
+		"*3\r\n$3\r\nfoo\r\n*2\r\n:1\r\n:2\r\n$3\r\nbar\r\n" +
+			"*0\r\n" +
+			"*-1\r\n" +
+			"$3\r\nfoo\r\n" +
+			"-ERR foo\r\n" +
+			"+OK\r\n",
+	))
+
+	var n int
+	require.NoError(t, Count(&n).UnmarshalRESP(br))
+	assert.Equal(t, 3, n)
+	require.NoError(t, Count(&n).UnmarshalRESP(br))
+	assert.Equal(t, 0, n)
+	n = 5
+	require.NoError(t, Count(&n).UnmarshalRESP(br))
+	assert.Equal(t, 0, n)
+
+	// non-array replies are an error
+	n = 5
+	err := Count(&n).UnmarshalRESP(br)
+	assert.True(t, errors.As(err, new(resp.ErrDiscarded)))
+	assert.Equal(t, 5, n)
+	err = Count(&n).UnmarshalRESP(br)
+	assert.True(t, errors.As(err, new(resp2.Error)))
+	assert.Equal(t, 5, n)
+
+	// the reply was fully consumed each time
+	var ok string
+	require.NoError(t, (resp2.Any{I: &ok}).UnmarshalRESP(br))
+	assert.Equal(t, "OK", ok)
+}
+
+func TestInto(t *T) {
+	type testStruct struct {
+		A string
+		B int `redis:"b"`
+		C int
+	}
+
+	stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		switch args[1] {
+		case "foo":
+			return []string{"A", "a", "b", "0", "D", "d"}
+		case "twice":
+			return []string{"C", "4", "C", "5"}
+		}
+		return []string{"C", "3"}
+	})
+
+	s := testStruct{C: 2}
+	set := []string{"stale"}
+	require.NoError(t, stub.Do(Cmd(Into(&s, &set), "HGETALL", "foo")))
+	assert.Equal(t, testStruct{A: "a", C: 2}, s)
+	assert.Equal(t, []string{"A", "B"}, set)
+
+	require.NoError(t, stub.Do(Cmd(Into(&s, &set), "HGETALL", "bar")))
+	assert.Equal(t, testStruct{A: "a", C: 3}, s)
+	assert.Equal(t, []string{"C"}, set)
+
+	// a field given twice is listed twice
+	require.NoError(t, stub.Do(Cmd(Into(&s, &set), "HGETALL", "twice")))
+	assert.Equal(t, testStruct{A: "a", C: 5}, s)
+	assert.Equal(t, []string{"C", "C"}, set)
+
+	// a nil setFields is like using rcv directly
+	require.NoError(t, stub.Do(Cmd(Into(&s, nil), "HGETALL", "bar")))
+	assert.Equal(t, testStruct{A: "a", C: 3}, s)
+}
+
+func TestWithAttributes(t *T) {
+	br := bufio.NewReader(bytes.NewBufferString(
+		"|1\r\n+ttl\r\n:3600\r\n|1\r\n+ttl\r\n:60\r\n*2\r\n:1\r\n:2\r\n" +
+			"+OK\r\n",
+	))
+
+	var ii []int
+	var attrs map[string]interface{}
+	require.NoError(t, Cmd(WithAttributes(&ii, &attrs), "CMD").UnmarshalRESP(br))
+	assert.Equal(t, []int{1, 2}, ii)
+	assert.Equal(t, map[string]interface{}{"ttl": int64(60)}, attrs)
+
+	var ok string
+	attrs = nil
+	require.NoError(t, WithAttributes(&ok, &attrs).UnmarshalRESP(br))
+	assert.Equal(t, "OK", ok)
+	assert.Nil(t, attrs)
+}
+
+func TestTuple(t *T) {
+	var count int64
+	var lowest, highest string
+	var consumers [][]string
+	br := bufio.NewReader(bytes.NewBufferString(
+		"*4\r\n:2\r\n$3\r\n1-0\r\n$3\r\n2-0\r\n*1\r\n*2\r\n$3\r\nfoo\r\n$1\r\n2\r\n" +
+			"*3\r\n:1\r\n:2\r\n:3\r\n" +
+			"*2\r\n$3\r\nfoo\r\n:1\r\n" +
+			"*2\r\n:1\r\n:2\r\n" +
+			"+OK\r\n",
+	))
+	require.NoError(t, Tuple(&count, &lowest, &highest, &consumers).UnmarshalRESP(br))
+	assert.Equal(t, int64(2), count)
+	assert.Equal(t, "1-0", lowest)
+	assert.Equal(t, "2-0", highest)
+	assert.Equal(t, [][]string{{"foo", "2"}}, consumers)
+
+	// length mismatch
+	err := Tuple(&count, &lowest).UnmarshalRESP(br)
+	assert.True(t, errors.As(err, new(resp.ErrDiscarded)))
+	assert.Contains(t, err.Error(), "expected array of 2 elements, got 3")
+
+	// an element which can't be unmarshaled doesn't stop the others
+	var bl bool
+	count = 0
+	err = Tuple(&count, &bl).UnmarshalRESP(br)
+	assert.True(t, errors.As(err, new(resp.ErrDiscarded)))
+	assert.Contains(t, err.Error(), "element 0")
+
+	// a nil receiver discards its element
+	count = 0
+	require.NoError(t, Tuple(nil, &count).UnmarshalRESP(br))
+	assert.Equal(t, int64(2), count)
+
+	// the reply was fully consumed each time
+	var ok string
+	require.NoError(t, (resp2.Any{I: &ok}).UnmarshalRESP(br))
+	assert.Equal(t, "OK", ok)
+}
+
+func TestReplyErrors(t *T) {
+	errLocked := errors.New("locked")
+	errs := map[string]error{"LOCKED": errLocked}
+
+	tests := []struct {
+		b      string
+		expErr error
+		exp    interface{}
+	}{
+		{b: "+LOCKED\r\n", expErr: errLocked},
+		{b: "$6\r\nLOCKED\r\n", expErr: errLocked},
+		{b: "+OK\r\n", exp: "OK"},
+		{b: "$3\r\nfoo\r\n", exp: "foo"},
+		{b: "$-1\r\n", exp: ""},
+		{b: ":5\r\n", exp: "5"},
+		{b: "*1\r\n+LOCKED\r\n", exp: []string{"LOCKED"}},
+	}
+
+	for _, test := range tests {
+		br := bufio.NewReader(bytes.NewBufferString(test.b))
+		var str string
+		var strs []string
+		re := ReplyErrors{Rcv: &str, Errs: errs}
+		if _, ok := test.exp.([]string); ok {
+			re.Rcv = &strs
+		}
+
+		err := re.UnmarshalRESP(br)
+		assert.Zero(t, br.Buffered(), "test:%q", test.b)
+		if test.expErr != nil {
+			assert.True(t, errors.Is(err, test.expErr), "test:%q", test.b)
+			assert.True(t, errors.As(err, new(resp.ErrDiscarded)), "test:%q", test.b)
+			assert.Empty(t, str, "test:%q", test.b)
+			continue
+		}
+
+		require.NoError(t, err, "test:%q", test.b)
+		if exp, ok := test.exp.([]string); ok {
+			assert.Equal(t, exp, strs, "test:%q", test.b)
+		} else {
+			assert.Equal(t, test.exp, str, "test:%q", test.b)
+		}
+	}
+
+	// resp errors are passed through untouched
+	br := bufio.NewReader(bytes.NewBufferString("-ERR LOCKED\r\n"))
+	err := (&ReplyErrors{Errs: errs}).UnmarshalRESP(br)
+	assert.True(t, errors.As(err, new(resp2.Error)))
+	assert.False(t, errors.Is(err, errLocked))
+}
+
+func TestDecodeFunc(t *T) {
+	stub := testStub()
+	require.NoError(t, stub.Do(Cmd(nil, "SET", "foo", "bar")))
+
+	var got string
+	rcv := DecodeFunc(func(br *bufio.Reader) error {
+		return resp2.Any{I: &got}.UnmarshalRESP(br)
+	})
+	require.NoError(t, stub.Do(Cmd(rcv, "GET", "foo")))
+	assert.Equal(t, "bar", got)
+
+	errFoo := errors.New("foo")
+	rcv = DecodeFunc(func(br *bufio.Reader) error {
+		if err := (resp2.Any{}).UnmarshalRESP(br); err != nil {
+			return err
+		}
+		return resp.ErrDiscarded{Err: errFoo}
+	})
+	err := stub.Do(Cmd(rcv, "GET", "foo"))
+	assert.True(t, errors.Is(err, errFoo))
+
+	// the stub should still be usable after the error
+	require.NoError(t, stub.Do(Cmd(&got, "ECHO", "baz")))
+	assert.Equal(t, "baz", got)
+}
+
 func ExampleMaybeNil() {
 	client, err := NewPool("tcp", "127.0.0.1:6379", 10) // or any other client
 	if err != nil {
@@ -450,6 +2351,15 @@ func BenchmarkCmdActionKeys(b *B) {
 	}
 }
 
+func BenchmarkCmdActionKeysParallel(b *B) {
+	cmd := Cmd(nil, "GET", "a")
+	b.RunParallel(func(pb *PB) {
+		for pb.Next() {
+			_ = cmd.Keys()
+		}
+	})
+}
+
 func BenchmarkFlatCmdActionKeys(b *B) {
 	for i := 0; i < b.N; i++ {
 		benchCmdActionKeys = FlatCmd(nil, "GET", "a").Keys()
@@ -461,3 +2371,13 @@ func BenchmarkWithConnKeys(b *B) {
 		benchCmdActionKeys = WithConn("a", func(Conn) error { return nil }).Keys()
 	}
 }
+
+var benchCmdString string // global variable used to store the string in benchmarks
+
+func BenchmarkCmdString(b *B) {
+	cmd := Cmd(nil, "SET", "foo", "bar", "EX", "10")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		benchCmdString = cmdString(cmd)
+	}
+}
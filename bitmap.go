@@ -0,0 +1,165 @@
+package radix
+
+import (
+	"math/bits"
+	"strconv"
+
+	errors "golang.org/x/xerrors"
+)
+
+// BitFieldOp describes a single operation to be performed as part of a BITFIELD
+// or BITFIELD_RO command. Use one of the BitField* functions to create one.
+type BitFieldOp struct {
+	args []string
+}
+
+// BitFieldGet returns a BitFieldOp which performs a GET of the integer of the
+// given type (e.g. "u8", "i16") at the given offset. The offset may be either a
+// bit offset (e.g. "100") or, if prefixed with "#", a multiple of the type's
+// width (e.g. "#2").
+func BitFieldGet(typ, offset string) BitFieldOp {
+	return BitFieldOp{args: []string{"GET", typ, offset}}
+}
+
+// BitFieldSet returns a BitFieldOp which performs a SET of the integer of the
+// given type at the given offset. See BitFieldGet for the format of typ and
+// offset.
+func BitFieldSet(typ, offset string, value int64) BitFieldOp {
+	return BitFieldOp{args: []string{"SET", typ, offset, strconv.FormatInt(value, 10)}}
+}
+
+// BitFieldIncrBy returns a BitFieldOp which performs an INCRBY of the integer
+// of the given type at the given offset. See BitFieldGet for the format of typ
+// and offset.
+func BitFieldIncrBy(typ, offset string, incr int64) BitFieldOp {
+	return BitFieldOp{args: []string{"INCRBY", typ, offset, strconv.FormatInt(incr, 10)}}
+}
+
+// BitFieldOverflow returns a BitFieldOp which sets the overflow behavior, one
+// of "WRAP", "SAT", or "FAIL", of all SET and INCRBY operations following it.
+func BitFieldOverflow(behavior string) BitFieldOp {
+	return BitFieldOp{args: []string{"OVERFLOW", behavior}}
+}
+
+func bitFieldArgs(key string, ops []BitFieldOp) []string {
+	args := []string{key}
+	for _, op := range ops {
+		args = append(args, op.args...)
+	}
+	return args
+}
+
+// BitField returns a CmdAction which performs a BITFIELD command on the given
+// key, with the given operations, and unmarshals the result of each operation
+// into rcv. When the FAIL overflow behavior prevents an operation the result is
+// a nil reply, which will be unmarshaled as 0.
+func BitField(rcv *[]int64, key string, ops ...BitFieldOp) CmdAction {
+	return Cmd(rcv, "BITFIELD", bitFieldArgs(key, ops)...)
+}
+
+// BitFieldRO returns a CmdAction which performs a BITFIELD_RO command on the
+// given key, with the given operations, and unmarshals the result of each
+// operation into rcv. BITFIELD_RO is only available in redis 6.2 and later.
+//
+// Since BITFIELD_RO is read-only it may be performed on replicas, e.g. by
+// using Cluster's DoSecondary method. Only GET operations (see BitFieldGet) are
+// allowed, an error is returned if any other operation, or the zero value of
+// BitFieldOp, is given.
+func BitFieldRO(rcv *[]int64, key string, ops ...BitFieldOp) (CmdAction, error) {
+	for _, op := range ops {
+		if len(op.args) == 0 {
+			return nil, errors.New("BITFIELD_RO was given an empty BitFieldOp")
+		} else if op.args[0] != "GET" {
+			return nil, errors.Errorf("BITFIELD_RO only supports GET operations, not %s", op.args[0])
+		}
+	}
+	return Cmd(rcv, "BITFIELD_RO", bitFieldArgs(key, ops)...), nil
+}
+
+// Bitset is a convenience type for working with the raw bytes of a redis
+// bitmap, e.g. as returned by GET on a key which was populated using SETBIT.
+// Bits are indexed in the same way as by SETBIT and GETBIT: bit 0 is the most
+// significant bit of the first byte.
+//
+// A Bitset can be used directly as the receiver of a GET, and as a value to
+// SET, since it implements encoding.BinaryUnmarshaler and
+// encoding.BinaryMarshaler:
+//
+//	var bs radix.Bitset
+//	err := client.Do(radix.Cmd(&bs, "GET", key))
+//	if err != nil {
+//		// handle error
+//	}
+//	fmt.Println(bs.Test(7), bs.Count())
+//
+// A key which doesn't exist results in an empty Bitset.
+type Bitset []byte
+
+// Len returns the number of bits in the Bitset, which is always a multiple of
+// 8.
+func (bs Bitset) Len() int64 {
+	return int64(len(bs)) * 8
+}
+
+// Test returns whether the bit at offset i is set. Bits beyond the end of the
+// Bitset are considered to be unset, as with GETBIT.
+func (bs Bitset) Test(i int64) bool {
+	if i < 0 || i >= bs.Len() {
+		return false
+	}
+	return bs[i/8]&(0x80>>uint(i%8)) != 0
+}
+
+// Set sets or clears the bit at offset i, growing the Bitset as necessary, and
+// returns the resulting Bitset. Like SETBIT, any bytes added are zero-filled.
+func (bs Bitset) Set(i int64, v bool) Bitset {
+	if i < 0 {
+		return bs
+	}
+	for int64(len(bs)) <= i/8 {
+		bs = append(bs, 0)
+	}
+	mask := byte(0x80 >> uint(i%8))
+	if v {
+		bs[i/8] |= mask
+	} else {
+		bs[i/8] &^= mask
+	}
+	return bs
+}
+
+// Count returns the number of bits which are set, as BITCOUNT would.
+func (bs Bitset) Count() int64 {
+	var n int
+	for _, b := range bs {
+		n += bits.OnesCount8(b)
+	}
+	return int64(n)
+}
+
+// Iterate calls fn with the offset of each bit which is set, in ascending
+// order. If fn returns false then iteration stops.
+func (bs Bitset) Iterate(fn func(i int64) bool) {
+	for bi, b := range bs {
+		for b != 0 {
+			lz := bits.LeadingZeros8(b)
+			if !fn(int64(bi)*8 + int64(lz)) {
+				return
+			}
+			b &^= 0x80 >> uint(lz)
+		}
+	}
+}
+
+// MarshalBinary implements the method for the encoding.BinaryMarshaler
+// interface.
+func (bs Bitset) MarshalBinary() ([]byte, error) {
+	return []byte(bs), nil
+}
+
+// UnmarshalBinary implements the method for the encoding.BinaryUnmarshaler
+// interface.
+func (bs *Bitset) UnmarshalBinary(b []byte) error {
+	*bs = append((*bs)[:0], b...)
+	return nil
+}
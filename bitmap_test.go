@@ -0,0 +1,117 @@
+package radix
+
+import (
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBitField(t *T) {
+	c := dial()
+	defer c.Close()
+	key := randStr()
+
+	var res []int64
+	require.NoError(t, c.Do(BitField(&res, key,
+		BitFieldSet("u8", "0", 200),
+		BitFieldIncrBy("u8", "#1", 5),
+		BitFieldOverflow("FAIL"),
+		BitFieldIncrBy("u8", "0", 100),
+	)))
+	assert.Equal(t, []int64{0, 5, 0}, res)
+
+	a, err := BitFieldRO(&res, key, BitFieldGet("u8", "0"), BitFieldGet("u8", "#1"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{key}, a.Keys())
+	require.NoError(t, c.Do(a))
+	assert.Equal(t, []int64{200, 5}, res)
+}
+
+func TestBitFieldRO(t *T) {
+	for _, op := range []BitFieldOp{
+		BitFieldSet("u8", "0", 1),
+		BitFieldIncrBy("u8", "0", 1),
+		BitFieldOverflow("SAT"),
+		{},
+	} {
+		_, err := BitFieldRO(nil, "foo", BitFieldGet("u8", "0"), op)
+		assert.Error(t, err)
+	}
+
+	a, err := BitFieldRO(nil, "foo", BitFieldGet("u8", "0"), BitFieldGet("i4", "#3"))
+	require.NoError(t, err)
+	assert.Equal(t, `["BITFIELD_RO" "foo" "GET" "u8" "0" "GET" "i4" "#3"]`, cmdString(a))
+	assert.Equal(t, []string{"foo"}, a.Keys())
+}
+
+func TestBitset(t *T) {
+	var bs Bitset
+	for _, i := range []int64{0, 7, 9, 23} {
+		bs = bs.Set(i, true)
+	}
+	assert.Equal(t, Bitset{0x81, 0x40, 0x01}, bs)
+	assert.Equal(t, int64(24), bs.Len())
+	assert.Equal(t, int64(4), bs.Count())
+	assert.True(t, bs.Test(9))
+	assert.False(t, bs.Test(8))
+	assert.False(t, bs.Test(100))
+	assert.False(t, bs.Test(-1))
+
+	var got []int64
+	bs.Iterate(func(i int64) bool {
+		got = append(got, i)
+		return true
+	})
+	assert.Equal(t, []int64{0, 7, 9, 23}, got)
+
+	got = nil
+	bs.Iterate(func(i int64) bool {
+		got = append(got, i)
+		return len(got) < 2
+	})
+	assert.Equal(t, []int64{0, 7}, got)
+
+	bs = bs.Set(7, false)
+	assert.Equal(t, Bitset{0x80, 0x40, 0x01}, bs)
+
+	// round-trip through the stub's SET and GET
+	stub := testStub()
+	require.NoError(t, stub.Do(FlatCmd(nil, "SET", "bits", bs)))
+	var bs2 Bitset
+	require.NoError(t, stub.Do(Cmd(&bs2, "GET", "bits")))
+	assert.Equal(t, bs, bs2)
+}
+
+func TestBitsetLive(t *T) {
+	c := dial()
+	defer c.Close()
+	key := randStr()
+
+	offsets := []int64{1, 8, 15, 100}
+	for _, i := range offsets {
+		require.NoError(t, c.Do(FlatCmd(nil, "SETBIT", key, i, 1)))
+	}
+
+	var bs Bitset
+	require.NoError(t, c.Do(Cmd(&bs, "GET", key)))
+	assert.Equal(t, int64(len(offsets)), bs.Count())
+	var got []int64
+	bs.Iterate(func(i int64) bool {
+		got = append(got, i)
+		return true
+	})
+	assert.Equal(t, offsets, got)
+
+	// a Bitset SET into redis is read the same by GETBIT
+	key2 := randStr()
+	require.NoError(t, c.Do(FlatCmd(nil, "SET", key2, bs.Set(3, true))))
+	for _, i := range []int64{1, 3, 8, 15, 100} {
+		var bit int
+		require.NoError(t, c.Do(FlatCmd(&bit, "GETBIT", key2, i)))
+		assert.Equal(t, 1, bit, "offset:%d", i)
+	}
+
+	require.NoError(t, c.Do(Cmd(&bs, "GET", randStr())))
+	assert.Empty(t, bs)
+}
@@ -1,6 +1,7 @@
 package radix
 
 import (
+	"bufio"
 	"reflect"
 	"strings"
 	"sync"
@@ -48,12 +49,33 @@ func (d *dedupe) do(fn func()) {
 // Action will be retried on the correct node.
 //
 // NOTE that the Actions which are returned by Cmd, FlatCmd, and EvalScript.Cmd
-// all implicitly implement this interface.
+// all implicitly implement this interface. NoRetry can be used to prevent them
+// from being retried.
 type ClusterCanRetryAction interface {
 	Action
 	ClusterCanRetry() bool
 }
 
+type noRetryAction struct {
+	CmdAction
+}
+
+// NoRetry wraps the given CmdAction so that its ClusterCanRetry method returns
+// false, and so it will never be retried by Cluster when redirected by a MOVED
+// or ASK error, nor by a Client returned from RetryLoading. This is useful for
+// non-idempotent commands, such as INCR or LPUSH, when it's preferable to
+// handle the error than to risk the command being applied twice.
+//
+// All other methods are delegated to the wrapped CmdAction, so the result can
+// be used anywhere the CmdAction could be, including in a Pipeline.
+func NoRetry(cmd CmdAction) CmdAction {
+	return noRetryAction{CmdAction: cmd}
+}
+
+func (noRetryAction) ClusterCanRetry() bool {
+	return false
+}
+
 ////////////////////////////////////////////////////////////////////////////////
 
 type clusterOpts struct {
@@ -61,6 +83,7 @@ type clusterOpts struct {
 	clusterDownWait time.Duration
 	syncEvery       time.Duration
 	ct              trace.ClusterTrace
+	learnCommands   bool
 }
 
 // ClusterOpt is an optional behavior which can be applied to the NewCluster
@@ -111,6 +134,25 @@ func ClusterWithTrace(ct trace.ClusterTrace) ClusterOpt {
 	}
 }
 
+// ClusterLearnCommands tells the Cluster whether to perform COMMAND once, when
+// it's created, and use the key positions described by the reply to determine
+// the keys of the commands it's given. This allows commands which radix doesn't
+// know about, e.g. those of modules, to be routed correctly.
+//
+// The learned key positions are only used for Actions created by Cmd or
+// CmdCtx. Commands which have "movablekeys" in their flags (e.g. EVAL), or which
+// have subcommands, can't be described by key positions, and so radix's
+// built-in handling is used for those, as it is for any command which COMMAND
+// didn't return. KeySpecs given to RegisterCommandKeys take precedence over
+// learned ones.
+//
+// If COMMAND fails then NewCluster returns an error.
+func ClusterLearnCommands(learn bool) ClusterOpt {
+	return func(co *clusterOpts) {
+		co.learnCommands = learn
+	}
+}
+
 // Cluster contains all information about a redis cluster needed to interact
 // with it, including a set of pools to each of its instances. All methods on
 // Cluster are thread-safe
@@ -130,6 +172,10 @@ type Cluster struct {
 	primTopo, topo ClusterTopo
 	secondaries    map[string]map[string]ClusterNode
 
+	// learned from COMMAND if ClusterLearnCommands is set, never modified
+	// afterwards
+	cmdKeySpecs commandKeySpecs
+
 	closeCh   chan struct{}
 	closeWG   sync.WaitGroup
 	closeOnce sync.Once
@@ -207,6 +253,15 @@ func NewCluster(clusterAddrs []string, opts ...ClusterOpt) (*Cluster, error) {
 		return nil, err
 	}
 
+	if c.co.learnCommands {
+		if err := c.learnCommands(); err != nil {
+			for _, p := range c.pools {
+				p.Close()
+			}
+			return nil, err
+		}
+	}
+
 	c.syncEvery(c.co.syncEvery)
 
 	return c, nil
@@ -228,7 +283,7 @@ func assertKeysSlot(keys []string) error {
 		if !ok {
 			ok = true
 		} else if slot != thisSlot {
-			return errors.Errorf("keys %q and %q do not belong to the same slot", prevKey, key)
+			return errors.Errorf("keys %q and %q do not belong to the same slot, hash tags (e.g. \"{user1}.a\" and \"{user1}.b\") can be used to ensure that they do", prevKey, key)
 		}
 		prevKey = key
 		slot = thisSlot
@@ -518,7 +573,7 @@ const doAttempts = 5
 // ClusterCanRetryAction's docs for more.
 func (c *Cluster) Do(a Action) error {
 	var addr, key string
-	keys := a.Keys()
+	keys := c.actionKeys(a)
 	if len(keys) == 0 {
 		// that's ok, key will then just be ""
 	} else if err := assertKeysSlot(keys); err != nil {
@@ -541,7 +596,7 @@ func (c *Cluster) Do(a Action) error {
 // If the Action can not be handled by a secondary the Action will be send to the primary instead.
 func (c *Cluster) DoSecondary(a Action) error {
 	var addr, key string
-	keys := a.Keys()
+	keys := c.actionKeys(a)
 	if len(keys) == 0 {
 		// that's ok, key will then just be ""
 	} else if err := assertKeysSlot(keys); err != nil {
@@ -554,6 +609,148 @@ func (c *Cluster) DoSecondary(a Action) error {
 	return c.doInner(a, addr, key, false, doAttempts)
 }
 
+// PreloadScript performs SCRIPT LOAD with the given EvalScript's script on
+// every primary in the Cluster, so that subsequent uses of the EvalScript don't
+// need to fall back to EVAL. This is useful for warming the Cluster at startup,
+// or after a failover or topology change. An error is returned if any primary
+// returns a sum which doesn't match the EvalScript's.
+func (c *Cluster) PreloadScript(es EvalScript) error {
+	for _, node := range c.Topo().Primaries() {
+		client, err := c.Client(node.Addr)
+		if err != nil {
+			return err
+		}
+
+		var sum string
+		if err := client.Do(Cmd(&sum, "SCRIPT", "LOAD", es.script)); err != nil {
+			return errors.Errorf("loading script on %s: %w", node.Addr, err)
+		} else if !strings.EqualFold(sum, es.sum) {
+			return errors.Errorf("script loaded on %s has sum %q, expected %q", node.Addr, sum, es.sum)
+		}
+	}
+	return nil
+}
+
+// actionKeys returns the keys of the given Action, using the key positions
+// learned from COMMAND if it's a Cmd or CmdCtx Action whose command has them.
+func (c *Cluster) actionKeys(a Action) []string {
+	if c.cmdKeySpecs == nil {
+		return a.Keys()
+	}
+
+	var ca *cmdAction
+	switch at := a.(type) {
+	case *cmdAction:
+		ca = at
+	case *cmdCtxAction:
+		ca = at.cmdAction
+	default:
+		return a.Keys()
+	}
+	if ca.flat {
+		return a.Keys()
+	}
+
+	cmd := strings.ToUpper(ca.cmd)
+	if _, ok := registeredKeySpec(cmd); ok {
+		return a.Keys()
+	} else if ks, ok := c.cmdKeySpecs[cmd]; ok {
+		return ks.keys(ca.args)
+	}
+	return a.Keys()
+}
+
+func (c *Cluster) learnCommands() error {
+	p, err := c.pool("")
+	if err != nil {
+		return err
+	}
+
+	specs := commandKeySpecs{}
+	if err := p.Do(Cmd(&specs, "COMMAND")); err != nil {
+		return errors.Errorf("learning commands: %w", err)
+	}
+	c.cmdKeySpecs = specs
+	return nil
+}
+
+// commandKeySpecs unmarshals the reply to COMMAND into the KeySpec of each
+// command which can be described by one, keyed by the upper-case command name.
+type commandKeySpecs map[string]KeySpec
+
+func (cks commandKeySpecs) UnmarshalRESP(br *bufio.Reader) error {
+	var ah resp2.ArrayHeader
+	if err := ah.UnmarshalRESP(br); err != nil {
+		return err
+	}
+
+	var firstErr error
+	for i := 0; i < ah.N; i++ {
+		if err := cks.unmarshalCommand(br); err == nil {
+			continue
+		} else if !errors.As(err, new(resp.ErrDiscarded)) {
+			return err
+		} else if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// unmarshalCommand unmarshals a single command's entry in the reply to
+// COMMAND, which looks like:
+//
+//	name, arity, flags, first key, last key, step,
+//	[ACL categories, tips, key specifications, subcommands]
+//
+// The trailing elements are only given by redis 6.0 and 7.0 onwards.
+func (cks commandKeySpecs) unmarshalCommand(br *bufio.Reader) error {
+	var ah resp2.ArrayHeader
+	if err := ah.UnmarshalRESP(br); err != nil {
+		return err
+	}
+
+	var name string
+	var arity int64
+	var flags []string
+	var ks KeySpec
+	var hasSubcommands bool
+	rcvs := []interface{}{&name, &arity, &flags, &ks.FirstKey, &ks.LastKey, &ks.Step}
+
+	var firstErr error
+	for i := 0; i < ah.N; i++ {
+		var rm resp2.RawMessage
+		if err := rm.UnmarshalRESP(br); err != nil {
+			return err
+		}
+
+		var err error
+		switch {
+		case i < len(rcvs):
+			err = rm.UnmarshalInto(resp2.Any{I: rcvs[i]})
+		case i == 9:
+			hasSubcommands = !rm.IsNil() && !rm.IsEmptyArray()
+		}
+		if err != nil && firstErr == nil {
+			// the element was still read in full
+			firstErr = resp.ErrDiscarded{Err: errors.Errorf("unmarshaling COMMAND reply: %w", err)}
+		}
+	}
+
+	if firstErr != nil {
+		return firstErr
+	} else if ah.N < len(rcvs) || hasSubcommands {
+		return nil
+	}
+	for _, flag := range flags {
+		if strings.EqualFold(flag, "movablekeys") {
+			return nil
+		}
+	}
+	cks[strings.ToUpper(name)] = ks
+	return nil
+}
+
 func (c *Cluster) getClusterDownSince() int64 {
 	return atomic.LoadInt64(&c.lastClusterdown)
 }
@@ -20,6 +20,10 @@ type clusterScanner struct {
 //
 // If the cluster topology changes during a scan the Scanner may or may not
 // error out due to it, depending on the nature of the change.
+//
+// If the ScanOpts' Dedupe field is set then each node's results are deduplicated
+// individually. Since a key only lives on a single node this is sufficient,
+// unless the key is migrated to another node during the scan.
 func (c *Cluster) NewScanner(o ScanOpts) Scanner {
 	if strings.ToUpper(o.Command) != "SCAN" {
 		panic("Cluster.NewScanner can only perform SCAN operations")
@@ -159,6 +159,17 @@ func (s *clusterNodeStub) newConn() Conn {
 				}
 				return ss
 			})
+		case "SORT_RO":
+			// the stub only stores strings, so treat each key as a single
+			// element list
+			k := args[1]
+			return s.withKey(k, asking, readonly, func(slot clusterSlotStub) interface{} {
+				s, ok := slot.kv[k]
+				if !ok {
+					return []string{}
+				}
+				return []string{s}
+			})
 		case "SET":
 			k := args[1]
 			return s.withKey(k, asking, readonly, func(slot clusterSlotStub) interface{} {
@@ -181,6 +192,32 @@ func (s *clusterNodeStub) newConn() Conn {
 			return s.withKey(args[3], asking, readonly, func(slot clusterSlotStub) interface{} {
 				return "EVAL: success!"
 			})
+		case "COMMAND":
+			return []interface{}{
+				[]interface{}{"get", 2, []string{"readonly", "fast"}, 1, 1, 1},
+				[]interface{}{"eval", -3, []string{"noscript", "movablekeys"}, 0, 0, 0},
+				[]interface{}{"ping", -1, []string{"fast"}, 0, 0, 0},
+				// redis 7 entries have ACL categories, tips, key specifications
+				// and subcommands
+				[]interface{}{
+					"mymod.copy", 3, []string{"write"}, 1, 2, 1,
+					[]string{"@write"}, []string{},
+					[]interface{}{map[string]interface{}{"flags": []string{"RO"}}},
+					[]string{},
+				},
+				[]interface{}{"mymod.mset", -3, []string{"write"}, 1, -1, 2},
+				[]interface{}{
+					"object", -2, []string{}, 0, 0, 0, []string{"@keyspace"}, []string{}, []string{},
+					[]interface{}{
+						[]interface{}{"object|encoding", 3, []string{"readonly"}, 2, 2, 1},
+					},
+				},
+			}
+		case "SCRIPT":
+			switch strings.ToUpper(args[1]) {
+			case "LOAD":
+				return NewEvalScript(0, args[2]).sum
+			}
 		case "PING":
 			return resp2.SimpleString{S: "PONG"}
 		case "CLUSTER":
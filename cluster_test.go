@@ -1,6 +1,9 @@
 package radix
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	. "testing"
 	"time"
 
@@ -158,6 +161,80 @@ func TestClusterDo(t *T) {
 	}
 }
 
+func TestClusterNoRetry(t *T) {
+	c, scl := newTestCluster()
+	defer c.Close()
+	stub16k := scl.stubForSlot(16000)
+
+	k := clusterSlotKeys[0]
+	require.Nil(t, c.Do(Cmd(nil, "SET", k, "1")))
+
+	// hitting the wrong node results in the MOVED error being returned, rather
+	// than the command being retried on the correct node
+	cmd := NoRetry(Cmd(nil, "SET", k, "2"))
+	assert.Equal(t, []string{k}, cmd.Keys())
+	err := c.doInner(cmd, stub16k.addr, k, false, doAttempts)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "MOVED")
+
+	var v string
+	require.Nil(t, c.Do(Cmd(&v, "GET", k)))
+	assert.Equal(t, "1", v)
+
+	// on the right node it works as normal
+	require.Nil(t, c.Do(NoRetry(Cmd(nil, "SET", k, "2"))))
+	require.Nil(t, c.Do(Cmd(&v, "GET", k)))
+	assert.Equal(t, "2", v)
+}
+
+func TestClusterCopyCrossSlot(t *T) {
+	c, _ := newTestCluster()
+	defer c.Close()
+
+	err := c.Do(Cmd(nil, "COPY", clusterSlotKeys[0], clusterSlotKeys[16000], "REPLACE"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "do not belong to the same slot")
+
+	tag := clusterSlotKeys[16000]
+	src, dst := "{"+tag+"}.src", "{"+tag+"}.dst"
+	keys := c.actionKeys(Cmd(nil, "COPY", src, dst, "DB", "0"))
+	assert.Equal(t, []string{src, dst}, keys)
+	assert.NoError(t, assertKeysSlot(keys))
+}
+
+func TestClusterWithConnKeys(t *T) {
+	c, _ := newTestCluster()
+	defer c.Close()
+
+	// keys sharing a hash tag are routed to the node holding their slot
+	tag := clusterSlotKeys[16000]
+	keys := []string{"{" + tag + "}.a", "{" + tag + "}.b"}
+	var called bool
+	err := c.Do(WithConnKeys(keys, func(conn Conn) error {
+		called = true
+		for _, k := range keys {
+			if err := conn.Do(Cmd(nil, "SET", k, "1")); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+	require.NoError(t, err)
+	assert.True(t, called)
+
+	// keys in different slots result in an error without the callback being
+	// called
+	called = false
+	err = c.Do(WithConnKeys([]string{clusterSlotKeys[0], clusterSlotKeys[16000]}, func(Conn) error {
+		called = true
+		return nil
+	}))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "do not belong to the same slot")
+	assert.Contains(t, err.Error(), "hash tags")
+	assert.False(t, called)
+}
+
 func TestClusterDoWhenDown(t *T) {
 	var stub *clusterNodeStub
 
@@ -223,6 +300,79 @@ func TestClusterEval(t *T) {
 	assert.Equal(t, "EVAL: success!", rcv)
 }
 
+func TestClusterPreloadScript(t *T) {
+	c, _ := newTestCluster()
+	defer c.Close()
+
+	script := NewEvalScript(1, `return 1`)
+	require.NoError(t, c.PreloadScript(script))
+
+	script.sum = "bad"
+	err := c.PreloadScript(script)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), c.Topo().Primaries()[0].Addr)
+	assert.Contains(t, err.Error(), `expected "bad"`)
+}
+
+func TestClusterLearnCommands(t *T) {
+	c, _ := newTestCluster(ClusterLearnCommands(true))
+	defer c.Close()
+
+	assert.Equal(t, commandKeySpecs{
+		"GET":        {FirstKey: 1, LastKey: 1, Step: 1},
+		"PING":       {},
+		"MYMOD.COPY": {FirstKey: 1, LastKey: 2, Step: 1},
+		"MYMOD.MSET": {FirstKey: 1, LastKey: -1, Step: 2},
+	}, c.cmdKeySpecs)
+
+	k1, k2 := clusterSlotKeys[0], clusterSlotKeys[1]
+	tests := []struct {
+		a   Action
+		exp []string
+	}{
+		{Cmd(nil, "GET", k1), []string{k1}},
+		{Cmd(nil, "mymod.copy", k1, k2, "REPLACE"), []string{k1, k2}},
+		{Cmd(nil, "MYMOD.MSET", k1, "a", k2, "b"), []string{k1, k2}},
+		{CmdCtx(context.Background(), nil, "MYMOD.COPY", k1, k2), []string{k1, k2}},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.exp, c.actionKeys(test.a), "%v", test.a)
+	}
+
+	// built-in handling is used for everything else
+	for _, a := range []Action{
+		Cmd(nil, "EVAL", "return 1", "1", k1),
+		NewEvalScript(1, "return 1").Cmd(nil, k1),
+		Cmd(nil, "OBJECT", "ENCODING", k1),
+		Cmd(nil, "MYMOD.OTHER", k1, k2),
+		FlatCmd(nil, "MYMOD.COPY", k1, k2),
+	} {
+		assert.Equal(t, a.Keys(), c.actionKeys(a), "%v", a)
+	}
+	assert.Equal(t, []string{k1}, c.actionKeys(Cmd(nil, "OBJECT", "ENCODING", k1)))
+
+	err := c.Do(Cmd(nil, "MYMOD.COPY", k1, k2))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "do not belong to the same slot")
+
+	// commands aren't learned unless asked for
+	c2, _ := newTestCluster()
+	defer c2.Close()
+	assert.Nil(t, c2.cmdKeySpecs)
+	assert.Equal(t, []string{k1}, c2.actionKeys(Cmd(nil, "MYMOD.COPY", k1, k2)))
+}
+
+func TestCommandKeySpecsRESP3(t *T) {
+	br := bufio.NewReader(bytes.NewBufferString(
+		"*2\r\n" +
+			"*6\r\n$3\r\nget\r\n:2\r\n~2\r\n+readonly\r\n+fast\r\n:1\r\n:1\r\n:1\r\n" +
+			"*6\r\n$4\r\neval\r\n:-3\r\n~1\r\n+movablekeys\r\n:0\r\n:0\r\n:0\r\n",
+	))
+	cks := commandKeySpecs{}
+	require.NoError(t, cks.UnmarshalRESP(br))
+	assert.Equal(t, commandKeySpecs{"GET": {FirstKey: 1, LastKey: 1, Step: 1}}, cks)
+}
+
 func TestClusterDoSecondary(t *T) {
 	var redirects int
 	c, _ := newTestCluster(
@@ -0,0 +1,70 @@
+//go:build go1.18
+// +build go1.18
+
+package radix
+
+import (
+	"bufio"
+
+	errors "golang.org/x/xerrors"
+)
+
+var errTypedCmdNotPerformed = errors.New("typed command has not been performed")
+
+type typedCmdAction[T any] struct {
+	CmdAction
+	rcv T
+
+	performed bool
+	err       error
+}
+
+// CmdTyped is like Cmd, but rather than taking in a receiver it returns, along
+// with the Action, a function which can be used to retrieve the reply once the
+// Action has been performed. The reply is unmarshaled into a T following the
+// same rules as for Cmd's receiver.
+//
+//	a, get := radix.CmdTyped[int]("INCR", "foo")
+//	if err := client.Do(a); err != nil {
+//		// handle error
+//	}
+//	n, _ := get()
+//
+// If the Action returned an error then the function will return T's zero value
+// and that same error. If the function is called before the Action has been
+// performed then T's zero value and an error are returned.
+//
+// The returned Action may also be used in a Pipeline, since it is actually a
+// CmdAction. Like Cmd, it should not be passed into Do more than once. This
+// function is only available when using go1.18 or later.
+func CmdTyped[T any](cmd string, args ...string) (Action, func() (T, error)) {
+	a := new(typedCmdAction[T])
+	a.CmdAction = Cmd(&a.rcv, cmd, args...)
+	return a, a.result
+}
+
+func (a *typedCmdAction[T]) result() (T, error) {
+	var zero T
+	if !a.performed {
+		return zero, errTypedCmdNotPerformed
+	} else if a.err != nil {
+		return zero, a.err
+	}
+	return a.rcv, nil
+}
+
+func (a *typedCmdAction[T]) UnmarshalRESP(br *bufio.Reader) error {
+	a.err = a.CmdAction.UnmarshalRESP(br)
+	a.performed = true
+	return a.err
+}
+
+func (a *typedCmdAction[T]) Run(c Conn) error {
+	a.err = a.CmdAction.Run(c)
+	a.performed = true
+	return a.err
+}
+
+func (a *typedCmdAction[T]) ClusterCanRetry() bool {
+	return true
+}
@@ -0,0 +1,65 @@
+//go:build go1.18
+// +build go1.18
+
+package radix
+
+import (
+	"fmt"
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCmdTyped(t *T) {
+	stub := testStub()
+	require.NoError(t, stub.Do(Cmd(nil, "SET", "foo", "5")))
+
+	t.Run("run", func(t *T) {
+		a, get := CmdTyped[int]("GET", "foo")
+		_, err := get()
+		assert.Equal(t, errTypedCmdNotPerformed, err)
+
+		require.NoError(t, stub.Do(a))
+		n, err := get()
+		require.NoError(t, err)
+		assert.Equal(t, 5, n)
+	})
+
+	t.Run("pipeline", func(t *T) {
+		a1, get1 := CmdTyped[string]("ECHO", "bar")
+		a2, get2 := CmdTyped[int64]("GET", "foo")
+		require.NoError(t, stub.Do(Pipeline(a1.(CmdAction), a2.(CmdAction))))
+
+		s, err := get1()
+		require.NoError(t, err)
+		assert.Equal(t, "bar", s)
+
+		n, err := get2()
+		require.NoError(t, err)
+		assert.Equal(t, int64(5), n)
+	})
+
+	t.Run("err", func(t *T) {
+		a, get := CmdTyped[int]("ECHO", "bar")
+		require.Error(t, stub.Do(a))
+		n, err := get()
+		assert.Error(t, err)
+		assert.Zero(t, n)
+	})
+}
+
+func ExampleCmdTyped() {
+	client, err := NewPool("tcp", "127.0.0.1:6379", 10) // or any other client
+	if err != nil {
+		// handle error
+	}
+
+	incr, getIncr := CmdTyped[int]("INCR", "foo")
+	if err := client.Do(incr); err != nil {
+		// handle error
+	}
+
+	n, _ := getIncr()
+	fmt.Printf("foo is now %d\n", n)
+}
@@ -0,0 +1,129 @@
+package radix
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+
+	errors "golang.org/x/xerrors"
+
+	"github.com/mediocregopher/radix/v3/resp"
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+)
+
+// Codec describes a compression algorithm which can be used with CompressedSet
+// and CompressedGet.
+type Codec interface {
+	// ID identifies the Codec within the header of values it has compressed.
+	// Each Codec used with the same data must have a different ID.
+	ID() byte
+
+	Compress(b []byte) ([]byte, error)
+	Decompress(b []byte) ([]byte, error)
+}
+
+type gzipCodec struct{}
+
+// GzipCodec is a Codec which uses the compress/gzip package at its default
+// compression level. Its ID is 1.
+var GzipCodec Codec = gzipCodec{}
+
+func (gzipCodec) ID() byte {
+	return 1
+}
+
+func (gzipCodec) Compress(b []byte) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	w := gzip.NewWriter(buf)
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	} else if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gzipCodec) Decompress(b []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// compressedMagic prefixes all values compressed by CompressedSet, and is
+// followed by the ID of the Codec which was used.
+var compressedMagic = []byte{0x00, 'R', 'Z'}
+
+type compressedValue struct {
+	codec Codec
+	value []byte
+}
+
+func (cv compressedValue) MarshalBinary() ([]byte, error) {
+	compressed, err := cv.codec.Compress(cv.value)
+	if err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, 0, len(compressedMagic)+1+len(compressed))
+	b = append(b, compressedMagic...)
+	b = append(b, cv.codec.ID())
+	return append(b, compressed...), nil
+}
+
+// CompressedSet returns an Action which compresses the given value using the
+// given Codec and then SETs it at the given key. CompressedGet can be used to
+// retrieve the value.
+//
+// The stored value is prefixed with a small header which identifies it as
+// compressed, and by which Codec. The header is the bytes "\x00RZ" followed by
+// the Codec's ID.
+func CompressedSet(codec Codec, key string, value []byte) CmdAction {
+	return FlatCmd(nil, "SET", key, compressedValue{codec: codec, value: value})
+}
+
+type decompressRcv struct {
+	rcv    *[]byte
+	codecs []Codec
+}
+
+func (dr decompressRcv) UnmarshalRESP(br *bufio.Reader) error {
+	var b []byte
+	if err := (resp2.Any{I: &b}).UnmarshalRESP(br); err != nil {
+		return err
+	} else if !bytes.HasPrefix(b, compressedMagic) || len(b) == len(compressedMagic) {
+		*dr.rcv = b
+		return nil
+	}
+
+	id := b[len(compressedMagic)]
+	for _, codec := range dr.codecs {
+		if codec.ID() != id {
+			continue
+		}
+
+		decompressed, err := codec.Decompress(b[len(compressedMagic)+1:])
+		if err != nil {
+			return resp.ErrDiscarded{Err: err}
+		}
+		*dr.rcv = decompressed
+		return nil
+	}
+	return resp.ErrDiscarded{Err: errors.Errorf("no Codec given with ID %d", id)}
+}
+
+// CompressedGet returns an Action which GETs the value at the given key and,
+// if it was stored using CompressedSet, decompresses it using whichever of the
+// given Codecs it was compressed with. The result is written to rcv.
+//
+// Values which weren't stored using CompressedSet, i.e. those which don't
+// begin with its header, are written to rcv as-is. This allows for migrating to
+// compressed values gradually. A nil reply (the key doesn't exist) results in
+// rcv being set to nil. If the value was compressed with a Codec which wasn't
+// given then an error is returned.
+func CompressedGet(rcv *[]byte, key string, codecs ...Codec) CmdAction {
+	return Cmd(decompressRcv{rcv: rcv, codecs: codecs}, "GET", key)
+}
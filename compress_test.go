@@ -0,0 +1,75 @@
+package radix
+
+import (
+	"bufio"
+	"bytes"
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type reverseCodec struct{}
+
+func (reverseCodec) ID() byte { return 100 }
+
+func (reverseCodec) Compress(b []byte) ([]byte, error) {
+	out := make([]byte, len(b))
+	for i := range b {
+		out[len(b)-1-i] = b[i]
+	}
+	return out, nil
+}
+
+func (c reverseCodec) Decompress(b []byte) ([]byte, error) {
+	return c.Compress(b)
+}
+
+func TestCompressed(t *T) {
+	stub := testStub()
+	value := bytes.Repeat([]byte("compress me please "), 100)
+
+	t.Run("gzip", func(t *T) {
+		require.NoError(t, stub.Do(CompressedSet(GzipCodec, "foo", value)))
+
+		var raw []byte
+		require.NoError(t, stub.Do(Cmd(&raw, "GET", "foo")))
+		assert.Equal(t, []byte{0x00, 'R', 'Z', 1}, raw[:4])
+		assert.True(t, len(raw) < len(value))
+
+		var got []byte
+		require.NoError(t, stub.Do(CompressedGet(&got, "foo", GzipCodec)))
+		assert.Equal(t, value, got)
+	})
+
+	t.Run("multiple codecs", func(t *T) {
+		require.NoError(t, stub.Do(CompressedSet(reverseCodec{}, "foo", []byte("abc"))))
+
+		var got []byte
+		require.NoError(t, stub.Do(CompressedGet(&got, "foo", GzipCodec, reverseCodec{})))
+		assert.Equal(t, []byte("abc"), got)
+
+		// the codec used isn't given
+		assert.Error(t, stub.Do(CompressedGet(&got, "foo", GzipCodec)))
+
+		// the stub should still be usable after the error
+		require.NoError(t, stub.Do(CompressedGet(&got, "foo", reverseCodec{})))
+		assert.Equal(t, []byte("abc"), got)
+	})
+
+	t.Run("uncompressed", func(t *T) {
+		for _, val := range []string{"", "bar", "\x00R", "\x00RZ"} {
+			require.NoError(t, stub.Do(Cmd(nil, "SET", "foo", val)))
+			got := []byte("stale")
+			require.NoError(t, stub.Do(CompressedGet(&got, "foo", GzipCodec)))
+			assert.Equal(t, val, string(got))
+		}
+	})
+
+	t.Run("nil", func(t *T) {
+		got := []byte("stale")
+		dr := decompressRcv{rcv: &got, codecs: []Codec{GzipCodec}}
+		require.NoError(t, dr.UnmarshalRESP(bufio.NewReader(bytes.NewBufferString("$-1\r\n"))))
+		assert.Nil(t, got)
+	})
+}
@@ -7,9 +7,11 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/mediocregopher/radix/v3/resp"
+	"github.com/mediocregopher/radix/v3/resp/resp2"
 )
 
 // Conn is a Client wrapping a single network connection which synchronously
@@ -65,16 +67,34 @@ func wrapDefaultConnFunc(addr string) ConnFunc {
 
 type connWrap struct {
 	net.Conn
-	brw *bufio.ReadWriter
+	brw         *bufio.ReadWriter
+	onAttribute func(map[string]interface{})
+
+	// the sums of the EvalScripts which are known to have been loaded, see
+	// EvalScript.TrackLoaded
+	scripts sync.Map
+
+	// whether the server supports SET's GET option, as found by GetSet, which
+	// is one of the setGet constants
+	setGet int32
 }
 
 // NewConn takes an existing net.Conn and wraps it to support the Conn interface
 // of this package. The Read and Write methods on the original net.Conn should
 // not be used after calling this method.
 func NewConn(conn net.Conn) Conn {
+	return newConnWrap(conn, 0, nil)
+}
+
+func newConnWrap(conn net.Conn, readBufferSize int, onAttribute func(map[string]interface{})) *connWrap {
+	br := bufio.NewReader(conn)
+	if readBufferSize > 0 {
+		br = bufio.NewReaderSize(conn, readBufferSize)
+	}
 	return &connWrap{
-		Conn: conn,
-		brw:  bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn)),
+		Conn:        conn,
+		brw:         bufio.NewReadWriter(br, bufio.NewWriter(conn)),
+		onAttribute: onAttribute,
 	}
 }
 
@@ -90,19 +110,105 @@ func (cw *connWrap) Encode(m resp.Marshaler) error {
 }
 
 func (cw *connWrap) Decode(u resp.Unmarshaler) error {
+	if err := cw.decodeAttributes(receiverAttributes(u)); err != nil {
+		return err
+	}
 	return u.UnmarshalRESP(cw.brw.Reader)
 }
 
+// decodeAttributes consumes any RESP3 attributes which precede the next reply,
+// so that the Unmarshaler passed to Decode only ever sees the reply itself.
+// Each attribute is passed to onAttribute, if it's set, and stored into dst, if
+// it's given.
+func (cw *connWrap) decodeAttributes(dst *map[string]interface{}) error {
+	for {
+		b, err := cw.brw.Peek(1)
+		if err != nil {
+			return err
+		} else if b[0] != resp2.AttributePrefix[0] {
+			return nil
+		}
+
+		if cw.onAttribute == nil && dst == nil {
+			if err := (resp2.Attribute{}).UnmarshalRESP(cw.brw.Reader); err != nil {
+				return err
+			}
+			continue
+		}
+
+		var attr map[string]interface{}
+		if err := (resp2.Attribute{I: &attr}).UnmarshalRESP(cw.brw.Reader); err != nil {
+			return err
+		}
+		if dst != nil {
+			storeAttributes(dst, attr)
+		}
+		if cw.onAttribute != nil {
+			cw.onAttribute(attr)
+		}
+	}
+}
+
 func (cw *connWrap) NetConn() net.Conn {
 	return cw.Conn
 }
 
+// connWrapOf returns the connWrap which the given Conn is, looking through any
+// of this package's Conn wrappers to find it, or nil if there isn't one.
+func connWrapOf(c Conn) *connWrap {
+	for c != nil {
+		if cw, ok := c.(*connWrap); ok {
+			return cw
+		}
+		c = innerConn(c)
+	}
+	return nil
+}
+
+// loadedScripts returns the set of EvalScript sums which are known to have been
+// loaded on the given Conn, looking through any of this package's Conn wrappers
+// to find it. If the Conn doesn't track this then nil is returned.
+func loadedScripts(c Conn) *sync.Map {
+	if cw := connWrapOf(c); cw != nil {
+		return &cw.scripts
+	}
+	return nil
+}
+
+// innerConn returns the Conn which is wrapped by the given one, if it's one of
+// this package's Conn wrappers, or nil otherwise.
+func innerConn(c Conn) Conn {
+	switch cc := c.(type) {
+	case *ioErrConn:
+		return cc.Conn
+	case *dbConn:
+		return cc.Conn
+	case *countingConn:
+		return cc.Conn
+	case askConn:
+		return cc.Conn
+	case *RecordConn:
+		return cc.Conn
+	case *trackingConn:
+		return cc.Conn
+	case *loggingConn:
+		return cc.Conn
+	case *deadlineConn:
+		return cc.Conn
+	case *replyHookConn:
+		return cc.Conn
+	}
+	return nil
+}
+
 type dialOpts struct {
 	connectTimeout, readTimeout, writeTimeout time.Duration
 	authUser, authPass                        string
 	selectDB                                  string
 	useTLSConfig                              bool
 	tlsConfig                                 *tls.Config
+	onAttribute                               func(map[string]interface{})
+	readBufferSize                            int
 }
 
 // DialOpt is an optional behavior which can be applied to the Dial function to
@@ -190,6 +296,38 @@ func DialUseTLS(config *tls.Config) DialOpt {
 	}
 }
 
+// DialOnAttribute will cause the given callback to be called with every RESP3
+// attribute which is received on the dialed connection. Attributes carry
+// out-of-band metadata about the reply which follows them, and are only sent to
+// connections which have been switched to RESP3.
+//
+// Attributes aren't passed to the receiver of a command, only the reply
+// following them is, unless the receiver is wrapped using WithAttributes. If
+// this option isn't set then attributes are otherwise discarded.
+// The callback is called synchronously from within Decode, and so shouldn't
+// block.
+func DialOnAttribute(fn func(map[string]interface{})) DialOpt {
+	return func(do *dialOpts) {
+		do.onAttribute = fn
+	}
+}
+
+// DialReadBufferSize sets the size, in bytes, of the buffer used for reading
+// replies on the dialed connection. The default is 4096 bytes, which is the
+// default of the bufio package.
+//
+// Each connection holds its buffer for its whole lifetime, so a larger buffer
+// increases the memory used by every connection in a Pool. In exchange, a
+// workload which commonly receives large replies, e.g. big LRANGE results or
+// large values, will require fewer read syscalls per reply, improving
+// throughput. Replies larger than the buffer are still read correctly, the
+// buffer size only affects how many reads are needed to do so.
+func DialReadBufferSize(size int) DialOpt {
+	return func(do *dialOpts) {
+		do.readBufferSize = size
+	}
+}
+
 type timeoutConn struct {
 	net.Conn
 	readTimeout, writeTimeout time.Duration
@@ -319,11 +457,11 @@ func Dial(network, addr string, opts ...DialOpt) (Conn, error) {
 		}
 	}
 
-	conn := NewConn(&timeoutConn{
+	conn := newConnWrap(&timeoutConn{
 		readTimeout:  do.readTimeout,
 		writeTimeout: do.writeTimeout,
 		Conn:         netConn,
-	})
+	}, do.readBufferSize, do.onAttribute)
 
 	if do.authUser != "" && do.authUser != defaultAuthUser {
 		if err := conn.Do(Cmd(nil, "AUTH", do.authUser, do.authPass)); err != nil {
@@ -0,0 +1,114 @@
+package radix
+
+import (
+	"strconv"
+	"strings"
+
+	errors "golang.org/x/xerrors"
+
+	"github.com/mediocregopher/radix/v3/resp"
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+)
+
+type dbConn struct {
+	Conn
+	db  int
+	cur int // -1 if not known
+
+	// number of SELECT replies, issued by dbConn itself, which have yet to be
+	// read
+	pendingSelects int
+}
+
+// OnDB wraps the given Conn such that a SELECT for the given logical database
+// is performed, if necessary, prior to each command. The currently selected
+// database is tracked so that SELECT is only performed when it will change the
+// selected database, e.g. the first time the returned Conn is used.
+//
+// If a SELECT command is performed through the returned Conn then the tracked
+// database is updated accordingly, and the next command will SELECT back into
+// db. SWAPDB doesn't change which database a connection has selected, only the
+// data within them, and so has no effect on the tracked state.
+//
+// The SELECT is written in the same write as the command it precedes, so no
+// extra round-trip is incurred. If the SELECT fails then its error is returned
+// from the Decode call of the command which it preceded. That command's reply
+// is discarded, since it was performed on the wrong database.
+//
+// Once wrapped, the given Conn should only be used through the returned Conn,
+// otherwise the tracked database may not reflect the one actually selected.
+// OnDB is only useful for non-cluster redis instances, as redis cluster only
+// supports database 0.
+func OnDB(db int, c Conn) Conn {
+	return &dbConn{Conn: c, db: db, cur: -1}
+}
+
+func (dc *dbConn) Do(a Action) error {
+	return a.Run(dc)
+}
+
+func (dc *dbConn) Encode(m resp.Marshaler) error {
+	if dc.cur != dc.db {
+		if err := dc.Conn.Encode(Cmd(nil, "SELECT", strconv.Itoa(dc.db))); err != nil {
+			dc.cur = -1
+			return err
+		}
+		dc.cur = dc.db
+		dc.pendingSelects++
+	}
+
+	if err := dc.Conn.Encode(m); err != nil {
+		dc.cur = -1
+		return err
+	}
+	dc.trackSelect(m)
+	return nil
+}
+
+// trackSelect updates the tracked database if m is, or contains, a SELECT
+// command.
+func (dc *dbConn) trackSelect(m resp.Marshaler) {
+	switch m := m.(type) {
+	case *cmdAction:
+		if !strings.EqualFold(m.cmd, "SELECT") {
+			return
+		}
+
+		arg := m.flatKey[0]
+		if !m.flat {
+			if len(m.args) == 0 {
+				return
+			}
+			arg = m.args[0]
+		}
+
+		if db, err := strconv.Atoi(arg); err == nil {
+			dc.cur = db
+		} else {
+			dc.cur = -1
+		}
+	case pipeline:
+		for _, cmd := range m {
+			dc.trackSelect(cmd)
+		}
+	}
+}
+
+func (dc *dbConn) Decode(u resp.Unmarshaler) error {
+	if dc.pendingSelects > 0 {
+		dc.pendingSelects--
+		if err := dc.Conn.Decode(resp2.Any{}); err != nil {
+			dc.cur = -1
+			if !errors.As(err, new(resp.ErrDiscarded)) {
+				return err
+			}
+			// discard the reply of the command which was performed on the
+			// wrong database
+			if derr := dc.Conn.Decode(resp2.Any{}); derr != nil && !errors.As(derr, new(resp.ErrDiscarded)) {
+				return derr
+			}
+			return err
+		}
+	}
+	return dc.Conn.Decode(u)
+}
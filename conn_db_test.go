@@ -0,0 +1,100 @@
+package radix
+
+import (
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	errors "golang.org/x/xerrors"
+
+	"github.com/mediocregopher/radix/v3/resp"
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+)
+
+func TestOnDB(t *T) {
+	var cmds [][]string
+	stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		cmds = append(cmds, args)
+		switch args[0] {
+		case "SELECT":
+			if args[1] == "99" {
+				return resp2.Error{E: errors.New("ERR DB index is out of range")}
+			}
+			return "OK"
+		case "ECHO":
+			return args[1]
+		}
+		return resp2.Error{E: errors.Errorf("unknown command %q", args[0])}
+	})
+
+	c := OnDB(2, stub)
+	assertCmds := func(exp ...[]string) {
+		t.Helper()
+		assert.Equal(t, exp, cmds)
+		cmds = nil
+	}
+
+	// the first command causes a SELECT
+	var out string
+	require.NoError(t, c.Do(Cmd(&out, "ECHO", "a")))
+	assert.Equal(t, "a", out)
+	assertCmds([]string{"SELECT", "2"}, []string{"ECHO", "a"})
+
+	// subsequent commands don't
+	require.NoError(t, c.Do(Pipeline(Cmd(&out, "ECHO", "b"), Cmd(nil, "ECHO", "c"))))
+	assert.Equal(t, "b", out)
+	assertCmds([]string{"ECHO", "b"}, []string{"ECHO", "c"})
+
+	// performing a SELECT directly causes a SELECT back into the db on the next
+	// command
+	require.NoError(t, c.Do(Cmd(nil, "SELECT", "3")))
+	require.NoError(t, c.Do(Cmd(&out, "ECHO", "d")))
+	assert.Equal(t, "d", out)
+	assertCmds([]string{"SELECT", "3"}, []string{"SELECT", "2"}, []string{"ECHO", "d"})
+
+	// performing a SELECT of the db itself doesn't
+	require.NoError(t, c.Do(FlatCmd(nil, "SELECT", "2")))
+	require.NoError(t, c.Do(Cmd(nil, "ECHO", "e")))
+	assertCmds([]string{"SELECT", "2"}, []string{"ECHO", "e"})
+
+	// a failed SELECT is returned as the command's error, and the command's
+	// reply is discarded
+	c = OnDB(99, stub)
+	out = ""
+	err := c.Do(Cmd(&out, "ECHO", "f"))
+	assert.True(t, errors.As(err, new(resp.ErrDiscarded)))
+	assert.Empty(t, out)
+
+	// the SELECT is retried on the next command
+	err = c.Do(Cmd(&out, "ECHO", "g"))
+	assert.Error(t, err)
+	assertCmds(
+		[]string{"SELECT", "99"}, []string{"ECHO", "f"},
+		[]string{"SELECT", "99"}, []string{"ECHO", "g"},
+	)
+
+	// the stub is still usable, i.e. all replies were read
+	require.NoError(t, stub.Do(Cmd(&out, "ECHO", "h")))
+	assert.Equal(t, "h", out)
+}
+
+func TestOnDBLive(t *T) {
+	c := dial()
+	defer c.Close()
+	key := randStr()
+
+	c1 := OnDB(1, c)
+	require.NoError(t, c1.Do(Cmd(nil, "SET", key, "a")))
+
+	var exists int
+	require.NoError(t, c1.Do(Pipeline(
+		Cmd(nil, "SELECT", "0"),
+		Cmd(&exists, "EXISTS", key),
+	)))
+	assert.Equal(t, 0, exists)
+
+	// c1 must SELECT back into 1, since a SELECT was performed through it
+	var got string
+	require.NoError(t, c1.Do(Cmd(&got, "GET", key)))
+	assert.Equal(t, "a", got)
+}
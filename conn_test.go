@@ -1,6 +1,7 @@
 package radix
 
 import (
+	"net"
 	"regexp"
 	"strings"
 	. "testing"
@@ -8,6 +9,8 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"github.com/mediocregopher/radix/v3/resp/resp2"
 )
 
 func TestCloseBehavior(t *T) {
@@ -134,3 +137,193 @@ func TestDialSelect(t *T) {
 		}
 	}
 }
+
+func TestConnOnAttribute(t *T) {
+	const reply = "|1\r\n+ttl\r\n:3600\r\n" +
+		"|1\r\n+keys\r\n*1\r\n+foo\r\n" +
+		"$3\r\nbar\r\n" +
+		"+OK\r\n"
+
+	for _, withCallback := range []bool{false, true} {
+		var attrs []map[string]interface{}
+		var onAttribute func(map[string]interface{})
+		if withCallback {
+			onAttribute = func(attr map[string]interface{}) {
+				attrs = append(attrs, attr)
+			}
+		}
+
+		client, server := net.Pipe()
+		go func() {
+			server.Write([]byte(reply))
+			server.Close()
+		}()
+
+		c := newConnWrap(client, 0, onAttribute)
+		var str string
+		require.Nil(t, c.Decode(resp2.Any{I: &str}))
+		assert.Equal(t, "bar", str)
+
+		// custom Unmarshalers never see the attribute either
+		var ss resp2.SimpleString
+		require.Nil(t, c.Decode(&ss))
+		assert.Equal(t, "OK", ss.S)
+		c.Close()
+
+		if withCallback {
+			assert.Equal(t, []map[string]interface{}{
+				{"ttl": int64(3600)},
+				{"keys": []interface{}{"foo"}},
+			}, attrs)
+		} else {
+			assert.Empty(t, attrs)
+		}
+	}
+}
+
+func TestConnWithAttributes(t *T) {
+	const reply = "|1\r\n+ttl\r\n:3600\r\n" +
+		"|1\r\n+keys\r\n*1\r\n+foo\r\n" +
+		"$3\r\nbar\r\n" +
+		"|1\r\n+ttl\r\n:60\r\n" +
+		"$3\r\nbaz\r\n" +
+		"$3\r\nbiz\r\n"
+
+	var cbAttrs []map[string]interface{}
+	client, server := net.Pipe()
+	go func() {
+		server.Write([]byte(reply))
+		server.Close()
+	}()
+	c := newConnWrap(client, 0, func(attr map[string]interface{}) {
+		cbAttrs = append(cbAttrs, attr)
+	})
+	defer c.Close()
+
+	var str string
+	var attrs map[string]interface{}
+	require.Nil(t, c.Decode(Cmd(WithAttributes(&str, &attrs), "GET", "foo")))
+	assert.Equal(t, "bar", str)
+	assert.Equal(t, map[string]interface{}{
+		"ttl":  int64(3600),
+		"keys": []interface{}{"foo"},
+	}, attrs)
+
+	// the same is true when wrapped by a Pool's countingConn
+	attrs = nil
+	cc := &countingConn{Conn: c}
+	require.Nil(t, cc.Decode(Cmd(WithAttributes(&str, &attrs), "GET", "foo")))
+	assert.Equal(t, "baz", str)
+	assert.Equal(t, map[string]interface{}{"ttl": int64(60)}, attrs)
+
+	// the callback is still called for all of them
+	assert.Len(t, cbAttrs, 3)
+
+	// no attributes leaves the map untouched
+	attrs = nil
+	require.Nil(t, c.Decode(Cmd(WithAttributes(&str, &attrs), "GET", "foo")))
+	assert.Equal(t, "biz", str)
+	assert.Nil(t, attrs)
+}
+
+func TestConnRESP3Replies(t *T) {
+	const reply = "%2\r\n+a\r\n+1\r\n+b\r\n+2\r\n" + // HGETALL
+		",1.5\r\n" + // ZSCORE
+		"_\r\n" + // GET of a missing key
+		"#t\r\n" + // e.g. a lua true
+		"~2\r\n+x\r\n+y\r\n" + // SMEMBERS
+		"=8\r\ntxt:ohey\r\n" // e.g. LATENCY DOCTOR
+
+	client, server := net.Pipe()
+	go func() {
+		server.Write([]byte(reply))
+		server.Close()
+	}()
+	c := NewConn(client)
+	defer c.Close()
+
+	var m map[string]string
+	require.NoError(t, c.Decode(Cmd(&m, "HGETALL", "h")))
+	assert.Equal(t, map[string]string{"a": "1", "b": "2"}, m)
+
+	var f float64
+	require.NoError(t, c.Decode(Cmd(&f, "ZSCORE", "z", "a")))
+	assert.Equal(t, 1.5, f)
+
+	mn := MaybeNil{Rcv: new(string)}
+	require.NoError(t, c.Decode(Cmd(&mn, "GET", "missing")))
+	assert.True(t, mn.Nil)
+
+	var b bool
+	require.NoError(t, c.Decode(Cmd(&b, "EVAL", "return true", "0")))
+	assert.True(t, b)
+
+	var ss []string
+	require.NoError(t, c.Decode(Cmd(&ss, "SMEMBERS", "s")))
+	assert.Equal(t, []string{"x", "y"}, ss)
+
+	var str string
+	require.NoError(t, c.Decode(Cmd(&str, "LATENCY", "DOCTOR")))
+	assert.Equal(t, "ohey", str)
+}
+
+func TestConnReadBufferSize(t *T) {
+	// replies larger than the buffer are still read correctly
+	big := strings.Repeat("a", 1000)
+	client, server := net.Pipe()
+	go func() {
+		server.Write([]byte("$1000\r\n" + big + "\r\n"))
+		server.Close()
+	}()
+
+	c := newConnWrap(client, 64, nil)
+	defer c.Close()
+	assert.Equal(t, 64, c.brw.Reader.Size())
+
+	var str string
+	require.NoError(t, c.Decode(Cmd(&str, "GET", "big")))
+	assert.Equal(t, big, str)
+}
+
+func TestDialReadBufferSize(t *T) {
+	dc, err := Dial("tcp", "127.0.0.1:6379", DialReadBufferSize(1<<16))
+	require.NoError(t, err)
+	defer dc.Close()
+	assert.Equal(t, 1<<16, dc.(*connWrap).brw.Reader.Size())
+	require.NoError(t, dc.Do(Cmd(nil, "PING")))
+}
+
+func TestConnRESP3(t *T) {
+	c := dial()
+	defer c.Close()
+	require.NoError(t, c.Do(Cmd(nil, "HELLO", "3")))
+
+	h, z, s := randStr(), randStr(), randStr()
+	require.NoError(t, c.Do(Cmd(nil, "HSET", h, "a", "1", "b", "2")))
+	require.NoError(t, c.Do(Cmd(nil, "ZADD", z, "1.5", "a")))
+	require.NoError(t, c.Do(Cmd(nil, "SADD", s, "x")))
+
+	var m map[string]string
+	require.NoError(t, c.Do(Cmd(&m, "HGETALL", h)))
+	assert.Equal(t, map[string]string{"a": "1", "b": "2"}, m)
+
+	var mi interface{}
+	require.NoError(t, c.Do(Cmd(&mi, "HGETALL", h)))
+	assert.Equal(t, map[string]interface{}{"a": []byte("1"), "b": []byte("2")}, mi)
+
+	var f float64
+	require.NoError(t, c.Do(Cmd(&f, "ZSCORE", z, "a")))
+	assert.Equal(t, 1.5, f)
+
+	mn := MaybeNil{Rcv: new(string)}
+	require.NoError(t, c.Do(Cmd(&mn, "GET", randStr())))
+	assert.True(t, mn.Nil)
+
+	var ss []string
+	require.NoError(t, c.Do(Cmd(&ss, "SMEMBERS", s)))
+	assert.Equal(t, []string{"x"}, ss)
+
+	var b bool
+	require.NoError(t, c.Do(Cmd(&b, "EVAL", "return true", "0")))
+	assert.True(t, b)
+}
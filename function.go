@@ -0,0 +1,60 @@
+package radix
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Function describes a function, as created using FUNCTION LOAD, which can be
+// called using FCALL. Functions are only available in redis 7.0 and later, and
+// unlike scripts used with EvalScript they are persisted by redis, so they only
+// need to be loaded once rather than by every client. See LoadFunctionLibrary.
+type Function struct {
+	name    string
+	numKeys int
+}
+
+// NewFunction initializes a Function instance for the function of the given
+// name. numKeys corresponds to the number of arguments which will be keys when
+// Cmd or CmdRO is called.
+func NewFunction(name string, numKeys int) Function {
+	return Function{name: name, numKeys: numKeys}
+}
+
+func (f Function) cmd(rcv interface{}, cmd string, args []string) CmdAction {
+	if len(args) < f.numKeys {
+		panic(fmt.Sprintf("Function %q expects at least %d arguments, got %d", f.name, f.numKeys, len(args)))
+	}
+	fcallArgs := make([]string, 0, 2+len(args))
+	fcallArgs = append(fcallArgs, f.name, strconv.Itoa(f.numKeys))
+	fcallArgs = append(fcallArgs, args...)
+	return Cmd(rcv, cmd, fcallArgs...)
+}
+
+// Cmd returns a CmdAction which performs an FCALL of the Function, and
+// unmarshals the result into rcv. The first numKeys of the given args are
+// passed as keys, and the rest as arguments. args must be at least as long as
+// the numKeys argument of NewFunction. The Action's Keys method returns those
+// keys.
+func (f Function) Cmd(rcv interface{}, args ...string) CmdAction {
+	return f.cmd(rcv, "FCALL", args)
+}
+
+// CmdRO is like Cmd, but performs an FCALL_RO, which redis only allows for
+// functions declared with the no-writes flag. Since FCALL_RO is read-only it
+// may be performed on replicas, e.g. by using Cluster's DoSecondary method.
+func (f Function) CmdRO(rcv interface{}, args ...string) CmdAction {
+	return f.cmd(rcv, "FCALL_RO", args)
+}
+
+// LoadFunctionLibrary returns an Action which performs a FUNCTION LOAD of the
+// given library code, replacing the library if it has already been loaded. The
+// code must begin with a shebang declaring the library's engine and name, e.g.
+// "#!lua name=mylib".
+//
+// When using Cluster each primary needs the library loaded individually, e.g.
+// by performing the Action on the Client of each of the primaries returned by
+// Topo.
+func LoadFunctionLibrary(code string) Action {
+	return Cmd(nil, "FUNCTION", "LOAD", "REPLACE", code)
+}
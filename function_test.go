@@ -0,0 +1,48 @@
+package radix
+
+import (
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFunction(t *T) {
+	f := NewFunction("myfunc", 2)
+
+	a := f.Cmd(nil, "k1", "k2", "a1")
+	assert.Equal(t, []string{"k1", "k2"}, a.Keys())
+	assert.Equal(t, `["FCALL" "myfunc" "2" "k1" "k2" "a1"]`, cmdString(a))
+	assert.True(t, a.(ClusterCanRetryAction).ClusterCanRetry())
+
+	a = f.CmdRO(nil, "k1", "k2")
+	assert.Equal(t, []string{"k1", "k2"}, a.Keys())
+	assert.Equal(t, `["FCALL_RO" "myfunc" "2" "k1" "k2"]`, cmdString(a))
+
+	assert.Panics(t, func() { f.Cmd(nil, "k1") })
+
+	// plain Cmds of FCALL also know their keys
+	assert.Equal(t, []string{"k1"}, Cmd(nil, "FCALL", "myfunc", "1", "k1", "a1").Keys())
+	assert.Empty(t, Cmd(nil, "FCALL", "myfunc", "0", "a1").Keys())
+	assert.Empty(t, LoadFunctionLibrary("#!lua name=mylib").Keys())
+}
+
+func TestFunctionLive(t *T) {
+	c := dial()
+	defer c.Close()
+	requireRedisVersion(t, c, 7, 0, 0)
+
+	lib := "#!lua name=radixtest\n" +
+		"redis.register_function('radixtest_set', function(keys, args) return redis.call('SET', keys[1], args[1]) end)\n" +
+		"redis.register_function{function_name='radixtest_get', callback=function(keys) return redis.call('GET', keys[1]) end, flags={'no-writes'}}"
+	require.NoError(t, c.Do(LoadFunctionLibrary(lib)))
+	// loading it again replaces it
+	require.NoError(t, c.Do(LoadFunctionLibrary(lib)))
+
+	key, val := randStr(), randStr()
+	require.NoError(t, c.Do(NewFunction("radixtest_set", 1).Cmd(nil, key, val)))
+
+	var got string
+	require.NoError(t, c.Do(NewFunction("radixtest_get", 1).CmdRO(&got, key)))
+	assert.Equal(t, val, got)
+}
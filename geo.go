@@ -0,0 +1,34 @@
+package radix
+
+// GeoUnit describes the unit of a distance used by the GEO family of commands.
+type GeoUnit string
+
+// All GeoUnits supported by redis.
+const (
+	GeoMeters     GeoUnit = "m"
+	GeoKilometers GeoUnit = "km"
+	GeoMiles      GeoUnit = "mi"
+	GeoFeet       GeoUnit = "ft"
+)
+
+// GeoDist returns a CmdAction which performs a GEODIST command, writing the
+// distance between the two given members of the geospatial index at key, in
+// the given unit, to rcv. If unit is empty then GeoMeters is used.
+//
+// If either of the members doesn't exist in the index then redis replies with
+// nil, and rcv is set to nil. rcv may itself be nil if the reply isn't needed.
+//
+//	var dist *float64
+//	err := client.Do(radix.GeoDist(&dist, "places", "home", "work", radix.GeoKilometers))
+//	if err != nil {
+//		// handle error
+//	} else if dist == nil {
+//		// one of the members doesn't exist
+//	}
+func GeoDist(rcv **float64, key, member1, member2 string, unit GeoUnit) CmdAction {
+	args := []string{key, member1, member2}
+	if unit != "" {
+		args = append(args, string(unit))
+	}
+	return Cmd(maybeFloat{rcv: rcv}, "GEODIST", args...)
+}
@@ -0,0 +1,60 @@
+package radix
+
+import (
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGeoDist(t *T) {
+	c := dial()
+	defer c.Close()
+	key := randStr()
+
+	require.NoError(t, c.Do(Cmd(nil, "GEOADD", key,
+		"13.361389", "38.115556", "Palermo",
+		"15.087269", "37.502669", "Catania",
+	)))
+
+	var dist *float64
+	require.NoError(t, c.Do(GeoDist(&dist, key, "Palermo", "Catania", "")))
+	require.NotNil(t, dist)
+	assert.InDelta(t, 166274.1516, *dist, 0.001)
+
+	require.NoError(t, c.Do(GeoDist(&dist, key, "Palermo", "Catania", GeoKilometers)))
+	require.NotNil(t, dist)
+	assert.InDelta(t, 166.2742, *dist, 0.0001)
+
+	require.NoError(t, c.Do(GeoDist(&dist, key, "Palermo", "Nowhere", GeoMiles)))
+	assert.Nil(t, dist)
+}
+
+func TestGeoDistStub(t *T) {
+	var cmds [][]string
+	stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		cmds = append(cmds, args)
+		if args[3] == "missing" {
+			return nil
+		}
+		return "1.5"
+	})
+
+	var dist *float64
+	require.NoError(t, stub.Do(GeoDist(&dist, "key", "a", "b", GeoFeet)))
+	require.NotNil(t, dist)
+	assert.Equal(t, 1.5, *dist)
+
+	require.NoError(t, stub.Do(GeoDist(&dist, "key", "a", "missing", "")))
+	assert.Nil(t, dist)
+
+	assert.Equal(t, [][]string{
+		{"GEODIST", "key", "a", "b", "ft"},
+		{"GEODIST", "key", "a", "missing"},
+	}, cmds)
+	assert.Equal(t, []string{"key"}, GeoDist(nil, "key", "a", "b", "").Keys())
+
+	// the reply is discarded when there's no receiver
+	require.NoError(t, stub.Do(GeoDist(nil, "key", "a", "b", "")))
+	require.NoError(t, stub.Do(GeoDist(nil, "key", "a", "missing", "")))
+}
@@ -0,0 +1,292 @@
+package radix
+
+import (
+	"bufio"
+	"reflect"
+	"strconv"
+
+	errors "golang.org/x/xerrors"
+
+	"github.com/mediocregopher/radix/v3/resp"
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+)
+
+var hIncrCappedScript = NewEvalScript(1, `
+	local cur = tonumber(redis.call("HGET", KEYS[1], ARGV[1]) or "0")
+	if cur + tonumber(ARGV[2]) > tonumber(ARGV[3]) then
+		return {cur, 0}
+	end
+	return {redis.call("HINCRBY", KEYS[1], ARGV[1], ARGV[2]), 1}
+`)
+
+type hIncrCappedReply struct {
+	val *int64
+	ok  *bool
+}
+
+func (r hIncrCappedReply) UnmarshalRESP(br *bufio.Reader) error {
+	var reply []int64
+	if err := (resp2.Any{I: &reply}).UnmarshalRESP(br); err != nil {
+		return err
+	} else if len(reply) != 2 {
+		return resp.ErrDiscarded{
+			Err: errors.Errorf("expected 2 elements in HIncrCapped reply, got %d", len(reply)),
+		}
+	}
+	if r.val != nil {
+		*r.val = reply[0]
+	}
+	if r.ok != nil {
+		*r.ok = reply[1] == 1
+	}
+	return nil
+}
+
+// HIncrCapped returns an Action which atomically increments the given hash
+// field by the given amount, as HINCRBY would, unless doing so would make the
+// field's value exceed max. This is useful for implementing quotas.
+//
+// If the increment was performed then the field's new value is written to val
+// and true is written to ok. Otherwise the field is left unchanged, its current
+// value is written to val, and false is written to ok. Either of val or ok may
+// be nil. A field which doesn't exist is treated as having a value of zero.
+//
+// The compare-and-increment is performed by a lua script, using EvalScript.
+func HIncrCapped(val *int64, ok *bool, key, field string, by, max int64) Action {
+	return hIncrCappedScript.Cmd(
+		hIncrCappedReply{val: val, ok: ok},
+		key,
+		field,
+		strconv.FormatInt(by, 10),
+		strconv.FormatInt(max, 10),
+	)
+}
+
+type hmGetStruct struct {
+	key [1]string // use array to avoid allocation in Keys
+	dst interface{}
+}
+
+// HMGetStruct returns an Action which performs an HMGET on the given key for the
+// fields of the struct pointed to by dst, and unmarshals each returned value
+// into its corresponding struct field.
+//
+// Struct fields are mapped to hash fields in the same way as when unmarshaling
+// into a struct normally, e.g. from HGETALL, see resp2.StructFields: the hash
+// field is given by the field's "redis" tag, or by the field's name if it has
+// no tag. Fields with a "redis" tag of "-", and unexported fields, are skipped.
+// Embedded structs have their fields included as if they were fields of the
+// outer struct.
+//
+// Each value is unmarshaled following the same rules as for any receiver, with
+// pointer fields being allocated as needed. If a hash field doesn't exist then
+// its struct field is set to its zero value, i.e. nil for pointer fields.
+//
+//	type User struct {
+//		Name  string `redis:"name"`
+//		Email string `redis:"email"`
+//		Age   int    `redis:"age"`
+//	}
+//
+//	var u User
+//	err := client.Do(radix.HMGetStruct("user:1", &u))
+func HMGetStruct(key string, dst interface{}) Action {
+	return &hmGetStruct{key: [1]string{key}, dst: dst}
+}
+
+func (hs *hmGetStruct) Keys() []string {
+	return hs.key[:]
+}
+
+func (hs *hmGetStruct) Run(c Conn) error {
+	v := reflect.ValueOf(hs.dst)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return errors.Errorf("HMGetStruct requires a pointer to a struct, not %T", hs.dst)
+	}
+
+	// the fields are found in the same way as when unmarshaling into a struct
+	// normally, so that HMGetStruct and HGETALL agree on them
+	rcv := hmGetStructRcv{v: v.Elem()}
+	for _, sf := range resp2.StructFields(v.Elem().Type()) {
+		rcv.names = append(rcv.names, sf.Name)
+		rcv.indices = append(rcv.indices, sf.Index)
+	}
+	if len(rcv.names) == 0 {
+		return errors.Errorf("%T has no fields which can be retrieved", hs.dst)
+	}
+
+	return c.Do(Cmd(&rcv, "HMGET", append([]string{hs.key[0]}, rcv.names...)...))
+}
+
+func (hs *hmGetStruct) ClusterCanRetry() bool {
+	return true
+}
+
+type hmGetStructRcv struct {
+	v       reflect.Value
+	names   []string
+	indices [][]int
+}
+
+func (rcv *hmGetStructRcv) UnmarshalRESP(br *bufio.Reader) error {
+	var ah resp2.ArrayHeader
+	if err := ah.UnmarshalRESP(br); err != nil {
+		return err
+	} else if ah.N != len(rcv.names) {
+		// this shouldn't really happen, but the reply still needs discarding
+		for i := 0; i < ah.N; i++ {
+			if err := (resp2.Any{}).UnmarshalRESP(br); err != nil {
+				return err
+			}
+		}
+		return resp.ErrDiscarded{
+			Err: errors.Errorf("HMGET returned %d values for %d fields", ah.N, len(rcv.names)),
+		}
+	}
+
+	var firstErr error
+	for i, indices := range rcv.indices {
+		fieldV := hmGetStructField(rcv.v, indices)
+
+		h, err := resp2.PeekHeader(br)
+		if err != nil {
+			return err
+		} else if !fieldV.IsValid() {
+			// the field belongs to an embedded struct which can't be set
+			if err := (resp2.Any{}).UnmarshalRESP(br); err != nil {
+				return err
+			}
+			continue
+		} else if h.IsNil() {
+			if err := (resp2.Any{}).UnmarshalRESP(br); err != nil {
+				return err
+			}
+			fieldV.Set(reflect.Zero(fieldV.Type()))
+			continue
+		}
+
+		if fieldV.Kind() == reflect.Ptr {
+			if fieldV.IsNil() {
+				fieldV.Set(reflect.New(fieldV.Type().Elem()))
+			}
+		} else {
+			fieldV = fieldV.Addr()
+		}
+
+		err = (resp2.Any{I: fieldV.Interface()}).UnmarshalRESP(br)
+		if err == nil {
+			continue
+		} else if !errors.As(err, new(resp.ErrDiscarded)) {
+			return err
+		} else if firstErr == nil {
+			firstErr = resp.ErrDiscarded{
+				Err: errors.Errorf("unmarshaling hash field %q: %w", rcv.names[i], err),
+			}
+		}
+	}
+	return firstErr
+}
+
+// hmGetStructField returns the field at the given indices within the given
+// struct value, allocating any nil embedded struct pointers along the way. If
+// one of those can't be allocated, because it's unexported, then an invalid
+// reflect.Value is returned.
+func hmGetStructField(v reflect.Value, indices []int) reflect.Value {
+	for j, i := range indices {
+		if j > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() && !v.CanSet() {
+				return reflect.Value{}
+			} else if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(i)
+	}
+	return v
+}
+
+type orderedStringMapPair struct {
+	key, value string
+}
+
+// OrderedStringMap is a map of strings which preserves the order in which its
+// keys were added, while still allowing for constant time lookup of a key. It
+// can be used as the receiver for any command whose reply is a list of
+// alternating keys and values (or a RESP3 map), e.g. HGETALL or CONFIG GET,
+// when the order of the reply matters, e.g. for rendering it in a stable
+// order:
+//
+//	var m radix.OrderedStringMap
+//	err := client.Do(radix.Cmd(&m, "CONFIG", "GET", "*"))
+//	if err != nil {
+//		// handle error
+//	}
+//	m.Range(func(key, value string) bool {
+//		fmt.Println(key, value)
+//		return true
+//	})
+//
+// Unmarshaling into an OrderedStringMap replaces its existing contents. The
+// zero value is an empty OrderedStringMap which is ready to use.
+type OrderedStringMap struct {
+	pairs []orderedStringMapPair
+	index map[string]int
+}
+
+// Len returns the number of keys in the OrderedStringMap.
+func (m *OrderedStringMap) Len() int {
+	return len(m.pairs)
+}
+
+// Get returns the value of the given key, and whether the key is set.
+func (m *OrderedStringMap) Get(key string) (string, bool) {
+	i, ok := m.index[key]
+	if !ok {
+		return "", false
+	}
+	return m.pairs[i].value, true
+}
+
+// Set sets the value of the given key. If the key isn't already set then it's
+// added after all existing keys, otherwise its value is replaced and its
+// position is unchanged.
+func (m *OrderedStringMap) Set(key, value string) {
+	if i, ok := m.index[key]; ok {
+		m.pairs[i].value = value
+		return
+	} else if m.index == nil {
+		m.index = map[string]int{}
+	}
+	m.index[key] = len(m.pairs)
+	m.pairs = append(m.pairs, orderedStringMapPair{key: key, value: value})
+}
+
+// Range calls fn with each key and value in the OrderedStringMap, in the order
+// the keys were added. If fn returns false then iteration stops.
+func (m *OrderedStringMap) Range(fn func(key, value string) bool) {
+	for _, p := range m.pairs {
+		if !fn(p.key, p.value) {
+			return
+		}
+	}
+}
+
+// UnmarshalRESP implements the method for the resp.Unmarshaler interface.
+func (m *OrderedStringMap) UnmarshalRESP(br *bufio.Reader) error {
+	var kvs []string
+	if err := (resp2.Any{I: &kvs}).UnmarshalRESP(br); err != nil {
+		return err
+	} else if len(kvs)%2 != 0 {
+		return resp.ErrDiscarded{
+			Err: errors.Errorf("cannot decode redis array with odd number of elements (%d) into OrderedStringMap", len(kvs)),
+		}
+	}
+
+	m.pairs = m.pairs[:0]
+	m.index = make(map[string]int, len(kvs)/2)
+	for i := 0; i < len(kvs); i += 2 {
+		m.Set(kvs[i], kvs[i+1])
+	}
+	return nil
+}
@@ -0,0 +1,205 @@
+package radix
+
+import (
+	"bufio"
+	"bytes"
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	errors "golang.org/x/xerrors"
+
+	"github.com/mediocregopher/radix/v3/resp"
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+)
+
+func TestHIncrCapped(t *T) {
+	c := dial()
+	defer c.Close()
+	key, field := randStr(), randStr()
+
+	var val int64
+	var ok bool
+	require.NoError(t, c.Do(HIncrCapped(&val, &ok, key, field, 3, 5)))
+	assert.Equal(t, int64(3), val)
+	assert.True(t, ok)
+
+	require.NoError(t, c.Do(HIncrCapped(&val, &ok, key, field, 2, 5)))
+	assert.Equal(t, int64(5), val)
+	assert.True(t, ok)
+
+	// the cap would be exceeded, so the field is left unchanged
+	require.NoError(t, c.Do(HIncrCapped(&val, &ok, key, field, 1, 5)))
+	assert.Equal(t, int64(5), val)
+	assert.False(t, ok)
+
+	var got int64
+	require.NoError(t, c.Do(Cmd(&got, "HGET", key, field)))
+	assert.Equal(t, int64(5), got)
+
+	// decrementing is always allowed
+	require.NoError(t, c.Do(HIncrCapped(nil, &ok, key, field, -4, 5)))
+	assert.True(t, ok)
+	require.NoError(t, c.Do(Cmd(&got, "HGET", key, field)))
+	assert.Equal(t, int64(1), got)
+}
+
+type testHMGetStructInner struct {
+	Email string `redis:"email"`
+}
+
+type testHMGetStruct struct {
+	testHMGetStructInner
+	Name    string `redis:"name"`
+	Age     int    `redis:"age"`
+	Score   *int64
+	Skipped string `redis:"-"`
+	skipped string
+}
+
+func TestHMGetStruct(t *T) {
+	var gotArgs []string
+	stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		gotArgs = args
+		return []interface{}{"a@b.c", "alice", nil, "5"}
+	})
+
+	stale := int64(1)
+	dst := testHMGetStruct{Age: 10, Score: &stale, Skipped: "untouched"}
+	a := HMGetStruct("user", &dst)
+	assert.Equal(t, []string{"user"}, a.Keys())
+	require.NoError(t, stub.Do(a))
+	assert.Equal(t, []string{"HMGET", "user", "email", "name", "age", "Score"}, gotArgs)
+
+	score := int64(5)
+	assert.Equal(t, testHMGetStruct{
+		testHMGetStructInner: testHMGetStructInner{Email: "a@b.c"},
+		Name:                 "alice",
+		Score:                &score,
+		Skipped:              "untouched",
+	}, dst)
+
+	assert.Error(t, stub.Do(HMGetStruct("user", dst)))
+	assert.Error(t, stub.Do(HMGetStruct("user", new(string))))
+}
+
+func TestHMGetStructDecodeErr(t *T) {
+	stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		if args[0] == "ECHO" {
+			return args[1]
+		}
+		return []interface{}{"a@b.c", "alice", "notint", "5"}
+	})
+
+	var dst testHMGetStruct
+	err := stub.Do(HMGetStruct("user", &dst))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `"age"`)
+
+	// the fields following the erroring one are still populated, and the stub
+	// is still usable
+	require.NotNil(t, dst.Score)
+	assert.Equal(t, int64(5), *dst.Score)
+	var out string
+	require.NoError(t, stub.Do(Cmd(&out, "ECHO", "foo")))
+	assert.Equal(t, "foo", out)
+}
+
+func TestHMGetStructNilPtr(t *T) {
+	stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		return []interface{}{nil, "alice", "1", nil}
+	})
+
+	score := int64(5)
+	dst := testHMGetStruct{Score: &score}
+	require.NoError(t, stub.Do(HMGetStruct("user", &dst)))
+	assert.Equal(t, testHMGetStruct{Name: "alice", Age: 1}, dst)
+	assert.Equal(t, int64(5), score)
+}
+
+func TestHMGetStructLive(t *T) {
+	c := dial()
+	defer c.Close()
+	key := randStr()
+
+	require.NoError(t, c.Do(Cmd(nil, "HSET", key, "name", "bob", "age", "30", "extra", "x")))
+	var dst testHMGetStruct
+	require.NoError(t, c.Do(HMGetStruct(key, &dst)))
+	assert.Equal(t, testHMGetStruct{Name: "bob", Age: 30}, dst)
+}
+
+func TestOrderedStringMap(t *T) {
+	rangeAll := func(m *OrderedStringMap) []string {
+		var kvs []string
+		m.Range(func(k, v string) bool {
+			kvs = append(kvs, k, v)
+			return true
+		})
+		return kvs
+	}
+
+	br := bufio.NewReader(bytes.NewBufferString(
+		"*6\r\n$1\r\nz\r\n$1\r\n1\r\n$1\r\na\r\n$1\r\n2\r\n$1\r\nm\r\n$1\r\n3\r\n" +
+			"%2\r\n+b\r\n+4\r\n+a\r\n+5\r\n" +
+			"*-1\r\n" +
+			"*1\r\n+odd\r\n" +
+			"+END\r\n",
+	))
+
+	var m OrderedStringMap
+	require.NoError(t, m.UnmarshalRESP(br))
+	assert.Equal(t, 3, m.Len())
+	assert.Equal(t, []string{"z", "1", "a", "2", "m", "3"}, rangeAll(&m))
+	v, ok := m.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, "2", v)
+	_, ok = m.Get("b")
+	assert.False(t, ok)
+
+	// Range stops when fn returns false
+	var keys []string
+	m.Range(func(k, _ string) bool {
+		keys = append(keys, k)
+		return len(keys) < 2
+	})
+	assert.Equal(t, []string{"z", "a"}, keys)
+
+	// Set keeps the position of existing keys
+	m.Set("z", "10")
+	m.Set("y", "11")
+	assert.Equal(t, []string{"z", "10", "a", "2", "m", "3", "y", "11"}, rangeAll(&m))
+
+	// unmarshaling replaces the contents, and RESP3 maps keep their order too
+	require.NoError(t, m.UnmarshalRESP(br))
+	assert.Equal(t, []string{"b", "4", "a", "5"}, rangeAll(&m))
+	_, ok = m.Get("z")
+	assert.False(t, ok)
+
+	require.NoError(t, m.UnmarshalRESP(br))
+	assert.Zero(t, m.Len())
+
+	err := m.UnmarshalRESP(br)
+	assert.True(t, errors.As(err, new(resp.ErrDiscarded)))
+
+	var end string
+	require.NoError(t, (resp2.Any{I: &end}).UnmarshalRESP(br))
+	assert.Equal(t, "END", end)
+
+	// the zero value is usable
+	var zero OrderedStringMap
+	zero.Set("a", "1")
+	assert.Equal(t, []string{"a", "1"}, rangeAll(&zero))
+}
+
+func TestOrderedStringMapLive(t *T) {
+	c := dial()
+	defer c.Close()
+	key := randStr()
+
+	require.NoError(t, c.Do(Cmd(nil, "HSET", key, "a", "1", "b", "2")))
+	var m OrderedStringMap
+	require.NoError(t, c.Do(Cmd(&m, "HGETALL", key)))
+	assert.Equal(t, 2, m.Len())
+	v, _ := m.Get("b")
+	assert.Equal(t, "2", v)
+}
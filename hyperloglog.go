@@ -0,0 +1,32 @@
+package radix
+
+// PFAdd returns a CmdAction which performs a PFADD command, adding the given
+// elements to the HyperLogLog at key. If added is not nil then whether or not
+// the HyperLogLog's internal registers were altered, i.e. whether its estimated
+// cardinality may have changed, is written to it.
+func PFAdd(added *bool, key string, elements ...string) CmdAction {
+	if added == nil {
+		return Cmd(nil, "PFADD", append([]string{key}, elements...)...)
+	}
+	return Cmd(added, "PFADD", append([]string{key}, elements...)...)
+}
+
+// PFCount returns a CmdAction which performs a PFCOUNT command, writing the
+// estimated cardinality of the union of the HyperLogLogs at the given keys to
+// rcv.
+//
+// When using Cluster all keys must belong to the same slot.
+func PFCount(rcv *int64, keys ...string) CmdAction {
+	if rcv == nil {
+		return Cmd(nil, "PFCOUNT", keys...)
+	}
+	return Cmd(rcv, "PFCOUNT", keys...)
+}
+
+// PFMerge returns a CmdAction which performs a PFMERGE command, merging the
+// HyperLogLogs at the given source keys into the one at destKey.
+//
+// When using Cluster all keys must belong to the same slot.
+func PFMerge(destKey string, sourceKeys ...string) CmdAction {
+	return Cmd(nil, "PFMERGE", append([]string{destKey}, sourceKeys...)...)
+}
@@ -0,0 +1,38 @@
+package radix
+
+import (
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHyperLogLog(t *T) {
+	c := dial()
+	defer c.Close()
+	prefix := "{" + randStr() + "}"
+	a, b, dst := prefix+"a", prefix+"b", prefix+"dst"
+
+	var added bool
+	require.NoError(t, c.Do(PFAdd(&added, a, "x", "y", "z")))
+	assert.True(t, added)
+	require.NoError(t, c.Do(PFAdd(&added, a, "x")))
+	assert.False(t, added)
+	require.NoError(t, c.Do(PFAdd(nil, b, "z", "w")))
+
+	var n int64
+	require.NoError(t, c.Do(PFCount(&n, a)))
+	assert.Equal(t, int64(3), n)
+	require.NoError(t, c.Do(PFCount(&n, a, b)))
+	assert.Equal(t, int64(4), n)
+
+	require.NoError(t, c.Do(PFMerge(dst, a, b)))
+	require.NoError(t, c.Do(PFCount(&n, dst)))
+	assert.Equal(t, int64(4), n)
+}
+
+func TestHyperLogLogKeys(t *T) {
+	assert.Equal(t, []string{"a"}, PFAdd(nil, "a", "x", "y").Keys())
+	assert.Equal(t, []string{"a", "b"}, PFCount(nil, "a", "b").Keys())
+	assert.Equal(t, []string{"dst", "a", "b"}, PFMerge("dst", "a", "b").Keys())
+}
@@ -0,0 +1,535 @@
+package radix
+
+import (
+	"bufio"
+	"sync/atomic"
+	"time"
+
+	errors "golang.org/x/xerrors"
+
+	"github.com/mediocregopher/radix/v3/resp"
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+)
+
+type restoreOpts struct {
+	replace  bool
+	idleTime time.Duration
+	freq     int
+	hasFreq  bool
+}
+
+// RestoreOpt is an optional behavior which can be applied to the Restore
+// function.
+type RestoreOpt func(*restoreOpts)
+
+// RestoreReplace causes Restore to overwrite the key if it already exists,
+// rather than returning an error.
+func RestoreReplace() RestoreOpt {
+	return func(ro *restoreOpts) {
+		ro.replace = true
+	}
+}
+
+// RestoreIdleTime sets the idle time of the restored key, as would be returned
+// by OBJECT IDLETIME. Redis only supports second precision.
+func RestoreIdleTime(d time.Duration) RestoreOpt {
+	return func(ro *restoreOpts) {
+		ro.idleTime = d
+	}
+}
+
+// RestoreFreq sets the LFU access frequency of the restored key, as would be
+// returned by OBJECT FREQ.
+func RestoreFreq(freq int) RestoreOpt {
+	return func(ro *restoreOpts) {
+		ro.freq = freq
+		ro.hasFreq = true
+	}
+}
+
+// Restore returns an Action which performs a RESTORE of the given payload,
+// which should have been retrieved using DUMP, at the given key. If ttl is
+// zero then the key will not expire, otherwise it's sent with millisecond
+// precision, and a ttl of less than a millisecond is rounded up to one so that
+// the key still expires. A negative ttl results in an error when the Action is
+// performed.
+//
+// The payload of a DUMP can be retrieved using a *[]byte receiver, which
+// always preserves the exact bytes which were sent:
+//
+//	var payload []byte
+//	if err := src.Do(radix.Cmd(&payload, "DUMP", key)); err != nil {
+//		// handle error
+//	}
+//	err := dst.Do(radix.Restore(key, 0, payload, radix.RestoreReplace()))
+func Restore(key string, ttl time.Duration, payload []byte, opts ...RestoreOpt) CmdAction {
+	var ro restoreOpts
+	for _, opt := range opts {
+		opt(&ro)
+	}
+
+	if ttl < 0 {
+		c := Cmd(nil, "RESTORE", key).(*cmdAction)
+		c.err = errors.Errorf("Restore was given a negative ttl %v", ttl)
+		return c
+	}
+
+	// RESTORE treats a ttl of 0 as no expiry
+	ttlMS := int64(ttl / time.Millisecond)
+	if ttlMS == 0 && ttl > 0 {
+		ttlMS = 1
+	}
+
+	args := []interface{}{ttlMS, payload}
+	if ro.replace {
+		args = append(args, "REPLACE")
+	}
+	if ro.idleTime > 0 {
+		args = append(args, "IDLETIME", int64(ro.idleTime/time.Second))
+	}
+	if ro.hasFreq {
+		args = append(args, "FREQ", ro.freq)
+	}
+	return FlatCmd(nil, "RESTORE", key, args...)
+}
+
+// ObjectReport performs the OBJECT command with the given sub-command, which
+// should be one returning an integer such as "IDLETIME" or "FREQ", for each of
+// the given keys, returning a map of each key to its value. Keys which don't
+// exist are omitted from the returned map.
+//
+// This is useful for cache analysis, e.g. for finding keys which haven't been
+// accessed in a long time:
+//
+//	idle, err := radix.ObjectReport(client, "IDLETIME", keys...)
+//
+// The OBJECT commands are performed using a Pipeline. If the given Client is a
+// *Cluster then the keys are grouped by slot and a separate Pipeline is
+// performed for each slot, so that each is routed to the correct node.
+//
+// Note that redis only tracks FREQ when maxmemory-policy is set to one of the
+// LFU policies, and only tracks IDLETIME when it isn't, an error is returned
+// otherwise.
+func ObjectReport(c Client, subCmd string, keys ...string) (map[string]int64, error) {
+	report := make(map[string]int64, len(keys))
+	if len(keys) == 0 {
+		return report, nil
+	}
+
+	groups := [][]string{keys}
+	if _, ok := c.(*Cluster); ok {
+		groups = groupKeysBySlot(keys)
+	}
+
+	for _, group := range groups {
+		vals := make([]int64, len(group))
+		mns := make([]MaybeNil, len(group))
+		cmds := make([]CmdAction, len(group))
+		for i, key := range group {
+			mns[i].Rcv = &vals[i]
+			cmds[i] = Cmd(&mns[i], "OBJECT", subCmd, key)
+		}
+
+		if err := c.Do(Pipeline(cmds...)); err != nil {
+			return nil, err
+		}
+
+		for i, key := range group {
+			if !mns[i].Nil {
+				report[key] = vals[i]
+			}
+		}
+	}
+	return report, nil
+}
+
+// groupKeysBySlot groups the given keys by the cluster slot they belong to,
+// retaining the order of the keys within each group.
+func groupKeysBySlot(keys []string) [][]string {
+	var groups [][]string
+	slotGroup := map[uint16]int{}
+	for _, key := range keys {
+		slot := ClusterSlot([]byte(key))
+		i, ok := slotGroup[slot]
+		if !ok {
+			i = len(groups)
+			slotGroup[slot] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], key)
+	}
+	return groups
+}
+
+type getSet struct {
+	rcv   interface{}
+	key   [1]string // use array to avoid allocation in Keys
+	value string
+}
+
+// GetSet returns an Action which sets the given key to the given value,
+// unmarshaling the key's previous value into rcv. If the key didn't previously
+// exist then rcv will receive a nil reply, MaybeNil can be used to detect this.
+//
+// GETSET is deprecated as of redis 6.2 in favor of SET with the GET option.
+// GetSet will use SET ... GET if the server's version, as reported by HELLO,
+// supports it, and GETSET otherwise. Note that this means GetSet requires an
+// extra round-trip to determine the version. For Conns created by this
+// package, e.g. by Dial or by a Pool, the result is remembered so that this
+// only happens once per connection.
+func GetSet(rcv interface{}, key, value string) Action {
+	return &getSet{rcv: rcv, key: [1]string{key}, value: value}
+}
+
+// values of connWrap's setGet field.
+const (
+	setGetUnknown int32 = iota
+	setGetSupported
+	setGetUnsupported
+)
+
+func (gs *getSet) Keys() []string {
+	return gs.key[:]
+}
+
+func (gs *getSet) Run(c Conn) error {
+	setGet := setGetUnknown
+	cw := connWrapOf(c)
+	if cw != nil {
+		setGet = atomic.LoadInt32(&cw.setGet)
+	}
+
+	if setGet == setGetUnknown {
+		// servers older than 6.0 don't support HELLO, and will return an error
+		var info HelloInfo
+		if err := c.Do(Cmd(&info, "HELLO")); err != nil && !errors.As(err, new(resp2.Error)) {
+			return err
+		}
+
+		setGet = setGetUnsupported
+		if info.VersionAtLeast(6, 2, 0) {
+			setGet = setGetSupported
+		}
+		if cw != nil {
+			atomic.StoreInt32(&cw.setGet, setGet)
+		}
+	}
+
+	if setGet == setGetSupported {
+		return c.Do(Cmd(gs.rcv, "SET", gs.key[0], gs.value, "GET"))
+	}
+	return c.Do(Cmd(gs.rcv, "GETSET", gs.key[0], gs.value))
+}
+
+// DeleteByPattern deletes all keys matching the given glob-style pattern, as
+// used by SCAN's MATCH option, returning the number of keys deleted. Keys are
+// found using a Scanner, rather than KEYS, so that the redis instance isn't
+// blocked, and are deleted using UNLINK (redis 4.0 and later) in batches of the
+// given size. If batch is not greater than zero then a batch size of 100 is
+// used.
+//
+// If the given Client is a *Cluster then every primary in the cluster is
+// scanned, and each batch of keys is deleted slot by slot.
+//
+// Keys which are created while DeleteByPattern is running may or may not be
+// deleted, as per the guarantees of SCAN.
+func DeleteByPattern(c Client, pattern string, batch int) (int64, error) {
+	if batch <= 0 {
+		batch = 100
+	}
+
+	cluster, ok := c.(*Cluster)
+	if !ok {
+		return deleteByPattern(c, pattern, batch, false)
+	}
+
+	var total int64
+	for _, node := range cluster.Topo().Primaries() {
+		client, err := cluster.Client(node.Addr)
+		if err != nil {
+			return total, err
+		}
+
+		n, err := deleteByPattern(client, pattern, batch, true)
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+func deleteByPattern(c Client, pattern string, batch int, bySlot bool) (int64, error) {
+	var total int64
+	keys := make([]string, 0, batch)
+	unlink := func() error {
+		groups := [][]string{keys}
+		if bySlot {
+			groups = groupKeysBySlot(keys)
+		}
+
+		for _, group := range groups {
+			var n int64
+			if err := c.Do(Cmd(&n, "UNLINK", group...)); err != nil {
+				return err
+			}
+			total += n
+		}
+		keys = keys[:0]
+		return nil
+	}
+
+	s := NewScanner(c, ScanOpts{Command: "SCAN", Pattern: pattern, Count: batch})
+	var key string
+	for s.Next(&key) {
+		if keys = append(keys, key); len(keys) < batch {
+			continue
+		} else if err := unlink(); err != nil {
+			s.Close()
+			return total, err
+		}
+	}
+
+	if err := s.Close(); err != nil {
+		return total, err
+	} else if len(keys) > 0 {
+		if err := unlink(); err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+var setIfChangedScript = NewEvalScript(1, `
+	if redis.call("GET", KEYS[1]) == ARGV[1] then
+		return 0
+	end
+	redis.call("SET", KEYS[1], ARGV[1])
+	return 1
+`)
+
+// SetIfChanged returns an Action which atomically SETs the given key to the
+// given value, but only if the key's current value differs from it. This
+// avoids unnecessary writes, and the replication traffic they generate, for
+// updaters which repeatedly write the same value.
+//
+// If changed is not nil then whether or not the write occurred is written to
+// it. A key which doesn't exist is always written. Like SET, if the write
+// occurs then any existing TTL on the key is discarded.
+//
+// The compare-and-set is performed by a lua script, using EvalScript.
+func SetIfChanged(changed *bool, key, value string) Action {
+	return setIfChangedScript.Cmd(changed, key, value)
+}
+
+var rotateScript = NewEvalScript(2, `
+	if redis.call("EXISTS", KEYS[1]) == 0 then
+		return 0
+	end
+	redis.call("RENAME", KEYS[1], KEYS[2])
+	return 1
+`)
+
+// Rotate returns an Action which atomically RENAMEs key to archiveKey, e.g. in
+// order to archive a key's current value under a timestamped name before
+// replacing it. Any existing value at archiveKey is overwritten.
+//
+// If rotated is not nil then whether or not the rename occurred is written to
+// it. If key doesn't exist then nothing is done and rotated is set to false,
+// rather than an error being returned as it would be by RENAME.
+//
+// The Action's Keys method returns both keys, so when using Cluster they must
+// belong to the same slot, e.g. by using a hashtag. The rotation is performed
+// by a lua script, using EvalScript.
+func Rotate(rotated *bool, key, archiveKey string) Action {
+	return rotateScript.Cmd(rotated, key, archiveKey)
+}
+
+// Sentinel values which TTLDuration writes to its Rcv, corresponding to the
+// negative replies of the TTL and PTTL commands. Since those commands never
+// return a negative remaining time otherwise, these values can't be confused
+// with a real TTL.
+const (
+	// TTLNoExpiry indicates that the key exists but has no associated expiry.
+	// It corresponds to a reply of -1.
+	TTLNoExpiry time.Duration = -1
+
+	// TTLNoKey indicates that the key doesn't exist. It corresponds to a reply
+	// of -2.
+	TTLNoKey time.Duration = -2
+)
+
+// TTLDuration is a receiver which unmarshals the integer reply of a TTL or
+// PTTL command into a time.Duration. Unit gives the unit of the reply, i.e.
+// time.Second for TTL and time.Millisecond for PTTL. If Unit is zero then
+// time.Millisecond is assumed.
+//
+// A reply of -1 results in Rcv being set to TTLNoExpiry, and a reply of -2 in
+// TTLNoKey. Callers should check for these before using Rcv as a real
+// duration.
+//
+//	var ttl time.Duration
+//	err := client.Do(radix.Cmd(radix.TTLDuration{Rcv: &ttl}, "PTTL", key))
+//	if err != nil {
+//		// handle error
+//	} else if ttl == radix.TTLNoKey {
+//		// the key doesn't exist
+//	}
+type TTLDuration struct {
+	Rcv  *time.Duration
+	Unit time.Duration
+}
+
+// UnmarshalRESP implements the method for the resp.Unmarshaler interface.
+func (td TTLDuration) UnmarshalRESP(br *bufio.Reader) error {
+	var n int64
+	if err := (resp2.Any{I: &n}).UnmarshalRESP(br); err != nil {
+		return err
+	}
+
+	unit := td.Unit
+	if unit == 0 {
+		unit = time.Millisecond
+	}
+
+	switch {
+	case n == -1:
+		*td.Rcv = TTLNoExpiry
+	case n == -2:
+		*td.Rcv = TTLNoKey
+	case n < 0:
+		return resp.ErrDiscarded{Err: errors.Errorf("unexpected TTL reply %d", n)}
+	default:
+		*td.Rcv = time.Duration(n) * unit
+	}
+	return nil
+}
+
+// Encoding describes the internal representation redis is using for the value
+// at a key, as returned by OBJECT ENCODING. Encodings which aren't listed here
+// are still returned as-is, so that newer versions of redis can be supported
+// without changes.
+type Encoding string
+
+// Encodings which may be returned by OBJECT ENCODING. Which encodings are used
+// for which types depends on the redis version, e.g. redis 7.0 replaced ziplist
+// with listpack.
+const (
+	EncodingRaw        Encoding = "raw"
+	EncodingInt        Encoding = "int"
+	EncodingEmbstr     Encoding = "embstr"
+	EncodingHashtable  Encoding = "hashtable"
+	EncodingZipmap     Encoding = "zipmap"
+	EncodingLinkedlist Encoding = "linkedlist"
+	EncodingZiplist    Encoding = "ziplist"
+	EncodingListpack   Encoding = "listpack"
+	EncodingQuicklist  Encoding = "quicklist"
+	EncodingIntset     Encoding = "intset"
+	EncodingSkiplist   Encoding = "skiplist"
+	EncodingStream     Encoding = "stream"
+)
+
+// compactEncodings are the memory-efficient encodings which redis uses for
+// small values, and converts away from once a value grows past the limits set
+// by the *-max-* configuration parameters (e.g. hash-max-listpack-entries).
+var compactEncodings = map[Encoding]bool{
+	EncodingInt:      true,
+	EncodingEmbstr:   true,
+	EncodingZipmap:   true,
+	EncodingZiplist:  true,
+	EncodingListpack: true,
+	EncodingIntset:   true,
+}
+
+// IsCompact returns true if the Encoding is one of the memory-efficient
+// encodings which redis uses for small values, i.e. int, embstr, intset,
+// ziplist, listpack, or zipmap.
+func (e Encoding) IsCompact() bool {
+	return compactEncodings[e]
+}
+
+// UnmarshalRESP implements the method for the resp.Unmarshaler interface. A nil
+// reply results in the empty Encoding.
+func (e *Encoding) UnmarshalRESP(br *bufio.Reader) error {
+	var s string
+	if err := (resp2.Any{I: &s}).UnmarshalRESP(br); err != nil {
+		return err
+	}
+	*e = Encoding(s)
+	return nil
+}
+
+// ObjectEncoding returns a CmdAction which performs an OBJECT ENCODING command
+// on the given key, writing the key's Encoding to rcv. If the key doesn't
+// exist then rcv is set to the empty Encoding.
+func ObjectEncoding(rcv *Encoding, key string) CmdAction {
+	return Cmd(rcv, "OBJECT", "ENCODING", key)
+}
+
+// ObjectIdleTime returns a CmdAction which performs an OBJECT IDLETIME command
+// on the given key, writing the time since the key was last read or written to
+// rcv, with second precision. If the key doesn't exist then rcv is set to zero.
+//
+// OBJECT IDLETIME returns an error if the server's maxmemory-policy is one of
+// the LFU policies, in which case OBJECT FREQ should be used instead.
+func ObjectIdleTime(rcv *time.Duration, key string) CmdAction {
+	return Cmd(TTLDuration{Rcv: rcv, Unit: time.Second}, "OBJECT", "IDLETIME", key)
+}
+
+type setRange struct {
+	newLen, padding *int64
+	key             [1]string // use array to avoid allocation in Keys
+	offset          int64
+	data            []byte
+}
+
+// SetRange returns an Action which performs a SETRANGE command, writing data to
+// the string at key starting at the given byte offset. If the offset is beyond
+// the end of the current value then redis zero-pads the value up to it, which
+// is useful for sparse binary storage.
+//
+// If newLen is not nil then the length of the value after the write is written
+// to it. If padding is not nil then the number of zero-padding bytes introduced
+// by the write is written to it, which requires a STRLEN to be performed
+// beforehand. Both commands are performed as a Pipeline on the same key, so
+// only a single round-trip is required and the Action can be used with Cluster,
+// but another client may still modify the key between the two.
+func SetRange(newLen, padding *int64, key string, offset int64, data []byte) Action {
+	return &setRange{
+		newLen:  newLen,
+		padding: padding,
+		key:     [1]string{key},
+		offset:  offset,
+		data:    data,
+	}
+}
+
+func (sr *setRange) Keys() []string {
+	return sr.key[:]
+}
+
+func (sr *setRange) Run(c Conn) error {
+	var prevLen, newLen int64
+	setRangeCmd := FlatCmd(&newLen, "SETRANGE", sr.key[0], sr.offset, sr.data)
+
+	var err error
+	if sr.padding == nil {
+		err = c.Do(setRangeCmd)
+	} else {
+		err = c.Do(Pipeline(Cmd(&prevLen, "STRLEN", sr.key[0]), setRangeCmd))
+	}
+	if err != nil {
+		return err
+	}
+
+	if sr.newLen != nil {
+		*sr.newLen = newLen
+	}
+	if sr.padding != nil {
+		*sr.padding = 0
+		if sr.offset > prevLen {
+			*sr.padding = sr.offset - prevLen
+		}
+	}
+	return nil
+}
@@ -0,0 +1,518 @@
+package radix
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	. "testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	errors "golang.org/x/xerrors"
+
+	"github.com/mediocregopher/radix/v3/resp"
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+)
+
+func TestObjectReport(t *T) {
+	c := dial()
+	defer c.Close()
+
+	cold, hot, missing := randStr(), randStr(), randStr()
+	require.NoError(t, c.Do(Cmd(nil, "SET", cold, "1")))
+	require.NoError(t, c.Do(Cmd(nil, "SET", hot, "1")))
+	time.Sleep(2 * time.Second)
+	require.NoError(t, c.Do(Cmd(nil, "GET", hot)))
+
+	report, err := ObjectReport(c, "IDLETIME", cold, hot, missing)
+	require.NoError(t, err)
+	assert.Len(t, report, 2)
+	assert.True(t, report[cold] >= 1, "cold key's idle time: %d", report[cold])
+	assert.True(t, report[hot] < report[cold], "hot key's idle time: %d", report[hot])
+
+	report, err = ObjectReport(c, "IDLETIME")
+	require.NoError(t, err)
+	assert.Empty(t, report)
+}
+
+func TestGroupKeysBySlot(t *T) {
+	groups := groupKeysBySlot([]string{"{a}1", "{b}1", "{a}2", "{b}2", "{a}3"})
+	assert.Equal(t, [][]string{{"{a}1", "{a}2", "{a}3"}, {"{b}1", "{b}2"}}, groups)
+}
+
+func TestRestore(t *T) {
+	c := dial()
+	defer c.Close()
+
+	// a value which isn't valid utf-8, to ensure it survives the round-trip
+	src, dst := randStr(), randStr()
+	val := string([]byte{0xff, 0x00, 0xfe, '\r', '\n', 0x80})
+	require.NoError(t, c.Do(Cmd(nil, "SET", src, val)))
+
+	var payload []byte
+	require.NoError(t, c.Do(Cmd(&payload, "DUMP", src)))
+
+	restore := Restore(dst, time.Minute, payload)
+	assert.Equal(t, []string{dst}, restore.Keys())
+	require.NoError(t, c.Do(restore))
+
+	var got string
+	require.NoError(t, c.Do(Cmd(&got, "GET", dst)))
+	assert.Equal(t, val, got)
+
+	var ttl int
+	require.NoError(t, c.Do(Cmd(&ttl, "TTL", dst)))
+	assert.True(t, ttl > 0 && ttl <= 60, "ttl: %d", ttl)
+
+	// restoring over an existing key requires REPLACE
+	assert.Error(t, c.Do(Restore(dst, 0, payload)))
+	require.NoError(t, c.Do(Restore(dst, 0, payload, RestoreReplace(), RestoreIdleTime(100*time.Second))))
+	require.NoError(t, c.Do(Cmd(&ttl, "TTL", dst)))
+	assert.Equal(t, -1, ttl)
+
+	var idle int
+	require.NoError(t, c.Do(Cmd(&idle, "OBJECT", "IDLETIME", dst)))
+	assert.True(t, idle >= 100, "idle: %d", idle)
+}
+
+func TestRestoreMarshal(t *T) {
+	payload := []byte{0xff, 0x00, '\r', '\n'}
+	tests := []struct {
+		a   CmdAction
+		exp []string
+	}{
+		{
+			a:   Restore("foo", 0, payload),
+			exp: []string{"RESTORE", "foo", "0", string(payload)},
+		},
+		{
+			a:   Restore("foo", 1500*time.Millisecond, payload, RestoreReplace()),
+			exp: []string{"RESTORE", "foo", "1500", string(payload), "REPLACE"},
+		},
+		{
+			a:   Restore("foo", time.Microsecond, payload),
+			exp: []string{"RESTORE", "foo", "1", string(payload)},
+		},
+		{
+			a: Restore("foo", 0, payload, RestoreIdleTime(time.Minute), RestoreFreq(5)),
+			exp: []string{
+				"RESTORE", "foo", "0", string(payload), "IDLETIME", "60", "FREQ", "5",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		buf := new(bytes.Buffer)
+		require.NoError(t, test.a.MarshalRESP(buf))
+		var got []string
+		require.NoError(t, resp2.Any{I: &got}.UnmarshalRESP(bufio.NewReader(buf)))
+		assert.Equal(t, test.exp, got)
+		assert.Equal(t, []string{"foo"}, test.a.Keys())
+	}
+
+	restore := Restore("foo", -time.Second, payload)
+	assert.Error(t, restore.MarshalRESP(new(bytes.Buffer)))
+	assert.Equal(t, []string{"foo"}, restore.Keys())
+}
+
+func TestGetSet(t *T) {
+	c := dial()
+	defer c.Close()
+	key := randStr()
+
+	var prev string
+	mn := MaybeNil{Rcv: &prev}
+	require.NoError(t, c.Do(GetSet(&mn, key, "a")))
+	assert.True(t, mn.Nil)
+
+	require.NoError(t, c.Do(GetSet(&mn, key, "b")))
+	assert.False(t, mn.Nil)
+	assert.Equal(t, "a", prev)
+
+	var got string
+	require.NoError(t, c.Do(Cmd(&got, "GET", key)))
+	assert.Equal(t, "b", got)
+}
+
+func TestGetSetVersion(t *T) {
+	tests := []struct {
+		hello  interface{}
+		expCmd string
+	}{
+		{hello: []string{"server", "redis", "version", "6.2.0"}, expCmd: "SET"},
+		{hello: []string{"server", "redis", "version", "7.0.5"}, expCmd: "SET"},
+		{hello: []string{"server", "redis", "version", "6.0.9"}, expCmd: "GETSET"},
+		{hello: resp2.Error{E: errors.New("ERR unknown command 'HELLO'")}, expCmd: "GETSET"},
+	}
+
+	for _, test := range tests {
+		var gotCmd []string
+		stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+			if args[0] == "HELLO" {
+				return test.hello
+			}
+			gotCmd = args
+			return "prev"
+		})
+
+		var prev string
+		require.NoError(t, stub.Do(GetSet(&prev, "foo", "bar")))
+		assert.Equal(t, "prev", prev)
+		if test.expCmd == "SET" {
+			assert.Equal(t, []string{"SET", "foo", "bar", "GET"}, gotCmd)
+		} else {
+			assert.Equal(t, []string{"GETSET", "foo", "bar"}, gotCmd)
+		}
+	}
+}
+
+func TestGetSetVersionCached(t *T) {
+	var hellos, sets int32
+	client, server := net.Pipe()
+	go func() {
+		defer server.Close()
+		br := bufio.NewReader(server)
+		for {
+			var args []string
+			if err := (resp2.Any{I: &args}).UnmarshalRESP(br); err != nil {
+				return
+			}
+			reply := "$4\r\nprev\r\n"
+			switch args[0] {
+			case "HELLO":
+				atomic.AddInt32(&hellos, 1)
+				reply = "*4\r\n$6\r\nserver\r\n$5\r\nredis\r\n$7\r\nversion\r\n$5\r\n6.2.0\r\n"
+			case "SET":
+				atomic.AddInt32(&sets, 1)
+			}
+			if _, err := server.Write([]byte(reply)); err != nil {
+				return
+			}
+		}
+	}()
+	c := NewConn(client)
+	defer c.Close()
+
+	// HELLO is only sent the first time, including through wrappers
+	for _, conn := range []Conn{c, c, &ioErrConn{Conn: c}} {
+		var prev string
+		require.NoError(t, conn.Do(GetSet(&prev, "foo", "bar")))
+		assert.Equal(t, "prev", prev)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&hellos))
+	assert.Equal(t, int32(3), atomic.LoadInt32(&sets))
+}
+
+func TestDeleteByPattern(t *T) {
+	m := map[string]bool{}
+	var unlinks [][]string
+	stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		switch args[0] {
+		case "SCAN":
+			// return every matching key in a single iteration
+			keys := []string{}
+			for k := range m {
+				if strings.HasPrefix(k, strings.TrimSuffix(args[3], "*")) {
+					keys = append(keys, k)
+				}
+			}
+			sort.Strings(keys)
+			return []interface{}{"0", keys}
+		case "UNLINK":
+			unlinks = append(unlinks, args[1:])
+			var n int
+			for _, k := range args[1:] {
+				if m[k] {
+					n++
+				}
+				delete(m, k)
+			}
+			return n
+		}
+		return resp2.Error{E: errors.Errorf("unknown command %q", args[0])}
+	})
+
+	for i := 0; i < 5; i++ {
+		m["foo:"+strconv.Itoa(i)] = true
+	}
+	m["bar"] = true
+
+	n, err := DeleteByPattern(stub, "foo:*", 2)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), n)
+	assert.Equal(t, map[string]bool{"bar": true}, m)
+	assert.Equal(t, [][]string{{"foo:0", "foo:1"}, {"foo:2", "foo:3"}, {"foo:4"}}, unlinks)
+
+	n, err = DeleteByPattern(stub, "foo:*", 0)
+	require.NoError(t, err)
+	assert.Zero(t, n)
+}
+
+func TestDeleteByPatternLive(t *T) {
+	c := dial()
+	defer c.Close()
+
+	prefix := randStr()
+	for i := 0; i < 250; i++ {
+		require.NoError(t, c.Do(Cmd(nil, "SET", prefix+":"+strconv.Itoa(i), "1")))
+	}
+	other := randStr()
+	require.NoError(t, c.Do(Cmd(nil, "SET", other, "1")))
+
+	n, err := DeleteByPattern(c, prefix+":*", 100)
+	require.NoError(t, err)
+	assert.Equal(t, int64(250), n)
+
+	var exists int
+	require.NoError(t, c.Do(Cmd(&exists, "EXISTS", prefix+":0", prefix+":249", other)))
+	assert.Equal(t, 1, exists)
+}
+
+func TestSetIfChanged(t *T) {
+	c := dial()
+	defer c.Close()
+	key := randStr()
+
+	var changed bool
+	require.NoError(t, c.Do(SetIfChanged(&changed, key, "a")))
+	assert.True(t, changed)
+
+	require.NoError(t, c.Do(SetIfChanged(&changed, key, "a")))
+	assert.False(t, changed)
+
+	require.NoError(t, c.Do(SetIfChanged(&changed, key, "b")))
+	assert.True(t, changed)
+
+	var got string
+	require.NoError(t, c.Do(Cmd(&got, "GET", key)))
+	assert.Equal(t, "b", got)
+
+	require.NoError(t, c.Do(SetIfChanged(nil, key, "b")))
+}
+
+func TestRotate(t *T) {
+	c := dial()
+	defer c.Close()
+	key := randStr()
+	archiveKey := key + ":" + strconv.FormatInt(time.Now().Unix(), 10)
+
+	// the source doesn't exist
+	rotated := true
+	require.NoError(t, c.Do(Rotate(&rotated, key, archiveKey)))
+	assert.False(t, rotated)
+
+	var exists int
+	require.NoError(t, c.Do(Cmd(&exists, "EXISTS", archiveKey)))
+	assert.Zero(t, exists)
+
+	require.NoError(t, c.Do(Cmd(nil, "SET", key, "a")))
+	require.NoError(t, c.Do(Rotate(&rotated, key, archiveKey)))
+	assert.True(t, rotated)
+
+	var got string
+	require.NoError(t, c.Do(Cmd(&got, "GET", archiveKey)))
+	assert.Equal(t, "a", got)
+	require.NoError(t, c.Do(Cmd(&exists, "EXISTS", key)))
+	assert.Zero(t, exists)
+
+	assert.Equal(t, []string{key, archiveKey}, Rotate(nil, key, archiveKey).Keys())
+}
+
+func TestRotateStub(t *T) {
+	var gotArgs []string
+	stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		gotArgs = args
+		return 0 // the source doesn't exist
+	})
+
+	rotated := true
+	require.NoError(t, stub.Do(Rotate(&rotated, "foo", "foo:archive")))
+	assert.False(t, rotated)
+	assert.Equal(t, "EVALSHA", gotArgs[0])
+	assert.Equal(t, []string{"2", "foo", "foo:archive"}, gotArgs[2:])
+}
+
+func TestTTLDuration(t *T) {
+	type test struct {
+		in   string
+		unit time.Duration
+		exp  time.Duration
+	}
+
+	tests := []test{
+		{in: ":1500\r\n", exp: 1500 * time.Millisecond},
+		{in: ":10\r\n", unit: time.Second, exp: 10 * time.Second},
+		{in: ":0\r\n", exp: 0},
+		{in: ":-1\r\n", exp: TTLNoExpiry},
+		{in: ":-2\r\n", unit: time.Second, exp: TTLNoKey},
+	}
+
+	for _, test := range tests {
+		var d time.Duration
+		br := bufio.NewReader(bytes.NewBufferString(test.in))
+		require.NoError(t, TTLDuration{Rcv: &d, Unit: test.unit}.UnmarshalRESP(br))
+		assert.Equal(t, test.exp, d, "in:%q", test.in)
+	}
+
+	var d time.Duration
+	br := bufio.NewReader(bytes.NewBufferString(":-3\r\n:1\r\n"))
+	err := TTLDuration{Rcv: &d}.UnmarshalRESP(br)
+	assert.True(t, errors.As(err, new(resp.ErrDiscarded)))
+	require.NoError(t, TTLDuration{Rcv: &d}.UnmarshalRESP(br))
+	assert.Equal(t, time.Millisecond, d)
+}
+
+func TestTTLDurationLive(t *T) {
+	c := dial()
+	defer c.Close()
+	key := randStr()
+
+	var ttl time.Duration
+	require.NoError(t, c.Do(Cmd(TTLDuration{Rcv: &ttl}, "PTTL", key)))
+	assert.Equal(t, TTLNoKey, ttl)
+
+	require.NoError(t, c.Do(Cmd(nil, "SET", key, "a")))
+	require.NoError(t, c.Do(Cmd(TTLDuration{Rcv: &ttl, Unit: time.Second}, "TTL", key)))
+	assert.Equal(t, TTLNoExpiry, ttl)
+
+	require.NoError(t, c.Do(Cmd(nil, "EXPIRE", key, "100")))
+	require.NoError(t, c.Do(Cmd(TTLDuration{Rcv: &ttl}, "PTTL", key)))
+	assert.True(t, ttl > 99*time.Second && ttl <= 100*time.Second, "ttl:%v", ttl)
+}
+
+func TestEncoding(t *T) {
+	for _, e := range []Encoding{
+		EncodingInt, EncodingEmbstr, EncodingZipmap, EncodingZiplist,
+		EncodingListpack, EncodingIntset,
+	} {
+		assert.True(t, e.IsCompact(), "encoding:%q", e)
+	}
+	for _, e := range []Encoding{
+		EncodingRaw, EncodingHashtable, EncodingLinkedlist, EncodingQuicklist,
+		EncodingSkiplist, EncodingStream, "", "somethingnew",
+	} {
+		assert.False(t, e.IsCompact(), "encoding:%q", e)
+	}
+
+	stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		if args[2] == "missing" {
+			return nil
+		}
+		return "listpack"
+	})
+
+	e := EncodingRaw
+	a := ObjectEncoding(&e, "key")
+	assert.Equal(t, []string{"key"}, a.Keys())
+	require.NoError(t, stub.Do(a))
+	assert.Equal(t, EncodingListpack, e)
+
+	require.NoError(t, stub.Do(ObjectEncoding(&e, "missing")))
+	assert.Equal(t, Encoding(""), e)
+}
+
+func TestObjectEncoding(t *T) {
+	c := dial()
+	defer c.Close()
+	key := randStr()
+
+	var e Encoding
+	require.NoError(t, c.Do(Cmd(nil, "SET", key, "1")))
+	require.NoError(t, c.Do(ObjectEncoding(&e, key)))
+	assert.Equal(t, EncodingInt, e)
+	assert.True(t, e.IsCompact())
+
+	key = randStr()
+	require.NoError(t, c.Do(Cmd(nil, "SADD", key, "1", "2", "3")))
+	require.NoError(t, c.Do(ObjectEncoding(&e, key)))
+	assert.Equal(t, EncodingIntset, e)
+	assert.True(t, e.IsCompact())
+
+	require.NoError(t, c.Do(Cmd(nil, "SADD", key, "a")))
+	require.NoError(t, c.Do(ObjectEncoding(&e, key)))
+	assert.True(t, e == EncodingHashtable || e == EncodingListpack, "encoding:%q", e)
+}
+
+func TestObjectIdleTime(t *T) {
+	stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		if args[2] == "missing" {
+			return nil
+		}
+		return 90
+	})
+
+	idle := ObjectIdleTime(new(time.Duration), "foo")
+	assert.Equal(t, []string{"foo"}, idle.Keys())
+
+	var d time.Duration
+	require.NoError(t, stub.Do(ObjectIdleTime(&d, "foo")))
+	assert.Equal(t, 90*time.Second, d)
+	require.NoError(t, stub.Do(ObjectIdleTime(&d, "missing")))
+	assert.Zero(t, d)
+}
+
+func TestSetRange(t *T) {
+	c := dial()
+	defer c.Close()
+	key := randStr()
+
+	require.NoError(t, c.Do(Cmd(nil, "SET", key, "abc")))
+
+	var newLen, padding int64
+	require.NoError(t, c.Do(SetRange(&newLen, &padding, key, 6, []byte("xy"))))
+	assert.Equal(t, int64(8), newLen)
+	assert.Equal(t, int64(3), padding)
+
+	var got []byte
+	require.NoError(t, c.Do(Cmd(&got, "GET", key)))
+	assert.Equal(t, []byte("abc\x00\x00\x00xy"), got)
+
+	// writing within the existing value introduces no padding
+	require.NoError(t, c.Do(SetRange(&newLen, &padding, key, 1, []byte("B"))))
+	assert.Equal(t, int64(8), newLen)
+	assert.Zero(t, padding)
+
+	require.NoError(t, c.Do(SetRange(&newLen, nil, key, 10, []byte("z"))))
+	assert.Equal(t, int64(11), newLen)
+}
+
+func TestSetRangeStub(t *T) {
+	m := map[string]string{"key": "abc"}
+	var cmds []string
+	stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		cmds = append(cmds, args[0])
+		switch args[0] {
+		case "STRLEN":
+			return len(m[args[1]])
+		case "SETRANGE":
+			offset, _ := strconv.Atoi(args[2])
+			v := []byte(m[args[1]])
+			for len(v) < offset+len(args[3]) {
+				v = append(v, 0)
+			}
+			copy(v[offset:], args[3])
+			m[args[1]] = string(v)
+			return len(v)
+		}
+		return resp2.Error{E: errors.Errorf("unknown command %q", args[0])}
+	})
+
+	var newLen, padding int64
+	a := SetRange(&newLen, &padding, "key", 5, []byte("xy"))
+	assert.Equal(t, []string{"key"}, a.Keys())
+	require.NoError(t, stub.Do(a))
+	assert.Equal(t, int64(7), newLen)
+	assert.Equal(t, int64(2), padding)
+	assert.Equal(t, "abc\x00\x00xy", m["key"])
+	assert.Equal(t, []string{"STRLEN", "SETRANGE"}, cmds)
+
+	cmds = nil
+	require.NoError(t, stub.Do(SetRange(&newLen, nil, "key", 0, []byte("A"))))
+	assert.Equal(t, int64(7), newLen)
+	assert.Equal(t, []string{"SETRANGE"}, cmds)
+}
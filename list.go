@@ -0,0 +1,128 @@
+package radix
+
+import (
+	"strconv"
+	"time"
+
+	errors "golang.org/x/xerrors"
+)
+
+type pushCapped struct {
+	rcv    *int
+	key    [1]string // use array to avoid allocation in Keys
+	maxLen int
+	values []string
+}
+
+// PushCapped returns an Action which LPUSHes the given values onto the list at
+// key and then LTRIMs the list so that it contains at most maxLen elements,
+// keeping the most recently pushed ones. This is the common "capped list"
+// idiom, as used for keeping track of a bounded number of recent items.
+//
+// If rcv is not nil then the length of the list after trimming will be written
+// to it.
+//
+// The LPUSH and LTRIM are performed as a Pipeline, so only a single round-trip
+// is required, and since both commands act on the same key the Action can be
+// used with Cluster. Other clients may briefly observe the list with more than
+// maxLen elements, use an EvalScript if that isn't acceptable.
+//
+// maxLen must be greater than zero, and at least one value must be given.
+func PushCapped(rcv *int, key string, maxLen int, values ...string) Action {
+	return &pushCapped{
+		rcv:    rcv,
+		key:    [1]string{key},
+		maxLen: maxLen,
+		values: values,
+	}
+}
+
+func (pc *pushCapped) Keys() []string {
+	return pc.key[:]
+}
+
+func (pc *pushCapped) Run(c Conn) error {
+	if pc.maxLen <= 0 {
+		return errors.Errorf("PushCapped maxLen must be greater than zero, got %d", pc.maxLen)
+	} else if len(pc.values) == 0 {
+		return errors.New("PushCapped requires at least one value")
+	}
+
+	var l int
+	key := pc.key[0]
+	err := Pipeline(
+		Cmd(&l, "LPUSH", append([]string{key}, pc.values...)...),
+		Cmd(nil, "LTRIM", key, "0", strconv.Itoa(pc.maxLen-1)),
+	).Run(c)
+	if err != nil {
+		return err
+	}
+
+	if pc.rcv != nil {
+		if l > pc.maxLen {
+			l = pc.maxLen
+		}
+		*pc.rcv = l
+	}
+	return nil
+}
+
+// ErrQueueEmpty is returned from QueueConsumer's Reserve method when no item
+// became available within the given timeout.
+var ErrQueueEmpty = errors.New("queue is empty")
+
+// QueueConsumer implements the reliable queue pattern on top of redis lists.
+// Items are pushed onto the left of the queue list, using LPUSH, by producers.
+// When an item is reserved by a consumer it is atomically moved onto a
+// processing list, and is only removed from there once the consumer
+// acknowledges that it has been processed. Items left on the processing list
+// by consumers which have died can be recovered by some other process.
+//
+// When using Cluster the queue and processing lists must belong to the same
+// slot, e.g. by using a hashtag like "{jobs}" and "{jobs}:processing".
+type QueueConsumer struct {
+	client            Client
+	queue, processing string
+}
+
+// NewQueueConsumer initializes and returns a QueueConsumer which will reserve
+// items from the queue list, moving them onto the processing list, using the
+// given Client.
+func NewQueueConsumer(client Client, queue, processing string) *QueueConsumer {
+	return &QueueConsumer{
+		client:     client,
+		queue:      queue,
+		processing: processing,
+	}
+}
+
+// Reserve blocks until an item is available on the queue, up to the given
+// timeout, and atomically moves it to the processing list using BRPOPLPUSH. A
+// timeout of zero blocks indefinitely, otherwise it's rounded up to the nearest
+// second. ErrQueueEmpty is returned if the timeout is reached.
+//
+// The returned ack function should be called once the item has been processed,
+// and will remove it from the processing list.
+//
+// NOTE that the timeout must be less than the read timeout of the Client's
+// connections (see DialReadTimeout), or the Client will time out first.
+func (qc *QueueConsumer) Reserve(timeout time.Duration) (string, func() error, error) {
+	secs := int64(timeout / time.Second)
+	if timeout%time.Second > 0 {
+		secs++
+	}
+
+	var item string
+	mn := MaybeNil{Rcv: &item}
+	err := qc.client.Do(Cmd(&mn, "BRPOPLPUSH", qc.queue, qc.processing, strconv.FormatInt(secs, 10)))
+	if err != nil {
+		return "", nil, err
+	} else if mn.Nil {
+		return "", nil, ErrQueueEmpty
+	}
+
+	ack := func() error {
+		return qc.client.Do(Cmd(nil, "LREM", qc.processing, "1", item))
+	}
+	return item, ack, nil
+}
@@ -0,0 +1,67 @@
+package radix
+
+import (
+	. "testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPushCapped(t *T) {
+	c := dial()
+	defer c.Close()
+	key := randStr()
+
+	var l int
+	require.NoError(t, c.Do(PushCapped(&l, key, 3, "a", "b")))
+	assert.Equal(t, 2, l)
+
+	require.NoError(t, c.Do(PushCapped(&l, key, 3, "c", "d")))
+	assert.Equal(t, 3, l)
+
+	var got []string
+	require.NoError(t, c.Do(Cmd(&got, "LRANGE", key, "0", "-1")))
+	assert.Equal(t, []string{"d", "c", "b"}, got)
+
+	require.NoError(t, c.Do(PushCapped(nil, key, 1, "e")))
+	require.NoError(t, c.Do(Cmd(&got, "LRANGE", key, "0", "-1")))
+	assert.Equal(t, []string{"e"}, got)
+
+	assert.Error(t, c.Do(PushCapped(&l, key, 0, "f")))
+	assert.Error(t, c.Do(PushCapped(&l, key, 3)))
+}
+
+func TestQueueConsumer(t *T) {
+	c := dial()
+	defer c.Close()
+	queue := randStr()
+	processing := queue + ":processing"
+	qc := NewQueueConsumer(c, queue, processing)
+
+	require.NoError(t, c.Do(Cmd(nil, "LPUSH", queue, "a", "b")))
+
+	item, ack, err := qc.Reserve(time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, "a", item)
+
+	var got []string
+	require.NoError(t, c.Do(Cmd(&got, "LRANGE", processing, "0", "-1")))
+	assert.Equal(t, []string{"a"}, got)
+
+	require.NoError(t, ack())
+	require.NoError(t, c.Do(Cmd(&got, "LRANGE", processing, "0", "-1")))
+	assert.Empty(t, got)
+
+	item, _, err = qc.Reserve(time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, "b", item)
+
+	_, ack, err = qc.Reserve(time.Second)
+	assert.Equal(t, ErrQueueEmpty, err)
+	assert.Nil(t, ack)
+
+	// the unacknowledged item remains on the processing list
+	require.NoError(t, c.Do(Cmd(&got, "LRANGE", processing, "0", "-1")))
+	assert.Equal(t, []string{"b"}, got)
+}
@@ -0,0 +1,85 @@
+package radix
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mediocregopher/radix/v3/resp"
+)
+
+type loggedCmd struct {
+	cmd   string
+	start time.Time
+}
+
+type loggingConn struct {
+	Conn
+	log     func(cmd string, dur time.Duration, err error)
+	pending []loggedCmd
+}
+
+// NewLoggingConn returns a Conn which wraps the given one, calling log once for
+// every command performed through it. log is given the command, formatted as by
+// the String method of the CmdActions returned from Cmd and FlatCmd, the time
+// between the command being written and its reply being read, and the error
+// which writing the command or reading its reply resulted in, if any.
+//
+// Each of the commands in a Pipeline is logged individually, with the time
+// being measured from when the whole Pipeline was written. If a command can't
+// be written then it's logged immediately, with the error.
+//
+// Like RecordConn, commands are matched to replies in the order they were sent,
+// so the SUBSCRIBE family of commands won't be logged correctly. All methods
+// other than Do, Encode, and Decode are passed through to the wrapped Conn
+// unchanged.
+func NewLoggingConn(c Conn, log func(cmd string, dur time.Duration, err error)) Conn {
+	return &loggingConn{Conn: c, log: log}
+}
+
+// loggedCmdString returns the string which the given Marshaler is logged as,
+// using its String method if it has one, since the CmdActions returned from Cmd
+// and FlatCmd do.
+func loggedCmdString(m resp.Marshaler) string {
+	if s, ok := m.(fmt.Stringer); ok {
+		return s.String()
+	}
+	return cmdString(m)
+}
+
+func (lc *loggingConn) Do(a Action) error {
+	return a.Run(lc)
+}
+
+func (lc *loggingConn) Encode(m resp.Marshaler) error {
+	var cmds []string
+	if p, ok := m.(pipeline); ok {
+		for _, cmd := range p {
+			cmds = append(cmds, loggedCmdString(cmd))
+		}
+	} else {
+		cmds = []string{loggedCmdString(m)}
+	}
+
+	start := time.Now()
+	if err := lc.Conn.Encode(m); err != nil {
+		for _, cmd := range cmds {
+			lc.log(cmd, time.Since(start), err)
+		}
+		return err
+	}
+
+	for _, cmd := range cmds {
+		lc.pending = append(lc.pending, loggedCmd{cmd: cmd, start: start})
+	}
+	return nil
+}
+
+func (lc *loggingConn) Decode(u resp.Unmarshaler) error {
+	err := lc.Conn.Decode(u)
+	if len(lc.pending) > 0 {
+		lc.log(lc.pending[0].cmd, time.Since(lc.pending[0].start), err)
+		lc.pending[0] = loggedCmd{}
+		lc.pending = lc.pending[1:]
+	}
+	return err
+}
@@ -0,0 +1,72 @@
+package radix
+
+import (
+	. "testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	errors "golang.org/x/xerrors"
+
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+)
+
+func TestLoggingConn(t *T) {
+	type logged struct {
+		cmd string
+		err error
+	}
+	stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		switch args[0] {
+		case "GET", "ECHO":
+			return args[1]
+		case "SET":
+			return resp2.SimpleString{S: "OK"}
+		}
+		return resp2.Error{E: errors.New("ERR unknown command")}
+	})
+
+	var logs []logged
+	c := NewLoggingConn(stub, func(cmd string, dur time.Duration, err error) {
+		assert.True(t, dur >= 0)
+		logs = append(logs, logged{cmd, err})
+	})
+
+	var foo string
+	require.NoError(t, c.Do(Cmd(nil, "SET", "foo", "1")))
+	require.NoError(t, c.Do(FlatCmd(&foo, "GET", "foo")))
+	assert.Equal(t, "foo", foo)
+	assert.Equal(t, []logged{
+		{cmd: `["SET" "foo" "1"]`},
+		{cmd: `["GET" "foo"]`},
+	}, logs)
+
+	// each command in a pipeline is logged, including their errors
+	logs = nil
+	err := c.Do(Pipeline(
+		Cmd(nil, "ECHO", "foo"),
+		Cmd(nil, "BADCMD"),
+		Cmd(nil, "ECHO", "bar"),
+	))
+	assert.True(t, errors.As(err, new(resp2.Error)))
+	require.Len(t, logs, 3)
+	assert.Equal(t, `["ECHO" "foo"]`, logs[0].cmd)
+	assert.NoError(t, logs[0].err)
+	assert.Equal(t, `["BADCMD"]`, logs[1].cmd)
+	assert.True(t, errors.As(logs[1].err, new(resp2.Error)))
+	assert.Equal(t, `["ECHO" "bar"]`, logs[2].cmd)
+
+	// a command which can't be written is logged straight away
+	logs = nil
+	assert.Error(t, c.Do(Cmd(nil, "BAD CMD")))
+	require.Len(t, logs, 1)
+	assert.Error(t, logs[0].err)
+
+	// other methods are passed through
+	assert.Equal(t, "127.0.0.1:6379", c.NetConn().RemoteAddr().String())
+	logs = nil
+	require.NoError(t, c.Encode(resp2.RawMessage("*2\r\n$4\r\nECHO\r\n$3\r\nbaz\r\n")))
+	require.NoError(t, c.Decode(resp2.Any{I: &foo}))
+	assert.Equal(t, "baz", foo)
+	assert.Equal(t, []logged{{cmd: `["ECHO" "baz"]`}}, logs)
+}
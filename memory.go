@@ -0,0 +1,180 @@
+package radix
+
+import "strconv"
+
+// MemoryUsage describes the number of keys in some group, and the total
+// number of bytes they use, within a MemoryReport.
+type MemoryUsage struct {
+	Keys  int64
+	Bytes int64
+}
+
+func (mu *MemoryUsage) add(bytes int64) {
+	mu.Keys++
+	mu.Bytes += bytes
+}
+
+// MemoryReport describes the memory profile of a sample of keys, as returned
+// by AnalyzeMemory. Byte counts are those reported by MEMORY USAGE.
+type MemoryReport struct {
+	// Total describes all keys which were analyzed.
+	Total MemoryUsage
+
+	// ByType groups the analyzed keys by their type, as returned by TYPE (e.g.
+	// "string", "hash").
+	ByType map[string]MemoryUsage
+
+	// ByEncoding groups the analyzed keys by their internal encoding, as
+	// returned by OBJECT ENCODING.
+	ByEncoding map[Encoding]MemoryUsage
+
+	// ByNode groups the analyzed keys by the address of the primary they were
+	// found on. It's only filled when analyzing a Cluster.
+	ByNode map[string]MemoryUsage
+}
+
+func newMemoryReport() MemoryReport {
+	return MemoryReport{
+		ByType:     map[string]MemoryUsage{},
+		ByEncoding: map[Encoding]MemoryUsage{},
+		ByNode:     map[string]MemoryUsage{},
+	}
+}
+
+func (mr *MemoryReport) add(node, typ string, enc Encoding, bytes int64) {
+	mr.Total.add(bytes)
+
+	byType := mr.ByType[typ]
+	byType.add(bytes)
+	mr.ByType[typ] = byType
+
+	byEnc := mr.ByEncoding[enc]
+	byEnc.add(bytes)
+	mr.ByEncoding[enc] = byEnc
+
+	if node != "" {
+		byNode := mr.ByNode[node]
+		byNode.add(bytes)
+		mr.ByNode[node] = byNode
+	}
+}
+
+// MemoryAnalysisOpts are optional parameters to AnalyzeMemory.
+type MemoryAnalysisOpts struct {
+	// Pattern is an optional pattern which keys must match in order to be
+	// analyzed, as used by SCAN.
+	Pattern string
+
+	// SampleSize is the maximum number of keys to analyze. When analyzing a
+	// Cluster this limit applies to each primary individually. If zero then
+	// all matching keys are analyzed.
+	SampleSize int
+
+	// Batch is the number of keys which are analyzed per round-trip, and is
+	// also used as the COUNT hint for SCAN. Defaults to 100.
+	Batch int
+
+	// Samples, if greater than zero, is passed as the SAMPLES option to
+	// MEMORY USAGE, which determines how many elements of nested values are
+	// sampled in order to estimate their size.
+	Samples int
+}
+
+// AnalyzeMemory SCANs the keys of the given Client and, for each one, performs
+// MEMORY USAGE, OBJECT ENCODING and TYPE, returning a report of how many keys
+// and bytes are used by each type and encoding. This is useful for
+// understanding the memory profile of a dataset, e.g. for capacity planning.
+//
+// The commands for each batch of keys are performed as a single Pipeline. Keys
+// which are deleted in between being scanned and being analyzed are skipped.
+//
+// If the Client is a *Cluster then each of its primaries is analyzed
+// individually, and the report's ByNode field describes each one.
+//
+// MEMORY USAGE is only available in redis 4.0 and later.
+func AnalyzeMemory(c Client, opts MemoryAnalysisOpts) (MemoryReport, error) {
+	if opts.Batch <= 0 {
+		opts.Batch = 100
+	}
+
+	report := newMemoryReport()
+	cluster, ok := c.(*Cluster)
+	if !ok {
+		return report, analyzeMemory(&report, c, "", opts)
+	}
+
+	for _, node := range cluster.Topo().Primaries() {
+		client, err := cluster.Client(node.Addr)
+		if err != nil {
+			return report, err
+		} else if err := analyzeMemory(&report, client, node.Addr, opts); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+type memoryAnalysisKey struct {
+	bytes    int64
+	bytesNil MaybeNil
+	enc      Encoding
+	typ      string
+}
+
+func analyzeMemory(report *MemoryReport, c Client, node string, opts MemoryAnalysisOpts) error {
+	keys := make([]string, 0, opts.Batch)
+	results := make([]memoryAnalysisKey, opts.Batch)
+	cmds := make([]CmdAction, 0, opts.Batch*3)
+
+	analyze := func() error {
+		cmds = cmds[:0]
+		for i, key := range keys {
+			res := &results[i]
+			*res = memoryAnalysisKey{}
+			res.bytesNil.Rcv = &res.bytes
+
+			usageArgs := []string{"USAGE", key}
+			if opts.Samples > 0 {
+				usageArgs = append(usageArgs, "SAMPLES", strconv.Itoa(opts.Samples))
+			}
+			cmds = append(cmds,
+				Cmd(&res.bytesNil, "MEMORY", usageArgs...),
+				ObjectEncoding(&res.enc, key),
+				Cmd(&res.typ, "TYPE", key),
+			)
+		}
+
+		if err := c.Do(Pipeline(cmds...)); err != nil {
+			return err
+		}
+
+		for _, res := range results[:len(keys)] {
+			if res.bytesNil.Nil || res.typ == "none" {
+				continue // key was deleted since being scanned
+			}
+			report.add(node, res.typ, res.enc, res.bytes)
+		}
+		keys = keys[:0]
+		return nil
+	}
+
+	s := NewScanner(c, ScanOpts{Command: "SCAN", Pattern: opts.Pattern, Count: opts.Batch})
+	var key string
+	var scanned int
+	for (opts.SampleSize <= 0 || scanned < opts.SampleSize) && s.Next(&key) {
+		scanned++
+		if keys = append(keys, key); len(keys) < opts.Batch {
+			continue
+		} else if err := analyze(); err != nil {
+			s.Close()
+			return err
+		}
+	}
+
+	if err := s.Close(); err != nil {
+		return err
+	} else if len(keys) > 0 {
+		return analyze()
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package radix
+
+import (
+	"strings"
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	errors "golang.org/x/xerrors"
+
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+)
+
+func TestAnalyzeMemory(t *T) {
+	type val struct {
+		typ   string
+		enc   Encoding
+		bytes int64
+	}
+	kv := map[string]val{
+		"a": {"string", EncodingEmbstr, 50},
+		"b": {"string", EncodingInt, 10},
+		"c": {"hash", EncodingListpack, 100},
+		"d": {"hash", EncodingHashtable, 1000},
+		"e": {"list", EncodingQuicklist, 200},
+	}
+	// "gone" is returned by SCAN but no longer exists
+	scanKeys := []string{"a", "b", "c", "gone", "d", "e"}
+
+	stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		switch strings.ToUpper(args[0]) {
+		case "SCAN":
+			return []interface{}{"0", scanKeys}
+		case "MEMORY":
+			if v, ok := kv[args[2]]; ok {
+				return v.bytes
+			}
+			return nil
+		case "OBJECT":
+			if v, ok := kv[args[2]]; ok {
+				return string(v.enc)
+			}
+			return nil
+		case "TYPE":
+			if v, ok := kv[args[1]]; ok {
+				return resp2.SimpleString{S: v.typ}
+			}
+			return resp2.SimpleString{S: "none"}
+		}
+		return resp2.Error{E: errors.Errorf("unknown command %q", args[0])}
+	})
+
+	report, err := AnalyzeMemory(stub, MemoryAnalysisOpts{Batch: 4})
+	require.NoError(t, err)
+	assert.Equal(t, MemoryUsage{Keys: 5, Bytes: 1360}, report.Total)
+	assert.Equal(t, map[string]MemoryUsage{
+		"string": {Keys: 2, Bytes: 60},
+		"hash":   {Keys: 2, Bytes: 1100},
+		"list":   {Keys: 1, Bytes: 200},
+	}, report.ByType)
+	assert.Equal(t, map[Encoding]MemoryUsage{
+		EncodingEmbstr:    {Keys: 1, Bytes: 50},
+		EncodingInt:       {Keys: 1, Bytes: 10},
+		EncodingListpack:  {Keys: 1, Bytes: 100},
+		EncodingHashtable: {Keys: 1, Bytes: 1000},
+		EncodingQuicklist: {Keys: 1, Bytes: 200},
+	}, report.ByEncoding)
+	assert.Empty(t, report.ByNode)
+
+	report, err = AnalyzeMemory(stub, MemoryAnalysisOpts{SampleSize: 2})
+	require.NoError(t, err)
+	assert.Equal(t, MemoryUsage{Keys: 2, Bytes: 60}, report.Total)
+}
+
+func TestAnalyzeMemoryLive(t *T) {
+	c := dial()
+	defer c.Close()
+	prefix := randStr()
+
+	require.NoError(t, c.Do(Cmd(nil, "SET", prefix+":a", "foo")))
+	require.NoError(t, c.Do(Cmd(nil, "HSET", prefix+":b", "foo", "bar")))
+
+	report, err := AnalyzeMemory(c, MemoryAnalysisOpts{Pattern: prefix + ":*", Samples: 5})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), report.Total.Keys)
+	assert.Equal(t, int64(1), report.ByType["string"].Keys)
+	assert.Equal(t, int64(1), report.ByType["hash"].Keys)
+	assert.NotZero(t, report.Total.Bytes)
+}
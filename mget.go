@@ -0,0 +1,21 @@
+package radix
+
+// MGet returns a CmdAction which performs an MGET command on the given keys,
+// and unmarshals the values into rcv in the same order as the keys. The element
+// for a key which doesn't exist (or doesn't hold a string) is nil, so that it
+// can be distinguished from a key holding an empty string.
+//
+// When using Cluster all of the keys must belong to the same slot.
+func MGet(rcv *[]*string, keys ...string) CmdAction {
+	return Cmd(rcv, "MGET", keys...)
+}
+
+// MSet returns a CmdAction which performs an MSET command, setting each of the
+// given keys to its value. The keys are given to MSET in sorted order, so that
+// the command is the same each time for the same map.
+//
+// The returned CmdAction's Keys method returns every key, as for FlatMapCmd, so
+// when using Cluster all of the keys must belong to the same slot.
+func MSet(kvs map[string]string) CmdAction {
+	return FlatMapCmd(nil, "MSET", kvs)
+}
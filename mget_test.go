@@ -0,0 +1,43 @@
+package radix
+
+import (
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMGetMSet(t *T) {
+	kv := map[string]string{}
+	stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		switch args[0] {
+		case "MSET":
+			for i := 1; i < len(args); i += 2 {
+				kv[args[i]] = args[i+1]
+			}
+			return "OK"
+		case "MGET":
+			vals := make([]interface{}, len(args)-1)
+			for i, k := range args[1:] {
+				if v, ok := kv[k]; ok {
+					vals[i] = v
+				}
+			}
+			return vals
+		}
+		return nil
+	})
+
+	set := MSet(map[string]string{"foo": "1", "bar": "", "baz": "3"})
+	assert.Equal(t, `["MSET" "bar" "" "baz" "3" "foo" "1"]`, cmdString(set))
+	assert.Equal(t, []string{"bar", "baz", "foo"}, set.Keys())
+	require.NoError(t, stub.Do(set))
+
+	var vals []*string
+	get := MGet(&vals, "foo", "missing", "bar")
+	require.NoError(t, stub.Do(get))
+	require.Len(t, vals, 3)
+	assert.Equal(t, "1", *vals[0])
+	assert.Nil(t, vals[1])
+	assert.Equal(t, "", *vals[2])
+}
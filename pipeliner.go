@@ -21,6 +21,9 @@ var blockingCmds = map[string]bool{
 	"BZPOPMIN": true,
 	"BZPOPMAX": true,
 
+	"BLMPOP": true,
+	"BZMPOP": true,
+
 	"XREAD":      true,
 	"XREADGROUP": true,
 
@@ -86,7 +89,14 @@ func (p *pipeliner) CanDo(a Action) bool {
 	// there is currently no way to get the command for CmdAction implementations
 	// from outside the radix package so we can not multiplex those commands. User
 	// defined pipelines are not pipelined to let the user better control them.
+	//
+	// Commands whose receiver is a channel are sent to as the reply is read,
+	// which could stall every other command in the pipeline if the channel
+	// isn't received from, so they're treated like blocking commands.
 	if cmdA, ok := a.(*cmdAction); ok {
+		if _, ok := chanRcv(cmdA.rcv); ok {
+			return false
+		}
 		return !blockingCmds[strings.ToUpper(cmdA.cmd)]
 	}
 	return false
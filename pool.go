@@ -1,6 +1,7 @@
 package radix
 
 import (
+	"bufio"
 	"io"
 	"net"
 	"sync"
@@ -10,6 +11,7 @@ import (
 	errors "golang.org/x/xerrors"
 
 	"github.com/mediocregopher/radix/v3/resp"
+	"github.com/mediocregopher/radix/v3/resp/resp2"
 	"github.com/mediocregopher/radix/v3/trace"
 )
 
@@ -69,6 +71,67 @@ func (ioc *ioErrConn) Close() error {
 
 ////////////////////////////////////////////////////////////////////////////////
 
+// countingConn is a Conn which counts the number of bytes which are marshaled
+// by Encode and unmarshaled by Decode. Since a reply must first be read into a
+// RawMessage in order to be counted it's only used when that's needed for
+// tracing.
+type countingConn struct {
+	Conn
+	written, read int64
+}
+
+type countingWriter struct {
+	io.Writer
+	n *int64
+}
+
+func (cw countingWriter) Write(b []byte) (int, error) {
+	n, err := cw.Writer.Write(b)
+	*cw.n += int64(n)
+	return n, err
+}
+
+type countingMarshaler struct {
+	resp.Marshaler
+	n *int64
+}
+
+func (cm countingMarshaler) MarshalRESP(w io.Writer) error {
+	return cm.Marshaler.MarshalRESP(countingWriter{Writer: w, n: cm.n})
+}
+
+type countingUnmarshaler struct {
+	resp.Unmarshaler
+	n *int64
+}
+
+func (cu countingUnmarshaler) UnmarshalRESP(br *bufio.Reader) error {
+	var rm resp2.RawMessage
+	if err := rm.UnmarshalRESP(br); err != nil {
+		return err
+	}
+	*cu.n += int64(len(rm))
+	return rm.UnmarshalInto(cu.Unmarshaler)
+}
+
+func (cu countingUnmarshaler) attributes() *map[string]interface{} {
+	return receiverAttributes(cu.Unmarshaler)
+}
+
+func (cc *countingConn) Do(a Action) error {
+	return a.Run(cc)
+}
+
+func (cc *countingConn) Encode(m resp.Marshaler) error {
+	return cc.Conn.Encode(countingMarshaler{Marshaler: m, n: &cc.written})
+}
+
+func (cc *countingConn) Decode(u resp.Unmarshaler) error {
+	return cc.Conn.Decode(countingUnmarshaler{Unmarshaler: u, n: &cc.read})
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
 type poolOpts struct {
 	cf                    ConnFunc
 	pingInterval          time.Duration
@@ -588,13 +651,50 @@ func (p *Pool) Do(a Action) error {
 		return err
 	}
 
-	err = c.Do(a)
+	if numCmds, ok := pipelineLen(a); ok && p.opts.pt.PipelineCompleted != nil {
+		cc := &countingConn{Conn: c}
+		err = a.Run(cc)
+		p.tracePipelineCompleted(numCmds, cc, time.Since(startTime), err)
+	} else {
+		err = c.Do(a)
+	}
 	p.put(c)
 	p.traceDoCompleted(time.Since(startTime), err)
 
 	return err
 }
 
+// pipelineLen returns the number of commands in the given Action, if it's one
+// of the pipeline types.
+func pipelineLen(a Action) (int, bool) {
+	switch a := a.(type) {
+	case *PipelineBuilder:
+		return a.Len(), true
+	case *pipelinerPipeline:
+		return len(a.pipeline), true
+	case pipelineCollect:
+		return len(a.pipeline), true
+	case pipelineAll:
+		return len(a.pipeline), true
+	case batchedPipeline:
+		return len(a.pipeline), true
+	case pipelineInto:
+		return len(a.pipeline), true
+	}
+	return 0, false
+}
+
+func (p *Pool) tracePipelineCompleted(numCmds int, cc *countingConn, elapsedTime time.Duration, err error) {
+	p.opts.pt.PipelineCompleted(trace.PoolPipelineCompleted{
+		PoolCommon:   p.traceCommon(),
+		NumCommands:  numCmds,
+		BytesWritten: cc.written,
+		BytesRead:    cc.read,
+		ElapsedTime:  elapsedTime,
+		Err:          err,
+	})
+}
+
 func (p *Pool) traceDoCompleted(elapsedTime time.Duration, err error) {
 	if p.opts.pt.DoCompleted != nil {
 		p.opts.pt.DoCompleted(trace.PoolDoCompleted{
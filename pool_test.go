@@ -316,3 +316,94 @@ func TestIoErrConn(t *T) {
 		require.Nil(t, err2)
 	})
 }
+
+func TestPoolChanRcvNotPipelined(t *T) {
+	pool := testPool(2,
+		PoolConnFunc(func(string, string) (Conn, error) {
+			return Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+				if args[0] == "ECHO" {
+					return args[1]
+				}
+				return []string{"a", "b", "c"}
+			}), nil
+		}),
+	)
+	defer pool.Close()
+
+	// the channel isn't received from until the end, so the LRANGE blocks
+	// whichever Conn it's performed on
+	ch := make(chan string)
+	lrangeErrCh := make(chan error, 1)
+	go func() { lrangeErrCh <- pool.Do(Cmd(ch, "LRANGE", "foo", "0", "-1")) }()
+
+	errCh := make(chan error, 1)
+	go func() {
+		var out string
+		errCh <- pool.Do(Cmd(&out, "ECHO", "foo"))
+	}()
+	select {
+	case err := <-errCh:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("ECHO was blocked by a Cmd with a channel receiver")
+	}
+
+	var got []string
+	for s := range ch {
+		got = append(got, s)
+	}
+	assert.Equal(t, []string{"a", "b", "c"}, got)
+	require.NoError(t, <-lrangeErrCh)
+}
+
+func TestPoolTracePipelineCompleted(t *T) {
+	var l sync.Mutex
+	var traces []trace.PoolPipelineCompleted
+	pool := testPool(1,
+		PoolConnFunc(func(string, string) (Conn, error) {
+			return Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+				if args[0] == "ECHO" {
+					return args[1]
+				}
+				return "PONG"
+			}), nil
+		}),
+		PoolWithTrace(trace.PoolTrace{
+			PipelineCompleted: func(ppc trace.PoolPipelineCompleted) {
+				l.Lock()
+				defer l.Unlock()
+				traces = append(traces, ppc)
+			},
+		}),
+	)
+	defer pool.Close()
+
+	var a, b string
+	require.NoError(t, pool.Do(Pipeline(
+		Cmd(&a, "ECHO", "foo"),
+		Cmd(&b, "ECHO", "barbaz"),
+	)))
+	assert.Equal(t, "foo", a)
+	assert.Equal(t, "barbaz", b)
+
+	var errs []error
+	require.NoError(t, pool.Do(PipelineCollect(&errs, Cmd(&a, "ECHO", "foo"))))
+	require.NoError(t, pool.Do(PipelineAll(Cmd(&a, "ECHO", "foo"))))
+
+	// commands which aren't pipelines don't trigger the trace
+	require.NoError(t, pool.Do(WithConn("", func(c Conn) error {
+		return c.Do(Cmd(nil, "ECHO", "foo"))
+	})))
+
+	l.Lock()
+	defer l.Unlock()
+	require.Len(t, traces, 3)
+	assert.Equal(t, 2, traces[0].NumCommands)
+	assert.Equal(t, 1, traces[1].NumCommands)
+	assert.Equal(t, 1, traces[2].NumCommands)
+	// *2\r\n$4\r\nECHO\r\n$3\r\nfoo\r\n + *2\r\n$4\r\nECHO\r\n$6\r\nbarbaz\r\n
+	assert.Equal(t, int64(23+26), traces[0].BytesWritten)
+	// $3\r\nfoo\r\n + $6\r\nbarbaz\r\n
+	assert.Equal(t, int64(9+12), traces[0].BytesRead)
+	assert.NoError(t, traces[0].Err)
+}
@@ -0,0 +1,188 @@
+package radix
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+
+	errors "golang.org/x/xerrors"
+
+	"github.com/mediocregopher/radix/v3/resp"
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+)
+
+// RecordedCmd is a single command, and the reply which was received for it, as
+// recorded by a RecordConn. Both are stored as the raw RESP bytes which were
+// sent and received.
+type RecordedCmd struct {
+	Cmd   []byte
+	Reply []byte
+}
+
+// Recording is a sequence of commands and their replies, in the order they
+// were performed, as recorded by a RecordConn. It can be replayed using
+// NewReplayConn.
+type Recording []RecordedCmd
+
+// splitCmds splits the given raw RESP bytes, which may contain multiple
+// commands (e.g. from a Pipeline), into the raw bytes of each command.
+func splitCmds(b []byte) ([][]byte, error) {
+	var cmds [][]byte
+	br := bufio.NewReader(bytes.NewReader(b))
+	for {
+		if _, err := br.Peek(1); err != nil {
+			return cmds, nil
+		}
+
+		var rm resp2.RawMessage
+		if err := rm.UnmarshalRESP(br); err != nil {
+			return nil, err
+		}
+		cmds = append(cmds, rm)
+	}
+}
+
+// RecordConn is a Conn which wraps another, recording every command performed
+// through it along with the reply received for it. Once recorded, the
+// Recording can be replayed using NewReplayConn, allowing for deterministic
+// tests which don't require a live redis instance.
+//
+// Replies are matched to commands in the order they were sent, so RecordConn
+// shouldn't be used for commands which don't receive exactly one reply per
+// command, e.g. the SUBSCRIBE family.
+type RecordConn struct {
+	Conn
+	pending [][]byte
+	rec     Recording
+}
+
+// NewRecordConn initializes and returns a RecordConn which wraps the given
+// Conn.
+func NewRecordConn(c Conn) *RecordConn {
+	return &RecordConn{Conn: c}
+}
+
+// Do implements the method for the Conn interface.
+func (rc *RecordConn) Do(a Action) error {
+	return a.Run(rc)
+}
+
+// Encode implements the method for the Conn interface.
+func (rc *RecordConn) Encode(m resp.Marshaler) error {
+	buf := new(bytes.Buffer)
+	if err := m.MarshalRESP(buf); err != nil {
+		return err
+	}
+
+	cmds, err := splitCmds(buf.Bytes())
+	if err != nil {
+		return err
+	}
+	rc.pending = append(rc.pending, cmds...)
+	return rc.Conn.Encode(resp2.RawMessage(buf.Bytes()))
+}
+
+// Decode implements the method for the Conn interface.
+func (rc *RecordConn) Decode(u resp.Unmarshaler) error {
+	var rm resp2.RawMessage
+	if err := rc.Conn.Decode(&rm); err != nil {
+		return err
+	}
+
+	if len(rc.pending) > 0 {
+		rc.rec = append(rc.rec, RecordedCmd{Cmd: rc.pending[0], Reply: rm})
+		rc.pending = rc.pending[1:]
+	}
+	return rm.UnmarshalInto(u)
+}
+
+// Recording returns all commands which have been recorded so far, along with
+// their replies. Commands which have been sent but whose replies haven't yet
+// been received are not included.
+func (rc *RecordConn) Recording() Recording {
+	return append(Recording(nil), rc.rec...)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+type replayConn struct {
+	*buffer
+	rec  Recording
+	used []bool
+}
+
+// NewReplayConn returns a (fake) Conn which serves the replies from the given
+// Recording, rather than communicating with a real redis instance. It is
+// primarily useful for writing tests.
+//
+// When a command is encoded it is matched, by its exact marshaled bytes,
+// against the first command in the Recording which hasn't yet been replayed,
+// and that command's recorded reply will be returned by the next call to
+// Decode. This means that a command which was recorded multiple times will have
+// its replies replayed in the order they were recorded. If there is no
+// matching command left in the Recording then Encode returns an error.
+//
+// Like Stub, Decode will block if there's no reply available, and all
+// inherited net.Conn methods other than those for deadlines and RemoteAddr will
+// panic.
+func NewReplayConn(rec Recording) Conn {
+	return &replayConn{
+		buffer: newBuffer("", ""),
+		rec:    rec,
+		used:   make([]bool, len(rec)),
+	}
+}
+
+func (rc *replayConn) Do(a Action) error {
+	return a.Run(rc)
+}
+
+func (rc *replayConn) Encode(m resp.Marshaler) error {
+	buf := new(bytes.Buffer)
+	if err := m.MarshalRESP(buf); err != nil {
+		return err
+	}
+
+	cmds, err := splitCmds(buf.Bytes())
+	if err != nil {
+		return err
+	}
+
+	// every command is matched before any replies are buffered, so that if
+	// one of them has no recording then the replies of the others aren't left
+	// behind to be read by a later Decode
+	matched := make([]int, 0, len(cmds))
+	for _, cmd := range cmds {
+		i := rc.match(cmd)
+		if i < 0 {
+			for _, j := range matched {
+				rc.used[j] = false
+			}
+			return errors.Errorf("no recorded reply for command %q", cmd)
+		}
+		rc.used[i] = true
+		matched = append(matched, i)
+	}
+
+	for _, i := range matched {
+		if err := rc.buffer.Encode(resp2.RawMessage(rc.rec[i].Reply)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// match returns the index of the first command in the Recording which hasn't
+// been replayed and which matches the given one, or -1 if there isn't one.
+func (rc *replayConn) match(cmd []byte) int {
+	for i, recCmd := range rc.rec {
+		if !rc.used[i] && bytes.Equal(recCmd.Cmd, cmd) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (rc *replayConn) NetConn() net.Conn {
+	return rc.buffer
+}
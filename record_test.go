@@ -0,0 +1,65 @@
+package radix
+
+import (
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordReplay(t *T) {
+	rc := NewRecordConn(testStub())
+	require.NoError(t, rc.Do(Cmd(nil, "SET", "foo", "1")))
+	require.NoError(t, rc.Do(Cmd(nil, "SET", "bar", "2")))
+
+	var foo, bar string
+	require.NoError(t, rc.Do(Pipeline(
+		Cmd(&foo, "GET", "foo"),
+		Cmd(&bar, "GET", "bar"),
+	)))
+	assert.Equal(t, "1", foo)
+	assert.Equal(t, "2", bar)
+
+	require.NoError(t, rc.Do(Cmd(nil, "SET", "foo", "3")))
+	require.NoError(t, rc.Do(Cmd(&foo, "GET", "foo")))
+	assert.Equal(t, "3", foo)
+
+	rec := rc.Recording()
+	require.Len(t, rec, 6)
+	assert.Equal(t, "*2\r\n$3\r\nGET\r\n$3\r\nfoo\r\n", string(rec[2].Cmd))
+	assert.Equal(t, "$1\r\n1\r\n", string(rec[2].Reply))
+
+	// replay it
+	c := NewReplayConn(rec)
+	require.NoError(t, c.Do(Cmd(nil, "SET", "foo", "1")))
+	require.NoError(t, c.Do(Cmd(nil, "SET", "bar", "2")))
+
+	// pipelined commands are matched individually, so order doesn't matter
+	foo, bar = "", ""
+	require.NoError(t, c.Do(Pipeline(
+		Cmd(&bar, "GET", "bar"),
+		Cmd(&foo, "GET", "foo"),
+	)))
+	assert.Equal(t, "1", foo)
+	assert.Equal(t, "2", bar)
+
+	// the second GET foo gets the second recorded reply
+	require.NoError(t, c.Do(FlatCmd(&foo, "GET", "foo")))
+	assert.Equal(t, "3", foo)
+
+	// every GET foo has been replayed
+	assert.Error(t, c.Do(Cmd(&foo, "GET", "foo")))
+	assert.Error(t, c.Do(Cmd(&foo, "GET", "baz")))
+
+	// a pipeline with a command which has no recording doesn't replay any of
+	// its commands
+	c = NewReplayConn(rec)
+	assert.Error(t, c.Do(Pipeline(
+		Cmd(&foo, "GET", "foo"),
+		Cmd(nil, "GET", "baz"),
+	)))
+	require.NoError(t, c.Do(Cmd(&bar, "GET", "bar")))
+	assert.Equal(t, "2", bar)
+	require.NoError(t, c.Do(Cmd(&foo, "GET", "foo")))
+	assert.Equal(t, "1", foo)
+}
@@ -86,3 +86,23 @@ func BenchmarkAnyUnmarshalRESP(b *testing.B) {
 		}
 	})
 }
+
+func BenchmarkAnyUnmarshalRESPBytes(b *testing.B) {
+	b.ReportAllocs()
+
+	input := "$64\r\n" + strings.Repeat("a", 64) + "\r\n"
+	var sr strings.Reader
+	br := bufio.NewReader(&sr)
+
+	// the same buffer is reused, so once it's large enough there should be no
+	// allocations
+	var buf []byte
+	for i := 0; i < b.N; i++ {
+		sr.Reset(input)
+		br.Reset(&sr)
+
+		if err := (Any{I: &buf}).UnmarshalRESP(br); err != nil {
+			b.Fatalf("failed to unmarshal %q: %s", input, err)
+		}
+	}
+}
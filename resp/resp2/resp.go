@@ -10,11 +10,14 @@ import (
 	"bufio"
 	"bytes"
 	"encoding"
+	"encoding/json"
 	"fmt"
 	"io"
 	"reflect"
+	"sort"
 	"strconv"
 	"sync"
+	"time"
 
 	errors "golang.org/x/xerrors"
 
@@ -42,6 +45,48 @@ var (
 	ArrayPrefix        = []byte{'*'}
 )
 
+// MapPrefix denotes the RESP3 map type, which redis will only send to
+// connections which have been switched to RESP3 (e.g. via HELLO 3). When
+// unmarshaling, a map of N key/value pairs is treated exactly like an array of
+// 2N elements, so the same receivers work for both it and the equivalent RESP2
+// reply. The exception is when unmarshaling into an interface{}, in which case
+// a map is unmarshaled into a map[string]interface{}, recursively, rather than
+// into a []interface{}.
+var MapPrefix = []byte{'%'}
+
+// AttributePrefix denotes the RESP3 attribute type. An attribute is a map of
+// out-of-band metadata which precedes the message it is attached to. Any and
+// RawMessage both discard attributes, unmarshaling only the message which
+// follows them. Use Attribute to unmarshal an attribute itself.
+var AttributePrefix = []byte{'|'}
+
+// Prefixes of the remaining RESP3 types, which redis will likewise only send
+// to connections which have been switched to RESP3. Since each type is denoted
+// by its own prefix Any decodes them regardless of which protocol version a
+// connection has negotiated, and so no per-connection configuration is needed
+// to receive them. When unmarshaling:
+//
+//   - A null is treated like a nil bulk string.
+//   - A boolean is treated like the integer 1 (true) or 0 (false), except that
+//     it's unmarshaled into a bool when unmarshaling into an interface{}.
+//   - A double is treated like a simple string containing the number, and is
+//     unmarshaled into a float64 when unmarshaling into an interface{}. The
+//     special values "inf", "-inf", and "nan" can be unmarshaled into floats.
+//   - A big number is treated like a simple string containing the number.
+//   - A verbatim string is treated like a bulk string, with its three letter
+//     format (e.g. "txt") and the colon following it removed.
+//   - A blob error is treated like an error.
+//   - A set is treated like an array.
+var (
+	NullPrefix           = []byte{'_'}
+	BooleanPrefix        = []byte{'#'}
+	DoublePrefix         = []byte{','}
+	BigNumberPrefix      = []byte{'('}
+	VerbatimStringPrefix = []byte{'='}
+	BlobErrorPrefix      = []byte{'!'}
+	SetPrefix            = []byte{'~'}
+)
+
 // String formats a prefix into a human-readable name for the type it denotes.
 func (p prefix) String() string {
 	pStr := string(p)
@@ -56,6 +101,24 @@ func (p prefix) String() string {
 		return "bulk-string"
 	case string(ArrayPrefix):
 		return "array"
+	case string(MapPrefix):
+		return "map"
+	case string(AttributePrefix):
+		return "attribute"
+	case string(NullPrefix):
+		return "null"
+	case string(BooleanPrefix):
+		return "boolean"
+	case string(DoublePrefix):
+		return "double"
+	case string(BigNumberPrefix):
+		return "big-number"
+	case string(VerbatimStringPrefix):
+		return "verbatim-string"
+	case string(BlobErrorPrefix):
+		return "blob-error"
+	case string(SetPrefix):
+		return "set"
 	default:
 		return pStr
 	}
@@ -64,6 +127,7 @@ func (p prefix) String() string {
 var (
 	nilBulkString = []byte("$-1\r\n")
 	nilArray      = []byte("*-1\r\n")
+	null          = []byte("_\r\n")
 	emptyArray    = []byte("*0\r\n")
 )
 
@@ -128,6 +192,88 @@ func assertBufferedPrefix(br *bufio.Reader, pref []byte) error {
 
 ////////////////////////////////////////////////////////////////////////////////
 
+// Header describes the type and top-level length of a RESP message, as
+// returned by PeekHeader.
+type Header struct {
+	// Prefix denotes the type of the message, and can be compared against
+	// ArrayPrefix, BulkStringPrefix, etc...
+	Prefix []byte
+
+	// Len is the number of elements in an array or set, the number of
+	// key/value pairs in a map or attribute, or the number of bytes in a bulk
+	// string, verbatim string, or blob error. It is -1 for nil arrays and bulk
+	// strings, and for nulls, and 0 for all other types.
+	Len int64
+}
+
+// IsNil returns true if the Header is that of a nil array or bulk string, or of
+// a RESP3 null.
+func (h Header) IsNil() bool {
+	return h.Len == -1
+}
+
+// PeekHeader returns the Header of the next RESP message on the given reader,
+// without consuming any of it, so that the message can be unmarshaled as normal
+// afterwards. This is useful when the type of a message isn't known ahead of
+// time, and how it should be unmarshaled depends on it:
+//
+//	func (u *myUnmarshaler) UnmarshalRESP(br *bufio.Reader) error {
+//		h, err := resp2.PeekHeader(br)
+//		if err != nil {
+//			return err
+//		} else if bytes.Equal(h.Prefix, resp2.ArrayPrefix) {
+//			return resp2.Any{I: &u.elems}.UnmarshalRESP(br)
+//		}
+//		return resp2.Any{I: &u.str}.UnmarshalRESP(br)
+//	}
+//
+// If the message is preceded by a RESP3 attribute then the Header of the
+// attribute is returned.
+func PeekHeader(br *bufio.Reader) (Header, error) {
+	// peek progressively more of the reader until the full first line of the
+	// message is available. If the line doesn't fit in the reader's buffer then
+	// bufio.ErrBufferFull will be returned.
+	n := 1
+	for {
+		b, err := br.Peek(n)
+		if err != nil {
+			return Header{}, err
+		} else if i := bytes.IndexByte(b, '\n'); i >= 0 {
+			return parseHeader(b[:i+1])
+		}
+
+		if n = br.Buffered(); n <= len(b) {
+			n = len(b) + 1
+		}
+	}
+}
+
+func parseHeader(line []byte) (Header, error) {
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return Header{}, errors.New("malformed data read")
+	}
+
+	h := Header{Prefix: []byte{line[0]}}
+	switch line[0] {
+	case ArrayPrefix[0], MapPrefix[0], AttributePrefix[0], BulkStringPrefix[0],
+		SetPrefix[0], VerbatimStringPrefix[0], BlobErrorPrefix[0]:
+		l, err := bytesutil.ParseInt(line[1 : len(line)-2])
+		if err != nil {
+			return Header{}, err
+		}
+		h.Len = l
+	case NullPrefix[0]:
+		h.Len = -1
+	case SimpleStringPrefix[0], ErrorPrefix[0], IntPrefix[0],
+		BooleanPrefix[0], DoublePrefix[0], BigNumberPrefix[0]:
+	default:
+		return Header{}, errors.Errorf("unknown type prefix %q", line[0])
+	}
+	return h, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
 // SimpleString represents the simple string type in the RESP protocol
 type SimpleString struct {
 	S string
@@ -466,16 +612,51 @@ func discardArrayAfterErr(br *bufio.Reader, left int, err error) error {
 //
 // Most things will be treated as bulk strings, except for those that have their
 // own corresponding type in the RESP protocol (e.g. ints). strings and []bytes
-// will always be encoded as bulk strings, never simple strings.
+// will always be encoded as bulk strings, never simple strings. A time.Duration
+// can't be marshaled, since the unit redis expects depends on the command, and
+// results in an error. A time.Time is marshaled using its MarshalText method.
 //
 // Arrays and slices will be treated as RESP arrays, and their values will be
 // treated as if also wrapped in an Any struct. Maps will be similarly treated,
 // but they will be flattened into arrays of their alternating keys/values
 // first.
 //
+// Pointers are marshaled as the value they point to. When MarshalNoArrayHeaders
+// is set a nil pointer is skipped entirely, as are map entries and struct
+// fields whose value is a nil pointer, and NumElems doesn't count them. Without
+// it a nil pointer is marshaled, and counted by NumElems, as the zero value of
+// the type it points to.
+//
 // When using UnmarshalRESP the value of I must be a pointer or nil. If it is
 // nil then the RESP value will be read and discarded.
 //
+// If I is a *sync.Map then the elements of a RESP array (or map) are stored into
+// it as alternating key/value pairs, using Store, so that it may be read from
+// concurrently while being populated. Both keys and values are stored as
+// strings, no other type conversion is done, and so values which are arrays
+// can't be stored. Existing entries in the sync.Map are not removed, and a nil
+// RESP value leaves the sync.Map untouched.
+//
+// If I is a *[]byte then the existing slice is truncated and the RESP value is
+// appended to it, so that a buffer can be reused across replies without
+// allocating once its capacity is large enough. A nil RESP value results in a
+// nil []byte, discarding the buffer.
+//
+// If I is a *json.RawMessage then the RESP value is copied into it as-is,
+// without being parsed or validated as JSON, and a nil RESP value will result
+// in a nil json.RawMessage.
+//
+// When a simple string, i.e. a status reply such as OK, PONG, or QUEUED, is
+// unmarshaled into a *bool then it's set to true, unless the status is empty or
+// a number which isn't greater than zero. Other types are unmarshaled into a
+// *bool as numbers, which are true if they're greater than zero.
+//
+// If I is a pointer to a pointer, e.g. a **string, then a nil RESP value sets
+// the inner pointer to nil, and any other value is unmarshaled into what the
+// inner pointer points to, allocating it first if it's nil. This allows a
+// []*string to distinguish the nil elements of an array, such as the reply to
+// MGET, from empty strings.
+//
 // If an error type is read in the UnmarshalRESP method then a resp2.Error will
 // be returned with that error, and the value of I won't be touched.
 type Any struct {
@@ -491,6 +672,24 @@ type Any struct {
 	// written, and an ArrayHeader must have been manually marshalled
 	// beforehand.
 	MarshalNoArrayHeaders bool
+
+	// If true then when a RESP array (or map) is unmarshaled into a struct,
+	// e.g. the reply to HGETALL, all of the struct's fields are first set to
+	// their zero values, so that fields which aren't present in the reply are
+	// zeroed. By default only the fields present in the reply are set and all
+	// others are left as they were, which allows for layering the results of
+	// multiple replies into the same struct. This applies to every struct
+	// being unmarshaled into, including the elements of slices and maps and
+	// nested structs.
+	UnmarshalZeroStruct bool
+
+	// If set then when a RESP array (or map) is unmarshaled into a struct the
+	// name of each of the struct's fields which was set from the reply is
+	// appended to it, in the order they were set. Field names are those of the
+	// go struct, not those given by a redis tag. Fields of nested structs
+	// which aren't embedded aren't included. When unmarshaling into a slice
+	// or map of structs the fields set in each element are appended in turn.
+	UnmarshalSetFields *[]string
 }
 
 func (a Any) cp(i interface{}) Any {
@@ -508,9 +707,11 @@ var byteSliceT = reflect.TypeOf([]byte{})
 //	Any{I: []string{"foo"}}.NumElems() == 1
 //	Any{I: []string{"foo", "bar"}}.NumElems() == 2
 //	Any{I: [][]string{{"foo"}, {"bar", "baz"}, {}}}.NumElems() == 3
+//	Any{I: []*int{nil, new(int)}}.NumElems() == 2
+//	Any{I: []*int{nil, new(int)}, MarshalNoArrayHeaders: true}.NumElems() == 1
 //
 func (a Any) NumElems() int {
-	return numElems(reflect.ValueOf(a.I))
+	return numElems(reflect.ValueOf(a.I), a.MarshalNoArrayHeaders)
 }
 
 var (
@@ -519,9 +720,27 @@ var (
 	encodingBinaryMarshalerT = reflect.TypeOf(new(encoding.BinaryMarshaler)).Elem()
 )
 
-func numElems(vv reflect.Value) int {
+// isNilPtr returns true if vv is a nil pointer, or an interface holding one.
+func isNilPtr(vv reflect.Value) bool {
+	for vv.Kind() == reflect.Interface && !vv.IsNil() {
+		vv = vv.Elem()
+	}
+	return vv.Kind() == reflect.Ptr && vv.IsNil()
+}
+
+// numElems returns the number of non-array elements which vv is marshaled as.
+// If skipNil is set then nil pointers are skipped, as they are when flattening,
+// otherwise they're counted as the zero value of the type they point to.
+func numElems(vv reflect.Value, skipNil bool) int {
 	if !vv.IsValid() {
 		return 1
+	} else if isNilPtr(vv) && skipNil {
+		return 0
+	} else if isNilPtr(vv) {
+		for vv.Kind() == reflect.Interface {
+			vv = vv.Elem()
+		}
+		return numElems(reflect.Zero(vv.Type().Elem()), skipNil)
 	}
 
 	tt := vv.Type()
@@ -536,7 +755,7 @@ func numElems(vv reflect.Value) int {
 
 	switch vv.Kind() {
 	case reflect.Ptr:
-		return numElems(reflect.Indirect(vv))
+		return numElems(reflect.Indirect(vv), skipNil)
 	case reflect.Slice, reflect.Array:
 		// TODO does []rune need extra support here?
 		if vv.Type() == byteSliceT {
@@ -546,7 +765,7 @@ func numElems(vv reflect.Value) int {
 		l := vv.Len()
 		var c int
 		for i := 0; i < l; i++ {
-			c += numElems(vv.Index(i))
+			c += numElems(vv.Index(i), skipNil)
 		}
 		return c
 
@@ -554,16 +773,18 @@ func numElems(vv reflect.Value) int {
 		kkv := vv.MapKeys()
 		var c int
 		for _, kv := range kkv {
-			c += numElems(kv)
-			c += numElems(vv.MapIndex(kv))
+			if mv := vv.MapIndex(kv); !skipNil || !isNilPtr(mv) {
+				c += numElems(kv, skipNil)
+				c += numElems(mv, skipNil)
+			}
 		}
 		return c
 
 	case reflect.Interface:
-		return numElems(vv.Elem())
+		return numElems(vv.Elem(), skipNil)
 
 	case reflect.Struct:
-		return numElemsStruct(vv, true)
+		return numElemsStruct(vv, true, skipNil)
 
 	default:
 		return 1
@@ -574,7 +795,10 @@ func numElems(vv reflect.Value) int {
 // reflect.Value and needs to know the numElems, so it wouldn't make sense to
 // recast to an interface{} to pass into NumElems, it would just get turned into
 // a reflect.Value again.
-func numElemsStruct(vv reflect.Value, flat bool) int {
+//
+// If flat is set then the elements of each field are counted, rather than each
+// field being counted as one, and skipNil is then as for numElems.
+func numElemsStruct(vv reflect.Value, flat, skipNil bool) int {
 	tt := vv.Type()
 	l := vv.NumField()
 	var c int
@@ -582,16 +806,18 @@ func numElemsStruct(vv reflect.Value, flat bool) int {
 		ft, fv := tt.Field(i), vv.Field(i)
 		if ft.Anonymous {
 			if fv = reflect.Indirect(fv); fv.IsValid() { // fv isn't nil
-				c += numElemsStruct(fv, flat)
+				c += numElemsStruct(fv, flat, skipNil)
 			}
 			continue
 		} else if ft.PkgPath != "" || ft.Tag.Get("redis") == "-" {
 			continue // continue
+		} else if flat && skipNil && isNilPtr(fv) {
+			continue
 		}
 
 		c++ // for the key
 		if flat {
-			c += numElems(fv)
+			c += numElems(fv, skipNil)
 		} else {
 			c++
 		}
@@ -646,8 +872,14 @@ func (a Any) MarshalRESP(w io.Writer) error {
 			return marshalBulk(*scratch)
 		}
 		return Int{I: at64}.MarshalRESP(w)
+	case time.Duration:
+		// the unit depends on the command, e.g. EXPIRE vs PEXPIRE, and guessing
+		// wrong could truncate the duration to zero
+		return errors.Errorf("cannot marshal time.Duration %v without a unit, use radix.Seconds or radix.Millis", at)
 	case error:
-		if a.MarshalBulkString {
+		if a.skipNil(at) {
+			return nil
+		} else if a.MarshalBulkString {
 			scratch := bytesutil.GetBytes()
 			defer bytesutil.PutBytes(scratch)
 			*scratch = append(*scratch, at.Error()...)
@@ -655,14 +887,23 @@ func (a Any) MarshalRESP(w io.Writer) error {
 		}
 		return Error{E: at}.MarshalRESP(w)
 	case resp.LenReader:
+		if a.skipNil(at) {
+			return nil
+		}
 		return BulkReader{LR: at}.MarshalRESP(w)
 	case encoding.TextMarshaler:
+		if a.skipNil(at) {
+			return nil
+		}
 		b, err := at.MarshalText()
 		if err != nil {
 			return err
 		}
 		return marshalBulk(b)
 	case encoding.BinaryMarshaler:
+		if a.skipNil(at) {
+			return nil
+		}
 		b, err := at.MarshalBinary()
 		if err != nil {
 			return err
@@ -676,7 +917,9 @@ func (a Any) MarshalRESP(w io.Writer) error {
 	// if it's a pointer we de-reference and try the pointed to value directly
 	if vv.Kind() == reflect.Ptr {
 		var ivv reflect.Value
-		if vv.IsNil() {
+		if vv.IsNil() && a.MarshalNoArrayHeaders {
+			return nil // when flattening nil pointers are skipped
+		} else if vv.IsNil() {
 			ivv = reflect.New(vv.Type().Elem())
 		} else {
 			ivv = reflect.Indirect(vv)
@@ -719,8 +962,12 @@ func (a Any) MarshalRESP(w io.Writer) error {
 		kkv := vv.MapKeys()
 		arrHeader(len(kkv) * 2)
 		for _, kv := range kkv {
+			mv := vv.MapIndex(kv)
+			if a.MarshalNoArrayHeaders && isNilPtr(mv) {
+				continue
+			}
 			arrVal(kv.Interface())
-			arrVal(vv.MapIndex(kv).Interface())
+			arrVal(mv.Interface())
 		}
 
 	case reflect.Struct:
@@ -733,10 +980,16 @@ func (a Any) MarshalRESP(w io.Writer) error {
 	return err
 }
 
+// skipNil returns true if v is a nil pointer which should be skipped, rather
+// than marshaled, because the Any is being flattened.
+func (a Any) skipNil(v interface{}) bool {
+	return a.MarshalNoArrayHeaders && isNilPtr(reflect.ValueOf(v))
+}
+
 func (a Any) marshalStruct(w io.Writer, vv reflect.Value, inline bool) error {
 	var err error
 	if !a.MarshalNoArrayHeaders && !inline {
-		numElems := numElemsStruct(vv, a.MarshalNoArrayHeaders)
+		numElems := numElemsStruct(vv, false, false)
 		if err = (ArrayHeader{N: numElems}).MarshalRESP(w); err != nil {
 			return err
 		}
@@ -756,6 +1009,8 @@ func (a Any) marshalStruct(w io.Writer, vv reflect.Value, inline bool) error {
 			continue
 		} else if ft.PkgPath != "" || tag == "-" {
 			continue // unexported
+		} else if a.MarshalNoArrayHeaders && isNilPtr(fv) {
+			continue
 		}
 
 		keyName := ft.Name
@@ -775,14 +1030,21 @@ func saneDefault(prefix byte) interface{} {
 	// we don't handle ErrorPrefix because that always returns an error and
 	// doesn't touch I
 	switch prefix {
-	case ArrayPrefix[0]:
+	case ArrayPrefix[0], SetPrefix[0]:
 		ii := make([]interface{}, 8)
 		return &ii
-	case BulkStringPrefix[0]:
+	case MapPrefix[0]:
+		m := map[string]interface{}{}
+		return &m
+	case BulkStringPrefix[0], VerbatimStringPrefix[0]:
 		bb := make([]byte, 16)
 		return &bb
-	case SimpleStringPrefix[0]:
+	case SimpleStringPrefix[0], BigNumberPrefix[0]:
 		return new(string)
+	case BooleanPrefix[0]:
+		return new(bool)
+	case DoublePrefix[0]:
+		return new(float64)
 	case IntPrefix[0]:
 		return new(int64)
 	}
@@ -809,6 +1071,10 @@ var (
 
 // UnmarshalRESP implements the Unmarshaler method
 func (a Any) UnmarshalRESP(br *bufio.Reader) error {
+	if err := discardAttributes(br); err != nil {
+		return err
+	}
+
 	// if I is itself an Unmarshaler just hit that directly
 	if u, ok := a.I.(resp.Unmarshaler); ok {
 		return u.UnmarshalRESP(br)
@@ -825,6 +1091,13 @@ func (a Any) UnmarshalRESP(br *bufio.Reader) error {
 	// into a default (created based on the type of th message), then set the
 	// *interface{} to that
 	if ai, ok := a.I.(*interface{}); ok {
+		if prefix == NullPrefix[0] {
+			if _, err := bytesutil.BufferedBytesDelim(br); err != nil {
+				return err
+			}
+			*ai = nil
+			return nil
+		}
 		innerA := Any{I: saneDefault(prefix)}
 		if err := innerA.UnmarshalRESP(br); err != nil {
 			return err
@@ -833,6 +1106,23 @@ func (a Any) UnmarshalRESP(br *bufio.Reader) error {
 		return nil
 	}
 
+	// Another special case, if a pointer to a pointer is given then a non-nil
+	// message is unmarshaled into what the inner pointer points to, and a nil
+	// message sets it to nil via unmarshalNil.
+	if vv := reflect.ValueOf(a.I); vv.Kind() == reflect.Ptr && !vv.IsNil() &&
+		vv.Elem().Kind() == reflect.Ptr && vv.Elem().CanSet() {
+		if isNil, err := peekNil(br); err != nil {
+			return err
+		} else if !isNil && prefix != ErrorPrefix[0] && prefix != BlobErrorPrefix[0] {
+			if vv.Elem().IsNil() {
+				vv.Elem().Set(reflect.New(vv.Elem().Type().Elem()))
+			}
+			innerA := a
+			innerA.I = vv.Elem().Interface()
+			return innerA.UnmarshalRESP(br)
+		}
+	}
+
 	br.Discard(1)
 	b, err = bytesutil.BufferedBytesDelim(br)
 	if err != nil {
@@ -842,7 +1132,22 @@ func (a Any) UnmarshalRESP(br *bufio.Reader) error {
 	switch prefix {
 	case ErrorPrefix[0]:
 		return Error{E: errors.New(string(b))}
-	case ArrayPrefix[0]:
+	case NullPrefix[0]:
+		return a.unmarshalNil()
+	case BlobErrorPrefix[0]:
+		l, err := bytesutil.ParseInt(b)
+		if err != nil {
+			return err
+		}
+		scratch := bytesutil.GetBytes()
+		defer bytesutil.PutBytes(scratch)
+		if *scratch, err = bytesutil.ReadNAppend(br, *scratch, int(l)); err != nil {
+			return err
+		} else if _, err := br.Discard(2); err != nil {
+			return err
+		}
+		return Error{E: errors.New(string(*scratch))}
+	case ArrayPrefix[0], SetPrefix[0]:
 		l, err := bytesutil.ParseInt(b)
 		if err != nil {
 			return err
@@ -850,7 +1155,15 @@ func (a Any) UnmarshalRESP(br *bufio.Reader) error {
 			return a.unmarshalNil()
 		}
 		return a.unmarshalArray(br, l)
-	case BulkStringPrefix[0]:
+	case MapPrefix[0]:
+		l, err := bytesutil.ParseInt(b)
+		if err != nil {
+			return err
+		} else if l == -1 {
+			return a.unmarshalNil()
+		}
+		return a.unmarshalArray(br, l*2)
+	case BulkStringPrefix[0], VerbatimStringPrefix[0]:
 		l, err := bytesutil.ParseInt(b) // fuck DRY
 		if err != nil {
 			return err
@@ -858,6 +1171,17 @@ func (a Any) UnmarshalRESP(br *bufio.Reader) error {
 			return a.unmarshalNil()
 		}
 
+		// a verbatim string's body begins with its format, e.g. "txt:", which
+		// is discarded
+		if prefix == VerbatimStringPrefix[0] {
+			if l < 4 {
+				return errors.New("malformed verbatim string")
+			} else if _, err := br.Discard(4); err != nil {
+				return err
+			}
+			l -= 4
+		}
+
 		// This is a bit of a clusterfuck. Basically:
 		// - If unmarshal returns a non-Discarded error, return that asap.
 		// - If discarding the last 2 bytes (in order to discard the full
@@ -872,7 +1196,21 @@ func (a Any) UnmarshalRESP(br *bufio.Reader) error {
 			return discardErr
 		}
 		return err
-	case SimpleStringPrefix[0], IntPrefix[0]:
+	case BooleanPrefix[0]:
+		if len(b) != 1 || (b[0] != 't' && b[0] != 'f') {
+			return errors.Errorf("malformed boolean %q", b)
+		}
+		if b[0] == 't' {
+			b = bools[1]
+		} else {
+			b = bools[0]
+		}
+		fallthrough
+	case SimpleStringPrefix[0], IntPrefix[0], DoublePrefix[0], BigNumberPrefix[0]:
+		if ab, ok := a.I.(*bool); ok && prefix == SimpleStringPrefix[0] {
+			*ab = statusBool(b)
+			return nil
+		}
 		reader := byteReaderPool.Get().(*bytes.Reader)
 		reader.Reset(b)
 		err := a.unmarshalSingle(reader, reader.Len())
@@ -883,6 +1221,26 @@ func (a Any) UnmarshalRESP(br *bufio.Reader) error {
 	}
 }
 
+// statusBool returns the bool which a simple string, i.e. a status reply like
+// OK, PONG, or QUEUED, is unmarshaled into. A number is treated as true if it's
+// greater than zero, like for other types, and any other non-empty status
+// indicates success and so is true.
+func statusBool(b []byte) bool {
+	digits := b
+	if len(digits) > 1 && (digits[0] == '-' || digits[0] == '+') {
+		digits = digits[1:]
+	}
+
+	var nonZero bool
+	for _, c := range digits {
+		if c < '0' || c > '9' {
+			return true // not a number
+		}
+		nonZero = nonZero || c != '0'
+	}
+	return nonZero && b[0] != '-'
+}
+
 func (a Any) unmarshalSingle(body io.Reader, n int) error {
 	var (
 		err error
@@ -901,6 +1259,10 @@ func (a Any) unmarshalSingle(body io.Reader, n int) error {
 		bytesutil.PutBytes(scratch)
 	case *[]byte:
 		*ai, err = bytesutil.ReadNAppend(body, (*ai)[:0], n)
+	case *json.RawMessage:
+		// the message body is assumed to already be JSON, and is passed
+		// through as-is rather than being checked
+		*ai, err = bytesutil.ReadNAppend(body, (*ai)[:0], n)
 	case *bool:
 		ui, err = bytesutil.ReadUint(body, n)
 		*ai = ui > 0
@@ -970,7 +1332,29 @@ func (a Any) unmarshalSingle(body io.Reader, n int) error {
 	return err
 }
 
+// peekNil returns whether the next message in br is a null, or a nil bulk
+// string, array, etc, without reading it. Only a length-prefixed message can
+// have a negative length, and a negative length always denotes nil.
+func peekNil(br *bufio.Reader) (bool, error) {
+	b, err := br.Peek(2)
+	if err != nil {
+		return false, err
+	}
+	switch b[0] {
+	case NullPrefix[0]:
+		return true, nil
+	case BulkStringPrefix[0], ArrayPrefix[0], MapPrefix[0], SetPrefix[0]:
+		return b[1] == '-', nil
+	}
+	return false, nil
+}
+
 func (a Any) unmarshalNil() error {
+	if _, ok := a.I.(*sync.Map); ok {
+		// a sync.Map may be in use concurrently, so it's never reset
+		return nil
+	}
+
 	vv := reflect.ValueOf(a.I)
 	if vv.Kind() != reflect.Ptr || !vv.Elem().CanSet() {
 		// If the type in I can't be set then just ignore it. This is kind of
@@ -986,6 +1370,8 @@ func (a Any) unmarshalNil() error {
 func (a Any) unmarshalArray(br *bufio.Reader, l int64) error {
 	if a.I == nil {
 		return discardArray(br, int(l))
+	} else if sm, ok := a.I.(*sync.Map); ok {
+		return unmarshalSyncMap(br, sm, int(l))
 	}
 
 	size := int(l)
@@ -1012,8 +1398,7 @@ func (a Any) unmarshalArray(br *bufio.Reader, l int64) error {
 		}
 
 		for i := 0; i < size; i++ {
-			ai := Any{I: v.Index(i).Addr().Interface()}
-			if err := ai.UnmarshalRESP(br); err != nil {
+			if err := a.cp(v.Index(i).Addr().Interface()).UnmarshalRESP(br); err != nil {
 				return discardArrayAfterErr(br, int(l)-i-1, err)
 			}
 		}
@@ -1042,7 +1427,7 @@ func (a Any) unmarshalArray(br *bufio.Reader, l int64) error {
 			if !kv.IsValid() {
 				kv = reflect.New(v.Type().Key())
 			}
-			if err := (Any{I: kv.Interface()}).UnmarshalRESP(br); err != nil {
+			if err := a.cp(kv.Interface()).UnmarshalRESP(br); err != nil {
 				return discardArrayAfterErr(br, int(l)-i-1, err)
 			}
 
@@ -1050,7 +1435,7 @@ func (a Any) unmarshalArray(br *bufio.Reader, l int64) error {
 			if !vv.IsValid() {
 				vv = reflect.New(v.Type().Elem())
 			}
-			if err := (Any{I: vv.Interface()}).UnmarshalRESP(br); err != nil {
+			if err := a.cp(vv.Interface()).UnmarshalRESP(br); err != nil {
 				return discardArrayAfterErr(br, int(l)-i-2, err)
 			}
 
@@ -1064,6 +1449,10 @@ func (a Any) unmarshalArray(br *bufio.Reader, l int64) error {
 			return discardArrayAfterErr(br, int(l), err)
 		}
 
+		if a.UnmarshalZeroStruct {
+			v.Set(reflect.Zero(v.Type()))
+		}
+
 		structFields := getStructFields(v.Type())
 		var field BulkStringBytes
 
@@ -1086,8 +1475,14 @@ func (a Any) unmarshalArray(br *bufio.Reader, l int64) error {
 				continue
 			}
 
-			if err := (Any{I: vv.Interface()}).UnmarshalRESP(br); err != nil {
+			// the fields of a nested struct aren't included in
+			// UnmarshalSetFields, but UnmarshalZeroStruct still applies to it
+			fieldA := a.cp(vv.Interface())
+			fieldA.UnmarshalSetFields = nil
+			if err := fieldA.UnmarshalRESP(br); err != nil {
 				return discardArrayAfterErr(br, int(l)-i-2, err)
+			} else if a.UnmarshalSetFields != nil {
+				*a.UnmarshalSetFields = append(*a.UnmarshalSetFields, structField.goName)
 			}
 		}
 
@@ -1099,6 +1494,24 @@ func (a Any) unmarshalArray(br *bufio.Reader, l int64) error {
 	}
 }
 
+func unmarshalSyncMap(br *bufio.Reader, sm *sync.Map, l int) error {
+	if l%2 != 0 {
+		err := resp.ErrDiscarded{Err: errors.New("cannot decode redis array with odd number of elements into sync.Map")}
+		return discardArrayAfterErr(br, l, err)
+	}
+
+	for i := 0; i < l; i += 2 {
+		var k, v string
+		if err := (Any{I: &k}).UnmarshalRESP(br); err != nil {
+			return discardArrayAfterErr(br, l-i-1, err)
+		} else if err := (Any{I: &v}).UnmarshalRESP(br); err != nil {
+			return discardArrayAfterErr(br, l-i-2, err)
+		}
+		sm.Store(k, v)
+	}
+	return nil
+}
+
 func canShareReflectValue(ty reflect.Type) bool {
 	switch ty.Kind() {
 	case reflect.Bool,
@@ -1126,6 +1539,7 @@ func canShareReflectValue(ty reflect.Type) bool {
 
 type structField struct {
 	name    string
+	goName  string
 	fromTag bool // from a tag overwrites a field name
 	indices []int
 }
@@ -1152,17 +1566,21 @@ func getStructFields(t reflect.Type) map[string]structField {
 		if t.Kind() == reflect.Ptr {
 			t = t.Elem()
 		}
+		if t.Kind() != reflect.Struct {
+			return // an embedded non-struct type has no fields of its own
+		}
 		l := t.NumField()
 
 		// first get all fields which aren't embedded structs
 		for i := 0; i < l; i++ {
 			ft := t.Field(i)
-			if ft.Anonymous || ft.PkgPath != "" {
+			tag := ft.Tag.Get("redis")
+			if ft.Anonymous || ft.PkgPath != "" || tag == "-" {
 				continue
 			}
 
 			key, fromTag := ft.Name, false
-			if tag := ft.Tag.Get("redis"); tag != "" && tag != "-" {
+			if tag != "" {
 				key, fromTag = tag, true
 			}
 			if m[key].fromTag {
@@ -1170,6 +1588,7 @@ func getStructFields(t reflect.Type) map[string]structField {
 			}
 			m[key] = structField{
 				name:    key,
+				goName:  ft.Name,
 				fromTag: fromTag,
 				indices: getIndices(parents, i),
 			}
@@ -1192,6 +1611,58 @@ func getStructFields(t reflect.Type) map[string]structField {
 
 // v must be setable. Always returns a Kind() == reflect.Ptr, unless it returns
 // the zero Value, which means a setable value couldn't be gotten.
+// StructField describes a field of a struct which a RESP array (or map), e.g.
+// the reply to HGETALL, can be unmarshaled into using Any.
+type StructField struct {
+	// Name is the key in the RESP array which the field is unmarshaled from,
+	// i.e. its redis tag, or its go name if it has none.
+	Name string
+
+	// GoName is the name of the go struct field.
+	GoName string
+
+	// Index is the index sequence of the field within the struct, as used by
+	// reflect's FieldByIndex. It includes the indices of any embedded structs
+	// which the field belongs to.
+	Index []int
+}
+
+// StructFields returns the fields of the given struct type which Any
+// unmarshals a RESP array (or map) into, i.e. those which are exported and
+// don't have a redis tag of "-", including the fields of embedded structs. The
+// fields are returned in the order in which they're declared.
+//
+// The returned slice must not be modified.
+func StructFields(t reflect.Type) []StructField {
+	if fV, ok := structFieldsListCache.Load(t); ok {
+		return fV.([]StructField)
+	}
+
+	m := getStructFields(t)
+	fields := make([]StructField, 0, len(m))
+	for _, sf := range m {
+		fields = append(fields, StructField{
+			Name:   sf.name,
+			GoName: sf.goName,
+			Index:  sf.indices,
+		})
+	}
+	sort.Slice(fields, func(i, j int) bool {
+		ii, ij := fields[i].Index, fields[j].Index
+		for k := 0; k < len(ii) && k < len(ij); k++ {
+			if ii[k] != ij[k] {
+				return ii[k] < ij[k]
+			}
+		}
+		return len(ii) < len(ij)
+	})
+
+	structFieldsListCache.LoadOrStore(t, fields)
+	return fields
+}
+
+var structFieldsListCache sync.Map // aka map[reflect.Type][]StructField
+
 func getStructField(v reflect.Value, ii []int) reflect.Value {
 	if len(ii) == 0 {
 		return v.Addr()
@@ -1214,6 +1685,51 @@ func getStructField(v reflect.Value, ii []int) reflect.Value {
 
 ////////////////////////////////////////////////////////////////////////////////
 
+// Attribute is an Unmarshaler which will unmarshal a RESP3 attribute into I,
+// which may be anything that a map (or an array of 2N elements) could be
+// unmarshaled into using Any. If I is nil then the attribute is discarded.
+//
+// Attribute only unmarshals the attribute itself, not the message which follows
+// it.
+type Attribute struct {
+	I interface{}
+}
+
+// UnmarshalRESP implements the Unmarshaler method.
+func (a Attribute) UnmarshalRESP(br *bufio.Reader) error {
+	if err := assertBufferedPrefix(br, AttributePrefix); err != nil {
+		return err
+	}
+
+	b, err := bytesutil.BufferedBytesDelim(br)
+	if err != nil {
+		return err
+	}
+
+	l, err := bytesutil.ParseInt(b)
+	if err != nil {
+		return err
+	}
+	return (Any{I: a.I}).unmarshalArray(br, l*2)
+}
+
+// discardAttributes discards any attributes which are next on the given reader,
+// stopping at the first message which isn't an attribute.
+func discardAttributes(br *bufio.Reader) error {
+	for {
+		b, err := br.Peek(1)
+		if err != nil {
+			return err
+		} else if b[0] != AttributePrefix[0] {
+			return nil
+		} else if err := (Attribute{}).UnmarshalRESP(br); err != nil {
+			return err
+		}
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
 // RawMessage is a Marshaler/Unmarshaler which will capture the exact raw bytes
 // of a RESP message. When Marshaling the exact bytes of the RawMessage will be
 // written as-is. When Unmarshaling the bytes of a single RESP message will be
@@ -1245,12 +1761,14 @@ func (rm *RawMessage) unmarshal(br *bufio.Reader) error {
 	body := b[1 : len(b)-2]
 
 	switch b[0] {
-	case ArrayPrefix[0]:
+	case ArrayPrefix[0], MapPrefix[0], SetPrefix[0]:
 		l, err := bytesutil.ParseInt(body)
 		if err != nil {
 			return err
 		} else if l == -1 {
 			return nil
+		} else if b[0] == MapPrefix[0] {
+			l *= 2
 		}
 		for i := 0; i < int(l); i++ {
 			if err := rm.unmarshal(br); err != nil {
@@ -1258,7 +1776,7 @@ func (rm *RawMessage) unmarshal(br *bufio.Reader) error {
 			}
 		}
 		return nil
-	case BulkStringPrefix[0]:
+	case BulkStringPrefix[0], VerbatimStringPrefix[0], BlobErrorPrefix[0]:
 		l, err := bytesutil.ParseInt(body) // fuck DRY
 		if err != nil {
 			return err
@@ -1267,7 +1785,23 @@ func (rm *RawMessage) unmarshal(br *bufio.Reader) error {
 		}
 		*rm, err = bytesutil.ReadNAppend(br, *rm, int(l+2))
 		return err
-	case ErrorPrefix[0], SimpleStringPrefix[0], IntPrefix[0]:
+	case AttributePrefix[0]:
+		l, err := bytesutil.ParseInt(body)
+		if err != nil {
+			return err
+		}
+
+		// the attribute is discarded, only the message following it is kept
+		start := len(*rm) - len(b)
+		for i := 0; i < int(l*2); i++ {
+			if err := rm.unmarshal(br); err != nil {
+				return err
+			}
+		}
+		*rm = (*rm)[:start]
+		return rm.unmarshal(br)
+	case ErrorPrefix[0], SimpleStringPrefix[0], IntPrefix[0],
+		NullPrefix[0], BooleanPrefix[0], DoublePrefix[0], BigNumberPrefix[0]:
 		return nil
 	default:
 		return errors.Errorf("unknown type prefix %q", b[0])
@@ -1289,9 +1823,21 @@ func (rm RawMessage) UnmarshalInto(u resp.Unmarshaler) error {
 	return err
 }
 
-// IsNil returns true if the contents of RawMessage are one of the nil values.
+// IsNil returns true if the contents of RawMessage are one of the nil values,
+// including the RESP3 null.
 func (rm RawMessage) IsNil() bool {
-	return bytes.Equal(rm, nilBulkString) || bytes.Equal(rm, nilArray)
+	return rm.IsNilBulkString() || rm.IsNilArray()
+}
+
+// IsNilBulkString returns true if the contents of RawMessage are a nil bulk
+// string, or the RESP3 null, which is treated like one.
+func (rm RawMessage) IsNilBulkString() bool {
+	return bytes.Equal(rm, nilBulkString) || bytes.Equal(rm, null)
+}
+
+// IsNilArray returns true if the contents of RawMessage are a nil array.
+func (rm RawMessage) IsNilArray() bool {
+	return bytes.Equal(rm, nilArray)
 }
 
 // IsEmptyArray returns true if the contents of RawMessage is empty array value.
@@ -3,9 +3,14 @@ package resp2
 import (
 	"bufio"
 	"bytes"
+	"encoding/json"
+	"io"
+	"math"
 	"reflect"
 	"strings"
+	"sync"
 	. "testing"
+	"time"
 
 	errors "golang.org/x/xerrors"
 
@@ -325,6 +330,19 @@ func TestAnyMarshal(t *T) {
 			in:  testStructC{},
 			out: "*2\r\n" + "$3\r\nBiz\r\n" + "$0\r\n\r\n",
 		},
+
+		// Pointers
+		{in: intPtr(5), out: ":5\r\n"},
+		{in: intPtr(5), flat: true, out: ":5\r\n"},
+		{in: (*int)(nil), out: ":0\r\n"},
+		{in: (*int)(nil), flat: true, out: ""},
+		{in: (*textCPMarshaler)(nil), flat: true, out: ""},
+		{in: []*int{nil, intPtr(1)}, flat: true, out: ":1\r\n"},
+		{in: []interface{}{(*int)(nil), 1}, flat: true, out: ":1\r\n"},
+		{in: map[string]*int{"one": nil}, flat: true, out: ""},
+		{in: map[string]interface{}{"one": (*int)(nil)}, flat: true, out: ""},
+		{in: testStructC{}, flat: true, out: ""},
+		{in: (*testStructC)(nil), flat: true, out: ""},
 	}
 
 	marshal := func(et encodeTest, buf *bytes.Buffer) {
@@ -353,6 +371,12 @@ func TestAnyMarshal(t *T) {
 			assert.Equal(t, et.out, string(out))
 		}
 	}
+
+	// a time.Duration has no unit, and so isn't marshaled at all
+	for _, in := range []interface{}{90 * time.Second, []interface{}{time.Millisecond}} {
+		buf := new(bytes.Buffer)
+		assert.Error(t, Any{I: in}.MarshalRESP(buf))
+	}
 }
 
 type textCPUnmarshaler []byte
@@ -439,10 +463,15 @@ func TestAnyUnmarshal(t *T) {
 			{in: "$4\r\n10.5\r\n", out: float32(10.5)},
 			{in: "$4\r\n10.5\r\n", out: float64(10.5)},
 			{in: "$4\r\nohey\r\n", preloadEmpty: true, out: []byte("ohey")},
+			{in: "$4\r\n\xff\x00\r\n\r\n", out: []byte{0xff, 0x00, '\r', '\n'}},
 			{in: "$4\r\nohey\r\n", out: nil},
+			{in: "$7\r\n{\"a\":1}\r\n", out: json.RawMessage(`{"a":1}`)},
+			{in: "$7\r\n{\"a\":1}\r\n", preload: json.RawMessage(`[]`), out: json.RawMessage(`{"a":1}`)},
+			{in: "$-1\r\n", preload: json.RawMessage(`[]`), out: json.RawMessage(nil)},
 
 			// Simple string
 			{in: "+\r\n", out: ""},
+			{in: "+null\r\n", out: json.RawMessage(`null`)},
 			{in: "+\r\n", out: []byte(nil)},
 			{in: "+ohey\r\n", out: "ohey"},
 			{in: "+ohey\r\n", out: []byte("ohey")},
@@ -455,6 +484,22 @@ func TestAnyUnmarshal(t *T) {
 			{in: "+10.5\r\n", out: float64(10.5)},
 			{in: "+ohey\r\n", preloadEmpty: true, out: "ohey"},
 			{in: "+ohey\r\n", out: nil},
+			{in: "+OK\r\n", out: true},
+			{in: "+PONG\r\n", out: true},
+			{in: "+QUEUED\r\n", out: true},
+			{in: "+OK\r\n", out: "OK"},
+			{in: "+PONG\r\n", out: "PONG"},
+			{in: "+QUEUED\r\n", out: "QUEUED"},
+			{in: "+Background saving started\r\n", out: true},
+			{in: "+1\r\n", out: true},
+			{in: "+0\r\n", out: false},
+			{in: "+\r\n", out: false},
+			{in: "+-1\r\n", out: false},
+			{in: "+-0\r\n", out: false},
+			{in: "++2\r\n", out: true},
+			{in: "++0\r\n", out: false},
+			{in: "+-\r\n", out: true},
+			{in: "+-x\r\n", out: true},
 
 			// Err
 			{in: "-ohey\r\n", out: "", shouldErr: "ohey"},
@@ -523,6 +568,92 @@ func TestAnyUnmarshal(t *T) {
 				},
 			},
 
+			// Maps (RESP3)
+			{in: "%-1\r\n", out: map[string]string(nil)},
+			{in: "%0\r\n", preload: map[string]string(nil), out: map[string]string{}},
+			{in: "%1\r\n+foo\r\n:1\r\n", out: map[string]int{"foo": 1}},
+			{in: "%1\r\n+foo\r\n:1\r\n", out: []interface{}{"foo", int64(1)}},
+			{in: "%1\r\n+foo\r\n:1\r\n", preloadEmpty: true, out: map[string]interface{}{"foo": int64(1)}},
+			{in: "%1\r\n+foo\r\n:1\r\n", out: nil},
+			{
+				in:  "%1\r\n+foo\r\n%1\r\n+bar\r\n:1\r\n",
+				out: map[string]map[string]int{"foo": {"bar": 1}},
+			},
+			{
+				in: "%2\r\n" +
+					"+foo\r\n" + "%2\r\n+a\r\n+1\r\n+b\r\n$1\r\n2\r\n" +
+					"+bar\r\n" + "%0\r\n",
+				out: map[string]map[string]string{"foo": {"a": "1", "b": "2"}, "bar": {}},
+			},
+			{
+				// the equivalent RESP2 representation, using flat arrays
+				in: "*4\r\n" +
+					"+foo\r\n" + "*4\r\n+a\r\n+1\r\n+b\r\n$1\r\n2\r\n" +
+					"+bar\r\n" + "*0\r\n",
+				out: map[string]map[string]string{"foo": {"a": "1", "b": "2"}, "bar": {}},
+			},
+			{
+				in:  "%1\r\n+a\r\n%1\r\n+b\r\n%1\r\n+c\r\n:1\r\n",
+				out: map[string]map[string]map[string]int{"a": {"b": {"c": 1}}},
+			},
+			{
+				in: "%2\r\n" +
+					"+foo\r\n" + "%2\r\n+a\r\n:1\r\n+b\r\n*1\r\n+c\r\n" +
+					"+bar\r\n" + "+baz\r\n",
+				out: map[string]interface{}{
+					"foo": map[string]interface{}{"a": int64(1), "b": []interface{}{"c"}},
+					"bar": "baz",
+				},
+			},
+
+			// Other types (RESP3)
+			{in: "_\r\n", out: []byte(nil)},
+			{in: "_\r\n", preload: "foo", out: ""},
+			{in: "_\r\n", preload: []string{"foo"}, out: []string(nil)},
+			{in: "_\r\n", out: nil},
+			{in: "#t\r\n", out: true},
+			{in: "#f\r\n", out: false},
+			{in: "#t\r\n", out: int64(1)},
+			{in: "#f\r\n", out: "0"},
+			{in: "#t\r\n", preloadEmpty: true, out: true},
+			{in: "#x\r\n", out: false, shouldErr: `malformed boolean "x"`},
+			{in: ",1.5\r\n", out: float64(1.5)},
+			{in: ",-1.5\r\n", out: float32(-1.5)},
+			{in: ",10\r\n", out: int64(10)},
+			{in: ",1.5\r\n", out: "1.5"},
+			{in: ",inf\r\n", out: math.Inf(1)},
+			{in: ",-inf\r\n", out: math.Inf(-1)},
+			{in: ",1.5\r\n", preloadEmpty: true, out: float64(1.5)},
+			{in: "(3492890328409238509324850943850943825024385\r\n", out: "3492890328409238509324850943850943825024385"},
+			{in: "(-12\r\n", out: int64(-12)},
+			{in: "(12\r\n", preloadEmpty: true, out: "12"},
+			{in: "=8\r\ntxt:ohey\r\n", out: "ohey"},
+			{in: "=8\r\ntxt:ohey\r\n", out: []byte("ohey")},
+			{in: "=4\r\nmkd:\r\n", out: ""},
+			{in: "=8\r\ntxt:ohey\r\n", preloadEmpty: true, out: []byte("ohey")},
+			{in: "!7\r\nERR foo\r\n", out: "", shouldErr: "ERR foo"},
+			{in: "!8\r\nERR\r\nfoo\r\n", out: nil, shouldErr: "ERR\r\nfoo"},
+			{in: "~2\r\n+foo\r\n:1\r\n", out: []string{"foo", "1"}},
+			{in: "~2\r\n+foo\r\n#t\r\n", preloadEmpty: true, out: []interface{}{"foo", true}},
+			{
+				in:  "%2\r\n+a\r\n,1.5\r\n+b\r\n_\r\n",
+				out: map[string]interface{}{"a": float64(1.5), "b": nil},
+			},
+
+			// Attributes (RESP3)
+			{in: "|1\r\n+ttl\r\n:3600\r\n+foo\r\n", out: "foo"},
+			{in: "|1\r\n+ttl\r\n:3600\r\n|1\r\n+a\r\n*1\r\n:1\r\n:5\r\n", out: int64(5)},
+			{in: "|1\r\n+ttl\r\n:3600\r\n$-1\r\n", out: []byte(nil)},
+			{in: "|1\r\n+ttl\r\n:3600\r\n:5\r\n", out: nil},
+			{
+				in:  "*2\r\n+foo\r\n|1\r\n+ttl\r\n:3600\r\n+bar\r\n",
+				out: []string{"foo", "bar"},
+			},
+			{
+				in:  "%1\r\n+foo\r\n|1\r\n+ttl\r\n:3600\r\n:1\r\n",
+				out: map[string]int{"foo": 1},
+			},
+
 			// Arrays (structs)
 			{
 				in: "*10\r\n" +
@@ -634,6 +765,14 @@ func TestRawMessage(t *T) {
 		{b: "*2\r\n:1\r\n:2\r\n"},
 		{b: "*-1\r\n", isNil: true},
 		{b: "*0\r\n", isEmpty: true},
+		{b: "%1\r\n+foo\r\n*1\r\n:1\r\n"},
+		{b: "_\r\n", isNil: true},
+		{b: "#t\r\n"},
+		{b: ",1.5\r\n"},
+		{b: "(12345\r\n"},
+		{b: "=8\r\ntxt:ohey\r\n"},
+		{b: "!7\r\nERR foo\r\n"},
+		{b: "~2\r\n+foo\r\n_\r\n"},
 	}
 
 	// one at a time
@@ -644,6 +783,8 @@ func TestRawMessage(t *T) {
 			require.Nil(t, rm.MarshalRESP(buf))
 			assert.Equal(t, rmt.b, buf.String())
 			assert.Equal(t, rmt.isNil, rm.IsNil())
+			assert.Equal(t, rmt.isNil && rmt.b[0] != '*', rm.IsNilBulkString())
+			assert.Equal(t, rmt.isNil && rmt.b[0] == '*', rm.IsNilArray())
 			assert.Equal(t, rmt.isEmpty, rm.IsEmptyArray())
 		}
 		{
@@ -669,6 +810,43 @@ func TestRawMessage(t *T) {
 	}
 }
 
+func TestRawMessageAttribute(t *T) {
+	br := bufio.NewReader(bytes.NewBufferString(
+		"|1\r\n+ttl\r\n:3600\r\n*2\r\n+foo\r\n|1\r\n+a\r\n:1\r\n+bar\r\n",
+	))
+	var rm RawMessage
+	require.Nil(t, rm.UnmarshalRESP(br))
+	assert.Equal(t, "*2\r\n+foo\r\n+bar\r\n", string(rm))
+	assert.Zero(t, br.Buffered())
+}
+
+func TestAttribute(t *T) {
+	in := "|2\r\n+ttl\r\n:3600\r\n+keys\r\n*1\r\n+a\r\n+foo\r\n"
+
+	br := bufio.NewReader(bytes.NewBufferString(in))
+	var attr map[string]interface{}
+	require.Nil(t, Attribute{I: &attr}.UnmarshalRESP(br))
+	assert.Equal(t, map[string]interface{}{
+		"ttl":  int64(3600),
+		"keys": []interface{}{"a"},
+	}, attr)
+
+	var str string
+	require.Nil(t, Any{I: &str}.UnmarshalRESP(br))
+	assert.Equal(t, "foo", str)
+
+	// discarding
+	br = bufio.NewReader(bytes.NewBufferString(in))
+	require.Nil(t, Attribute{}.UnmarshalRESP(br))
+	str, err := br.ReadString('\n')
+	require.Nil(t, err)
+	assert.Equal(t, "+foo\r\n", str)
+
+	// not an attribute
+	br = bufio.NewReader(bytes.NewBufferString("+foo\r\n"))
+	assert.Error(t, Attribute{I: &attr}.UnmarshalRESP(br))
+}
+
 func TestAnyConsumedOnErr(t *T) {
 	type foo struct {
 		Foo int
@@ -732,3 +910,272 @@ func TestErrorAs(t *T) {
 		assert.Equal(t, *err, errDiscarded.Err)
 	}
 }
+
+func TestPeekHeader(t *T) {
+	tests := []struct {
+		in  string
+		exp Header
+	}{
+		{in: "+foo\r\n", exp: Header{Prefix: SimpleStringPrefix}},
+		{in: "-ERR foo\r\n", exp: Header{Prefix: ErrorPrefix}},
+		{in: ":5\r\n", exp: Header{Prefix: IntPrefix}},
+		{in: "$3\r\nfoo\r\n", exp: Header{Prefix: BulkStringPrefix, Len: 3}},
+		{in: "$-1\r\n", exp: Header{Prefix: BulkStringPrefix, Len: -1}},
+		{in: "*2\r\n:1\r\n:2\r\n", exp: Header{Prefix: ArrayPrefix, Len: 2}},
+		{in: "*-1\r\n", exp: Header{Prefix: ArrayPrefix, Len: -1}},
+		{in: "%1\r\n+foo\r\n:1\r\n", exp: Header{Prefix: MapPrefix, Len: 1}},
+		{in: "|1\r\n+foo\r\n:1\r\n+bar\r\n", exp: Header{Prefix: AttributePrefix, Len: 1}},
+		{in: "_\r\n", exp: Header{Prefix: NullPrefix, Len: -1}},
+		{in: "#t\r\n", exp: Header{Prefix: BooleanPrefix}},
+		{in: ",1.5\r\n", exp: Header{Prefix: DoublePrefix}},
+		{in: "(12\r\n", exp: Header{Prefix: BigNumberPrefix}},
+		{in: "=8\r\ntxt:ohey\r\n", exp: Header{Prefix: VerbatimStringPrefix, Len: 8}},
+		{in: "!3\r\nERR\r\n", exp: Header{Prefix: BlobErrorPrefix, Len: 3}},
+		{in: "~1\r\n+foo\r\n", exp: Header{Prefix: SetPrefix, Len: 1}},
+	}
+
+	for _, test := range tests {
+		br := bufio.NewReader(bytes.NewBufferString(test.in))
+		h, err := PeekHeader(br)
+		require.Nil(t, err, "in:%q", test.in)
+		assert.Equal(t, test.exp, h, "in:%q", test.in)
+		assert.Equal(t, test.exp.Len == -1, h.IsNil(), "in:%q", test.in)
+
+		// nothing should have been consumed
+		var rm RawMessage
+		require.Nil(t, rm.UnmarshalRESP(br), "in:%q", test.in)
+		assert.Zero(t, br.Buffered(), "in:%q", test.in)
+	}
+
+	// the header arriving in multiple reads
+	pr, pw := io.Pipe()
+	go func() {
+		pw.Write([]byte("*1"))
+		pw.Write([]byte("0"))
+		pw.Write([]byte("\r\n"))
+		pw.Close()
+	}()
+	h, err := PeekHeader(bufio.NewReader(pr))
+	require.Nil(t, err)
+	assert.Equal(t, Header{Prefix: ArrayPrefix, Len: 10}, h)
+
+	// malformed
+	_, err = PeekHeader(bufio.NewReader(bytes.NewBufferString("?5\r\n")))
+	assert.Error(t, err)
+	_, err = PeekHeader(bufio.NewReader(bytes.NewBufferString("*5")))
+	assert.Error(t, err)
+}
+
+func TestAnyUnmarshalSyncMap(t *T) {
+	syncMapContents := func(sm *sync.Map) map[interface{}]interface{} {
+		m := map[interface{}]interface{}{}
+		sm.Range(func(k, v interface{}) bool {
+			m[k] = v
+			return true
+		})
+		return m
+	}
+
+	var sm sync.Map
+	sm.Store("existing", "1")
+	br := bufio.NewReader(bytes.NewBufferString(
+		"*4\r\n+foo\r\n:1\r\n$3\r\nbar\r\n$3\r\nbaz\r\n" +
+			"%1\r\n+biz\r\n+buz\r\n" +
+			"*-1\r\n" +
+			"*3\r\n+a\r\n+b\r\n+c\r\n" +
+			"*4\r\n+a\r\n*1\r\n+b\r\n+c\r\n+d\r\n" +
+			"+END\r\n",
+	))
+
+	require.Nil(t, Any{I: &sm}.UnmarshalRESP(br))
+	assert.Equal(t, map[interface{}]interface{}{
+		"existing": "1",
+		"foo":      "1",
+		"bar":      "baz",
+	}, syncMapContents(&sm))
+
+	require.Nil(t, Any{I: &sm}.UnmarshalRESP(br))
+	assert.Equal(t, "buz", syncMapContents(&sm)["biz"])
+
+	// nil leaves the map as it was
+	require.Nil(t, Any{I: &sm}.UnmarshalRESP(br))
+	assert.Len(t, syncMapContents(&sm), 4)
+
+	// odd number of elements
+	err := Any{I: &sm}.UnmarshalRESP(br)
+	assert.True(t, errors.As(err, new(resp.ErrDiscarded)))
+
+	// array values can't be stored, but the rest of the message is discarded
+	err = Any{I: &sm}.UnmarshalRESP(br)
+	assert.True(t, errors.As(err, new(resp.ErrDiscarded)))
+
+	var end string
+	require.Nil(t, Any{I: &end}.UnmarshalRESP(br))
+	assert.Equal(t, "END", end)
+}
+
+func TestAnyUnmarshalStructPartial(t *T) {
+	br := bufio.NewReader(bytes.NewBufferString(
+		"*4\r\n$3\r\nFoo\r\n:1\r\n$3\r\nBAZ\r\n+baz\r\n" +
+			"*2\r\n$3\r\nBiz\r\n+biz\r\n" +
+			"%1\r\n$3\r\nBiz\r\n+biz2\r\n",
+	))
+
+	// by default only the fields in the reply are set, so replies can be
+	// layered into the same struct
+	var s testStructA
+	require.Nil(t, Any{I: &s}.UnmarshalRESP(br))
+	require.Nil(t, Any{I: &s}.UnmarshalRESP(br))
+	assert.Equal(t, testStructA{
+		testStructInner: testStructInner{Foo: 1, Baz: "baz"},
+		Biz:             []byte("biz"),
+	}, s)
+
+	// with UnmarshalZeroStruct the fields absent from the reply are zeroed
+	require.Nil(t, Any{I: &s, UnmarshalZeroStruct: true}.UnmarshalRESP(br))
+	assert.Equal(t, testStructA{Biz: []byte("biz2")}, s)
+}
+
+func TestStructFields(t *T) {
+	var names []string
+	for _, sf := range StructFields(reflect.TypeOf(testStructA{})) {
+		names = append(names, sf.Name+"/"+sf.GoName)
+	}
+	assert.Equal(t, []string{"Foo/Foo", "BAZ/Baz", "Boz/Boz", "Biz/Biz"}, names)
+
+	// a field with a redis tag of "-" isn't unmarshaled into
+	br := bufio.NewReader(bytes.NewBufferString("*4\r\n$3\r\nBuz\r\n+buz\r\n$3\r\nFoo\r\n:1\r\n"))
+	var s testStructA
+	require.Nil(t, Any{I: &s}.UnmarshalRESP(br))
+	assert.Equal(t, testStructA{testStructInner: testStructInner{Foo: 1}}, s)
+}
+
+func TestAnyUnmarshalStructSlice(t *T) {
+	br := bufio.NewReader(bytes.NewBufferString(
+		"*2\r\n*2\r\n$3\r\nFoo\r\n:1\r\n*2\r\n$3\r\nBiz\r\n+biz\r\n",
+	))
+
+	// the options apply to each element, not just to a struct itself
+	ss := []testStructA{
+		{testStructInner: testStructInner{Baz: "baz"}},
+		{testStructInner: testStructInner{Foo: 2}},
+	}
+	var set []string
+	a := Any{I: &ss, UnmarshalZeroStruct: true, UnmarshalSetFields: &set}
+	require.Nil(t, a.UnmarshalRESP(br))
+	assert.Equal(t, []testStructA{
+		{testStructInner: testStructInner{Foo: 1}},
+		{Biz: []byte("biz")},
+	}, ss)
+	assert.Equal(t, []string{"Foo", "Biz"}, set)
+}
+
+func TestAnyUnmarshalSetFields(t *T) {
+	br := bufio.NewReader(bytes.NewBufferString(
+		"*6\r\n$3\r\nFoo\r\n:1\r\n$3\r\nBAZ\r\n+baz\r\n$3\r\nbar\r\n+bar\r\n" +
+			"*2\r\n$3\r\nBiz\r\n+biz\r\n",
+	))
+
+	// fields which aren't in the struct aren't included, and tagged fields use
+	// their go name
+	var s testStructA
+	var set []string
+	require.Nil(t, Any{I: &s, UnmarshalSetFields: &set}.UnmarshalRESP(br))
+	assert.Equal(t, []string{"Foo", "Baz"}, set)
+
+	// further fields are appended
+	require.Nil(t, Any{I: &s, UnmarshalSetFields: &set}.UnmarshalRESP(br))
+	assert.Equal(t, []string{"Foo", "Baz", "Biz"}, set)
+}
+
+func TestAnyUnmarshalPointer(t *T) {
+	br := bufio.NewReader(bytes.NewBufferString(
+		"*4\r\n$3\r\nfoo\r\n$-1\r\n$0\r\n\r\n_\r\n" +
+			"$3\r\nbar\r\n" +
+			"$-1\r\n" +
+			"-ERR bad\r\n",
+	))
+
+	var ss []*string
+	require.Nil(t, Any{I: &ss}.UnmarshalRESP(br))
+	require.Len(t, ss, 4)
+	assert.Equal(t, "foo", *ss[0])
+	assert.Nil(t, ss[1])
+	assert.Equal(t, "", *ss[2])
+	assert.Nil(t, ss[3])
+
+	// an existing pointer is unmarshaled into, rather than replaced
+	s := "baz"
+	sp := &s
+	require.Nil(t, Any{I: &sp}.UnmarshalRESP(br))
+	assert.True(t, sp == &s)
+	assert.Equal(t, "bar", s)
+
+	require.Nil(t, Any{I: &sp}.UnmarshalRESP(br))
+	assert.Nil(t, sp)
+
+	// errors leave the pointer untouched
+	sp = &s
+	err := Any{I: &sp}.UnmarshalRESP(br)
+	assert.True(t, errors.As(err, new(Error)))
+	assert.True(t, sp == &s)
+}
+
+func TestAnyUnmarshalBytesReuse(t *T) {
+	br := bufio.NewReader(bytes.NewBufferString(
+		"$3\r\nfoo\r\n$2\r\nba\r\n$0\r\n\r\n$-1\r\n",
+	))
+
+	buf := make([]byte, 5, 16)
+	ptr := &buf[:1][0]
+	for _, exp := range []string{"foo", "ba", ""} {
+		require.Nil(t, Any{I: &buf}.UnmarshalRESP(br))
+		assert.Equal(t, exp, string(buf))
+		assert.Equal(t, 16, cap(buf))
+		assert.True(t, ptr == &buf[:1][0], "buffer wasn't reused")
+	}
+
+	require.Nil(t, Any{I: &buf}.UnmarshalRESP(br))
+	assert.Nil(t, buf)
+}
+
+func TestAnyNumElems(t *T) {
+	tests := []interface{}{
+		nil,
+		"foo",
+		[]string{"foo", "bar"},
+		intPtr(1),
+		(*int)(nil),
+		(*textCPMarshaler)(nil),
+		[]*int{nil, intPtr(1), nil},
+		[]interface{}{(*int)(nil), "foo", []int(nil), map[string]int(nil)},
+		map[string]*int{"one": nil, "two": intPtr(2)},
+		testStructC{},
+		testStructC{Biz: new(string)},
+		(*testStructC)(nil),
+	}
+
+	// NumElems must agree with the number of messages written when flattening
+	for _, in := range tests {
+		a := Any{I: in, MarshalBulkString: true, MarshalNoArrayHeaders: true}
+		buf := new(bytes.Buffer)
+		require.Nil(t, a.MarshalRESP(buf), "in: %#v", in)
+
+		var n int
+		br := bufio.NewReader(buf)
+		for ; br.Buffered() > 0 || buf.Len() > 0; n++ {
+			require.Nil(t, (Any{}).UnmarshalRESP(br), "in: %#v", in)
+		}
+		assert.Equal(t, n, a.NumElems(), "in: %#v", in)
+	}
+
+	// when not flattening nil pointers are counted as their zero values
+	assert.Equal(t, 1, Any{I: (*int)(nil)}.NumElems())
+	assert.Equal(t, 3, Any{I: []*int{nil, intPtr(1), nil}}.NumElems())
+	assert.Equal(t, 4, Any{I: map[string]*int{"one": nil, "two": intPtr(2)}}.NumElems())
+	assert.Equal(t, Any{I: testStructC{}}.NumElems(), Any{I: (*testStructC)(nil)}.NumElems())
+
+	buf := new(bytes.Buffer)
+	require.Nil(t, Any{I: (*int)(nil)}.MarshalRESP(buf))
+	assert.Equal(t, ":0\r\n", buf.String())
+}
@@ -0,0 +1,116 @@
+package radix
+
+import (
+	"strings"
+	"time"
+
+	errors "golang.org/x/xerrors"
+
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+)
+
+type loadingRetryOpts struct {
+	minBackoff, maxBackoff time.Duration
+	timeout                time.Duration
+	retryAll               bool
+}
+
+// LoadingRetryOpt is an optional behavior which can be applied to the
+// RetryLoading function to effect how it retries Actions.
+type LoadingRetryOpt func(*loadingRetryOpts)
+
+// LoadingRetryBackoff sets the delay between attempts. The first retry waits
+// for min, and each subsequent one doubles the delay, up to max.
+//
+// Defaults to 50ms and 1s.
+func LoadingRetryBackoff(min, max time.Duration) LoadingRetryOpt {
+	return func(lro *loadingRetryOpts) {
+		lro.minBackoff, lro.maxBackoff = min, max
+	}
+}
+
+// LoadingRetryTimeout sets the maximum total amount of time which will be spent
+// waiting between attempts of a single Action. Once another wait would exceed
+// it the Action is no longer retried, and the LOADING error is returned.
+//
+// Defaults to 30s.
+func LoadingRetryTimeout(d time.Duration) LoadingRetryOpt {
+	return func(lro *loadingRetryOpts) {
+		lro.timeout = d
+	}
+}
+
+// LoadingRetryAll causes all Actions to be retried, rather than only those
+// which are ClusterCanRetryActions. This may cause commands to be performed
+// more than once, if an Action performs multiple commands and a later one fails
+// with LOADING. See RetryLoading.
+func LoadingRetryAll() LoadingRetryOpt {
+	return func(lro *loadingRetryOpts) {
+		lro.retryAll = true
+	}
+}
+
+type loadingRetryClient struct {
+	Client
+	opts    loadingRetryOpts
+	sleepFn func(time.Duration) // only changed in tests
+}
+
+// RetryLoading wraps the given Client such that Actions which fail due to a
+// LOADING error, which redis returns while it is loading its dataset into
+// memory after a restart or failover, are retried with a backoff until either
+// they succeed, they fail with some other error, or a timeout is reached. This
+// spares applications from having to handle LOADING errors themselves.
+//
+// Retrying is safe, even for commands which aren't idempotent such as INCR,
+// because redis rejects a command with a LOADING error without executing it.
+// That only holds for the command which failed though, and an Action which
+// performs multiple commands, e.g. using WithConn, may have already performed
+// some of them by then. So by default only ClusterCanRetryActions (whose
+// ClusterCanRetry method returns true) are retried, which includes those
+// returned by Cmd, FlatCmd, and EvalScript.Cmd. LoadingRetryAll can be used to
+// retry all Actions regardless.
+//
+// The default options RetryLoading uses are:
+//
+//	LoadingRetryBackoff(50 * time.Millisecond, 1 * time.Second)
+//	LoadingRetryTimeout(30 * time.Second)
+func RetryLoading(c Client, opts ...LoadingRetryOpt) Client {
+	lrc := &loadingRetryClient{Client: c, sleepFn: time.Sleep}
+	defaultLoadingRetryOpts := []LoadingRetryOpt{
+		LoadingRetryBackoff(50*time.Millisecond, 1*time.Second),
+		LoadingRetryTimeout(30 * time.Second),
+	}
+	for _, opt := range append(defaultLoadingRetryOpts, opts...) {
+		opt(&lrc.opts)
+	}
+	return lrc
+}
+
+func isLoadingErr(err error) bool {
+	var rerr resp2.Error
+	return errors.As(err, &rerr) && strings.HasPrefix(rerr.E.Error(), "LOADING")
+}
+
+func (lrc *loadingRetryClient) Do(a Action) error {
+	if !lrc.opts.retryAll {
+		if ccra, ok := a.(ClusterCanRetryAction); !ok || !ccra.ClusterCanRetry() {
+			return lrc.Client.Do(a)
+		}
+	}
+
+	var waited time.Duration
+	backoff := lrc.opts.minBackoff
+	for {
+		err := lrc.Client.Do(a)
+		if !isLoadingErr(err) || waited+backoff > lrc.opts.timeout {
+			return err
+		}
+
+		lrc.sleepFn(backoff)
+		waited += backoff
+		if backoff *= 2; backoff > lrc.opts.maxBackoff {
+			backoff = lrc.opts.maxBackoff
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package radix
+
+import (
+	. "testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	errors "golang.org/x/xerrors"
+
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+)
+
+func TestRetryLoading(t *T) {
+	var loadingLeft, calls int
+	stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		calls++
+		if loadingLeft > 0 {
+			loadingLeft--
+			return resp2.Error{E: errors.New("LOADING Redis is loading the dataset in memory")}
+		} else if args[0] == "FAIL" {
+			return resp2.Error{E: errors.New("ERR failed")}
+		}
+		return args[1]
+	})
+
+	newClient := func(opts ...LoadingRetryOpt) (Client, *[]time.Duration) {
+		var sleeps []time.Duration
+		c := RetryLoading(stub, opts...)
+		c.(*loadingRetryClient).sleepFn = func(d time.Duration) {
+			sleeps = append(sleeps, d)
+		}
+		return c, &sleeps
+	}
+
+	t.Run("eventual success", func(t *T) {
+		loadingLeft, calls = 5, 0
+		c, sleeps := newClient(LoadingRetryBackoff(10*time.Millisecond, 50*time.Millisecond))
+
+		var out string
+		require.NoError(t, c.Do(Cmd(&out, "ECHO", "foo")))
+		assert.Equal(t, "foo", out)
+		assert.Equal(t, 6, calls)
+		assert.Equal(t, []time.Duration{
+			10 * time.Millisecond,
+			20 * time.Millisecond,
+			40 * time.Millisecond,
+			50 * time.Millisecond,
+			50 * time.Millisecond,
+		}, *sleeps)
+	})
+
+	t.Run("timeout", func(t *T) {
+		loadingLeft, calls = 100, 0
+		c, sleeps := newClient(
+			LoadingRetryBackoff(10*time.Millisecond, 10*time.Millisecond),
+			LoadingRetryTimeout(35*time.Millisecond),
+		)
+
+		err := c.Do(Cmd(nil, "ECHO", "foo"))
+		assert.True(t, isLoadingErr(err))
+		assert.Equal(t, 4, calls)
+		assert.Len(t, *sleeps, 3)
+	})
+
+	t.Run("other error", func(t *T) {
+		loadingLeft, calls = 1, 0
+		c, sleeps := newClient()
+
+		err := c.Do(Cmd(nil, "FAIL", "foo"))
+		assert.EqualError(t, err, "ERR failed")
+		assert.Equal(t, 2, calls)
+		assert.Len(t, *sleeps, 1)
+	})
+
+	t.Run("not retryable", func(t *T) {
+		a := WithConn("", func(conn Conn) error {
+			return conn.Do(Cmd(nil, "ECHO", "foo"))
+		})
+
+		loadingLeft, calls = 1, 0
+		c, _ := newClient()
+		assert.True(t, isLoadingErr(c.Do(a)))
+		assert.Equal(t, 1, calls)
+
+		loadingLeft, calls = 1, 0
+		c, _ = newClient(LoadingRetryAll())
+		assert.NoError(t, c.Do(a))
+		assert.Equal(t, 2, calls)
+	})
+}
@@ -45,6 +45,15 @@ type ScanOpts struct {
 	// If used with an older version of Redis or with a Command other than
 	// "SCAN", scanning will fail.
 	Type string
+
+	// If true then results which have already been returned during the scan
+	// are skipped, since a SCAN may return the same element multiple times.
+	// This requires keeping a set of every result returned so far, and so uses
+	// memory proportional to the size of what is being scanned. For HSCAN and
+	// ZSCAN, whose results are alternating fields (or members) and values,
+	// only the fields are considered, and a duplicate field is skipped along
+	// with its value.
+	Dedupe bool
 }
 
 func (o ScanOpts) cmd(rcv interface{}, cursor string) CmdAction {
@@ -79,6 +88,9 @@ type scanner struct {
 	res    scanResult
 	resIdx int
 	err    error
+
+	// only used if Dedupe is set
+	seen map[string]struct{}
 }
 
 // NewScanner creates a new Scanner instance which will iterate over the redis
@@ -116,7 +128,34 @@ func (s *scanner) Next(res *string) bool {
 
 		s.err = s.Client.Do(s.cmd(&s.res, s.res.cur))
 		s.resIdx = 0
+		if s.err == nil && s.Dedupe {
+			s.dedupe()
+		}
+	}
+}
+
+// dedupe removes the results which have been seen previously from the current
+// set of results.
+func (s *scanner) dedupe() {
+	if s.seen == nil {
+		s.seen = map[string]struct{}{}
+	}
+
+	step := 1
+	switch strings.ToUpper(s.Command) {
+	case "HSCAN", "ZSCAN":
+		step = 2
+	}
+
+	keys := s.res.keys[:0]
+	for i := 0; i+step <= len(s.res.keys); i += step {
+		if _, ok := s.seen[s.res.keys[i]]; ok {
+			continue
+		}
+		s.seen[s.res.keys[i]] = struct{}{}
+		keys = append(keys, s.res.keys[i:i+step]...)
 	}
+	s.res.keys = keys
 }
 
 func (s *scanner) Close() error {
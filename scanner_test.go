@@ -185,3 +185,37 @@ func ExampleNewScanner_hscan() {
 		log.Fatal(err)
 	}
 }
+
+func TestScannerDedupe(t *T) {
+	pages := map[string]map[string][]interface{}{
+		"SCAN": {
+			"0": {"5", []string{"a", "b"}},
+			"5": {"0", []string{"b", "c", "a"}},
+		},
+		"HSCAN": {
+			"0": {"5", []string{"a", "1", "b", "1"}},
+			"5": {"0", []string{"b", "2", "c", "1"}},
+		},
+	}
+	stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		return pages[args[0]][args[len(args)-1]]
+	})
+
+	scan := func(o ScanOpts) []string {
+		s := NewScanner(stub, o)
+		var res []string
+		var str string
+		for s.Next(&str) {
+			res = append(res, str)
+		}
+		require.NoError(t, s.Close())
+		return res
+	}
+
+	assert.Equal(t, []string{"a", "b", "b", "c", "a"}, scan(ScanOpts{Command: "SCAN"}))
+	assert.Equal(t, []string{"a", "b", "c"}, scan(ScanOpts{Command: "SCAN", Dedupe: true}))
+	assert.Equal(t,
+		[]string{"a", "1", "b", "1", "c", "1"},
+		scan(ScanOpts{Command: "HSCAN", Key: "h", Dedupe: true}),
+	)
+}
@@ -0,0 +1,657 @@
+package radix
+
+import (
+	"bufio"
+	"strconv"
+	"strings"
+	"time"
+
+	errors "golang.org/x/xerrors"
+
+	"github.com/mediocregopher/radix/v3/resp"
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+)
+
+// HelloInfo describes the server metadata which is returned by the HELLO
+// command, and can be used as the receiver when performing it:
+//
+//	var info radix.HelloInfo
+//	err := client.Do(radix.Cmd(&info, "HELLO"))
+//
+// Both the RESP2 array form of the reply and the RESP3 map form (returned when
+// HELLO is used to switch the connection to RESP3) are supported. Fields which
+// aren't present in the reply will be left as their zero value.
+type HelloInfo struct {
+	Server  string        `redis:"server"`
+	Version string        `redis:"version"`
+	Proto   int           `redis:"proto"`
+	ID      int64         `redis:"id"`
+	Mode    string        `redis:"mode"`
+	Role    string        `redis:"role"`
+	Modules []HelloModule `redis:"modules"`
+}
+
+// HelloModule describes a single module loaded into the server, as returned
+// within the reply to the HELLO command.
+type HelloModule struct {
+	Name string   `redis:"name"`
+	Ver  int64    `redis:"ver"`
+	Path string   `redis:"path"`
+	Args []string `redis:"args"`
+}
+
+// UnmarshalRESP implements the method for the resp.Unmarshaler interface.
+func (hi *HelloInfo) UnmarshalRESP(br *bufio.Reader) error {
+	// helloInfo doesn't have the UnmarshalRESP method, so it can be decoded
+	// into like any other struct
+	type helloInfo HelloInfo
+	var into helloInfo
+	if err := (resp2.Any{I: &into}).UnmarshalRESP(br); err != nil {
+		return err
+	}
+	*hi = HelloInfo(into)
+	return nil
+}
+
+// VersionAtLeast returns true if the Version field, which should be of the form
+// "major.minor.patch", denotes a version greater than or equal to the given
+// one. False is returned if Version can't be parsed.
+func (hi HelloInfo) VersionAtLeast(major, minor, patch int) bool {
+	parts := strings.SplitN(hi.Version, ".", 3)
+	if len(parts) != 3 {
+		return false
+	}
+
+	want := [3]int{major, minor, patch}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return false
+		} else if n != want[i] {
+			return n > want[i]
+		}
+	}
+	return true
+}
+
+// ClientInfo describes a single client connection, as returned by the CLIENT
+// INFO command or within the reply to CLIENT LIST. It can be used as the
+// receiver for CLIENT INFO:
+//
+//	var info radix.ClientInfo
+//	err := client.Do(radix.Cmd(&info, "CLIENT", "INFO"))
+//
+// The set of fields returned varies between redis versions. Fields which aren't
+// present in the reply are left as their zero value, and fields in the reply
+// which aren't known are ignored.
+type ClientInfo struct {
+	ID       int64
+	Addr     string
+	LAddr    string
+	FD       int64
+	Name     string
+	Age      time.Duration
+	Idle     time.Duration
+	Flags    string
+	DB       int
+	Sub      int
+	PSub     int
+	Multi    int
+	QBuf     int64
+	QBufFree int64
+	OMem     int64
+	Events   string
+	Cmd      string
+	User     string
+	Resp     int
+}
+
+// UnmarshalRESP implements the method for the resp.Unmarshaler interface.
+func (ci *ClientInfo) UnmarshalRESP(br *bufio.Reader) error {
+	var line string
+	if err := (resp2.Any{I: &line}).UnmarshalRESP(br); err != nil {
+		return err
+	}
+
+	info, err := parseClientInfo(strings.TrimSpace(line))
+	if err != nil {
+		return resp.ErrDiscarded{Err: err}
+	}
+	*ci = info
+	return nil
+}
+
+func parseClientInfo(line string) (ClientInfo, error) {
+	var ci ClientInfo
+	for _, field := range strings.Fields(line) {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			return ClientInfo{}, errors.Errorf("malformed client info field %q", field)
+		}
+
+		var err error
+		var secs int64
+		switch k, v := kv[0], kv[1]; k {
+		case "id":
+			ci.ID, err = strconv.ParseInt(v, 10, 64)
+		case "addr":
+			ci.Addr = v
+		case "laddr":
+			ci.LAddr = v
+		case "fd":
+			ci.FD, err = strconv.ParseInt(v, 10, 64)
+		case "name":
+			ci.Name = v
+		case "age":
+			secs, err = strconv.ParseInt(v, 10, 64)
+			ci.Age = time.Duration(secs) * time.Second
+		case "idle":
+			secs, err = strconv.ParseInt(v, 10, 64)
+			ci.Idle = time.Duration(secs) * time.Second
+		case "flags":
+			ci.Flags = v
+		case "db":
+			ci.DB, err = strconv.Atoi(v)
+		case "sub":
+			ci.Sub, err = strconv.Atoi(v)
+		case "psub":
+			ci.PSub, err = strconv.Atoi(v)
+		case "multi":
+			ci.Multi, err = strconv.Atoi(v)
+		case "qbuf":
+			ci.QBuf, err = strconv.ParseInt(v, 10, 64)
+		case "qbuf-free":
+			ci.QBufFree, err = strconv.ParseInt(v, 10, 64)
+		case "omem":
+			ci.OMem, err = strconv.ParseInt(v, 10, 64)
+		case "events":
+			ci.Events = v
+		case "cmd":
+			ci.Cmd = v
+		case "user":
+			ci.User = v
+		case "resp":
+			ci.Resp, err = strconv.Atoi(v)
+		}
+
+		if err != nil {
+			return ClientInfo{}, errors.Errorf("parsing client info field %q: %w", field, err)
+		}
+	}
+	return ci, nil
+}
+
+// ClientList is the receiver for the CLIENT LIST command, whose reply contains
+// one line of client info per connected client:
+//
+//	var clients radix.ClientList
+//	err := client.Do(radix.Cmd(&clients, "CLIENT", "LIST"))
+type ClientList []ClientInfo
+
+// UnmarshalRESP implements the method for the resp.Unmarshaler interface.
+func (cl *ClientList) UnmarshalRESP(br *bufio.Reader) error {
+	var body string
+	if err := (resp2.Any{I: &body}).UnmarshalRESP(br); err != nil {
+		return err
+	}
+
+	list := (*cl)[:0]
+	for _, line := range strings.Split(body, "\n") {
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+
+		info, err := parseClientInfo(line)
+		if err != nil {
+			return resp.ErrDiscarded{Err: err}
+		}
+		list = append(list, info)
+	}
+	*cl = list
+	return nil
+}
+
+// ReplicationInfo describes the replication state of a redis instance, as
+// returned in the replication section of the INFO command. It can be used as
+// the receiver when performing it:
+//
+//	var info radix.ReplicationInfo
+//	err := client.Do(radix.Cmd(&info, "INFO", "replication"))
+//
+// Fields which aren't relevant to the instance's role are left as their zero
+// value.
+type ReplicationInfo struct {
+	// Role is either "master" or "slave".
+	Role string
+
+	// MasterReplOffset is the replication offset of the instance. For a
+	// master this is the offset of the most recent write it has performed.
+	MasterReplOffset int64
+
+	// ConnectedSlaves is the number of replicas connected to a master.
+	ConnectedSlaves int
+
+	// MasterLinkStatus is the status of a replica's link to its master,
+	// either "up" or "down".
+	MasterLinkStatus string
+
+	// SlaveReplOffset is the replication offset which a replica has
+	// processed up to.
+	SlaveReplOffset int64
+}
+
+// UnmarshalRESP implements the method for the resp.Unmarshaler interface.
+func (ri *ReplicationInfo) UnmarshalRESP(br *bufio.Reader) error {
+	var body string
+	if err := (resp2.Any{I: &body}).UnmarshalRESP(br); err != nil {
+		return err
+	}
+
+	var info ReplicationInfo
+	for _, line := range strings.Split(body, "\n") {
+		kv := strings.SplitN(strings.TrimSpace(line), ":", 2)
+		if len(kv) != 2 {
+			continue // empty lines and section headers
+		}
+
+		var err error
+		switch k, v := kv[0], kv[1]; k {
+		case "role":
+			info.Role = v
+		case "master_repl_offset":
+			info.MasterReplOffset, err = strconv.ParseInt(v, 10, 64)
+		case "connected_slaves":
+			info.ConnectedSlaves, err = strconv.Atoi(v)
+		case "master_link_status":
+			info.MasterLinkStatus = v
+		case "slave_repl_offset":
+			info.SlaveReplOffset, err = strconv.ParseInt(v, 10, 64)
+		}
+
+		if err != nil {
+			return resp.ErrDiscarded{
+				Err: errors.Errorf("parsing replication info line %q: %w", line, err),
+			}
+		}
+	}
+
+	*ri = info
+	return nil
+}
+
+// Offset returns the replication offset which the instance has reached, i.e.
+// MasterReplOffset for a master and SlaveReplOffset for a replica.
+func (ri ReplicationInfo) Offset() int64 {
+	if ri.Role == "slave" {
+		return ri.SlaveReplOffset
+	}
+	return ri.MasterReplOffset
+}
+
+// ReplicationOffset returns the current replication offset of the instance the
+// given Client is connected to, as returned by ReplicationInfo's Offset
+// method.
+//
+// When performed against a master directly after a write, the returned offset
+// can be passed into WaitForReplicationOffset in order to wait for a replica
+// to have received that write, providing read-your-writes consistency when
+// reading from replicas.
+func ReplicationOffset(c Client) (int64, error) {
+	var info ReplicationInfo
+	if err := c.Do(Cmd(&info, "INFO", "replication")); err != nil {
+		return 0, err
+	}
+	return info.Offset(), nil
+}
+
+// waitForReplicationOffsetInterval is how often WaitForReplicationOffset polls
+// the replica.
+const waitForReplicationOffsetInterval = 10 * time.Millisecond
+
+// WaitForReplicationOffset polls the replication offset of the instance the
+// given Client is connected to (see ReplicationOffset) until it's at least the
+// given offset. An error is returned if the offset isn't reached within the
+// given timeout.
+func WaitForReplicationOffset(c Client, offset int64, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		curr, err := ReplicationOffset(c)
+		if err != nil {
+			return err
+		} else if curr >= offset {
+			return nil
+		} else if time.Now().After(deadline) {
+			return errors.Errorf(
+				"replication offset %d didn't reach %d within %v", curr, offset, timeout,
+			)
+		}
+		time.Sleep(waitForReplicationOffsetInterval)
+	}
+}
+
+// LatencyEvent describes the latest and maximum latency recorded for a single
+// event by the latency monitor, as returned within the reply to LATENCY
+// LATEST.
+type LatencyEvent struct {
+	Name string
+
+	// Time is when the latest latency spike for the event occurred.
+	Time time.Time
+
+	// Latest and Max are the latency of the latest spike and of the largest
+	// spike recorded for the event. Redis reports these in milliseconds.
+	Latest, Max time.Duration
+}
+
+// LatencyLatest is the receiver for the LATENCY LATEST command:
+//
+//	var events radix.LatencyLatest
+//	err := client.Do(radix.Cmd(&events, "LATENCY", "LATEST"))
+//
+// If the latency monitor isn't enabled, or no events have been recorded, the
+// result is empty.
+type LatencyLatest []LatencyEvent
+
+// UnmarshalRESP implements the method for the resp.Unmarshaler interface.
+func (ll *LatencyLatest) UnmarshalRESP(br *bufio.Reader) error {
+	var rows [][]string
+	if err := (resp2.Any{I: &rows}).UnmarshalRESP(br); err != nil {
+		return err
+	}
+
+	events := (*ll)[:0]
+	for _, row := range rows {
+		if len(row) < 4 {
+			return resp.ErrDiscarded{
+				Err: errors.Errorf("LATENCY LATEST entry has %d elements, expected at least 4", len(row)),
+			}
+		}
+
+		ts, err := parseLatencyTime(row[1])
+		if err != nil {
+			return resp.ErrDiscarded{Err: err}
+		}
+		latest, err := parseLatencyMillis(row[2])
+		if err != nil {
+			return resp.ErrDiscarded{Err: err}
+		}
+		max, err := parseLatencyMillis(row[3])
+		if err != nil {
+			return resp.ErrDiscarded{Err: err}
+		}
+		events = append(events, LatencyEvent{Name: row[0], Time: ts, Latest: latest, Max: max})
+	}
+	*ll = events
+	return nil
+}
+
+// LatencySample is a single latency spike for an event, as returned within
+// the reply to LATENCY HISTORY.
+type LatencySample struct {
+	Time    time.Time
+	Latency time.Duration
+}
+
+// LatencyHistory is the receiver for the LATENCY HISTORY command, containing
+// the latency spikes recorded for a single event in the order they occurred:
+//
+//	var history radix.LatencyHistory
+//	err := client.Do(radix.Cmd(&history, "LATENCY", "HISTORY", "command"))
+type LatencyHistory []LatencySample
+
+// UnmarshalRESP implements the method for the resp.Unmarshaler interface.
+func (lh *LatencyHistory) UnmarshalRESP(br *bufio.Reader) error {
+	var rows [][]string
+	if err := (resp2.Any{I: &rows}).UnmarshalRESP(br); err != nil {
+		return err
+	}
+
+	samples := (*lh)[:0]
+	for _, row := range rows {
+		if len(row) < 2 {
+			return resp.ErrDiscarded{
+				Err: errors.Errorf("LATENCY HISTORY entry has %d elements, expected 2", len(row)),
+			}
+		}
+
+		ts, err := parseLatencyTime(row[0])
+		if err != nil {
+			return resp.ErrDiscarded{Err: err}
+		}
+		latency, err := parseLatencyMillis(row[1])
+		if err != nil {
+			return resp.ErrDiscarded{Err: err}
+		}
+		samples = append(samples, LatencySample{Time: ts, Latency: latency})
+	}
+	*lh = samples
+	return nil
+}
+
+func parseLatencyTime(s string) (time.Time, error) {
+	secs, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}, errors.Errorf("parsing latency timestamp %q: %w", s, err)
+	}
+	return time.Unix(secs, 0), nil
+}
+
+func parseLatencyMillis(s string) (time.Duration, error) {
+	ms, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, errors.Errorf("parsing latency %q: %w", s, err)
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+// CommandDocs is the receiver for the COMMAND DOCS command, mapping each
+// command's name to its documentation:
+//
+//	var docs radix.CommandDocs
+//	err := client.Do(radix.Cmd(&docs, "COMMAND", "DOCS", "GET", "SET"))
+//
+// Both the RESP2 array form of the reply and the RESP3 map form are supported.
+// COMMAND DOCS is only available in redis 7.0 and later.
+type CommandDocs map[string]CommandDoc
+
+// CommandDoc describes a single command, as returned within the reply to
+// COMMAND DOCS. Fields which aren't present in the reply, which varies between
+// commands and redis versions, are left as their zero value.
+type CommandDoc struct {
+	Summary         string
+	Since           string
+	Group           string
+	Complexity      string
+	DocFlags        []string
+	DeprecatedSince string
+	ReplacedBy      string
+	History         []CommandDocHistory
+	Arguments       []CommandDocArg
+
+	// Subcommands maps the full name of each of the command's subcommands,
+	// e.g. "config|get", to its documentation.
+	Subcommands map[string]CommandDoc
+}
+
+// CommandDocHistory describes a single change to a command's behavior, as
+// returned within its CommandDoc.
+type CommandDocHistory struct {
+	Version     string
+	Description string
+}
+
+// CommandDocArg describes a single argument of a command, as returned within
+// its CommandDoc.
+type CommandDocArg struct {
+	Name        string
+	DisplayText string
+
+	// Type is the argument's type, e.g. "string", "integer", "key", or one of
+	// "oneof" or "block" for arguments which are made up of the arguments in
+	// Arguments.
+	Type string
+
+	// KeySpecIndex is the index of the key specification (see COMMAND INFO)
+	// describing the argument, if Type is "key", and -1 otherwise.
+	KeySpecIndex int64
+
+	Token           string
+	Summary         string
+	Since           string
+	DeprecatedSince string
+
+	// Flags contains any of "optional", "multiple" and "multiple_token".
+	Flags []string
+
+	Arguments []CommandDocArg
+}
+
+// UnmarshalRESP implements the method for the resp.Unmarshaler interface.
+func (cd *CommandDocs) UnmarshalRESP(br *bufio.Reader) error {
+	var i interface{}
+	if err := (resp2.Any{I: &i}).UnmarshalRESP(br); err != nil {
+		return err
+	}
+
+	docs, err := parseCommandDocs(i)
+	if err != nil {
+		return resp.ErrDiscarded{Err: errors.Errorf("parsing COMMAND DOCS reply: %w", err)}
+	}
+	*cd = docs
+	return nil
+}
+
+// commandDocFields returns the fields of a map in the COMMAND DOCS reply,
+// which is either a RESP3 map or, for RESP2, an array of alternating keys and
+// values.
+func commandDocFields(i interface{}) (map[string]interface{}, error) {
+	switch i := i.(type) {
+	case nil:
+		return nil, nil
+	case map[string]interface{}:
+		return i, nil
+	case []interface{}:
+		if len(i)%2 != 0 {
+			return nil, errors.Errorf("map has odd number of elements %d", len(i))
+		}
+		m := make(map[string]interface{}, len(i)/2)
+		for j := 0; j < len(i); j += 2 {
+			m[commandDocString(i[j])] = i[j+1]
+		}
+		return m, nil
+	default:
+		return nil, errors.Errorf("unexpected map type %T", i)
+	}
+}
+
+func commandDocString(i interface{}) string {
+	switch i := i.(type) {
+	case string:
+		return i
+	case []byte:
+		return string(i)
+	case int64:
+		return strconv.FormatInt(i, 10)
+	}
+	return ""
+}
+
+func commandDocStrings(i interface{}) []string {
+	ii, _ := i.([]interface{})
+	if len(ii) == 0 {
+		return nil
+	}
+	ss := make([]string, len(ii))
+	for j := range ii {
+		ss[j] = commandDocString(ii[j])
+	}
+	return ss
+}
+
+func parseCommandDocs(i interface{}) (CommandDocs, error) {
+	fields, err := commandDocFields(i)
+	if err != nil {
+		return nil, err
+	}
+
+	docs := make(CommandDocs, len(fields))
+	for name, docI := range fields {
+		if docs[name], err = parseCommandDoc(docI); err != nil {
+			return nil, errors.Errorf("command %q: %w", name, err)
+		}
+	}
+	return docs, nil
+}
+
+func parseCommandDoc(i interface{}) (CommandDoc, error) {
+	fields, err := commandDocFields(i)
+	if err != nil {
+		return CommandDoc{}, err
+	}
+
+	doc := CommandDoc{
+		Summary:         commandDocString(fields["summary"]),
+		Since:           commandDocString(fields["since"]),
+		Group:           commandDocString(fields["group"]),
+		Complexity:      commandDocString(fields["complexity"]),
+		DocFlags:        commandDocStrings(fields["doc_flags"]),
+		DeprecatedSince: commandDocString(fields["deprecated_since"]),
+		ReplacedBy:      commandDocString(fields["replaced_by"]),
+	}
+
+	history, _ := fields["history"].([]interface{})
+	for _, entryI := range history {
+		entry := commandDocStrings(entryI)
+		if len(entry) != 2 {
+			return CommandDoc{}, errors.Errorf("history entry has %d elements, expected 2", len(entry))
+		}
+		doc.History = append(doc.History, CommandDocHistory{Version: entry[0], Description: entry[1]})
+	}
+
+	if doc.Arguments, err = parseCommandDocArgs(fields["arguments"]); err != nil {
+		return CommandDoc{}, err
+	}
+
+	if subI, ok := fields["subcommands"]; ok {
+		if doc.Subcommands, err = parseCommandDocs(subI); err != nil {
+			return CommandDoc{}, err
+		}
+	}
+	return doc, nil
+}
+
+func parseCommandDocArgs(i interface{}) ([]CommandDocArg, error) {
+	argsI, _ := i.([]interface{})
+	if len(argsI) == 0 {
+		return nil, nil
+	}
+
+	args := make([]CommandDocArg, len(argsI))
+	for j, argI := range argsI {
+		fields, err := commandDocFields(argI)
+		if err != nil {
+			return nil, err
+		}
+
+		arg := CommandDocArg{
+			Name:            commandDocString(fields["name"]),
+			DisplayText:     commandDocString(fields["display_text"]),
+			Type:            commandDocString(fields["type"]),
+			KeySpecIndex:    -1,
+			Token:           commandDocString(fields["token"]),
+			Summary:         commandDocString(fields["summary"]),
+			Since:           commandDocString(fields["since"]),
+			DeprecatedSince: commandDocString(fields["deprecated_since"]),
+			Flags:           commandDocStrings(fields["flags"]),
+		}
+		if idx, ok := fields["key_spec_index"].(int64); ok {
+			arg.KeySpecIndex = idx
+		}
+
+		if arg.Arguments, err = parseCommandDocArgs(fields["arguments"]); err != nil {
+			return nil, errors.Errorf("argument %q: %w", arg.Name, err)
+		}
+		args[j] = arg
+	}
+	return args, nil
+}
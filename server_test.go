@@ -0,0 +1,402 @@
+package radix
+
+import (
+	"bufio"
+	"bytes"
+	"strconv"
+	. "testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	errors "golang.org/x/xerrors"
+
+	"github.com/mediocregopher/radix/v3/resp"
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+)
+
+func TestHelloInfo(t *T) {
+	exp := HelloInfo{
+		Server:  "redis",
+		Version: "6.2.1",
+		Proto:   3,
+		ID:      5,
+		Mode:    "standalone",
+		Role:    "master",
+		Modules: []HelloModule{
+			{Name: "search", Ver: 20006, Path: "/lib/search.so", Args: []string{}},
+		},
+	}
+
+	tests := []struct {
+		name string
+		in   string
+	}{
+		{
+			name: "resp2",
+			in: "*14\r\n" +
+				"$6\r\nserver\r\n$5\r\nredis\r\n" +
+				"$7\r\nversion\r\n$5\r\n6.2.1\r\n" +
+				"$5\r\nproto\r\n:3\r\n" +
+				"$2\r\nid\r\n:5\r\n" +
+				"$4\r\nmode\r\n$10\r\nstandalone\r\n" +
+				"$4\r\nrole\r\n$6\r\nmaster\r\n" +
+				"$7\r\nmodules\r\n*1\r\n*8\r\n" +
+				"$4\r\nname\r\n$6\r\nsearch\r\n" +
+				"$3\r\nver\r\n:20006\r\n" +
+				"$4\r\npath\r\n$14\r\n/lib/search.so\r\n" +
+				"$4\r\nargs\r\n*0\r\n",
+		},
+		{
+			name: "resp3",
+			in: "%7\r\n" +
+				"$6\r\nserver\r\n$5\r\nredis\r\n" +
+				"$7\r\nversion\r\n$5\r\n6.2.1\r\n" +
+				"$5\r\nproto\r\n:3\r\n" +
+				"$2\r\nid\r\n:5\r\n" +
+				"$4\r\nmode\r\n$10\r\nstandalone\r\n" +
+				"$4\r\nrole\r\n$6\r\nmaster\r\n" +
+				"$7\r\nmodules\r\n*1\r\n%4\r\n" +
+				"$4\r\nname\r\n$6\r\nsearch\r\n" +
+				"$3\r\nver\r\n:20006\r\n" +
+				"$4\r\npath\r\n$14\r\n/lib/search.so\r\n" +
+				"$4\r\nargs\r\n*0\r\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *T) {
+			info := HelloInfo{Server: "stale", Modules: []HelloModule{{}, {}}}
+			br := bufio.NewReader(bytes.NewBufferString(test.in))
+			require.NoError(t, info.UnmarshalRESP(br))
+			assert.Equal(t, exp, info)
+			assert.Zero(t, br.Buffered())
+		})
+	}
+}
+
+func TestHelloInfoVersionAtLeast(t *T) {
+	info := HelloInfo{Version: "6.2.1"}
+	assert.True(t, info.VersionAtLeast(6, 2, 1))
+	assert.True(t, info.VersionAtLeast(6, 2, 0))
+	assert.True(t, info.VersionAtLeast(6, 0, 9))
+	assert.True(t, info.VersionAtLeast(5, 9, 9))
+	assert.False(t, info.VersionAtLeast(6, 2, 2))
+	assert.False(t, info.VersionAtLeast(6, 3, 0))
+	assert.False(t, info.VersionAtLeast(7, 0, 0))
+	assert.False(t, HelloInfo{Version: "unstable"}.VersionAtLeast(0, 0, 0))
+}
+
+func TestClientList(t *T) {
+	body := "id=3 addr=127.0.0.1:52555 laddr=127.0.0.1:6379 fd=8 name=worker age=855 idle=0 flags=N db=0 sub=0 psub=0 multi=-1 qbuf=26 qbuf-free=40928 argv-mem=10 obl=0 oll=0 omem=0 tot-mem=61466 events=r cmd=client|list user=default redir=-1 resp=2\n" +
+		"id=4 addr=127.0.0.1:52556 fd=9 name= age=10 idle=5 flags=P db=1 sub=0 psub=2 multi=-1 qbuf=0 qbuf-free=0 obl=0 oll=0 omem=0 events=r cmd=psubscribe\n"
+	in := "$" + strconv.Itoa(len(body)) + "\r\n" + body + "\r\n"
+
+	cl := ClientList{{ID: 100}, {ID: 101}, {ID: 102}}
+	br := bufio.NewReader(bytes.NewBufferString(in))
+	require.NoError(t, cl.UnmarshalRESP(br))
+	assert.Zero(t, br.Buffered())
+	assert.Equal(t, ClientList{
+		{
+			ID:       3,
+			Addr:     "127.0.0.1:52555",
+			LAddr:    "127.0.0.1:6379",
+			FD:       8,
+			Name:     "worker",
+			Age:      855 * time.Second,
+			Flags:    "N",
+			Multi:    -1,
+			QBuf:     26,
+			QBufFree: 40928,
+			Events:   "r",
+			Cmd:      "client|list",
+			User:     "default",
+			Resp:     2,
+		},
+		{
+			ID:     4,
+			Addr:   "127.0.0.1:52556",
+			FD:     9,
+			Age:    10 * time.Second,
+			Idle:   5 * time.Second,
+			Flags:  "P",
+			DB:     1,
+			PSub:   2,
+			Multi:  -1,
+			Events: "r",
+			Cmd:    "psubscribe",
+		},
+	}, cl)
+
+	// a malformed line results in an error, but the message is still consumed
+	br = bufio.NewReader(bytes.NewBufferString("$9\r\nid=notint\r\n+OK\r\n"))
+	assert.Error(t, cl.UnmarshalRESP(br))
+	var ok string
+	require.NoError(t, resp2.Any{I: &ok}.UnmarshalRESP(br))
+	assert.Equal(t, "OK", ok)
+}
+
+func TestClientInfo(t *T) {
+	c := dial()
+	defer c.Close()
+	require.NoError(t, c.Do(Cmd(nil, "CLIENT", "SETNAME", "TestClientInfo")))
+
+	var clients ClientList
+	require.NoError(t, c.Do(Cmd(&clients, "CLIENT", "LIST")))
+	var found bool
+	for _, ci := range clients {
+		found = found || ci.Name == "TestClientInfo"
+	}
+	assert.True(t, found)
+}
+
+func TestReplicationInfo(t *T) {
+	body := "# Replication\r\n" +
+		"role:slave\r\n" +
+		"master_host:127.0.0.1\r\n" +
+		"master_port:6379\r\n" +
+		"master_link_status:up\r\n" +
+		"slave_repl_offset:1234\r\n" +
+		"slave_priority:100\r\n" +
+		"connected_slaves:0\r\n" +
+		"master_repl_offset:1300\r\n"
+	in := "$" + strconv.Itoa(len(body)) + "\r\n" + body + "\r\n"
+
+	var info ReplicationInfo
+	br := bufio.NewReader(bytes.NewBufferString(in))
+	require.NoError(t, info.UnmarshalRESP(br))
+	assert.Equal(t, ReplicationInfo{
+		Role:             "slave",
+		MasterReplOffset: 1300,
+		MasterLinkStatus: "up",
+		SlaveReplOffset:  1234,
+	}, info)
+	assert.Equal(t, int64(1234), info.Offset())
+
+	info = ReplicationInfo{Role: "master", MasterReplOffset: 10}
+	assert.Equal(t, int64(10), info.Offset())
+}
+
+func TestWaitForReplicationOffset(t *T) {
+	var offset int64
+	stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		offset += 10
+		return "# Replication\r\nrole:slave\r\nslave_repl_offset:" +
+			strconv.FormatInt(offset, 10) + "\r\n"
+	})
+
+	require.NoError(t, WaitForReplicationOffset(stub, 50, time.Second))
+	assert.Equal(t, int64(50), offset)
+
+	assert.Error(t, WaitForReplicationOffset(stub, 1<<40, 50*time.Millisecond))
+}
+
+func TestReplicationOffset(t *T) {
+	c := dial()
+	defer c.Close()
+
+	before, err := ReplicationOffset(c)
+	require.NoError(t, err)
+	require.NoError(t, WaitForReplicationOffset(c, before, time.Second))
+}
+
+func TestLatencyLatest(t *T) {
+	in := "*2\r\n" +
+		"*4\r\n$7\r\ncommand\r\n:1405067976\r\n:251\r\n:1001\r\n" +
+		"*4\r\n$12\r\nfast-command\r\n:1405067822\r\n:10\r\n:15\r\n" +
+		"*0\r\n"
+
+	br := bufio.NewReader(bytes.NewBufferString(in))
+	var ll LatencyLatest
+	require.NoError(t, ll.UnmarshalRESP(br))
+	assert.Equal(t, LatencyLatest{
+		{
+			Name:   "command",
+			Time:   time.Unix(1405067976, 0),
+			Latest: 251 * time.Millisecond,
+			Max:    1001 * time.Millisecond,
+		},
+		{
+			Name:   "fast-command",
+			Time:   time.Unix(1405067822, 0),
+			Latest: 10 * time.Millisecond,
+			Max:    15 * time.Millisecond,
+		},
+	}, ll)
+
+	require.NoError(t, ll.UnmarshalRESP(br))
+	assert.Empty(t, ll)
+
+	assert.Empty(t, Cmd(nil, "LATENCY", "LATEST").Keys())
+}
+
+func TestLatencyHistory(t *T) {
+	in := "*2\r\n" +
+		"*2\r\n:1405067822\r\n:251\r\n" +
+		"*2\r\n:1405067941\r\n:1001\r\n" +
+		"*1\r\n*1\r\n:1405067941\r\n" +
+		"+END\r\n"
+
+	br := bufio.NewReader(bytes.NewBufferString(in))
+	var lh LatencyHistory
+	require.NoError(t, lh.UnmarshalRESP(br))
+	assert.Equal(t, LatencyHistory{
+		{Time: time.Unix(1405067822, 0), Latency: 251 * time.Millisecond},
+		{Time: time.Unix(1405067941, 0), Latency: 1001 * time.Millisecond},
+	}, lh)
+
+	// a malformed entry is an error, but the reply is still consumed
+	err := lh.UnmarshalRESP(br)
+	assert.True(t, errors.As(err, new(resp.ErrDiscarded)))
+
+	var end string
+	require.NoError(t, (resp2.Any{I: &end}).UnmarshalRESP(br))
+	assert.Equal(t, "END", end)
+}
+
+func TestLatencyLive(t *T) {
+	c := dial()
+	defer c.Close()
+
+	var ll LatencyLatest
+	require.NoError(t, c.Do(Cmd(&ll, "LATENCY", "LATEST")))
+	var lh LatencyHistory
+	require.NoError(t, c.Do(Cmd(&lh, "LATENCY", "HISTORY", "command")))
+}
+
+func TestCommandDocs(t *T) {
+	// a subset of the reply to "COMMAND DOCS SET CONFIG" from redis 7.2
+	sample := []interface{}{
+		"set", []interface{}{
+			"summary", "Sets the string value of a key, ignoring its type. The key is created if it doesn't exist.",
+			"since", "1.0.0",
+			"group", "string",
+			"complexity", "O(1)",
+			"history", []interface{}{
+				[]interface{}{"2.6.12", "Added the `EX`, `PX`, `NX` and `XX` options."},
+				[]interface{}{"6.2.0", "Added the `GET`, `EXAT` and `PXAT` option."},
+			},
+			"arguments", []interface{}{
+				[]interface{}{
+					"name", "key",
+					"type", "key",
+					"display_text", "key",
+					"key_spec_index", 0,
+				},
+				[]interface{}{
+					"name", "condition",
+					"type", "oneof",
+					"since", "2.6.12",
+					"flags", []interface{}{"optional"},
+					"arguments", []interface{}{
+						[]interface{}{"name", "nx", "type", "pure-token", "display_text", "nx", "token", "NX"},
+						[]interface{}{"name", "xx", "type", "pure-token", "display_text", "xx", "token", "XX"},
+					},
+				},
+			},
+		},
+		"config", []interface{}{
+			"summary", "A container for server configuration commands.",
+			"since", "2.0.0",
+			"group", "server",
+			"complexity", "Depends on subcommand.",
+			"subcommands", []interface{}{
+				"config|get", []interface{}{
+					"summary", "Returns the effective values of configuration parameters.",
+					"since", "2.0.0",
+					"group", "server",
+					"complexity", "O(N) when N is the number of configuration parameters provided",
+					"arguments", []interface{}{
+						[]interface{}{
+							"name", "parameter",
+							"type", "string",
+							"display_text", "parameter",
+							"flags", []interface{}{"multiple"},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	buf := new(bytes.Buffer)
+	require.NoError(t, (resp2.Any{I: sample}).MarshalRESP(buf))
+
+	var docs CommandDocs
+	require.NoError(t, docs.UnmarshalRESP(bufio.NewReader(buf)))
+	assert.Equal(t, CommandDocs{
+		"set": {
+			Summary:    "Sets the string value of a key, ignoring its type. The key is created if it doesn't exist.",
+			Since:      "1.0.0",
+			Group:      "string",
+			Complexity: "O(1)",
+			History: []CommandDocHistory{
+				{Version: "2.6.12", Description: "Added the `EX`, `PX`, `NX` and `XX` options."},
+				{Version: "6.2.0", Description: "Added the `GET`, `EXAT` and `PXAT` option."},
+			},
+			Arguments: []CommandDocArg{
+				{Name: "key", Type: "key", DisplayText: "key", KeySpecIndex: 0},
+				{
+					Name:         "condition",
+					Type:         "oneof",
+					KeySpecIndex: -1,
+					Since:        "2.6.12",
+					Flags:        []string{"optional"},
+					Arguments: []CommandDocArg{
+						{Name: "nx", Type: "pure-token", DisplayText: "nx", KeySpecIndex: -1, Token: "NX"},
+						{Name: "xx", Type: "pure-token", DisplayText: "xx", KeySpecIndex: -1, Token: "XX"},
+					},
+				},
+			},
+		},
+		"config": {
+			Summary:    "A container for server configuration commands.",
+			Since:      "2.0.0",
+			Group:      "server",
+			Complexity: "Depends on subcommand.",
+			Subcommands: map[string]CommandDoc{
+				"config|get": {
+					Summary:    "Returns the effective values of configuration parameters.",
+					Since:      "2.0.0",
+					Group:      "server",
+					Complexity: "O(N) when N is the number of configuration parameters provided",
+					Arguments: []CommandDocArg{{
+						Name:         "parameter",
+						Type:         "string",
+						DisplayText:  "parameter",
+						KeySpecIndex: -1,
+						Flags:        []string{"multiple"},
+					}},
+				},
+			},
+		},
+	}, docs)
+
+	// the RESP3 form of the reply, using maps and simple strings
+	br := bufio.NewReader(bytes.NewBufferString("%1\r\n" +
+		"+get\r\n%3\r\n" +
+		"+summary\r\n+Returns the string value of a key.\r\n" +
+		"+doc_flags\r\n~1\r\n+deprecated\r\n" +
+		"+arguments\r\n*1\r\n%3\r\n+name\r\n+key\r\n+type\r\n+key\r\n+key_spec_index\r\n:0\r\n",
+	))
+	require.NoError(t, docs.UnmarshalRESP(br))
+	assert.Equal(t, CommandDocs{
+		"get": {
+			Summary:   "Returns the string value of a key.",
+			DocFlags:  []string{"deprecated"},
+			Arguments: []CommandDocArg{{Name: "key", Type: "key", KeySpecIndex: 0}},
+		},
+	}, docs)
+
+	assert.Empty(t, Cmd(nil, "COMMAND", "DOCS", "GET").Keys())
+}
+
+func TestCommandDocsLive(t *T) {
+	c := dial()
+	defer c.Close()
+
+	var docs CommandDocs
+	require.NoError(t, c.Do(Cmd(&docs, "COMMAND", "DOCS", "SET")))
+	assert.Equal(t, "string", docs["set"].Group)
+	assert.NotEmpty(t, docs["set"].Arguments)
+}
@@ -0,0 +1,91 @@
+package radix
+
+import (
+	"strconv"
+
+	errors "golang.org/x/xerrors"
+)
+
+// SortOp describes a single option to a SORT or SORT_RO command. Use one of the
+// Sort* functions to create one.
+type SortOp struct {
+	args []string
+}
+
+// SortBy returns a SortOp which sorts elements by the values of the external
+// keys described by pattern, rather than by the elements themselves.
+func SortBy(pattern string) SortOp {
+	return SortOp{args: []string{"BY", pattern}}
+}
+
+// SortGet returns a SortOp which retrieves the values of the external keys
+// described by pattern, rather than the elements themselves. It may be given
+// multiple times, and the pattern "#" retrieves the element itself.
+func SortGet(pattern string) SortOp {
+	return SortOp{args: []string{"GET", pattern}}
+}
+
+// SortLimit returns a SortOp which limits the result to count elements,
+// starting at offset.
+func SortLimit(offset, count int64) SortOp {
+	return SortOp{args: []string{
+		"LIMIT", strconv.FormatInt(offset, 10), strconv.FormatInt(count, 10),
+	}}
+}
+
+// SortAlpha returns a SortOp which sorts elements lexicographically, rather
+// than as numbers.
+func SortAlpha() SortOp {
+	return SortOp{args: []string{"ALPHA"}}
+}
+
+// SortDesc returns a SortOp which sorts elements in descending order, rather
+// than the default ascending order.
+func SortDesc() SortOp {
+	return SortOp{args: []string{"DESC"}}
+}
+
+// SortStore returns a SortOp which stores the result as a list at the given
+// key, rather than returning it. It can't be used with SortRO.
+func SortStore(destination string) SortOp {
+	return SortOp{args: []string{"STORE", destination}}
+}
+
+func sortArgs(key string, ops []SortOp) []string {
+	args := []string{key}
+	for _, op := range ops {
+		args = append(args, op.args...)
+	}
+	return args
+}
+
+// Sort returns a CmdAction which performs a SORT command on the given key,
+// with the given options, and unmarshals the result into rcv. If SortStore is
+// given then the result is the number of elements stored, otherwise it's the
+// sorted elements.
+//
+// The Action's Keys method returns the given key and the SortStore destination,
+// if any, but not the keys described by any BY or GET patterns, so when using
+// Cluster those must all belong to the same slot as key.
+func Sort(rcv interface{}, key string, ops ...SortOp) CmdAction {
+	return Cmd(rcv, "SORT", sortArgs(key, ops)...)
+}
+
+// SortRO returns a CmdAction which performs a SORT_RO command on the given
+// key, with the given options, and unmarshals the sorted elements into rcv.
+// SORT_RO is only available in redis 7.0 and later.
+//
+// Since SORT_RO is read-only it may be performed on replicas, e.g. by using
+// Cluster's DoSecondary method. SortStore isn't allowed, an error is returned
+// if it's given, as it is for the zero value of SortOp. The Action's Keys
+// method only returns the given key.
+func SortRO(rcv *[]string, key string, ops ...SortOp) (CmdAction, error) {
+	for _, op := range ops {
+		if len(op.args) == 0 {
+			return nil, errors.New("SORT_RO was given an empty SortOp")
+		} else if op.args[0] == "STORE" {
+			return nil, errors.New("SORT_RO doesn't support the STORE option")
+		}
+	}
+	return Cmd(rcv, "SORT_RO", sortArgs(key, ops)...), nil
+}
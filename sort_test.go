@@ -0,0 +1,76 @@
+package radix
+
+import (
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mediocregopher/radix/v3/trace"
+)
+
+func TestSort(t *T) {
+	c := dial()
+	defer c.Close()
+	key, dst := randStr(), randStr()
+
+	require.NoError(t, c.Do(Cmd(nil, "RPUSH", key, "3", "1", "2")))
+
+	var res []string
+	require.NoError(t, c.Do(Sort(&res, key, SortDesc(), SortLimit(0, 2))))
+	assert.Equal(t, []string{"3", "2"}, res)
+
+	var n int
+	require.NoError(t, c.Do(Sort(&n, key, SortStore(dst))))
+	assert.Equal(t, 3, n)
+	require.NoError(t, c.Do(Cmd(&res, "LRANGE", dst, "0", "-1")))
+	assert.Equal(t, []string{"1", "2", "3"}, res)
+
+	a, err := SortRO(&res, key, SortAlpha())
+	require.NoError(t, err)
+	require.NoError(t, c.Do(a))
+	assert.Equal(t, []string{"1", "2", "3"}, res)
+}
+
+func TestSortRO(t *T) {
+	_, err := SortRO(nil, "foo", SortAlpha(), SortStore("bar"))
+	assert.Error(t, err)
+	_, err = SortRO(nil, "foo", SortAlpha(), SortOp{})
+	assert.Error(t, err)
+
+	a, err := SortRO(nil, "foo", SortBy("w_*"), SortGet("#"), SortGet("o_*"), SortLimit(1, 5), SortDesc())
+	require.NoError(t, err)
+	assert.Equal(t,
+		`["SORT_RO" "foo" "BY" "w_*" "GET" "#" "GET" "o_*" "LIMIT" "1" "5" "DESC"]`,
+		cmdString(a))
+	assert.Equal(t, []string{"foo"}, a.Keys())
+	assert.Equal(t, []string{"foo", "bar"}, Sort(nil, "foo", SortGet("#"), SortStore("bar")).Keys())
+}
+
+func TestSortROSecondary(t *T) {
+	var redirects int
+	c, _ := newTestCluster(
+		ClusterWithTrace(trace.ClusterTrace{
+			Redirected: func(trace.ClusterRedirected) {
+				redirects++
+			},
+		}),
+	)
+	defer c.Close()
+
+	key := clusterSlotKeys[0]
+	require.NoError(t, c.Do(Cmd(nil, "SET", key, "foo")))
+
+	for secAddr := range c.secondaries[c.addrForKey(key)] {
+		sec, err := c.Client(secAddr)
+		require.NoError(t, err)
+		require.NoError(t, sec.Do(Cmd(nil, "READONLY")))
+	}
+
+	var res []string
+	a, err := SortRO(&res, key)
+	require.NoError(t, err)
+	require.NoError(t, c.DoSecondary(a))
+	assert.Equal(t, []string{"foo"}, res)
+	assert.Zero(t, redirects)
+}
@@ -0,0 +1,121 @@
+package radix
+
+import (
+	"bufio"
+	"strconv"
+
+	errors "golang.org/x/xerrors"
+
+	"github.com/mediocregopher/radix/v3/resp"
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+)
+
+// maybeFloat unmarshals a reply which is either a float, encoded as a string,
+// or nil into rcv, allocating a new float64 for the former and setting rcv to
+// nil for the latter. If rcv is nil then the reply is discarded.
+type maybeFloat struct {
+	rcv **float64
+}
+
+func (mf maybeFloat) UnmarshalRESP(br *bufio.Reader) error {
+	if mf.rcv == nil {
+		return (resp2.Any{}).UnmarshalRESP(br)
+	}
+
+	var f float64
+	mn := MaybeNil{Rcv: &f}
+	if err := mn.UnmarshalRESP(br); err != nil {
+		return err
+	} else if mn.Nil {
+		*mf.rcv = nil
+		return nil
+	}
+	*mf.rcv = &f
+	return nil
+}
+
+// ZAddIncr returns a CmdAction which performs a ZADD with the INCR option,
+// incrementing the score of member in the sorted set at key by incr. Any of the
+// conditional options ZADD supports, i.e. "NX", "XX", "GT" or "LT", can be
+// given as flags (GT and LT require redis 6.2 or later).
+//
+// When the increment is applied the new score of the member is written to rcv.
+// When one of the conditions prevents the increment, e.g. GT is given and incr
+// is negative, redis replies with nil rather than a score, and rcv is set to
+// nil. rcv may itself be nil if the reply isn't needed.
+//
+//	var score *float64
+//	err := client.Do(radix.ZAddIncr(&score, "scores", "alice", -5, "GT"))
+//	if err != nil {
+//		// handle error
+//	} else if score == nil {
+//		// the score was not changed
+//	}
+func ZAddIncr(rcv **float64, key, member string, incr float64, flags ...string) CmdAction {
+	args := make([]interface{}, 0, len(flags)+3)
+	for _, flag := range flags {
+		args = append(args, flag)
+	}
+	args = append(args, "INCR", incr, member)
+
+	if rcv == nil {
+		return FlatCmd(nil, "ZADD", key, args...)
+	}
+	return FlatCmd(maybeFloat{rcv: rcv}, "ZADD", key, args...)
+}
+
+var zIncrAndRankScript = NewEvalScript(1, `
+	local score = redis.call("ZINCRBY", KEYS[1], ARGV[2], ARGV[1])
+	return {score, redis.call("ZREVRANK", KEYS[1], ARGV[1])}
+`)
+
+type zIncrAndRankReply struct {
+	score *float64
+	rank  *int64
+}
+
+func (r zIncrAndRankReply) UnmarshalRESP(br *bufio.Reader) error {
+	var reply []string
+	if err := (resp2.Any{I: &reply}).UnmarshalRESP(br); err != nil {
+		return err
+	} else if len(reply) != 2 {
+		return resp.ErrDiscarded{
+			Err: errors.Errorf("expected 2 elements in ZIncrAndRank reply, got %d", len(reply)),
+		}
+	}
+
+	score, err := strconv.ParseFloat(reply[0], 64)
+	if err != nil {
+		return resp.ErrDiscarded{Err: err}
+	}
+	rank, err := strconv.ParseInt(reply[1], 10, 64)
+	if err != nil {
+		return resp.ErrDiscarded{Err: err}
+	}
+
+	if r.score != nil {
+		*r.score = score
+	}
+	if r.rank != nil {
+		*r.rank = rank
+	}
+	return nil
+}
+
+// ZIncrAndRank returns an Action which atomically increments the score of
+// member in the sorted set at key by the given amount, as ZINCRBY would, and
+// then retrieves the member's new rank, as ZREVRANK would. This is the common
+// leaderboard update, where the rank is zero for the member with the highest
+// score.
+//
+// The new score is written to score and the new rank to rank, either of which
+// may be nil. The increment and rank read are performed by a lua script, using
+// EvalScript, so the rank is guaranteed to correspond to the new score.
+func ZIncrAndRank(score *float64, rank *int64, key, member string, by float64) Action {
+	return zIncrAndRankScript.Cmd(
+		zIncrAndRankReply{score: score, rank: rank},
+		key,
+		member,
+		strconv.FormatFloat(by, 'f', -1, 64),
+	)
+}
@@ -0,0 +1,105 @@
+package radix
+
+import (
+	"bufio"
+	"strings"
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	errors "golang.org/x/xerrors"
+
+	"github.com/mediocregopher/radix/v3/resp"
+)
+
+func TestZAddIncr(t *T) {
+	c := dial()
+	defer c.Close()
+	key := randStr()
+
+	var score *float64
+	require.NoError(t, c.Do(ZAddIncr(&score, key, "a", 5)))
+	require.NotNil(t, score)
+	assert.Equal(t, float64(5), *score)
+
+	// GT prevents the score from being decremented, and redis replies with nil
+	require.NoError(t, c.Do(ZAddIncr(&score, key, "a", -2, "GT")))
+	assert.Nil(t, score)
+
+	require.NoError(t, c.Do(ZAddIncr(&score, key, "a", 1.5, "GT")))
+	require.NotNil(t, score)
+	assert.Equal(t, 6.5, *score)
+
+	// NX prevents the score of an existing member from being changed
+	require.NoError(t, c.Do(ZAddIncr(&score, key, "a", 1, "NX")))
+	assert.Nil(t, score)
+
+	require.NoError(t, c.Do(ZAddIncr(nil, key, "a", 1)))
+	var got float64
+	require.NoError(t, c.Do(Cmd(&got, "ZSCORE", key, "a")))
+	assert.Equal(t, 7.5, got)
+}
+
+func TestZAddIncrNil(t *T) {
+	var cmds [][]string
+	stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		cmds = append(cmds, args)
+		if args[2] == "GT" {
+			return nil
+		}
+		return "5"
+	})
+
+	var score *float64
+	require.NoError(t, stub.Do(ZAddIncr(&score, "key", "a", 5)))
+	require.NotNil(t, score)
+	assert.Equal(t, float64(5), *score)
+
+	require.NoError(t, stub.Do(ZAddIncr(&score, "key", "a", -2, "GT")))
+	assert.Nil(t, score)
+
+	assert.Equal(t, [][]string{
+		{"ZADD", "key", "INCR", "5", "a"},
+		{"ZADD", "key", "GT", "INCR", "-2", "a"},
+	}, cmds)
+}
+
+func TestZIncrAndRank(t *T) {
+	c := dial()
+	defer c.Close()
+	key := randStr()
+
+	require.NoError(t, c.Do(Cmd(nil, "ZADD", key, "10", "a", "20", "b", "30", "c")))
+
+	var score float64
+	var rank int64
+	require.NoError(t, c.Do(ZIncrAndRank(&score, &rank, key, "a", 15)))
+	assert.Equal(t, float64(25), score)
+	assert.Equal(t, int64(1), rank)
+
+	require.NoError(t, c.Do(ZIncrAndRank(&score, &rank, key, "a", 5.5)))
+	assert.Equal(t, 30.5, score)
+	assert.Equal(t, int64(0), rank)
+
+	// a member which doesn't exist is added, as with ZINCRBY
+	require.NoError(t, c.Do(ZIncrAndRank(&score, &rank, key, "d", 1)))
+	assert.Equal(t, float64(1), score)
+	assert.Equal(t, int64(3), rank)
+
+	require.NoError(t, c.Do(ZIncrAndRank(nil, nil, key, "d", 1)))
+}
+
+func TestZIncrAndRankReply(t *T) {
+	var score float64
+	var rank int64
+	r := zIncrAndRankReply{score: &score, rank: &rank}
+
+	br := bufio.NewReader(strings.NewReader("*2\r\n$4\r\n25.5\r\n:3\r\n"))
+	require.NoError(t, r.UnmarshalRESP(br))
+	assert.Equal(t, 25.5, score)
+	assert.Equal(t, int64(3), rank)
+
+	br = bufio.NewReader(strings.NewReader("*1\r\n$4\r\n25.5\r\n"))
+	err := r.UnmarshalRESP(br)
+	assert.True(t, errors.As(err, new(resp.ErrDiscarded)))
+}
@@ -139,6 +139,13 @@ var errInvalidStreamEntry = errors.New("invalid stream entry")
 
 // UnmarshalRESP implements the resp.Unmarshaler interface.
 func (s *StreamEntry) UnmarshalRESP(br *bufio.Reader) error {
+	return s.unmarshalRESP(br, nil)
+}
+
+// unmarshalRESP unmarshals the entry, using names (if not nil) to intern the
+// field names, so that entries sharing the same field names, as is typical,
+// also share the allocated strings for them.
+func (s *StreamEntry) unmarshalRESP(br *bufio.Reader, names map[string]string) error {
 	var ah resp2.ArrayHeader
 	if err := ah.UnmarshalRESP(br); err != nil {
 		return err
@@ -167,20 +174,108 @@ func (s *StreamEntry) UnmarshalRESP(br *bufio.Reader) error {
 		}
 	}
 
+	if names == nil {
+		var bs resp2.BulkString
+		for i := 0; i < ah.N; i += 2 {
+			if err := bs.UnmarshalRESP(br); err != nil {
+				return err
+			}
+			key := bs.S
+			if err := bs.UnmarshalRESP(br); err != nil {
+				return err
+			}
+			s.Fields[key] = bs.S
+		}
+		return nil
+	}
+
+	buf := bytesutil.GetBytes()
+	defer bytesutil.PutBytes(buf)
+
+	bsb := resp2.BulkStringBytes{B: (*buf)[:0]}
 	var bs resp2.BulkString
 	for i := 0; i < ah.N; i += 2 {
-		if err := bs.UnmarshalRESP(br); err != nil {
+		if err := bsb.UnmarshalRESP(br); err != nil {
 			return err
 		}
-		key := bs.S
+		key, ok := names[string(bsb.B)]
+		if !ok {
+			key = string(bsb.B)
+			names[key] = key
+		}
 		if err := bs.UnmarshalRESP(br); err != nil {
 			return err
 		}
 		s.Fields[key] = bs.S
 	}
+	*buf = bsb.B
+	return nil
+}
+
+// StreamEntries is a slice of StreamEntry which can be unmarshaled directly from
+// the reply of an XRANGE or XREVRANGE command. It's equivalent to unmarshaling
+// into a []StreamEntry, but avoids the reflection otherwise required for each
+// entry, which matters when reading many entries at a time.
+//
+// When unmarshaling, the existing capacity of the slice is reused, as are the
+// Fields maps of any entries within that capacity. Field names which are
+// shared by multiple entries are only allocated once.
+type StreamEntries []StreamEntry
+
+var _ resp.Unmarshaler = (*StreamEntries)(nil)
+
+// UnmarshalRESP implements the resp.Unmarshaler interface.
+func (s *StreamEntries) UnmarshalRESP(br *bufio.Reader) error {
+	var ah resp2.ArrayHeader
+	if err := ah.UnmarshalRESP(br); err != nil {
+		return err
+	} else if ah.N < 0 {
+		*s = nil
+		return nil
+	}
+
+	entries := *s
+	if cap(entries) < ah.N {
+		entries = append(entries[:cap(entries)], make([]StreamEntry, ah.N-cap(entries))...)
+	}
+	entries = entries[:ah.N]
+	*s = entries
+
+	names := map[string]string{}
+	for i := range entries {
+		if err := entries[i].unmarshalRESP(br, names); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+func xRangeCmd(rcv *[]StreamEntry, cmd, key, start, end string, count int) CmdAction {
+	args := []string{key, start, end}
+	if count > 0 {
+		args = append(args, "COUNT", strconv.Itoa(count))
+	}
+	return Cmd((*StreamEntries)(rcv), cmd, args...)
+}
+
+// XRange returns a CmdAction which performs an XRANGE command on the stream at
+// key, unmarshaling the entries with IDs between start and end (inclusive)
+// into rcv. The IDs may be "-" and "+" to indicate the smallest and greatest
+// possible IDs, respectively. If count is greater than zero then at most that
+// many entries will be returned.
+//
+// See StreamEntries for details on how rcv is reused.
+func XRange(rcv *[]StreamEntry, key, start, end string, count int) CmdAction {
+	return xRangeCmd(rcv, "XRANGE", key, start, end, count)
+}
+
+// XRevRange is like XRange, but performs an XREVRANGE command, returning
+// entries in reverse order. Note that, like XREVRANGE, the end ID comes before
+// the start ID.
+func XRevRange(rcv *[]StreamEntry, key, end, start string, count int) CmdAction {
+	return xRangeCmd(rcv, "XREVRANGE", key, end, start, count)
+}
+
 // StreamReaderOpts contains various options given for NewStreamReader that influence the behaviour.
 //
 // The only required field is Streams.
@@ -388,5 +483,48 @@ func (s *streamReaderEntry) UnmarshalRESP(br *bufio.Reader) error {
 	}
 	s.stream = stream.S
 
-	return (resp2.Any{I: &s.entries}).UnmarshalRESP(br)
+	return (*StreamEntries)(&s.entries).UnmarshalRESP(br)
+}
+
+// ConsumeNext reads the next new entry, i.e. one which has never been
+// delivered to any consumer, from the stream at key on behalf of the given
+// consumer in the given consumer group, using XREADGROUP. The returned ack
+// function should be called once the entry has been processed, and will
+// acknowledge it using XACK. Until then the entry remains in the group's
+// pending entries list, from where it can be claimed by another consumer if
+// this one fails.
+//
+// If block is positive then ConsumeNext will wait up to that long for a new
+// entry to become available, if block is negative then it will wait
+// indefinitely, and if block is zero it won't wait at all. If no entry is
+// available then nil is returned for both the entry and the ack function, with
+// no error.
+//
+// NOTE that, as with StreamReader, the Client's read timeout must be
+// substantially higher than block, or the Client will time out first.
+func ConsumeNext(c Client, key, group, consumer string, block time.Duration) (*StreamEntry, func() error, error) {
+	args := []string{"GROUP", group, consumer, "COUNT", "1"}
+	if block != 0 {
+		var msec int64
+		if block > 0 {
+			if msec = int64(block / time.Millisecond); block%time.Millisecond > 0 {
+				msec++
+			}
+		}
+		args = append(args, "BLOCK", strconv.FormatInt(msec, 10))
+	}
+	args = append(args, "STREAMS", key, ">")
+
+	var res []streamReaderEntry
+	if err := c.Do(Cmd(&res, "XREADGROUP", args...)); err != nil {
+		return nil, nil, err
+	} else if len(res) == 0 || len(res[0].entries) == 0 {
+		return nil, nil, nil
+	}
+
+	entry := res[0].entries[0]
+	ack := func() error {
+		return c.Do(Cmd(nil, "XACK", key, group, entry.ID.String()))
+	}
+	return &entry, ack, nil
 }
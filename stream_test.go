@@ -3,6 +3,7 @@ package radix
 import (
 	"bufio"
 	"bytes"
+	"fmt"
 	"io/ioutil"
 	"math"
 	"strconv"
@@ -12,6 +13,9 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	errors "golang.org/x/xerrors"
+
+	"github.com/mediocregopher/radix/v3/resp/resp2"
 )
 
 func TestStreamEntryID(t *T) {
@@ -367,6 +371,97 @@ func BenchmarkStreamEntry(b *B) {
 	}
 }
 
+func TestStreamEntries(t *T) {
+	in := "*2\r\n" +
+		"*2\r\n$3\r\n1-1\r\n*4\r\n$5\r\nhello\r\n$5\r\nworld\r\n$3\r\nfoo\r\n$3\r\nbar\r\n" +
+		"*2\r\n$3\r\n1-2\r\n*2\r\n$5\r\nhello\r\n$3\r\nbar\r\n"
+	exp := StreamEntries{
+		{ID: StreamEntryID{Time: 1, Seq: 1}, Fields: map[string]string{"hello": "world", "foo": "bar"}},
+		{ID: StreamEntryID{Time: 1, Seq: 2}, Fields: map[string]string{"hello": "bar"}},
+	}
+
+	var entries StreamEntries
+	require.NoError(t, entries.UnmarshalRESP(bufio.NewReader(strings.NewReader(in))))
+	assert.Equal(t, exp, entries)
+
+	// unmarshaling again reuses the existing slice
+	entries = append(entries, StreamEntry{ID: StreamEntryID{Time: 9}})[:1]
+	require.NoError(t, entries.UnmarshalRESP(bufio.NewReader(strings.NewReader(in))))
+	assert.Equal(t, exp, entries)
+
+	require.NoError(t, entries.UnmarshalRESP(bufio.NewReader(strings.NewReader("*0\r\n"))))
+	assert.Empty(t, entries)
+	assert.NotNil(t, entries)
+
+	require.NoError(t, entries.UnmarshalRESP(bufio.NewReader(strings.NewReader("*-1\r\n"))))
+	assert.Nil(t, entries)
+}
+
+func TestXRange(t *T) {
+	c := dial()
+	defer c.Close()
+
+	stream := randStr()
+	var ids [3]string
+	for i := range ids {
+		require.NoError(t, c.Do(Cmd(&ids[i], "XADD", stream, "*", "i", strconv.Itoa(i))))
+	}
+
+	var entries []StreamEntry
+	require.NoError(t, c.Do(XRange(&entries, stream, "-", "+", 0)))
+	require.Len(t, entries, 3)
+	for i := range entries {
+		assert.Equal(t, ids[i], entries[i].ID.String())
+		assert.Equal(t, map[string]string{"i": strconv.Itoa(i)}, entries[i].Fields)
+	}
+
+	require.NoError(t, c.Do(XRange(&entries, stream, ids[1], "+", 1)))
+	require.Len(t, entries, 1)
+	assert.Equal(t, ids[1], entries[0].ID.String())
+
+	require.NoError(t, c.Do(XRevRange(&entries, stream, "+", "-", 2)))
+	require.Len(t, entries, 2)
+	assert.Equal(t, ids[2], entries[0].ID.String())
+	assert.Equal(t, ids[1], entries[1].ID.String())
+
+	assert.Equal(t, []string{stream}, XRange(&entries, stream, "-", "+", 0).Keys())
+}
+
+func BenchmarkStreamEntries(b *B) {
+	const n = 10000
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, "*%d\r\n", n)
+	for i := 0; i < n; i++ {
+		id := "1526919030474-" + strconv.Itoa(i)
+		fmt.Fprintf(buf, "*2\r\n$%d\r\n%s\r\n", len(id), id)
+		buf.WriteString("*4\r\n$5\r\nhello\r\n$5\r\nworld\r\n$3\r\nfoo\r\n$3\r\nbar\r\n")
+	}
+	in := buf.Bytes()
+
+	r := bytes.NewReader(in)
+	br := bufio.NewReader(r)
+
+	b.Run("Any", func(b *B) {
+		b.ReportAllocs()
+		var entries []StreamEntry
+		for i := 0; i < b.N; i++ {
+			r.Reset(in)
+			br.Reset(r)
+			benchErr = (resp2.Any{I: &entries}).UnmarshalRESP(br)
+		}
+	})
+
+	b.Run("StreamEntries", func(b *B) {
+		b.ReportAllocs()
+		var entries StreamEntries
+		for i := 0; i < b.N; i++ {
+			r.Reset(in)
+			br.Reset(r)
+			benchErr = entries.UnmarshalRESP(br)
+		}
+	})
+}
+
 func TestStreamReader(t *T) {
 	t.Run("Group", func(t *T) {
 		t.Run("Empty", func(t *T) {
@@ -911,3 +1006,80 @@ func assertConsumer(tb TB, c Client, stream, group, consumer string, pending int
 
 	assert.Failf(tb, "pending messages assertion failed", "consumer %s not in group %s for stream %s", consumer, group, stream)
 }
+
+func TestConsumeNext(t *T) {
+	c := dial()
+	defer c.Close()
+
+	stream, group, consumer := randStr(), randStr(), randStr()
+	require.NoError(t, c.Do(Cmd(nil, "XGROUP", "CREATE", stream, group, "$", "MKSTREAM")))
+
+	var id string
+	require.NoError(t, c.Do(Cmd(&id, "XADD", stream, "*", "hello", "world")))
+
+	entry, ack, err := ConsumeNext(c, stream, group, consumer, 0)
+	require.NoError(t, err)
+	require.NotNil(t, entry)
+	assert.Equal(t, id, entry.ID.String())
+	assert.Equal(t, map[string]string{"hello": "world"}, entry.Fields)
+
+	var pending []interface{}
+	require.NoError(t, c.Do(Cmd(&pending, "XPENDING", stream, group)))
+	assert.Equal(t, int64(1), pending[0])
+
+	require.NoError(t, ack())
+	require.NoError(t, c.Do(Cmd(&pending, "XPENDING", stream, group)))
+	assert.Equal(t, int64(0), pending[0])
+
+	// there are no new entries, so ConsumeNext times out
+	start := time.Now()
+	entry, ack, err = ConsumeNext(c, stream, group, consumer, 100*time.Millisecond)
+	require.NoError(t, err)
+	assert.Nil(t, entry)
+	assert.Nil(t, ack)
+	assert.True(t, time.Since(start) >= 100*time.Millisecond)
+}
+
+func TestConsumeNextStub(t *T) {
+	var cmds [][]string
+	stub := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		cmds = append(cmds, args)
+		switch args[0] {
+		case "XREADGROUP":
+			if len(cmds) > 2 {
+				return nil
+			}
+			return []interface{}{
+				[]interface{}{"stream", []interface{}{
+					[]interface{}{"1-1", []string{"hello", "world"}},
+				}},
+			}
+		case "XACK":
+			return 1
+		}
+		return resp2.Error{E: errors.Errorf("unknown command %q", args[0])}
+	})
+
+	entry, ack, err := ConsumeNext(stub, "stream", "group", "consumer", 1500*time.Microsecond)
+	require.NoError(t, err)
+	assert.Equal(t, &StreamEntry{
+		ID:     StreamEntryID{Time: 1, Seq: 1},
+		Fields: map[string]string{"hello": "world"},
+	}, entry)
+	require.NoError(t, ack())
+
+	entry, ack, err = ConsumeNext(stub, "stream", "group", "consumer", -1)
+	require.NoError(t, err)
+	assert.Nil(t, entry)
+	assert.Nil(t, ack)
+
+	_, _, err = ConsumeNext(stub, "stream", "group", "consumer", 0)
+	require.NoError(t, err)
+
+	assert.Equal(t, [][]string{
+		{"XREADGROUP", "GROUP", "group", "consumer", "COUNT", "1", "BLOCK", "2", "STREAMS", "stream", ">"},
+		{"XACK", "stream", "group", "1-1"},
+		{"XREADGROUP", "GROUP", "group", "consumer", "COUNT", "1", "BLOCK", "0", "STREAMS", "stream", ">"},
+		{"XREADGROUP", "GROUP", "group", "consumer", "COUNT", "1", "STREAMS", "stream", ">"},
+	}, cmds)
+}
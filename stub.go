@@ -215,7 +215,9 @@ func (s *stub) Encode(m resp.Marshaler) error {
 		// result is an error it is assumed to want to be returned directly.
 		ret := s.fn(ss)
 		if m, ok := ret.(resp.Marshaler); ok {
-			return s.buffer.Encode(m)
+			if err := s.buffer.Encode(m); err != nil {
+				return err
+			}
 		} else if err, _ := ret.(error); err != nil {
 			return err
 		} else if err = s.buffer.Encode(resp2.Any{I: ret}); err != nil {
@@ -22,6 +22,12 @@ type PoolTrace struct {
 
 	// InitCompleted is called after pool fills its connections
 	InitCompleted func(PoolInitCompleted)
+
+	// PipelineCompleted is called after a pipeline of commands has been
+	// performed on one of the Pool's connections. This includes both the
+	// pipelines created implicitly by the Pool (see radix.PoolPipelineWindow)
+	// and those created using radix.Pipeline.
+	PipelineCompleted func(PoolPipelineCompleted)
 }
 
 // PoolCommon contains information which is passed into all Pool-related
@@ -131,3 +137,24 @@ type PoolInitCompleted struct {
 	// How long it took to fill all connections.
 	ElapsedTime time.Duration
 }
+
+// PoolPipelineCompleted is passed into the PoolTrace.PipelineCompleted callback
+// whenever the Pool finishes performing a pipeline of commands. It can be used
+// to tune pipeline sizes.
+type PoolPipelineCompleted struct {
+	PoolCommon
+
+	// NumCommands is the number of commands in the pipeline.
+	NumCommands int
+
+	// BytesWritten is the total number of bytes written to the connection for
+	// all commands, and BytesRead the total number of bytes read from it for
+	// all replies.
+	BytesWritten, BytesRead int64
+
+	// How long it took to perform the pipeline.
+	ElapsedTime time.Duration
+
+	// This is the error returned from performing the pipeline.
+	Err error
+}
@@ -0,0 +1,251 @@
+package radix
+
+import (
+	"bufio"
+	"sync"
+	"sync/atomic"
+
+	errors "golang.org/x/xerrors"
+
+	"github.com/mediocregopher/radix/v3/resp"
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+)
+
+// invalidateChannel is the channel which redis publishes invalidation messages
+// on when CLIENT TRACKING is redirected to another connection.
+const invalidateChannel = "__redis__:invalidate"
+
+// Cache is used by a Conn returned from NewTrackingConn to store the replies of
+// the commands performed using TrackingCmd. Its methods may be called
+// concurrently, including from a separate go-routine which handles
+// invalidations.
+type Cache interface {
+	// Get returns the reply previously stored for the command identified by
+	// id, if any.
+	Get(id string) (resp2.RawMessage, bool)
+
+	// Set stores the reply of the command identified by id, which read the
+	// given keys. The reply must not be modified.
+	Set(id string, keys []string, reply resp2.RawMessage)
+
+	// Invalidate removes all replies which were stored for commands reading
+	// any of the given keys. If no keys are given then all replies must be
+	// removed.
+	Invalidate(keys ...string)
+}
+
+type trackingConn struct {
+	Conn
+	invalidations Conn
+	cache         Cache
+
+	// gen is incremented every time an invalidation is received, and broken is
+	// set once invalidations can no longer be received. setL is held while
+	// either is changed and the cache invalidated, and while a reply is stored
+	// after checking them, so that an invalidation can't be missed in between.
+	gen    uint64
+	broken int32
+	setL   sync.Mutex
+	doneCh chan struct{}
+}
+
+// NewTrackingConn enables client-side caching on conn, using CLIENT TRACKING,
+// and returns a Conn which may be used to perform TrackingCmds. Replies to
+// TrackingCmds are stored in the given Cache, and are invalidated whenever
+// redis reports that one of the keys they read has been modified.
+//
+// Invalidations are received on the invalidations Conn, which must be a
+// separate connection to the same redis instance and is dedicated to that
+// purpose, redis is told to redirect the invalidations of conn to it. Both
+// Conns are closed when the returned Conn is, and both are closed if an error
+// is returned. CLIENT TRACKING is only available in redis 6.0 and later.
+//
+// A Cache may be shared by multiple tracking Conns, for example by using a
+// ConnFunc with Pool:
+//
+//	connFunc := func(network, addr string) (radix.Conn, error) {
+//		conn, err := radix.Dial(network, addr)
+//		if err != nil {
+//			return nil, err
+//		}
+//		invalidations, err := radix.Dial(network, addr)
+//		if err != nil {
+//			conn.Close()
+//			return nil, err
+//		}
+//		return radix.NewTrackingConn(conn, invalidations, cache)
+//	}
+//
+// Since redis only tracks the keys read by each connection, once a tracking
+// Conn is closed, or its invalidations Conn fails, the entire Cache is
+// invalidated, and TrackingCmds performed on that Conn no longer use the Cache.
+func NewTrackingConn(conn, invalidations Conn, cache Cache) (Conn, error) {
+	closeAll := func(err error) (Conn, error) {
+		conn.Close()
+		invalidations.Close()
+		return nil, err
+	}
+
+	var id string
+	if err := invalidations.Do(Cmd(&id, "CLIENT", "ID")); err != nil {
+		return closeAll(err)
+	} else if err := invalidations.Do(Cmd(nil, "SUBSCRIBE", invalidateChannel)); err != nil {
+		return closeAll(err)
+	} else if err := conn.Do(Cmd(nil, "CLIENT", "TRACKING", "ON", "REDIRECT", id)); err != nil {
+		return closeAll(err)
+	}
+
+	tc := &trackingConn{
+		Conn:          conn,
+		invalidations: invalidations,
+		cache:         cache,
+		doneCh:        make(chan struct{}),
+	}
+	go tc.spin()
+	return tc, nil
+}
+
+func (tc *trackingConn) spin() {
+	defer close(tc.doneCh)
+	for {
+		var msg invalidationMsg
+		err := tc.invalidations.Decode(&msg)
+		if errors.Is(err, errNotPubSubMessage) {
+			continue
+		} else if err != nil {
+			tc.setL.Lock()
+			atomic.StoreInt32(&tc.broken, 1)
+			tc.cache.Invalidate()
+			tc.setL.Unlock()
+			return
+		}
+		tc.setL.Lock()
+		atomic.AddUint64(&tc.gen, 1)
+		tc.cache.Invalidate(msg.keys...)
+		tc.setL.Unlock()
+	}
+}
+
+// Do is overwritten so that Actions are performed using the trackingConn, and
+// so TrackingCmds can find it.
+func (tc *trackingConn) Do(a Action) error {
+	return a.Run(tc)
+}
+
+func (tc *trackingConn) Close() error {
+	err := tc.Conn.Close()
+	if ierr := tc.invalidations.Close(); err == nil {
+		err = ierr
+	}
+	<-tc.doneCh
+	return err
+}
+
+// trackingConnOf returns the trackingConn which the given Conn is, or wraps,
+// or nil if it's not one.
+func trackingConnOf(c Conn) *trackingConn {
+	for c != nil {
+		if tc, ok := c.(*trackingConn); ok {
+			return tc
+		}
+		c = innerConn(c)
+	}
+	return nil
+}
+
+// invalidationMsg unmarshals an invalidation message published on
+// invalidateChannel. A nil message, which indicates that all keys have been
+// invalidated (e.g. due to FLUSHALL), results in no keys.
+type invalidationMsg struct {
+	keys []string
+}
+
+func (msg *invalidationMsg) UnmarshalRESP(br *bufio.Reader) error {
+	var ah resp2.ArrayHeader
+	if err := ah.UnmarshalRESP(br); err != nil {
+		return err
+	}
+
+	var msgType, channel string
+	if ah.N != 3 {
+		// not a message, e.g. a subscribe confirmation
+	} else if err := (resp2.Any{I: &msgType}).UnmarshalRESP(br); err != nil {
+		return err
+	} else if err := (resp2.Any{I: &channel}).UnmarshalRESP(br); err != nil {
+		return err
+	} else if msgType == "message" && channel == invalidateChannel {
+		return (resp2.Any{I: &msg.keys}).UnmarshalRESP(br)
+	} else {
+		ah.N = 1
+	}
+
+	for i := 0; i < ah.N; i++ {
+		if err := (resp2.Any{}).UnmarshalRESP(br); err != nil {
+			return err
+		}
+	}
+	return resp.ErrDiscarded{Err: errNotPubSubMessage}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+type trackingCmd struct {
+	rcv  interface{}
+	cmd  string
+	args []string
+	keys []string
+}
+
+// TrackingCmd is like Cmd, but the reply is stored in the Cache of the Conn it
+// is performed on, which must be a Conn returned from NewTrackingConn (possibly
+// via a Pool whose ConnFunc uses it). If a reply for the same command and
+// arguments is already in the Cache then the command isn't sent at all, and
+// that reply is unmarshaled into rcv instead.
+//
+// TrackingCmd should only be used for commands which read keys without
+// modifying them, e.g. GET or HGETALL. Error replies are never stored.
+func TrackingCmd(rcv interface{}, cmd string, args ...string) Action {
+	return &trackingCmd{
+		rcv:  rcv,
+		cmd:  cmd,
+		args: args,
+		keys: Cmd(nil, cmd, args...).Keys(),
+	}
+}
+
+func (tc *trackingCmd) Keys() []string {
+	return tc.keys
+}
+
+func (tc *trackingCmd) Run(c Conn) error {
+	t := trackingConnOf(c)
+	if t == nil {
+		return errors.New("TrackingCmd must be performed on a Conn returned from NewTrackingConn")
+	}
+
+	useCache := atomic.LoadInt32(&t.broken) == 0
+	id := cmdString(Cmd(nil, tc.cmd, tc.args...))
+	if useCache {
+		if reply, ok := t.cache.Get(id); ok {
+			return reply.UnmarshalInto(resp2.Any{I: tc.rcv})
+		}
+	}
+
+	// if an invalidation is received while the command is being performed then
+	// it may be for the reply, in which case the reply mustn't be stored
+	gen := atomic.LoadUint64(&t.gen)
+	var reply resp2.RawMessage
+	if err := c.Do(Cmd(&reply, tc.cmd, tc.args...)); err != nil {
+		return err
+	}
+
+	isErr := len(reply) > 0 && reply[0] == resp2.ErrorPrefix[0]
+	if useCache && !isErr {
+		t.setL.Lock()
+		if atomic.LoadUint64(&t.gen) == gen && atomic.LoadInt32(&t.broken) == 0 {
+			t.cache.Set(id, tc.keys, reply)
+		}
+		t.setL.Unlock()
+	}
+	return reply.UnmarshalInto(resp2.Any{I: tc.rcv})
+}
@@ -0,0 +1,251 @@
+package radix
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"sync"
+	. "testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	errors "golang.org/x/xerrors"
+
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+)
+
+type testCache struct {
+	l             sync.Mutex
+	m             map[string]resp2.RawMessage
+	keys          map[string][]string
+	invalidatedCh chan []string
+}
+
+func newTestCache() *testCache {
+	return &testCache{
+		m:             map[string]resp2.RawMessage{},
+		keys:          map[string][]string{},
+		invalidatedCh: make(chan []string, 16),
+	}
+}
+
+func (c *testCache) Get(id string) (resp2.RawMessage, bool) {
+	c.l.Lock()
+	defer c.l.Unlock()
+	reply, ok := c.m[id]
+	return reply, ok
+}
+
+func (c *testCache) Set(id string, keys []string, reply resp2.RawMessage) {
+	c.l.Lock()
+	defer c.l.Unlock()
+	c.m[id] = reply
+	for _, key := range keys {
+		c.keys[key] = append(c.keys[key], id)
+	}
+}
+
+func (c *testCache) Invalidate(keys ...string) {
+	c.l.Lock()
+	if len(keys) == 0 {
+		c.m = map[string]resp2.RawMessage{}
+		c.keys = map[string][]string{}
+	}
+	for _, key := range keys {
+		for _, id := range c.keys[key] {
+			delete(c.m, id)
+		}
+		delete(c.keys, key)
+	}
+	c.l.Unlock()
+	c.invalidatedCh <- keys
+}
+
+func (c *testCache) awaitInvalidated(t *T) []string {
+	select {
+	case keys := <-c.invalidatedCh:
+		return keys
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for invalidation")
+		return nil
+	}
+}
+
+// invalidationsPipe returns a Conn which acts as a redis connection which
+// invalidations are redirected to, with the CLIENT ID 7, and the server side of
+// it which invalidation messages can be written to.
+func invalidationsPipe() (Conn, net.Conn) {
+	client, server := net.Pipe()
+	go func() {
+		br := bufio.NewReader(server)
+		for i := 0; i < 2; i++ {
+			var args []string
+			if err := (resp2.Any{I: &args}).UnmarshalRESP(br); err != nil {
+				return
+			}
+			switch strings.ToUpper(args[0]) {
+			case "CLIENT":
+				server.Write([]byte(":7\r\n"))
+			case "SUBSCRIBE":
+				server.Write([]byte("*3\r\n$9\r\nsubscribe\r\n$20\r\n__redis__:invalidate\r\n:1\r\n"))
+			}
+		}
+	}()
+	return NewConn(client), server
+}
+
+func TestTrackingConn(t *T) {
+	kv := map[string]string{"foo": "1", "bar": "2"}
+	var cmds [][]string
+	var cmdsL sync.Mutex
+	conn := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		cmdsL.Lock()
+		cmds = append(cmds, args)
+		cmdsL.Unlock()
+		switch args[0] {
+		case "GET":
+			return kv[args[1]]
+		case "BADCMD":
+			return resp2.Error{E: errors.New("ERR unknown command")}
+		}
+		return resp2.SimpleString{S: "OK"}
+	})
+	numCmds := func() int {
+		cmdsL.Lock()
+		defer cmdsL.Unlock()
+		return len(cmds)
+	}
+
+	invalidations, server := invalidationsPipe()
+	cache := newTestCache()
+	tc, err := NewTrackingConn(conn, invalidations, cache)
+	require.NoError(t, err)
+	assert.Equal(t, [][]string{{"CLIENT", "TRACKING", "ON", "REDIRECT", "7"}}, cmds)
+
+	get := func(key string) string {
+		var val string
+		a := TrackingCmd(&val, "GET", key)
+		assert.Equal(t, []string{key}, a.Keys())
+		require.NoError(t, tc.Do(a))
+		return val
+	}
+
+	// the first GET is sent, the second is cached
+	assert.Equal(t, "1", get("foo"))
+	assert.Equal(t, "1", get("foo"))
+	assert.Equal(t, "2", get("bar"))
+	assert.Equal(t, 3, numCmds())
+
+	// invalidating foo causes it to be sent again
+	kv["foo"] = "3"
+	_, err = server.Write([]byte("*3\r\n$7\r\nmessage\r\n$20\r\n__redis__:invalidate\r\n*1\r\n$3\r\nfoo\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"foo"}, cache.awaitInvalidated(t))
+	assert.Equal(t, "3", get("foo"))
+	assert.Equal(t, "2", get("bar"))
+	assert.Equal(t, 4, numCmds())
+
+	// error replies aren't cached
+	assert.Error(t, tc.Do(TrackingCmd(nil, "BADCMD")))
+	assert.Error(t, tc.Do(TrackingCmd(nil, "BADCMD")))
+	assert.Equal(t, 6, numCmds())
+
+	// a nil invalidation message invalidates everything
+	_, err = server.Write([]byte("*3\r\n$7\r\nmessage\r\n$20\r\n__redis__:invalidate\r\n*-1\r\n"))
+	require.NoError(t, err)
+	assert.Empty(t, cache.awaitInvalidated(t))
+	assert.Equal(t, "3", get("foo"))
+	assert.Equal(t, 7, numCmds())
+
+	// once invalidations can't be received the cache is flushed and no longer
+	// used
+	server.Close()
+	assert.Empty(t, cache.awaitInvalidated(t))
+	assert.Equal(t, "3", get("foo"))
+	assert.Equal(t, "3", get("foo"))
+	assert.Equal(t, 9, numCmds())
+	assert.Empty(t, cache.m)
+
+	require.NoError(t, tc.Close())
+}
+
+// setHookCache is a testCache which calls beforeSet, if it's set, before each
+// reply is stored.
+type setHookCache struct {
+	*testCache
+	beforeSet func()
+}
+
+func (c *setHookCache) Set(id string, keys []string, reply resp2.RawMessage) {
+	if c.beforeSet != nil {
+		c.beforeSet()
+	}
+	c.testCache.Set(id, keys, reply)
+}
+
+func TestTrackingConnInvalidateDuringSet(t *T) {
+	var numGets int
+	var numGetsL sync.Mutex
+	conn := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		if args[0] == "GET" {
+			numGetsL.Lock()
+			numGets++
+			numGetsL.Unlock()
+			return "1"
+		}
+		return resp2.SimpleString{S: "OK"}
+	})
+
+	invalidations, server := invalidationsPipe()
+	cache := &setHookCache{testCache: newTestCache()}
+	tc, err := NewTrackingConn(conn, invalidations, cache)
+	require.NoError(t, err)
+	defer tc.Close()
+
+	// an invalidation for foo is handled after the reply has been checked, but
+	// before it's stored, and must still remove it from the cache
+	cache.beforeSet = func() {
+		cache.beforeSet = nil
+		go server.Write([]byte("*3\r\n$7\r\nmessage\r\n$20\r\n__redis__:invalidate\r\n*1\r\n$3\r\nfoo\r\n"))
+		time.Sleep(50 * time.Millisecond)
+	}
+	require.NoError(t, tc.Do(TrackingCmd(nil, "GET", "foo")))
+	assert.Equal(t, []string{"foo"}, cache.awaitInvalidated(t))
+
+	require.NoError(t, tc.Do(TrackingCmd(nil, "GET", "foo")))
+	numGetsL.Lock()
+	assert.Equal(t, 2, numGets)
+	numGetsL.Unlock()
+}
+
+func TestTrackingCmdNotTracking(t *T) {
+	conn := Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		return "1"
+	})
+	assert.Error(t, conn.Do(TrackingCmd(nil, "GET", "foo")))
+}
+
+func TestTrackingConnLive(t *T) {
+	cache := newTestCache()
+	tc, err := NewTrackingConn(dial(), dial(), cache)
+	require.NoError(t, err)
+	defer tc.Close()
+
+	c := dial()
+	defer c.Close()
+	key := randStr()
+	require.NoError(t, c.Do(Cmd(nil, "SET", key, "1")))
+
+	var val string
+	require.NoError(t, tc.Do(TrackingCmd(&val, "GET", key)))
+	assert.Equal(t, "1", val)
+	_, ok := cache.Get(cmdString(Cmd(nil, "GET", key)))
+	assert.True(t, ok)
+
+	// modifying the key from another connection invalidates it
+	require.NoError(t, c.Do(Cmd(nil, "SET", key, "2")))
+	assert.Equal(t, []string{key}, cache.awaitInvalidated(t))
+	require.NoError(t, tc.Do(TrackingCmd(&val, "GET", key)))
+	assert.Equal(t, "2", val)
+}
@@ -0,0 +1,201 @@
+package radix
+
+import (
+	"bufio"
+	"bytes"
+
+	errors "golang.org/x/xerrors"
+
+	"github.com/mediocregopher/radix/v3/resp"
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+)
+
+// ErrTxAborted is returned from a Transaction whose EXEC was aborted by redis,
+// because one of the WATCHed keys was modified before it was performed. The
+// Transaction can generally be retried when this happens.
+var ErrTxAborted = errors.New("transaction aborted by a watched key being modified")
+
+// Tx is given to the callback of a Transaction, and is used to queue up the
+// commands which will be performed as part of the MULTI/EXEC transaction.
+type Tx struct {
+	conn    Conn
+	cmds    []CmdAction
+	replies []interface{}
+}
+
+// Do performs the given Action immediately, on the same Conn as the
+// transaction but outside of it. This is useful for reading the values of
+// WATCHed keys before deciding which commands to Queue.
+func (tx *Tx) Do(a Action) error {
+	return tx.conn.Do(a)
+}
+
+// Queue adds the given CmdAction to the transaction. CmdActions are performed
+// in the order they're queued, and once the transaction has been executed each
+// one's receiver is filled with its reply.
+func (tx *Tx) Queue(cmd CmdAction) {
+	tx.cmds = append(tx.cmds, cmd)
+}
+
+// Replies returns the reply of each queued command, in the order they were
+// queued, once the transaction has been executed. Each reply is decoded as
+// described by resp2.Any when given an interface{} (e.g. int64 for an integer,
+// []interface{} for an array), except for error replies, for which the error
+// itself is given. If the transaction hasn't been executed, or was aborted,
+// then nil is returned.
+func (tx *Tx) Replies() []interface{} {
+	return tx.replies
+}
+
+type transaction struct {
+	watch []string
+	fn    func(*Tx) error
+}
+
+// Transaction returns an Action which performs a MULTI/EXEC transaction on a
+// single Conn. WATCH is first performed for the given keys, if any, and then
+// fn is called, which Queues the commands to be performed within the
+// transaction. Finally MULTI, the queued commands, and EXEC are all written in
+// a single round-trip, and the reply of each queued command is unmarshaled
+// into its receiver.
+//
+// If fn returns an error then no transaction is started, UNWATCH is performed,
+// and the error is returned. If redis refuses to queue one of the commands,
+// e.g. because of a syntax error, then the transaction is discarded and a
+// PipelineError describing the command is returned. If EXEC is aborted because
+// a WATCHed key was modified then ErrTxAborted is returned, and the Action can
+// be retried from the start. If one of the queued commands fails when it's
+// performed (the others will still have been performed, as per redis'
+// semantics) then a PipelineError describing it is returned.
+//
+//	err := client.Do(radix.Transaction([]string{"balance"}, func(tx *radix.Tx) error {
+//		var balance int
+//		if err := tx.Do(radix.Cmd(&balance, "GET", "balance")); err != nil {
+//			return err
+//		} else if balance < 10 {
+//			return errInsufficientFunds
+//		}
+//		tx.Queue(radix.Cmd(nil, "DECRBY", "balance", "10"))
+//		tx.Queue(radix.Cmd(nil, "RPUSH", "purchases", "widget"))
+//		return nil
+//	}))
+//
+// The Action's Keys method returns the keys given to WATCH, so when using
+// Cluster any queued commands must be acting on keys in the same slot as
+// those.
+func Transaction(watch []string, fn func(tx *Tx) error) Action {
+	return &transaction{watch: watch, fn: fn}
+}
+
+func (t *transaction) Keys() []string {
+	return t.watch
+}
+
+func (t *transaction) Run(c Conn) error {
+	if len(t.watch) > 0 {
+		if err := c.Do(Cmd(nil, "WATCH", t.watch...)); err != nil {
+			return err
+		}
+	}
+
+	tx := &Tx{conn: c}
+	if err := t.fn(tx); err != nil {
+		if len(t.watch) > 0 {
+			if uerr := c.Do(Cmd(nil, "UNWATCH")); uerr != nil {
+				return uerr
+			}
+		}
+		return err
+	}
+
+	// the whole transaction is marshaled up front, so that if any of the
+	// commands fail to marshal nothing is sent
+	buf := new(bytes.Buffer)
+	if err := Cmd(nil, "MULTI").MarshalRESP(buf); err != nil {
+		return err
+	}
+	for _, cmd := range tx.cmds {
+		if err := cmd.MarshalRESP(buf); err != nil {
+			if len(t.watch) > 0 {
+				_ = c.Do(Cmd(nil, "UNWATCH"))
+			}
+			return err
+		}
+	}
+	if err := Cmd(nil, "EXEC").MarshalRESP(buf); err != nil {
+		return err
+	} else if err := c.Encode(resp2.RawMessage(buf.Bytes())); err != nil {
+		return err
+	}
+
+	if err := c.Decode(resp2.Any{}); err != nil { // MULTI's OK
+		pipeline(tx.cmds).drain(c, len(tx.cmds)+1)
+		return err
+	}
+
+	var queueErr error
+	for i, cmd := range tx.cmds {
+		if err := c.Decode(resp2.Any{}); err != nil && queueErr == nil {
+			queueErr = decodeErr(i, cmd, err)
+		}
+	}
+
+	execErr := c.Decode((*txExecRcv)(tx))
+	switch {
+	case queueErr != nil && errors.As(execErr, new(resp.ErrDiscarded)):
+		// EXEC will have returned EXECABORT, the queueing error is more useful
+		return queueErr
+	case errors.Is(execErr, ErrTxAborted):
+		return ErrTxAborted
+	}
+	return execErr
+}
+
+// txExecRcv unmarshals the reply to EXEC into each of the transaction's queued
+// CmdActions, and into its replies.
+type txExecRcv Tx
+
+func (rcv *txExecRcv) UnmarshalRESP(br *bufio.Reader) error {
+	var ah resp2.ArrayHeader
+	if err := ah.UnmarshalRESP(br); err != nil {
+		return err
+	} else if ah.N < 0 {
+		return resp.ErrDiscarded{Err: ErrTxAborted}
+	} else if ah.N != len(rcv.cmds) {
+		for i := 0; i < ah.N; i++ {
+			if err := (resp2.Any{}).UnmarshalRESP(br); err != nil {
+				return err
+			}
+		}
+		return resp.ErrDiscarded{
+			Err: errors.Errorf("EXEC returned %d replies for %d commands", ah.N, len(rcv.cmds)),
+		}
+	}
+
+	var firstErr error
+	rcv.replies = make([]interface{}, len(rcv.cmds))
+	for i, cmd := range rcv.cmds {
+		var rm resp2.RawMessage
+		if err := rm.UnmarshalRESP(br); err != nil {
+			return err
+		} else if len(rm) > 0 && rm[0] == resp2.ErrorPrefix[0] {
+			var replyErr resp2.Error
+			if err := rm.UnmarshalInto(&replyErr); err != nil {
+				return err
+			}
+			rcv.replies[i] = replyErr
+		} else if err := rm.UnmarshalInto(resp2.Any{I: &rcv.replies[i]}); err != nil {
+			return err
+		}
+
+		err := rm.UnmarshalInto(cmd)
+		if err == nil {
+			continue
+		} else if !errors.As(err, new(resp.ErrDiscarded)) {
+			return err
+		} else if firstErr == nil {
+			firstErr = resp.ErrDiscarded{Err: decodeErr(i, cmd, err)}
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,227 @@
+package radix
+
+import (
+	"strings"
+	. "testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	errors "golang.org/x/xerrors"
+
+	"github.com/mediocregopher/radix/v3/resp"
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+)
+
+// txStub returns a Conn which implements just enough of MULTI/EXEC/WATCH, over
+// the commands GET, SET and INCR, to test Transaction. If abort is set then
+// EXEC is always aborted, as if a watched key was modified. Every command
+// received is recorded into cmds.
+func txStub(abort bool, cmds *[]string) Conn {
+	kv := map[string]string{"balance": "10"}
+	var multi bool
+	var queued [][]string
+
+	var do func(args []string) interface{}
+	do = func(args []string) interface{} {
+		switch strings.ToUpper(args[0]) {
+		case "GET":
+			return kv[args[1]]
+		case "SET":
+			kv[args[1]] = args[2]
+			return resp2.SimpleString{S: "OK"}
+		case "INCR":
+			if kv[args[1]] == "nan" {
+				return resp2.Error{E: errors.New("ERR value is not an integer or out of range")}
+			}
+			kv[args[1]] += "1"
+			return int64(len(kv[args[1]]))
+		}
+		return resp2.Error{E: errors.Errorf("ERR unknown command '%s'", args[0])}
+	}
+
+	return Stub("tcp", "127.0.0.1:6379", func(args []string) interface{} {
+		*cmds = append(*cmds, args[0])
+		switch cmd := strings.ToUpper(args[0]); {
+		case cmd == "WATCH" || cmd == "UNWATCH":
+			return resp2.SimpleString{S: "OK"}
+		case cmd == "MULTI":
+			multi, queued = true, nil
+			return resp2.SimpleString{S: "OK"}
+		case cmd == "EXEC":
+			multi = false
+			if abort {
+				return []string(nil)
+			}
+			for _, q := range queued {
+				if q == nil {
+					return resp2.Error{E: errors.New("EXECABORT Transaction discarded because of previous errors.")}
+				}
+			}
+			replies := resp2.Array{A: make([]resp.Marshaler, len(queued))}
+			for i, q := range queued {
+				reply := do(q)
+				if m, ok := reply.(resp.Marshaler); ok {
+					replies.A[i] = m
+				} else {
+					replies.A[i] = resp2.Any{I: reply}
+				}
+			}
+			return replies
+		case multi && cmd == "BADCMD":
+			queued = append(queued, nil)
+			return resp2.Error{E: errors.New("ERR unknown command 'BADCMD'")}
+		case multi:
+			queued = append(queued, args)
+			return resp2.SimpleString{S: "QUEUED"}
+		}
+		return do(args)
+	})
+}
+
+func TestTransaction(t *T) {
+	t.Run("success", func(t *T) {
+		var cmds []string
+		c := txStub(false, &cmds)
+
+		var balance, got string
+		var n int
+		a := Transaction([]string{"balance"}, func(tx *Tx) error {
+			if err := tx.Do(Cmd(&balance, "GET", "balance")); err != nil {
+				return err
+			}
+			tx.Queue(Cmd(nil, "SET", "balance", "5"))
+			tx.Queue(Cmd(&n, "INCR", "balance"))
+			tx.Queue(Cmd(&got, "GET", "balance"))
+			return nil
+		})
+		assert.Equal(t, []string{"balance"}, a.Keys())
+		require.NoError(t, c.Do(a))
+		assert.Equal(t, "10", balance)
+		assert.Equal(t, 2, n)
+		assert.Equal(t, "51", got)
+		assert.Equal(t, []string{"WATCH", "GET", "MULTI", "SET", "INCR", "GET", "EXEC"}, cmds)
+	})
+
+	t.Run("replies", func(t *T) {
+		var cmds []string
+		c := txStub(false, &cmds)
+
+		var tx *Tx
+		require.NoError(t, c.Do(Transaction(nil, func(innerTx *Tx) error {
+			tx = innerTx
+			tx.Queue(Cmd(nil, "SET", "foo", "bar"))
+			tx.Queue(Cmd(nil, "INCR", "foo"))
+			return nil
+		})))
+		assert.Equal(t, []interface{}{"OK", int64(4)}, tx.Replies())
+		assert.Equal(t, []string{"MULTI", "SET", "INCR", "EXEC"}, cmds)
+	})
+
+	t.Run("callbackErr", func(t *T) {
+		var cmds []string
+		c := txStub(false, &cmds)
+
+		errFoo := errors.New("foo")
+		err := c.Do(Transaction([]string{"balance"}, func(tx *Tx) error {
+			tx.Queue(Cmd(nil, "SET", "balance", "5"))
+			return errFoo
+		}))
+		assert.Equal(t, errFoo, err)
+		assert.Equal(t, []string{"WATCH", "UNWATCH"}, cmds)
+	})
+
+	t.Run("aborted", func(t *T) {
+		var cmds []string
+		c := txStub(true, &cmds)
+
+		var tx *Tx
+		err := c.Do(Transaction([]string{"balance"}, func(innerTx *Tx) error {
+			tx = innerTx
+			tx.Queue(Cmd(nil, "SET", "balance", "5"))
+			return nil
+		}))
+		assert.Equal(t, ErrTxAborted, err)
+		assert.Nil(t, tx.Replies())
+
+		// the Conn is still usable
+		var balance string
+		require.NoError(t, c.Do(Cmd(&balance, "GET", "balance")))
+		assert.Equal(t, "10", balance)
+	})
+
+	t.Run("queueErr", func(t *T) {
+		var cmds []string
+		c := txStub(false, &cmds)
+
+		err := c.Do(Transaction(nil, func(tx *Tx) error {
+			tx.Queue(Cmd(nil, "SET", "balance", "5"))
+			tx.Queue(Cmd(nil, "BADCMD"))
+			return nil
+		}))
+		var pe PipelineError
+		require.True(t, errors.As(err, &pe))
+		assert.Equal(t, 1, pe.Index)
+		assert.Contains(t, err.Error(), "unknown command 'BADCMD'")
+
+		// nothing was performed
+		var balance string
+		require.NoError(t, c.Do(Cmd(&balance, "GET", "balance")))
+		assert.Equal(t, "10", balance)
+	})
+
+	t.Run("execErr", func(t *T) {
+		var cmds []string
+		c := txStub(false, &cmds)
+
+		var got string
+		var tx *Tx
+		err := c.Do(Transaction(nil, func(innerTx *Tx) error {
+			tx = innerTx
+			tx.Queue(Cmd(nil, "SET", "foo", "nan"))
+			tx.Queue(Cmd(nil, "INCR", "foo"))
+			tx.Queue(Cmd(&got, "GET", "foo"))
+			return nil
+		}))
+		var pe PipelineError
+		require.True(t, errors.As(err, &pe))
+		assert.Equal(t, 1, pe.Index)
+		assert.True(t, errors.As(err, new(resp2.Error)))
+
+		// the other commands were still performed
+		assert.Equal(t, "nan", got)
+		require.Len(t, tx.Replies(), 3)
+		assert.Equal(t, "OK", tx.Replies()[0])
+		assert.Error(t, tx.Replies()[1].(error))
+		assert.Equal(t, []byte("nan"), tx.Replies()[2])
+	})
+}
+
+func TestTransactionLive(t *T) {
+	c := dial()
+	defer c.Close()
+	key := randStr()
+	require.NoError(t, c.Do(Cmd(nil, "SET", key, "10")))
+
+	var balance, n int
+	require.NoError(t, c.Do(Transaction([]string{key}, func(tx *Tx) error {
+		if err := tx.Do(Cmd(&balance, "GET", key)); err != nil {
+			return err
+		}
+		tx.Queue(Cmd(&n, "DECRBY", key, "3"))
+		return nil
+	})))
+	assert.Equal(t, 10, balance)
+	assert.Equal(t, 7, n)
+
+	// modifying the watched key from another connection aborts the transaction
+	c2 := dial()
+	defer c2.Close()
+	err := c.Do(Transaction([]string{key}, func(tx *Tx) error {
+		if err := c2.Do(Cmd(nil, "SET", key, "0")); err != nil {
+			return err
+		}
+		tx.Queue(Cmd(nil, "DECRBY", key, "3"))
+		return nil
+	}))
+	assert.Equal(t, ErrTxAborted, err)
+}